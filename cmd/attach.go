@@ -0,0 +1,105 @@
+// cmd/attach.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// attachCommand handles "floka attach [--detach-keys KEYS] [--no-stdin] CONTAINER",
+// exiting with the container's exit code once it exits
+func attachCommand(ctx context.Context, args []string) {
+	attachFlags := newFlagSet("attach", "[--detach-keys KEYS] [--no-stdin] CONTAINER")
+	detachKeys := attachFlags.String("detach-keys", container.DefaultDetachKeys, "Key sequence detaching from the container, e.g. ctrl-p,ctrl-q")
+	noStdin := attachFlags.Bool("no-stdin", false, "Don't send our stdin to the container")
+	parseFlags(attachFlags, args)
+
+	if attachFlags.NArg() != 1 {
+		fmt.Println("Error: 'attach' requires exactly 1 argument")
+		fmt.Println("Usage: floka attach [--detach-keys KEYS] [--no-stdin] CONTAINER")
+		os.Exit(1)
+	}
+
+	keys, err := container.ParseDetachKeys(*detachKeys)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	cont, err := container.Find(attachFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	attachContainer(ctx, cont, keys, !*noStdin)
+}
+
+// attachContainer relays our stdio to those of a detached container, and
+// once it exits, exits with its exit code. It returns when the detach keys
+// are read.
+func attachContainer(ctx context.Context, cont *container.Container, keys []byte, stdin bool) {
+	opts := &container.AttachOptions{Stdout: os.Stdout, Stderr: os.Stderr, DetachKeys: keys}
+	if stdin {
+		opts.Stdin = os.Stdin
+	}
+
+	// The terminal of a container run with -t takes over ours: keys go to
+	// it as they are typed and it gets our size
+	stdinFd := os.Stdin.Fd()
+	restoreTerminal := func() {}
+	if cont.Tty && term.IsTerminal(stdinFd) {
+		resize := make(chan container.TerminalSize, 1)
+		sendSize := func() {
+			if ws, err := term.GetWinsize(stdinFd); err == nil {
+				select {
+				case resize <- container.TerminalSize{Rows: ws.Rows, Cols: ws.Cols}:
+				default:
+				}
+			}
+		}
+		sendSize()
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				sendSize()
+			}
+		}()
+		opts.Resize = resize
+
+		if stdin {
+			restore, err := term.MakeRaw(stdinFd)
+			if err != nil {
+				logging.L().Warn("failed to put terminal in raw mode", "err", err)
+			} else {
+				restoreTerminal = func() { restore() }
+			}
+		}
+	}
+
+	err := cont.Attach(opts)
+	restoreTerminal()
+	if errors.Is(err, container.ErrDetached) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// The output ends when the container exits for good
+	exitCode, err := cont.Wait(ctx)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(exitCode)
+}
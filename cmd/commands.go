@@ -0,0 +1,212 @@
+// cmd/commands.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Exit codes of floka itself, as docker uses them: the container command's
+// own exit code is passed on otherwise
+const (
+	exitCodeError        = 125 // floka failed, the container command didn't run
+	exitCodeCannotInvoke = 126 // The container command couldn't be executed
+	exitCodeNotFound     = 127 // The container command wasn't found
+)
+
+// execExitCode returns the exit code of a failure to execute the
+// container command: exitCodeNotFound if it, or a directory on its path,
+// doesn't exist or it isn't in $PATH, exitCodeCannotInvoke otherwise, e.g.
+// when it isn't executable
+func execExitCode(err error) int {
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, exec.ErrNotFound) {
+		return exitCodeNotFound
+	}
+	return exitCodeCannotInvoke
+}
+
+// command is a floka subcommand
+type command struct {
+	name     string
+	summary  string
+	run      func(ctx context.Context, args []string)
+	internal bool // Started by floka itself, left out of the help
+}
+
+// commands are the subcommands of floka, in the order the help lists them.
+// They are set up in init as the help command lists them.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", summary: "Run a command in a new container", run: runCommand},
+		{name: "create", summary: "Create a container without starting it", run: createCommand},
+		{name: "pull", summary: "Pull an image from a registry", run: pullCommand},
+		{name: "push", summary: "Push an image to a registry", run: pushCommand},
+		{name: "login", summary: "Log in to a registry", run: loginCommand},
+		{name: "logout", summary: "Log out from a registry", run: withoutContext(logoutCommand)},
+		{name: "build", summary: "Build an image from a Flokafile", run: buildCommand},
+		{name: "images", summary: "List images", run: withoutContext(imagesCommand)},
+		{name: "history", summary: "Show the history of an image", run: withoutContext(historyCommand)},
+		{name: "tag", summary: "Add a reference to an image", run: withoutContext(tagCommand)},
+		{name: "rmi", summary: "Remove one or more images", run: withoutContext(rmiCommand)},
+		{name: "save", summary: "Save images to a tar archive", run: withoutContext(saveCommand)},
+		{name: "load", summary: "Load images from a tar archive", run: withoutContext(loadCommand)},
+		{name: "ps", summary: "List containers", run: withoutContext(psCommand)},
+		{name: "logs", summary: "Fetch the logs of a container", run: logsCommand},
+		{name: "start", summary: "Start one or more stopped containers", run: startCommand},
+		{name: "stop", summary: "Stop one or more running containers", run: stopCommand},
+		{name: "kill", summary: "Send a signal to one or more running containers", run: withoutContext(killCommand)},
+		{name: "wait", summary: "Block until containers exit and print their exit codes", run: waitCommand},
+		{name: "pause", summary: "Pause all processes of one or more containers", run: func(ctx context.Context, args []string) { pauseCommand(args, false) }},
+		{name: "unpause", summary: "Resume all processes of one or more paused containers", run: func(ctx context.Context, args []string) { pauseCommand(args, true) }},
+		{name: "rename", summary: "Rename a container", run: withoutContext(renameCommand)},
+		{name: "update", summary: "Change the resource limits of one or more containers", run: withoutContext(updateCommand)},
+		{name: "rm", summary: "Remove one or more containers", run: rmCommand},
+		{name: "exec", summary: "Run a command in a running container", run: execCommand},
+		{name: "attach", summary: "Attach to the stdio of a detached container", run: attachCommand},
+		{name: "inspect", summary: "Show detailed information on containers and images", run: withoutContext(inspectCommand)},
+		{name: "port", summary: "List the published ports of a container", run: withoutContext(portCommand)},
+		{name: "stats", summary: "Show the resource usage of containers", run: statsCommand},
+		{name: "top", summary: "List the processes running in a container", run: withoutContext(topCommand)},
+		{name: "diff", summary: "List the files a container changed in its image", run: withoutContext(diffCommand)},
+		{name: "cp", summary: "Copy files between a container and the host", run: withoutContext(cpCommand)},
+		{name: "events", summary: "Show container and image events", run: eventsCommand},
+		{name: "compose", summary: "Run the services of a compose file (up, down, ps, logs)", run: composeCommand},
+		{name: "volume", summary: "Manage volumes", run: withoutContext(volumeCommand)},
+		{name: "network", summary: "Manage networks", run: withoutContext(networkCommand)},
+		{name: "pod", summary: "Manage pods, containers sharing namespaces (create, start, stop, ls, rm)", run: podCommand},
+		{name: "schedule", summary: "Manage schedules made with run --schedule (ls, history, rm)", run: scheduleCommand},
+		{name: "kube", summary: "Run the pod of a Kubernetes Pod or Deployment manifest (play, down)", run: kubeCommand},
+		{name: "generate", summary: "Generate systemd units for containers", run: withoutContext(generateCommand)},
+		{name: "plugin", summary: "List installed plugins", run: withoutContext(pluginCommand)},
+		{name: "container", summary: "Manage containers (prune)", run: withoutContext(containerCommand)},
+		{name: "image", summary: "Manage images (prune)", run: withoutContext(imageCommand)},
+		{name: "system", summary: "Manage floka's data and check the host (prune, info, shutdown, restore)", run: systemCommand},
+		{name: "version", summary: "Show the version of floka", run: withoutContext(versionCommand)},
+		{name: "help", summary: "Show help", run: withoutContext(helpCommand)},
+
+		{name: "containerize", run: withoutContext(containerizeCommand), internal: true},
+		{name: "shim", run: shimCommand, internal: true},
+		{name: "scheduler", run: schedulerCommand, internal: true},
+		{name: "nsexec", run: withoutContext(runNsexec), internal: true},
+		{name: "test", run: func(context.Context, []string) {}, internal: true},
+	}
+}
+
+// withoutContext adapts the handler of a command that can't be cancelled
+func withoutContext(run func(args []string)) func(context.Context, []string) {
+	return func(_ context.Context, args []string) { run(args) }
+}
+
+// findCommand returns the subcommand with the given name, nil if there is
+// none
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// usage prints the commands floka has and its global options
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: floka [OPTIONS] COMMAND [ARG...]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, c := range commands {
+		if !c.internal {
+			fmt.Fprintf(os.Stderr, "  %-11s %s\n", c.name, c.summary)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal options:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nRun 'floka COMMAND --help' for the options of a command.\n")
+}
+
+// helpCommand handles "floka help [COMMAND]", showing the help of a command
+// as its --help flag does
+func helpCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+	c := findCommand(args[0])
+	if c == nil || c.internal {
+		fmt.Printf("Error: unknown command '%s'\n", args[0])
+		os.Exit(1)
+	}
+	c.run(context.Background(), []string{"--help"})
+}
+
+// newFlagSet returns the flag set of a command, whose help shows its usage
+// line, the summary of the command and the flags. Parse it with parseFlags
+// or parseLeadingFlags.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: floka %s\n", strings.TrimSpace(name+" "+args))
+		if c := findCommand(name); c != nil && c.summary != "" {
+			fmt.Fprintf(out, "\n%s\n", c.summary)
+		}
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\nOptions:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses the arguments of a command, taking flags given after
+// its arguments as well, up to a "--". --help shows the command's help and
+// exits, invalid flags exit with status 125.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	var positional []string
+	for {
+		checkFlagsErr(fs.Parse(args))
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	// Leave the arguments for Args and NArg
+	fs.Parse(append([]string{"--"}, positional...))
+}
+
+// parseLeadingFlags parses the flags of a command up to its first
+// argument, those after it being left to the arguments as they are of the
+// command a container runs
+func parseLeadingFlags(fs *flag.FlagSet, args []string) {
+	checkFlagsErr(fs.Parse(args))
+}
+
+// checkFlagsErr exits on an error of fs.Parse, which printed it and the
+// usage already
+func checkFlagsErr(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitCodeError)
+	}
+}
+
+// isHelp reports whether an argument asks for the help of a command
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
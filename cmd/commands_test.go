@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// testFlagSet returns a flag set with the kinds of flags commands have
+func testFlagSet() (*flag.FlagSet, *bool, *string) {
+	fs := newFlagSet("test", "[OPTIONS] ARG...")
+	fs.SetOutput(io.Discard)
+	detach := fs.Bool("d", false, "Detach")
+	name := fs.String("name", "", "Name")
+	return fs, detach, name
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantDetach bool
+		wantName   string
+		wantArgs   []string
+	}{
+		{"no arguments", nil, false, "", []string{}},
+		{"flags only", []string{"-d", "--name", "web"}, true, "web", []string{}},
+		{"flags before arguments", []string{"-d", "--name=web", "a", "b"}, true, "web", []string{"a", "b"}},
+		{"flags after arguments", []string{"a", "-d", "b", "--name", "web"}, true, "web", []string{"a", "b"}},
+		{"flags between arguments", []string{"a", "--name=web", "b", "-d"}, true, "web", []string{"a", "b"}},
+		{"dash dash ends the flags", []string{"a", "--", "-d", "--name=web"}, false, "", []string{"a", "-d", "--name=web"}},
+		{"dash dash first", []string{"--", "-d"}, false, "", []string{"-d"}},
+		{"dash dash after flags", []string{"-d", "--", "a", "--name"}, true, "", []string{"a", "--name"}},
+		{"single dash is an argument", []string{"-", "-d"}, true, "", []string{"-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, detach, name := testFlagSet()
+			parseFlags(fs, tt.args)
+			if *detach != tt.wantDetach || *name != tt.wantName {
+				t.Errorf("flags are -d=%v --name=%q, want -d=%v --name=%q", *detach, *name, tt.wantDetach, tt.wantName)
+			}
+			if got := fs.Args(); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("arguments are %q, want %q", got, tt.wantArgs)
+			}
+			if fs.NArg() != len(tt.wantArgs) {
+				t.Errorf("NArg is %d, want %d", fs.NArg(), len(tt.wantArgs))
+			}
+		})
+	}
+}
+
+func TestParseLeadingFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantDetach bool
+		wantName   string
+		wantArgs   []string
+	}{
+		{"flags only", []string{"-d", "--name", "web"}, true, "web", []string{}},
+		{"flags before the command", []string{"-d", "alpine", "ls"}, true, "", []string{"alpine", "ls"}},
+		{"command flags left as they are", []string{"--name=web", "alpine", "ls", "-d", "--name", "x"}, false, "web", []string{"alpine", "ls", "-d", "--name", "x"}},
+		{"dash dash ends the flags", []string{"-d", "--", "-alpine"}, true, "", []string{"-alpine"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, detach, name := testFlagSet()
+			parseLeadingFlags(fs, tt.args)
+			if *detach != tt.wantDetach || *name != tt.wantName {
+				t.Errorf("flags are -d=%v --name=%q, want -d=%v --name=%q", *detach, *name, tt.wantDetach, tt.wantName)
+			}
+			if got := fs.Args(); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("arguments are %q, want %q", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestWithoutFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"separate values", []string{"-d", "--name", "web", "-e", "A=1"}, []string{"-e", "A=1"}},
+		{"joined values", []string{"--name=web", "-d=true", "-e=A=1"}, []string{"-e=A=1"}},
+		{"value like a flag", []string{"-e", "--name", "--name", "web"}, []string{"-e", "--name"}},
+		{"dash dash kept", []string{"-d", "--"}, []string{"--"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, _, _ := testFlagSet()
+			fs.String("e", "", "Environment")
+			parseLeadingFlags(fs, tt.args)
+			if got := withoutFlags(fs, tt.args, "d", "name"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flags kept are %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// parseExitEnv makes the test binary parse the arguments it names instead
+// of running the tests, for TestParseFlagsExit
+const parseExitEnv = "FLOKA_TEST_PARSE"
+
+func TestParseFlagsExit(t *testing.T) {
+	if mode := os.Getenv(parseExitEnv); mode != "" {
+		fs, _, _ := testFlagSet()
+		args := os.Args[len(os.Args)-1:]
+		if mode == "leading" {
+			parseLeadingFlags(fs, args)
+		} else {
+			parseFlags(fs, args)
+		}
+		os.Exit(3)
+	}
+
+	tests := []struct {
+		name     string
+		leading  bool
+		arg      string
+		wantCode int
+	}{
+		{"help", false, "--help", 0},
+		{"short help", false, "-h", 0},
+		{"help of leading flags", true, "--help", 0},
+		{"unknown flag", false, "--nope", exitCodeError},
+		{"unknown leading flag", true, "--nope", exitCodeError},
+		{"missing value", false, "--name", exitCodeError},
+		{"valid flag", false, "-d", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := "interspersed"
+			if tt.leading {
+				mode = "leading"
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestParseFlagsExit$", "--", tt.arg)
+			cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", parseExitEnv, mode))
+			err := cmd.Run()
+			code := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exited with %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestExecExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"missing file", syscall.ENOENT, exitCodeNotFound},
+		{"missing directory on the path", syscall.ENOTDIR, exitCodeNotFound},
+		{"wrapped missing file", &os.PathError{Op: "fork/exec", Path: "/nope", Err: syscall.ENOENT}, exitCodeNotFound},
+		{"not in PATH", &exec.Error{Name: "nope", Err: exec.ErrNotFound}, exitCodeNotFound},
+		{"not executable", &os.PathError{Op: "fork/exec", Path: "/etc/hostname", Err: syscall.EACCES}, exitCodeCannotInvoke},
+		{"bad format", syscall.ENOEXEC, exitCodeCannotInvoke},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := execExitCode(tt.err); got != tt.want {
+				t.Errorf("execExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// cmd/compose.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/bensdz/floka/pkg/compose"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+	"github.com/bensdz/floka/pkg/logdriver"
+)
+
+// composeCommand handles "floka compose [-f FILE] [-p NAME] COMMAND",
+// managing the containers of the services defined in a compose file
+func composeCommand(ctx context.Context, args []string) {
+	composeFlags := newFlagSet("compose", "[-f FILE] [-p NAME] up|down|ps|logs")
+	file := composeFlags.String("f", "", "Compose file (default: compose.yaml in the current directory)")
+	composeFlags.StringVar(file, "file", "", "Same as -f")
+	projectName := composeFlags.String("p", "", "Project name (default: the directory of the compose file)")
+	composeFlags.StringVar(projectName, "project-name", "", "Same as -p")
+	parseLeadingFlags(composeFlags, args)
+
+	if composeFlags.NArg() < 1 {
+		fmt.Println("Error: 'compose' requires a command")
+		fmt.Println("Usage: floka compose [-f FILE] [-p NAME] up|down|ps|logs")
+		os.Exit(1)
+	}
+
+	path := *file
+	if path == "" {
+		var err error
+		if path, err = compose.FindFile("."); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	project, err := compose.Load(path, *projectName)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	subArgs := composeFlags.Args()[1:]
+	switch composeFlags.Arg(0) {
+	case "up":
+		composeUp(ctx, project, subArgs)
+	case "down":
+		composeDown(ctx, project, subArgs)
+	case "ps":
+		composePs(project, subArgs)
+	case "logs":
+		composeLogs(ctx, project, subArgs)
+	default:
+		fmt.Printf("Error: unknown compose command '%s'\n", composeFlags.Arg(0))
+		fmt.Println("Usage: floka compose [-f FILE] [-p NAME] up|down|ps|logs")
+		os.Exit(1)
+	}
+}
+
+// composeUp handles "floka compose up [--build] [SERVICE...]", starting the
+// services and the services they depend on in the background, each after
+// its dependencies. Running containers are left alone and stopped ones
+// are replaced.
+func composeUp(ctx context.Context, project *compose.Project, args []string) {
+	upFlags := newFlagSet("compose up", "[--build] [SERVICE...]")
+	build := upFlags.Bool("build", false, "Build images before starting, even if they exist")
+	parseFlags(upFlags, args)
+
+	services, err := project.Order(upFlags.Args())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	existing := projectContainers(project)
+
+	for _, service := range services {
+		name := project.ContainerName(service)
+		if cont := existing[service.Name]; cont != nil {
+			if cont.IsRunning() || cont.Status == "restarting" {
+				fmt.Printf("Container %s Running\n", name)
+				continue
+			}
+			if err := cont.Remove(); err != nil {
+				fmt.Printf("Error removing container %s: %s\n", name, err)
+				os.Exit(1)
+			}
+			releaseVolumes(containerVolumes(cont))
+		}
+
+		image := project.ImageRef(service)
+		if service.Build != nil {
+			if _, err := fimage.Lookup(image); *build || errors.Is(err, fimage.ErrImageNotFound) {
+				buildImage(ctx, fimage.BuildOptions{
+					Flokafile:  service.Build.Flokafile,
+					ContextDir: service.Build.Context,
+					Tag:        image,
+					BuildArgs:  service.Build.Args,
+					Target:     service.Build.Target,
+				})
+			}
+		}
+
+		runContainerWithOpts(ctx, image, service.Command, runOptions{
+			detach:  true,
+			quiet:   true,
+			name:    name,
+			env:     service.Environment,
+			volumes: service.Volumes,
+			publish: service.Ports,
+			restart: service.Restart,
+			labels: stringList{
+				compose.LabelProject + "=" + project.Name,
+				compose.LabelService + "=" + service.Name,
+			},
+		})
+		fmt.Printf("Container %s Started\n", name)
+	}
+}
+
+// composeDown handles "floka compose down", stopping and removing the
+// containers of the project, dependents first. Containers of services no
+// longer in the compose file go as well.
+func composeDown(ctx context.Context, project *compose.Project, args []string) {
+	downFlags := newFlagSet("compose down", "")
+	parseFlags(downFlags, args)
+
+	failed := false
+	for _, cont := range sortedProjectContainers(project, true) {
+		name := cont.Name
+		if cont.IsRunning() || cont.Status == "restarting" {
+			if err := cont.Stop(ctx, container.DefaultStopTimeout); err != nil {
+				fmt.Printf("Error stopping container %s: %s\n", name, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Container %s Stopped\n", name)
+		}
+		if err := cont.Remove(); err != nil {
+			fmt.Printf("Error removing container %s: %s\n", name, err)
+			failed = true
+			continue
+		}
+		releaseVolumes(containerVolumes(cont))
+		fmt.Printf("Container %s Removed\n", name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// composePs handles "floka compose ps [-a]", listing the containers of the
+// project
+func composePs(project *compose.Project, args []string) {
+	psFlags := newFlagSet("compose ps", "[-a]")
+	all := psFlags.Bool("a", false, "Show all containers, not only running ones")
+	psFlags.BoolVar(all, "all", false, "Same as -a")
+	parseFlags(psFlags, args)
+
+	fmt.Printf("%-30s %-20s %-28s %s\n", "NAME", "SERVICE", "STATUS", "PORTS")
+	for _, cont := range sortedProjectContainers(project, false) {
+		if !*all && !cont.IsRunning() && cont.Status != "restarting" {
+			continue
+		}
+		row := newPsRow(cont)
+		fmt.Printf("%-30s %-20s %-28s %s\n", cont.Name, cont.Labels[compose.LabelService], row.Status, row.Ports)
+	}
+}
+
+// composeLogs handles "floka compose logs [-f] [SERVICE...]", printing the
+// logs of the services' containers with each line prefixed by its service
+func composeLogs(ctx context.Context, project *compose.Project, args []string) {
+	logsFlags := newFlagSet("compose logs", "[-f] [SERVICE...]")
+	follow := logsFlags.Bool("f", false, "Follow log output")
+	logsFlags.BoolVar(follow, "follow", false, "Follow log output")
+	parseFlags(logsFlags, args)
+
+	for _, name := range logsFlags.Args() {
+		if _, ok := project.Services[name]; !ok {
+			fmt.Printf("Error: no such service: %s\n", name)
+			os.Exit(1)
+		}
+	}
+	var containers []*container.Container
+	width := 0
+	for _, cont := range sortedProjectContainers(project, false) {
+		service := cont.Labels[compose.LabelService]
+		if logsFlags.NArg() > 0 && !contains(logsFlags.Args(), service) {
+			continue
+		}
+		containers = append(containers, cont)
+		if len(service) > width {
+			width = len(service)
+		}
+	}
+
+	// Lines of containers followed together are written whole
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := false
+	for _, cont := range containers {
+		prefix := fmt.Sprintf("%-*s | ", width, cont.Labels[compose.LabelService])
+		read := func(cont *container.Container) {
+			opts := logdriver.ReadOptions{Follow: *follow}
+			if *follow {
+				opts.Done = followDone(ctx, cont)
+			}
+			err := readContainerLogs(cont, opts, func(msg *logdriver.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				out := os.Stdout
+				if msg.Source == "stderr" {
+					out = os.Stderr
+				}
+				_, err := fmt.Fprintf(out, "%s%s\n", prefix, msg.Line)
+				return err
+			})
+			if err != nil {
+				mu.Lock()
+				fmt.Printf("Error reading logs of %s: %s\n", cont.Name, err)
+				failed = true
+				mu.Unlock()
+			}
+		}
+		if !*follow {
+			read(cont)
+			continue
+		}
+		wg.Add(1)
+		go func(cont *container.Container) {
+			defer wg.Done()
+			read(cont)
+		}(cont)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readContainerLogs reads back the logs of a container through its log
+// driver
+func readContainerLogs(cont *container.Container, opts logdriver.ReadOptions, fn func(*logdriver.Message) error) error {
+	driver, err := cont.LogDriver()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	err = logdriver.ReadLogs(driver, opts, fn)
+	if errors.Is(err, logdriver.ErrReadNotSupported) {
+		return fmt.Errorf("the %s log driver does not support reading", cont.LogConfig.Type)
+	}
+	return err
+}
+
+// projectContainers returns the containers of the project by service
+func projectContainers(project *compose.Project) map[string]*container.Container {
+	containers, err := container.ListContainers(&container.ListOptions{
+		Labels: []string{compose.LabelProject + "=" + project.Name},
+	})
+	if err != nil {
+		fmt.Printf("Error listing containers: %s\n", err)
+		os.Exit(1)
+	}
+	byService := map[string]*container.Container{}
+	for _, cont := range containers {
+		byService[cont.Labels[compose.LabelService]] = cont
+	}
+	return byService
+}
+
+// sortedProjectContainers returns the containers of the project with
+// dependencies first or, when reverse is set, dependents first.
+// Containers of services no longer defined come last, or first.
+func sortedProjectContainers(project *compose.Project, reverse bool) []*container.Container {
+	services, err := project.Order(nil)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	rank := map[string]int{}
+	for i, service := range services {
+		rank[service.Name] = i
+	}
+
+	var containers []*container.Container
+	for _, cont := range projectContainers(project) {
+		containers = append(containers, cont)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		si, sj := containers[i].Labels[compose.LabelService], containers[j].Labels[compose.LabelService]
+		ri, oki := rank[si]
+		rj, okj := rank[sj]
+		if !oki {
+			ri = len(services)
+		}
+		if !okj {
+			rj = len(services)
+		}
+		if ri == rj {
+			return si < sj
+		}
+		if reverse {
+			return ri > rj
+		}
+		return ri < rj
+	})
+	return containers
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
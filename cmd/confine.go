@@ -0,0 +1,171 @@
+// cmd/confine.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// confinement is what restricts the processes of a container
+type confinement struct {
+	caps       []string          // Capabilities they keep, all when nil
+	seccomp    []unix.SockFilter // Seccomp filter, none when nil
+	noNewPrivs bool              // Keep them from gaining privileges through setuid and file capabilities
+}
+
+// containerProcess returns the command starting a container process as
+// the given user, nil to stay root, under conf. Unless conf restricts
+// nothing, it goes through "floka confine", which restricts itself right
+// before executing the command since exec.Cmd can't. The command's Err is
+// set when it isn't found.
+//
+// The process gets a cgroup namespace of its own. We are in the
+// container's cgroup by then, so the container only sees its own subtree.
+func containerProcess(command []string, credential *syscall.Credential, conf confinement) (*exec.Cmd, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	if cmd.Err != nil || (conf.caps == nil && conf.seccomp == nil && !conf.noNewPrivs) {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWCGROUP, Credential: credential}
+		return cmd, nil
+	}
+
+	args := []string{"confine", "--caps", container.FormatCapabilities(conf.caps)}
+	if conf.noNewPrivs {
+		args = append(args, "--no-new-privs")
+	}
+	if credential != nil {
+		groups := make([]string, len(credential.Groups))
+		for i, g := range credential.Groups {
+			groups[i] = strconv.FormatUint(uint64(g), 10)
+		}
+		args = append(args, "--uid", strconv.FormatUint(uint64(credential.Uid), 10),
+			"--gid", strconv.FormatUint(uint64(credential.Gid), 10), "--groups", strings.Join(groups, ","))
+	}
+	var extraFiles []*os.File
+	if conf.seccomp != nil {
+		// The filter, at most 32 KiB, fits in the pipe buffer
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pipe: %w", err)
+		}
+		defer w.Close()
+		if err := container.WriteSeccompFilter(w, conf.seccomp); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to pass seccomp filter: %w", err)
+		}
+		extraFiles = append(extraFiles, r)
+		args = append(args, "--seccomp")
+	}
+	args = append(args, cmd.Path)
+	args = append(args, command...)
+
+	// Our executable is still reachable through /proc once the host's
+	// filesystem is gone
+	confined := exec.Command("/proc/self/exe", args...)
+	confined.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWCGROUP}
+	confined.ExtraFiles = extraFiles
+	return confined, nil
+}
+
+// runConfined restricts itself to the capabilities given and the seccomp
+// filter it reads from fd 3, sets no_new_privs if asked to, switches to
+// the user given and executes the command. It is the helper started by
+// containerProcess.
+func runConfined(args []string) {
+	confineFlags := flag.NewFlagSet("confine", flag.ExitOnError)
+	caps := confineFlags.String("caps", container.AllCapabilities, "Comma-separated capabilities to keep, or ALL")
+	seccomp := confineFlags.Bool("seccomp", false, "Install the seccomp filter read from fd 3")
+	noNewPrivs := confineFlags.Bool("no-new-privs", false, "Keep the command from gaining privileges through setuid and file capabilities")
+	uid := confineFlags.Int("uid", -1, "Run as this user ID")
+	gid := confineFlags.Int("gid", 0, "Run with this group ID")
+	groups := confineFlags.String("groups", "", "Comma-separated supplementary group IDs")
+	confineFlags.Parse(args)
+
+	if confineFlags.NArg() < 2 {
+		fmt.Println("Error: not enough arguments for confine")
+		fmt.Println("Usage: confine [--caps CAP,...] [--seccomp] [--no-new-privs] [--uid UID --gid GID --groups GID,...] PATH ARG0 [ARG...]")
+		os.Exit(1)
+	}
+	fail := func(format string, a ...any) {
+		fmt.Fprintf(os.Stderr, "Error: "+format+"\n", a...)
+		os.Exit(exitCodeCannotInvoke)
+	}
+
+	// The bounding and other capability sets belong to a thread, the one
+	// executing the command
+	runtime.LockOSThread()
+	keep := container.ParseCapabilities(*caps)
+	if keep != nil {
+		if err := container.DropCapabilities(keep); err != nil {
+			fail("%s", err)
+		}
+	}
+
+	if *noNewPrivs {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			fail("failed to set no_new_privs: %s", err)
+		}
+	}
+
+	// Without no_new_privs, installing the filter takes CAP_SYS_ADMIN,
+	// which is dropped below
+	if *seccomp {
+		filterFile := os.NewFile(3, "seccomp")
+		filter, err := container.ReadSeccompFilter(filterFile)
+		filterFile.Close()
+		if err != nil {
+			fail("%s", err)
+		}
+		if err := container.InstallSeccomp(filter); err != nil {
+			fail("%s", err)
+		}
+	}
+
+	if *uid >= 0 {
+		var gids []int
+		for _, g := range strings.Split(*groups, ",") {
+			if g == "" {
+				continue
+			}
+			id, err := strconv.Atoi(g)
+			if err != nil {
+				fail("invalid group %q", g)
+			}
+			gids = append(gids, id)
+		}
+		if err := syscall.Setgroups(gids); err != nil {
+			fail("failed to set groups: %s", err)
+		}
+		if err := syscall.Setgid(*gid); err != nil {
+			fail("failed to set group: %s", err)
+		}
+		if err := syscall.Setuid(*uid); err != nil {
+			fail("failed to set user: %s", err)
+		}
+	}
+
+	// Other users lost their capabilities switching to it, root keeps
+	// those of the bounding set
+	if keep != nil {
+		if *uid > 0 {
+			keep = []string{}
+		}
+		if err := container.SetCapabilities(keep); err != nil {
+			fail("%s", err)
+		}
+	}
+
+	command := confineFlags.Args()
+	err := syscall.Exec(command[0], command[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %s\n", command[1], err)
+	os.Exit(execExitCode(err))
+}
@@ -0,0 +1,53 @@
+// cmd/container.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// containerCommand handles "floka container prune"
+func containerCommand(args []string) {
+	if len(args) < 1 {
+		containerUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		containerUsage()
+		return
+	}
+
+	switch args[0] {
+	case "prune":
+		reclaimed, err := pruneContainers()
+		fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+		if err != nil {
+			fmt.Printf("Error pruning containers: %s\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		containerUsage()
+		os.Exit(1)
+	}
+}
+
+func containerUsage() {
+	fmt.Println("Usage: floka container COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  prune    Remove all containers that are not running")
+}
+
+// pruneContainers removes the containers that are not running, releasing
+// their volumes, prints their IDs and returns the disk space freed
+func pruneContainers() (int64, error) {
+	removed, reclaimed, err := container.Prune()
+	for _, cont := range removed {
+		releaseVolumes(containerVolumes(cont))
+		fmt.Printf("Deleted container: %s\n", cont.ID)
+	}
+	return reclaimed, err
+}
@@ -0,0 +1,60 @@
+// cmd/cp.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// cpCommand handles "floka cp CONTAINER:SRC DEST" and "floka cp SRC
+// CONTAINER:DEST", copying files between the host and a container
+func cpCommand(args []string) {
+	cpFlags := newFlagSet("cp", "CONTAINER:SRC_PATH DEST_PATH | SRC_PATH CONTAINER:DEST_PATH")
+	parseFlags(cpFlags, args)
+
+	if cpFlags.NArg() != 2 {
+		fmt.Println("Error: 'cp' requires 2 arguments")
+		fmt.Println("Usage: floka cp CONTAINER:SRC_PATH DEST_PATH")
+		fmt.Println("       floka cp SRC_PATH CONTAINER:DEST_PATH")
+		os.Exit(1)
+	}
+
+	srcContainer, srcPath := splitCopyPath(cpFlags.Arg(0))
+	dstContainer, dstPath := splitCopyPath(cpFlags.Arg(1))
+	if (srcContainer == "") == (dstContainer == "") {
+		fmt.Println("Error: exactly one of the paths must be in a container, as CONTAINER:PATH")
+		os.Exit(1)
+	}
+
+	ref := srcContainer + dstContainer
+	cont, err := container.Find(ref)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if srcContainer != "" {
+		err = cont.CopyFrom(srcPath, dstPath)
+	} else {
+		err = cont.CopyTo(srcPath, dstPath)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// splitCopyPath splits CONTAINER:PATH. Paths starting with / or . are on
+// the host, even with a colon in them.
+func splitCopyPath(arg string) (containerRef, path string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	ref, path, ok := strings.Cut(arg, ":")
+	if !ok || ref == "" || strings.Contains(ref, "/") {
+		return "", arg
+	}
+	return ref, path
+}
@@ -0,0 +1,48 @@
+// cmd/create.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// createCommand handles "floka create [OPTIONS] IMAGE [COMMAND] [ARG...]",
+// which takes the options of "floka run" but only sets the container up,
+// for "floka start" to start it later
+func createCommand(ctx context.Context, args []string) {
+	createFlags := newFlagSet("create", "[OPTIONS] IMAGE [COMMAND] [ARG...]")
+	var runOpts runOptions
+	addRunFlags(createFlags, &runOpts)
+	parseFlags(createFlags, args)
+	runOpts.visit(createFlags)
+
+	if createFlags.NArg() < 1 {
+		fmt.Println("Error: 'create' requires at least 1 argument")
+		fmt.Println("Usage: floka create [OPTIONS] IMAGE [COMMAND] [ARG...]")
+		os.Exit(exitCodeError)
+	}
+
+	// Keep stdout for the container ID
+	if fimage.Progress == os.Stdout {
+		fimage.Progress = os.Stderr
+	}
+
+	image, command, opts, volumes := containerOpts(ctx, createFlags.Arg(0), createFlags.Args()[1:], runOpts)
+	cont, err := container.Create(image, command, opts)
+	if err != nil {
+		fmt.Printf("Error creating container: %s\n", err)
+		if cont != nil {
+			if removeErr := cont.Remove(); removeErr != nil {
+				logging.L().Warn("failed to remove container", "container", cont.ID, "err", removeErr)
+			}
+		}
+		releaseVolumes(volumes)
+		os.Exit(exitCodeError)
+	}
+	fmt.Println(cont.ID)
+}
@@ -0,0 +1,36 @@
+// cmd/diff.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// diffCommand handles "floka diff CONTAINER", printing the paths the
+// container added (A), changed (C) or deleted (D) relative to its image
+func diffCommand(args []string) {
+	diffFlags := newFlagSet("diff", "CONTAINER")
+	parseFlags(diffFlags, args)
+
+	if diffFlags.NArg() != 1 {
+		fmt.Println("Error: 'diff' requires exactly 1 argument")
+		fmt.Println("Usage: floka diff CONTAINER")
+		os.Exit(1)
+	}
+
+	cont, err := container.Find(diffFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	changes, err := cont.Diff()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
+	}
+}
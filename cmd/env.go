@@ -0,0 +1,56 @@
+// cmd/env.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseEnv turns -e values into KEY=VALUE entries. A bare KEY takes its
+// value from our own environment and is skipped if it isn't set there.
+func parseEnv(values []string) ([]string, error) {
+	var env []string
+	for _, v := range values {
+		key, _, hasValue := strings.Cut(v, "=")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid environment variable %q", v)
+		}
+		if hasValue {
+			env = append(env, v)
+		} else if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return env, nil
+}
+
+// readEnvFile reads KEY=VALUE lines from a file given with --env-file.
+// Blank lines and lines starting with # are ignored.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer f.Close()
+
+	var values []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %w", path, err)
+	}
+
+	env, err := parseEnv(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
@@ -0,0 +1,143 @@
+// cmd/events.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/events"
+)
+
+// eventsCommand handles "floka events [--since TIME] [--until TIME]
+// [--filter KEY=VALUE] [--format json|TEMPLATE]", printing the recorded
+// events from --since and then the new ones as they happen, until --until
+// or until interrupted
+func eventsCommand(ctx context.Context, args []string) {
+	eventsFlags := newFlagSet("events", "[--since TIME] [--until TIME] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+	since := eventsFlags.String("since", "", "Show events since a time (RFC 3339, Unix seconds or a duration like 10m)")
+	until := eventsFlags.String("until", "", "Stop at a time (RFC 3339, Unix seconds or a duration like 10m)")
+	var filters stringList
+	eventsFlags.Var(&filters, "filter", "Filter events by type=, event=, container= or image= (repeatable)")
+	eventsFlags.Var(&filters, "f", "Same as --filter")
+	format := eventsFlags.String("format", "", "Print events as JSON lines with json, or using a Go template")
+	parseFlags(eventsFlags, args)
+
+	if eventsFlags.NArg() > 0 {
+		fmt.Println("Error: 'events' accepts no arguments")
+		fmt.Println("Usage: floka events [--since TIME] [--until TIME] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+		os.Exit(1)
+	}
+
+	filter, err := parseEventFilters(filters)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	now := time.Now()
+	if *since != "" {
+		if filter.Since, err = parseEventTime(*since, now); err != nil {
+			fmt.Printf("Error: invalid --since: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = parseEventTime(*until, now); err != nil {
+			fmt.Printf("Error: invalid --until: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	eventFormat := parseListFormat(*format)
+	print := func(e events.Event) {
+		if eventFormat != nil {
+			eventFormat.print(e)
+		} else {
+			fmt.Println(formatEvent(e))
+		}
+	}
+
+	// Past events are only shown when asked for
+	if !filter.Since.IsZero() {
+		past, err := events.Read(filter)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		for _, e := range past {
+			print(e)
+		}
+	}
+	if !filter.Until.IsZero() && !filter.Until.After(now) {
+		return
+	}
+	if err := events.Follow(ctx, filter, print); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseEventFilters turns --filter values into an events filter
+func parseEventFilters(filters []string) (*events.Filter, error) {
+	filter := &events.Filter{}
+	for _, f := range filters {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid filter %q, expected KEY=VALUE", f)
+		}
+		switch key {
+		case "type":
+			if value != events.TypeContainer && value != events.TypeImage {
+				return nil, fmt.Errorf("invalid type %q, expected container or image", value)
+			}
+			filter.Types = append(filter.Types, value)
+		case "event":
+			filter.Actions = append(filter.Actions, value)
+		case "container":
+			filter.Containers = append(filter.Containers, value)
+		case "image":
+			filter.Images = append(filter.Images, value)
+		default:
+			return nil, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	return filter, nil
+}
+
+// parseEventTime parses a time given as RFC 3339, as Unix seconds or as a
+// duration before now
+func parseEventTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time or duration", s)
+}
+
+// formatEvent formats an event as a line of text, e.g.
+// 2024-01-02T15:04:05.000000000Z container die 3f2a... (exitCode=0, image=alpine:latest)
+func formatEvent(e events.Event) string {
+	line := fmt.Sprintf("%s %s %s %s", e.Time.Local().Format(time.RFC3339Nano), e.Type, e.Action, e.ID)
+	if len(e.Attributes) == 0 {
+		return line
+	}
+	keys := make([]string, 0, len(e.Attributes))
+	for key := range e.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]string, len(keys))
+	for i, key := range keys {
+		attrs[i] = key + "=" + e.Attributes[key]
+	}
+	return line + " (" + strings.Join(attrs, ", ") + ")"
+}
@@ -0,0 +1,120 @@
+// cmd/exec.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// execCommand handles "floka exec [-i] [-t] [-u USER] CONTAINER COMMAND [ARG...]"
+func execCommand(ctx context.Context, args []string) {
+	execFlags := newFlagSet("exec", "[-i] [-t] [-u USER] CONTAINER COMMAND [ARG...]")
+	interactive := execFlags.Bool("i", false, "Keep stdin attached to the command")
+	tty := execFlags.Bool("t", false, "Allocate a pseudo-terminal")
+	execFlags.BoolVar(interactive, "interactive", false, "Keep stdin attached to the command")
+	execFlags.BoolVar(tty, "tty", false, "Allocate a pseudo-terminal")
+	user := execFlags.String("u", "", "Run as a user NAME|UID[:GROUP|GID] instead of the container's")
+	execFlags.StringVar(user, "user", "", "Same as -u")
+	parseLeadingFlags(execFlags, args)
+
+	if execFlags.NArg() < 2 {
+		fmt.Println("Error: 'exec' requires at least 2 arguments")
+		fmt.Println("Usage: floka exec [-i] [-t] [-u USER] CONTAINER COMMAND [ARG...]")
+		os.Exit(exitCodeError)
+	}
+
+	cont, err := container.Find(execFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(exitCodeError)
+	}
+	command := execFlags.Args()[1:]
+
+	opts := &container.ExecOptions{Stdout: os.Stdout, Stderr: os.Stderr, User: *user}
+	if *interactive {
+		opts.Stdin = os.Stdin
+	}
+
+	if !*tty {
+		exitCode, err := cont.Exec(ctx, command, opts)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		os.Exit(exitCode)
+	}
+
+	os.Exit(execWithTTY(ctx, cont, command, *interactive, *user))
+}
+
+// execWithTTY runs the command on a new pseudo-terminal and relays it to
+// our own stdio, putting our terminal in raw mode while it runs
+func execWithTTY(ctx context.Context, cont *container.Container, command []string, interactive bool, user string) int {
+	master, slave, err := term.OpenPTY()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return exitCodeError
+	}
+	defer master.Close()
+
+	stdinFd := os.Stdin.Fd()
+	if term.IsTerminal(stdinFd) {
+		if ws, err := term.GetWinsize(stdinFd); err == nil {
+			term.SetWinsize(master.Fd(), ws)
+		}
+
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		defer signal.Stop(resize)
+		go func() {
+			for range resize {
+				if ws, err := term.GetWinsize(stdinFd); err == nil {
+					term.SetWinsize(master.Fd(), ws)
+				}
+			}
+		}()
+
+		if interactive {
+			restore, err := term.MakeRaw(stdinFd)
+			if err != nil {
+				logging.L().Warn("failed to put terminal in raw mode", "err", err)
+			} else {
+				defer restore()
+			}
+		}
+	}
+
+	if interactive {
+		go io.Copy(master, os.Stdin)
+	}
+	outputDone := make(chan struct{})
+	go func() {
+		// Reading the master fails with EIO once the command is gone
+		io.Copy(os.Stdout, master)
+		close(outputDone)
+	}()
+
+	exitCode, err := cont.Exec(ctx, command, &container.ExecOptions{
+		Stdin:  slave,
+		Stdout: slave,
+		Stderr: slave,
+		TTY:    true,
+		User:   user,
+	})
+	slave.Close()
+	<-outputDone
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return exitCodeError
+	}
+	return exitCode
+}
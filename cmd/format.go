@@ -0,0 +1,52 @@
+// cmd/format.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// listFormat is the --format of a listing: "json" prints each row as a
+// JSON object on a line of its own, for jq and scripts, anything else is a
+// Go template executed with each row
+type listFormat struct {
+	json bool
+	tmpl *template.Template
+}
+
+// parseListFormat parses a --format value, exiting if the template is
+// invalid. Without one it returns nil, for the table.
+func parseListFormat(format string) *listFormat {
+	if format == "" {
+		return nil
+	}
+	if format == "json" {
+		return &listFormat{json: true}
+	}
+	tmpl, err := template.New("format").Funcs(formatFuncs).Parse(format)
+	if err != nil {
+		fmt.Printf("Error parsing format: %s\n", err)
+		os.Exit(1)
+	}
+	return &listFormat{tmpl: tmpl}
+}
+
+// print prints a row of the listing
+func (f *listFormat) print(row interface{}) {
+	if f.json {
+		out, err := json.Marshal(row)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if err := f.tmpl.Execute(os.Stdout, row); err != nil {
+		fmt.Printf("Error executing format: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
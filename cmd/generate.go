@@ -0,0 +1,117 @@
+// cmd/generate.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/systemd"
+)
+
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// generateCommand handles "floka generate systemd [OPTIONS] CONTAINER..."
+func generateCommand(args []string) {
+	if len(args) == 1 && isHelp(args[0]) {
+		fmt.Println("Usage: floka generate systemd [OPTIONS] CONTAINER...")
+		fmt.Println("")
+		fmt.Println("Run 'floka generate systemd --help' for the options.")
+		return
+	}
+	if len(args) < 1 || args[0] != "systemd" {
+		fmt.Println("Error: 'generate' requires a generator name")
+		fmt.Println("Usage: floka generate systemd [OPTIONS] CONTAINER...")
+		os.Exit(1)
+	}
+
+	genFlags := newFlagSet("generate systemd", "[OPTIONS] CONTAINER...")
+	restartPolicy := genFlags.String("restart-policy", "", "Restart policy (no, on-failure[:max], always, unless-stopped), by default the container's own or on-failure")
+	stopTimeout := genFlags.Int("stop-timeout", 10, "Seconds to wait for the container to stop before killing it")
+	toFiles := genFlags.Bool("files", false, "Write unit files to the current directory instead of stdout")
+	var requires stringList
+	genFlags.Var(&requires, "requires", "Container that must be started before these ones (repeatable)")
+	parseFlags(genFlags, args[1:])
+
+	if genFlags.NArg() < 1 {
+		fmt.Println("Error: 'generate systemd' requires at least 1 container")
+		fmt.Println("Usage: floka generate systemd [OPTIONS] CONTAINER...")
+		os.Exit(1)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error locating floka executable: %s\n", err)
+		os.Exit(1)
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("Error getting working directory: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Dependencies may be given by name or ID prefix too
+	for i, ref := range requires {
+		dep, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		requires[i] = dep.ID
+	}
+
+	opts := systemd.UnitOptions{
+		Executable:    executable,
+		DataRoot:      config.Root(),
+		RestartPolicy: *restartPolicy,
+		StopTimeout:   *stopTimeout,
+		Requires:      requires,
+	}
+
+	for _, id := range genFlags.Args() {
+		cont, err := container.Find(id)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		// systemd takes over restarting the container from its supervisor
+		opts.RestartPolicy = *restartPolicy
+		if opts.RestartPolicy == "" {
+			opts.RestartPolicy = cont.RestartPolicy
+			if opts.RestartPolicy == "" || opts.RestartPolicy == container.RestartNo {
+				opts.RestartPolicy = container.RestartOnFailure
+			}
+		}
+		unit, err := systemd.GenerateUnit(cont, opts)
+		if err != nil {
+			fmt.Printf("Error generating unit for container %s: %s\n", id, err)
+			os.Exit(1)
+		}
+
+		if !*toFiles {
+			fmt.Print(unit)
+			continue
+		}
+
+		unitPath := filepath.Join(workingDir, systemd.UnitName(cont.ID))
+		if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+			fmt.Printf("Error writing unit file: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(unitPath)
+	}
+}
@@ -0,0 +1,71 @@
+// cmd/history.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// historyCreatedByWidth is where the CREATED BY column is cut without
+// --no-trunc
+const historyCreatedByWidth = 45
+
+// historyCommand handles "floka history [-q] [--no-trunc] IMAGE", listing
+// the steps that made an image newest first
+func historyCommand(args []string) {
+	historyFlags := newFlagSet("history", "[-q] [--no-trunc] IMAGE")
+	quiet := historyFlags.Bool("q", false, "Only show image IDs")
+	noTrunc := historyFlags.Bool("no-trunc", false, "Don't truncate the output")
+	parseFlags(historyFlags, args)
+
+	if historyFlags.NArg() != 1 {
+		fmt.Println("Error: 'history' requires exactly 1 argument")
+		fmt.Println("Usage: floka history [-q] [--no-trunc] IMAGE")
+		os.Exit(1)
+	}
+
+	img, err := fimage.Lookup(historyFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Only the image itself has an ID, the steps below made images that
+	// may not exist here
+	id := img.ID
+	if !*noTrunc {
+		id = strings.TrimPrefix(id, "sha256:")[:12]
+	}
+	if *quiet {
+		for i := range img.LayerHistory() {
+			if i == 0 {
+				fmt.Println(id)
+			} else {
+				fmt.Println("<missing>")
+			}
+		}
+		return
+	}
+
+	fmt.Printf("%-14s %-16s %-*s %-10s %s\n", "IMAGE", "CREATED", historyCreatedByWidth, "CREATED BY", "SIZE", "COMMENT")
+	for i, entry := range img.LayerHistory() {
+		rowID := "<missing>"
+		if i == 0 {
+			rowID = id
+		}
+		created := "N/A"
+		if !entry.Created.IsZero() {
+			created = container.HumanDuration(time.Since(entry.Created)) + " ago"
+		}
+		createdBy := strings.Join(strings.Fields(entry.CreatedBy), " ")
+		if !*noTrunc && len(createdBy) > historyCreatedByWidth {
+			createdBy = createdBy[:historyCreatedByWidth-3] + "..."
+		}
+		fmt.Printf("%-14s %-16s %-*s %-10s %s\n", rowID, created, historyCreatedByWidth, createdBy, humanSize(entry.Size), entry.Comment)
+	}
+}
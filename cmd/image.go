@@ -0,0 +1,63 @@
+// cmd/image.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// imageCommand handles "floka image prune [-a]"
+func imageCommand(args []string) {
+	if len(args) < 1 {
+		imageUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		imageUsage()
+		return
+	}
+
+	switch args[0] {
+	case "prune":
+		pruneFlags := newFlagSet("image prune", "[-a]")
+		all := pruneFlags.Bool("a", false, "Remove all images not used by a container, not just untagged ones")
+		pruneFlags.BoolVar(all, "all", false, "Remove all images not used by a container, not just untagged ones")
+		parseFlags(pruneFlags, args[1:])
+
+		reclaimed, err := pruneImages(*all)
+		fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+		if err != nil {
+			fmt.Printf("Error pruning images: %s\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		imageUsage()
+		os.Exit(1)
+	}
+}
+
+func imageUsage() {
+	fmt.Println("Usage: floka image COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  prune [-a]    Remove untagged images, or all images not used by a container")
+}
+
+// pruneImages removes untagged images, or with all every image no
+// container uses, prints what was removed and returns the disk space freed
+func pruneImages(all bool) (int64, error) {
+	result, err := fimage.Prune(all)
+	if result == nil {
+		return 0, err
+	}
+	for _, tag := range result.Untagged {
+		fmt.Printf("Untagged: %s\n", tag)
+	}
+	for _, id := range result.Deleted {
+		fmt.Printf("Deleted: %s\n", id)
+	}
+	return result.Size, err
+}
@@ -0,0 +1,139 @@
+// cmd/images.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// imagesRow is what a floka images --format template is executed with,
+// and what --format json prints
+type imagesRow struct {
+	ID           string // Short image ID
+	Repository   string
+	Tag          string
+	Digest       string // Manifest digest
+	CreatedSince string // Human readable, e.g. "2 hours ago"
+	CreatedAt    string
+	Size         string // Human readable, e.g. "5.6MB"
+}
+
+// imagesCommand handles "floka images [-q] [--digests] [--filter KEY=VALUE] [--format json|TEMPLATE]"
+func imagesCommand(args []string) {
+	imagesFlags := newFlagSet("images", "[-q] [--digests] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+	var filters stringList
+	imagesFlags.Var(&filters, "filter", "Filter images by label=KEY[=VALUE] or reference=PATTERN (repeatable)")
+	imagesFlags.Var(&filters, "f", "Same as --filter")
+	quiet := imagesFlags.Bool("q", false, "Only print image IDs")
+	imagesFlags.BoolVar(quiet, "quiet", false, "Same as -q")
+	digests := imagesFlags.Bool("digests", false, "Show manifest digests")
+	format := imagesFlags.String("format", "", "Print each image as json or using a Go template")
+	parseFlags(imagesFlags, args)
+
+	if imagesFlags.NArg() > 0 {
+		fmt.Println("Error: 'images' accepts no arguments")
+		fmt.Println("Usage: floka images [-q] [--digests] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+		os.Exit(1)
+	}
+
+	rowFormat := parseListFormat(*format)
+
+	opts, err := parseImageFilters(filters)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	images, err := fimage.GetImagesFromLocalStorage(opts)
+	if err != nil {
+		fmt.Printf("Error listing images: %v\n", err)
+		os.Exit(1)
+	}
+
+	// An image with several references is listed once per reference, but
+	// its ID only once
+	if *quiet {
+		seen := map[string]bool{}
+		for _, img := range images {
+			if row := newImagesRow(img); !seen[row.ID] {
+				seen[row.ID] = true
+				fmt.Println(row.ID)
+			}
+		}
+		return
+	}
+
+	if rowFormat != nil {
+		for _, img := range images {
+			rowFormat.print(newImagesRow(img))
+		}
+		return
+	}
+
+	if *digests {
+		fmt.Printf("%-20s %-20s %-72s %-14s %-16s %s\n", "REPOSITORY", "TAG", "DIGEST", "IMAGE ID", "CREATED", "SIZE")
+	} else {
+		fmt.Printf("%-20s %-20s %-14s %-16s %s\n", "REPOSITORY", "TAG", "IMAGE ID", "CREATED", "SIZE")
+	}
+	for _, img := range images {
+		row := newImagesRow(img)
+		if *digests {
+			fmt.Printf("%-20s %-20s %-72s %-14s %-16s %s\n", row.Repository, row.Tag, row.Digest, row.ID, row.CreatedSince, row.Size)
+		} else {
+			fmt.Printf("%-20s %-20s %-14s %-16s %s\n", row.Repository, row.Tag, row.ID, row.CreatedSince, row.Size)
+		}
+	}
+}
+
+// newImagesRow formats the columns of an image
+func newImagesRow(img *fimage.Image) imagesRow {
+	// Images without a reference are listed as <none>
+	repository, tag := img.Name, img.Tag
+	if repository == "" {
+		repository, tag = "<none>", "<none>"
+	}
+	digest := img.Digest
+	if digest == "" {
+		digest = "<none>"
+	}
+	created, createdAt := "N/A", ""
+	if !img.Created.IsZero() {
+		created = container.HumanDuration(time.Since(img.Created)) + " ago"
+		createdAt = img.Created.Format(time.RFC3339)
+	}
+	return imagesRow{
+		// The image ID is the digest of its config
+		ID:           strings.TrimPrefix(img.ID, "sha256:")[:12],
+		Repository:   repository,
+		Tag:          tag,
+		Digest:       digest,
+		CreatedSince: created,
+		CreatedAt:    createdAt,
+		Size:         humanSize(img.Size),
+	}
+}
+
+// parseImageFilters turns --filter values into list options, all of which
+// must match
+func parseImageFilters(filters []string) (*fimage.ListOptions, error) {
+	opts := &fimage.ListOptions{}
+	for _, f := range filters {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid filter %q, expected KEY=VALUE", f)
+		}
+		switch key {
+		case "label":
+			opts.Labels = append(opts.Labels, value)
+		case "reference":
+			opts.Reference = value
+		default:
+			return nil, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,196 @@
+// cmd/info.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+	"github.com/bensdz/floka/pkg/logdriver"
+	"github.com/bensdz/floka/pkg/network"
+	"github.com/bensdz/floka/pkg/volume"
+)
+
+// systemInfoData is what floka system info shows, and what --format json
+// prints
+type systemInfoData struct {
+	container.HostInfo
+	Iptables          string // Version of the iptables floka sets its rules up with, empty without it
+	Nftables          bool   // The nft command is there
+	ConfigFile        string // Path of the config file, empty when there is none
+	DataRoot          string
+	DiskSize          int64 // Bytes of the data root's filesystem
+	DiskAvailable     int64
+	Images            int
+	Containers        int
+	ContainersRunning int
+	ContainersPaused  int
+	ContainersStopped int
+	Volumes           int
+	Networks          int
+	Warnings          []string // Features floka needs that the host lacks, and problems of the config file
+}
+
+// systemInfo prints what floka can use on this host and the number of
+// objects it stores, warning about what it needs but can't use
+func systemInfo(format *listFormat) {
+	info := &systemInfoData{HostInfo: *container.Host(), DataRoot: config.Root()}
+	if _, err := os.Stat(config.Path()); err == nil {
+		info.ConfigFile = config.Path()
+	}
+	if version, err := network.IptablesVersion(); err == nil {
+		info.Iptables = version
+	}
+	_, err := exec.LookPath("nft")
+	info.Nftables = err == nil
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(info.DataRoot, &fs); err == nil {
+		info.DiskSize = int64(fs.Blocks) * fs.Bsize
+		info.DiskAvailable = int64(fs.Bavail) * fs.Bsize
+	}
+
+	images, err := fimage.GetImagesFromLocalStorage(nil)
+	if err != nil {
+		fmt.Printf("Error listing images: %s\n", err)
+		os.Exit(1)
+	}
+	seen := map[string]bool{}
+	for _, img := range images {
+		seen[img.ID] = true
+	}
+	info.Images = len(seen)
+
+	containers, err := container.ListContainers(nil)
+	if err != nil {
+		fmt.Printf("Error listing containers: %s\n", err)
+		os.Exit(1)
+	}
+	info.Containers = len(containers)
+	for _, cont := range containers {
+		switch cont.Status {
+		case "running", "restarting":
+			info.ContainersRunning++
+		case "paused":
+			info.ContainersPaused++
+		default:
+			info.ContainersStopped++
+		}
+	}
+
+	volumes, err := volume.List()
+	if err != nil {
+		fmt.Printf("Error listing volumes: %s\n", err)
+		os.Exit(1)
+	}
+	info.Volumes = len(volumes)
+	networks, err := network.List()
+	if err != nil {
+		fmt.Printf("Error listing networks: %s\n", err)
+		os.Exit(1)
+	}
+	info.Networks = len(networks)
+
+	info.Warnings = append(hostWarnings(info), configWarnings(info)...)
+	if format != nil {
+		format.print(info)
+		return
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	cgroups := "none"
+	if info.CgroupVersion > 0 {
+		cgroups = fmt.Sprintf("v%d (%s)", info.CgroupVersion, strings.Join(info.CgroupControllers, ", "))
+	}
+	storage := container.StorageOverlay
+	if !info.Overlay {
+		storage = container.StorageVFS
+	}
+	iptables := info.Iptables
+	if iptables == "" {
+		iptables = "not found"
+	}
+	fmt.Printf("Kernel version:   %s\n", info.KernelVersion)
+	fmt.Printf("Cgroups:          %s\n", cgroups)
+	fmt.Printf("Storage driver:   %s\n", storage)
+	fmt.Printf("User namespaces:  %s\n", yesNo(info.UserNamespaces))
+	fmt.Printf("Seccomp:          %s\n", yesNo(info.Seccomp))
+	fmt.Printf("iptables:         %s\n", iptables)
+	fmt.Printf("nftables:         %s\n", yesNo(info.Nftables))
+	configFile := info.ConfigFile
+	if configFile == "" {
+		configFile = "none"
+	}
+	fmt.Printf("Config file:      %s\n", configFile)
+	fmt.Printf("Data root:        %s\n", info.DataRoot)
+	if info.DiskSize > 0 {
+		fmt.Printf("Disk:             %s available of %s\n", humanSize(info.DiskAvailable), humanSize(info.DiskSize))
+	}
+	fmt.Printf("Images:           %d\n", info.Images)
+	fmt.Printf("Containers:       %d (%d running, %d paused, %d stopped)\n",
+		info.Containers, info.ContainersRunning, info.ContainersPaused, info.ContainersStopped)
+	fmt.Printf("Volumes:          %d\n", info.Volumes)
+	fmt.Printf("Networks:         %d\n", info.Networks)
+	for _, w := range info.Warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+}
+
+// hostWarnings returns what floka needs that the host lacks, and what
+// containers do without then
+func hostWarnings(info *systemInfoData) []string {
+	var warnings []string
+	if os.Geteuid() != 0 {
+		warnings = append(warnings, "floka is not running as root, which it needs to create containers")
+	}
+	switch info.CgroupVersion {
+	case 0:
+		warnings = append(warnings, "no cgroup filesystem at /sys/fs/cgroup, containers can't be limited, paused or measured")
+	default:
+		for _, controller := range info.MissingControllers {
+			warnings = append(warnings, fmt.Sprintf("cgroup controller %s is not available, its limits and statistics won't apply", controller))
+		}
+	}
+	if !info.Overlay {
+		warnings = append(warnings, fmt.Sprintf("overlayfs can't be used in the data root (%s), containers get a full copy of their image", info.OverlayError))
+	}
+	if !info.Seccomp {
+		warnings = append(warnings, "the kernel has no seccomp filters, containers only start with --privileged or --security-opt seccomp=unconfined")
+	}
+	if info.Iptables == "" {
+		warnings = append(warnings, "iptables not found, containers can't reach outside networks and UDP ports can't be published")
+	}
+	return warnings
+}
+
+// configWarnings returns the problems of the config file, whose invalid
+// settings fail the commands that apply them
+func configWarnings(info *systemInfoData) []string {
+	if info.ConfigFile == "" {
+		return nil
+	}
+	errs := config.Problems(info.ConfigFile)
+	cfg := config.Current()
+	if err := container.CheckCgroupParent(cfg.CgroupParent); err != nil {
+		errs = append(errs, err)
+	}
+	if err := logdriver.Check(cfg.LogDriver); err != nil {
+		errs = append(errs, err)
+	}
+	var warnings []string
+	for _, err := range errs {
+		warnings = append(warnings, fmt.Sprintf("config file %s: %s", info.ConfigFile, err))
+	}
+	return warnings
+}
@@ -0,0 +1,92 @@
+// cmd/init.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// runInit starts the container command and waits for it the way an init
+// process would, for "floka containerize" of containers run with --init.
+// As PID 1 of the container, it passes the signals it gets on to the
+// command and reaps every process orphaned in the container, not only the
+// command. It returns the exit code of the command.
+func runInit(cmd *exec.Cmd) int {
+	// Catch the signals before the command exists, so that none sent in
+	// between falls to the default action, which would end the container
+	sigCh := make(chan os.Signal, 32)
+	signal.Notify(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing command in container: %s\n", err)
+		return execExitCode(err)
+	}
+	pid := cmd.Process.Pid
+
+	for {
+		sig := (<-sigCh).(syscall.Signal)
+		switch sig {
+		case syscall.SIGCHLD:
+		case syscall.SIGURG:
+			// Sent by the Go runtime to preempt goroutines
+			continue
+		default:
+			if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+				logging.L().Debug("failed to forward signal", "signal", sig, "pid", pid, "err", err)
+			}
+			continue
+		}
+
+		// cmd.Wait can't be used, it would miss the orphans
+		for {
+			var ws syscall.WaitStatus
+			reaped, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || reaped <= 0 {
+				break
+			}
+			if reaped != pid {
+				logging.L().Debug("reaped orphaned process", "pid", reaped)
+				continue
+			}
+			// A command killed by a signal exits as a shell would report it
+			if ws.Signaled() {
+				return 128 + int(ws.Signal())
+			}
+			return ws.ExitStatus()
+		}
+	}
+}
+
+// runPause is what "floka containerize" of a pod's infra container does
+// instead of running a command: it holds the pod's namespaces until it
+// gets SIGTERM or SIGINT. As PID 1 of a pod sharing its PID namespace, it
+// reaps the processes orphaned in the pod's containers.
+func runPause() int {
+	sigCh := make(chan os.Signal, 32)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGCHLD)
+	for sig := range sigCh {
+		if sig != syscall.SIGCHLD {
+			return 0
+		}
+		for {
+			var ws syscall.WaitStatus
+			reaped, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || reaped <= 0 {
+				break
+			}
+			logging.L().Debug("reaped orphaned process", "pid", reaped)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+// cmd/inspect.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// inspectCommand handles "floka inspect [--type TYPE] [-f FORMAT] NAME..."
+func inspectCommand(args []string) {
+	inspectFlags := newFlagSet("inspect", "[--type container|image] [-f FORMAT] NAME...")
+	format := inspectFlags.String("format", "", "Print each object as json on a line or using a Go template, instead of an indented array")
+	inspectFlags.StringVar(format, "f", "", "Same as --format")
+	objType := inspectFlags.String("type", "", "Only inspect objects of this type (container, image)")
+	parseFlags(inspectFlags, args)
+
+	if inspectFlags.NArg() < 1 {
+		fmt.Println("Error: 'inspect' requires at least 1 argument")
+		fmt.Println("Usage: floka inspect [--type container|image] [-f FORMAT] NAME...")
+		os.Exit(1)
+	}
+	if *objType != "" && *objType != "container" && *objType != "image" {
+		fmt.Printf("Error: unknown type %q, expected container or image\n", *objType)
+		os.Exit(1)
+	}
+
+	objFormat := parseListFormat(*format)
+
+	var results []interface{}
+	failed := false
+	for _, ref := range inspectFlags.Args() {
+		obj, err := inspectObject(ref, *objType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			failed = true
+			continue
+		}
+		results = append(results, obj)
+	}
+
+	if objFormat != nil {
+		for _, obj := range results {
+			objFormat.print(obj)
+		}
+	} else {
+		if results == nil {
+			results = []interface{}{}
+		}
+		out, _ := json.MarshalIndent(results, "", "    ")
+		fmt.Println(string(out))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// inspectObject looks ref up as a container, then as an image
+func inspectObject(ref, objType string) (interface{}, error) {
+	if objType != "image" {
+		info, err := container.Inspect(ref)
+		if !errors.Is(err, container.ErrContainerNotFound) || objType == "container" {
+			return info, err
+		}
+	}
+	if objType != "container" {
+		info, err := fimage.Inspect(ref)
+		if !errors.Is(err, fimage.ErrImageNotFound) || objType == "image" {
+			return info, err
+		}
+	}
+	return nil, fmt.Errorf("no such object: %s", ref)
+}
+
+// formatFuncs are the functions available in --format templates
+var formatFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
@@ -0,0 +1,48 @@
+// cmd/kill.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// killCommand handles "floka kill [-s SIGNAL] CONTAINER..."
+func killCommand(args []string) {
+	killFlags := newFlagSet("kill", "[-s SIGNAL] CONTAINER...")
+	signal := killFlags.String("s", "KILL", "Signal to send, by name (HUP, SIGHUP) or number")
+	killFlags.StringVar(signal, "signal", "KILL", "Same as -s")
+	parseFlags(killFlags, args)
+
+	if killFlags.NArg() < 1 {
+		fmt.Println("Error: 'kill' requires at least 1 argument")
+		fmt.Println("Usage: floka kill [-s SIGNAL] CONTAINER...")
+		os.Exit(1)
+	}
+	sig, err := container.ParseSignal(*signal)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range killFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		if err := cont.Kill(sig); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(cont.ID)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
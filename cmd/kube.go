@@ -0,0 +1,165 @@
+// cmd/kube.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/kube"
+	"github.com/bensdz/floka/pkg/network"
+	"github.com/bensdz/floka/pkg/volume"
+)
+
+// kubeCommand handles "floka kube play|down FILE", running the pod of a
+// Kubernetes manifest as a floka pod
+func kubeCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		kubeUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		kubeUsage()
+		return
+	}
+
+	switch args[0] {
+	case "play":
+		playFlags := newFlagSet("kube play", "FILE")
+		parseFlags(playFlags, args[1:])
+		if playFlags.NArg() != 1 {
+			fmt.Println("Usage: floka kube play FILE")
+			os.Exit(1)
+		}
+		kubePlay(ctx, loadManifest(playFlags.Arg(0)))
+
+	case "down":
+		downFlags := newFlagSet("kube down", "FILE")
+		parseFlags(downFlags, args[1:])
+		if downFlags.NArg() != 1 {
+			fmt.Println("Usage: floka kube down FILE")
+			os.Exit(1)
+		}
+		kubeDown(ctx, loadManifest(downFlags.Arg(0)))
+
+	default:
+		kubeUsage()
+		os.Exit(1)
+	}
+}
+
+// loadManifest reads the pod of a manifest, exiting if it can't
+func loadManifest(path string) *kube.Pod {
+	pod, err := kube.Load(path)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	return pod
+}
+
+// kubePlay creates the pod of a manifest and runs its containers in the
+// background, in the order the manifest lists them. emptyDir volumes are
+// floka volumes named after the pod, hostPath ones bind mounts.
+func kubePlay(ctx context.Context, pod *kube.Pod) {
+	opts := container.PodOpts{Name: pod.Name, Labels: pod.Labels}
+	for _, c := range pod.Containers {
+		for _, spec := range c.Ports {
+			mapping, err := network.ParsePortSpec(spec)
+			if err != nil {
+				fmt.Printf("Error: container %s: %s\n", c.Name, err)
+				os.Exit(1)
+			}
+			opts.Ports = append(opts.Ports, mapping)
+		}
+	}
+	if _, err := container.CreatePod(&opts); err != nil {
+		fmt.Printf("Error creating pod: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pod %s Created\n", pod.Name)
+
+	for _, c := range pod.Containers {
+		runOpts := runOptions{
+			detach:     true,
+			quiet:      true,
+			name:       pod.Name + "-" + c.Name,
+			pod:        pod.Name,
+			env:        c.Env,
+			restart:    pod.RestartPolicy,
+			entrypoint: c.Command,
+		}
+		if c.Memory > 0 {
+			runOpts.memLimit = strconv.FormatInt(c.Memory, 10)
+		}
+		if c.MemoryLow > 0 {
+			runOpts.memReservation = strconv.FormatInt(c.MemoryLow, 10)
+		}
+		if c.CPUs > 0 {
+			runOpts.cpus = strconv.FormatFloat(c.CPUs, 'f', -1, 64)
+		}
+		for _, m := range c.Mounts {
+			source := pod.Volumes[m.Volume].HostPath
+			if source == "" {
+				source = pod.VolumeName(pod.Volumes[m.Volume])
+			}
+			spec := source + ":" + m.Path
+			if m.ReadOnly {
+				spec += ":ro"
+			}
+			runOpts.volumes = append(runOpts.volumes, spec)
+		}
+		runContainerWithOpts(ctx, c.Image, c.Args, runOpts)
+		fmt.Printf("Container %s Started\n", runOpts.name)
+	}
+}
+
+// kubeDown stops and removes the pod of a manifest with its containers and
+// the volumes of its emptyDir volumes
+func kubeDown(ctx context.Context, pod *kube.Pod) {
+	p, err := container.FindPod(pod.Name)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	removed, err := p.Remove(ctx, true)
+	for _, cont := range removed {
+		releaseVolumes(containerVolumes(cont))
+	}
+	if err != nil {
+		fmt.Printf("Error removing pod %s: %s\n", pod.Name, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pod %s Removed\n", pod.Name)
+
+	failed := false
+	for _, name := range pod.EmptyDirs() {
+		v, err := volume.Get(name)
+		if errors.Is(err, volume.ErrVolumeNotFound) {
+			continue
+		}
+		if err == nil {
+			err = v.Remove()
+		}
+		if err != nil {
+			fmt.Printf("Error removing volume %s: %s\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Volume %s Removed\n", name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func kubeUsage() {
+	fmt.Println("Usage: floka kube COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  play FILE  Run the pod of a Kubernetes Pod or Deployment manifest")
+	fmt.Println("  down FILE  Remove the pod of a manifest, its containers and emptyDir volumes")
+}
@@ -0,0 +1,44 @@
+// cmd/load.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// loadCommand handles "floka load [-i FILE]"
+func loadCommand(args []string) {
+	loadFlags := newFlagSet("load", "[-i FILE]")
+	input := loadFlags.String("i", "", "Read from a file instead of stdin")
+	loadFlags.StringVar(input, "input", "", "Read from a file instead of stdin")
+	parseFlags(loadFlags, args)
+
+	if loadFlags.NArg() > 0 {
+		fmt.Println("Error: 'load' accepts no arguments")
+		fmt.Println("Usage: floka load [-i FILE]")
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	images, err := fimage.Load(r)
+	for _, img := range images {
+		fmt.Printf("Loaded image: %s:%s\n", img.Name, img.Tag)
+	}
+	if err != nil {
+		fmt.Printf("Error loading images: %s\n", err)
+		os.Exit(1)
+	}
+}
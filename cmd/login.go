@@ -0,0 +1,104 @@
+// cmd/login.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// loginCommand handles "floka login [-u USER] [-p PASSWORD | --password-stdin] [SERVER]"
+func loginCommand(ctx context.Context, args []string) {
+	loginFlags := newFlagSet("login", "[-u USER] [-p PASSWORD | --password-stdin] [SERVER]")
+	username := loginFlags.String("u", "", "Username")
+	loginFlags.StringVar(username, "username", "", "Same as -u")
+	password := loginFlags.String("p", "", "Password")
+	loginFlags.StringVar(password, "password", "", "Same as -p")
+	passwordStdin := loginFlags.Bool("password-stdin", false, "Read the password from stdin")
+	parseFlags(loginFlags, args)
+
+	if loginFlags.NArg() > 1 {
+		fmt.Println("Error: 'login' accepts at most 1 argument")
+		fmt.Println("Usage: floka login [-u USER] [-p PASSWORD | --password-stdin] [SERVER]")
+		os.Exit(1)
+	}
+	if *password != "" && *passwordStdin {
+		fmt.Println("Error: --password and --password-stdin are mutually exclusive")
+		os.Exit(1)
+	}
+	registry := fimage.RegistryHost(loginFlags.Arg(0))
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *passwordStdin {
+		if *username == "" {
+			fmt.Println("Error: --password-stdin requires a username with -u")
+			os.Exit(1)
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Printf("Error reading password: %s\n", err)
+			os.Exit(1)
+		}
+		*password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	// Whatever is missing is asked for on the terminal
+	interactive := term.IsTerminal(os.Stdin.Fd())
+	if *username == "" {
+		if !interactive {
+			fmt.Println("Error: a username is required, give it with -u")
+			os.Exit(1)
+		}
+		fmt.Print("Username: ")
+		line, _ := stdin.ReadString('\n')
+		*username = strings.TrimSpace(line)
+	}
+	if *password == "" {
+		if !interactive {
+			fmt.Println("Error: a password is required, give it with --password-stdin")
+			os.Exit(1)
+		}
+		fmt.Print("Password: ")
+		line, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Println()
+		if err != nil {
+			fmt.Printf("Error reading password: %s\n", err)
+			os.Exit(1)
+		}
+		*password = line
+	}
+	if *username == "" || *password == "" {
+		fmt.Println("Error: username and password must not be empty")
+		os.Exit(1)
+	}
+
+	if err := fimage.Login(ctx, registry, fimage.Credentials{Username: *username, Password: *password}); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Login Succeeded, credentials saved in %s\n", fimage.AuthPath())
+}
+
+// logoutCommand handles "floka logout [SERVER]"
+func logoutCommand(args []string) {
+	logoutFlags := newFlagSet("logout", "[SERVER]")
+	parseFlags(logoutFlags, args)
+
+	if logoutFlags.NArg() > 1 {
+		fmt.Println("Error: 'logout' accepts at most 1 argument")
+		fmt.Println("Usage: floka logout [SERVER]")
+		os.Exit(1)
+	}
+	registry := fimage.RegistryHost(logoutFlags.Arg(0))
+	if err := fimage.Logout(registry); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed login credentials for %s\n", registry)
+}
@@ -0,0 +1,135 @@
+// cmd/logs.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logdriver"
+)
+
+// logsCommand handles "floka logs [-f] [--tail N] [--since TIME] CONTAINER"
+func logsCommand(ctx context.Context, args []string) {
+	logsFlags := newFlagSet("logs", "[-f] [--tail N] [--since TIME] CONTAINER")
+	follow := logsFlags.Bool("f", false, "Follow log output")
+	logsFlags.BoolVar(follow, "follow", false, "Follow log output")
+	tail := logsFlags.String("tail", "all", "Number of lines to show from the end of the logs")
+	since := logsFlags.String("since", "", "Show logs since a timestamp (RFC 3339 or Unix) or relative time (e.g. 42m)")
+	parseFlags(logsFlags, args)
+
+	if logsFlags.NArg() != 1 {
+		fmt.Println("Error: 'logs' requires exactly 1 argument")
+		fmt.Println("Usage: floka logs [-f] [--tail N] [--since TIME] CONTAINER")
+		os.Exit(1)
+	}
+
+	opts := logdriver.ReadOptions{Follow: *follow}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		opts.Since = t
+	}
+	if *tail != "all" {
+		n, err := strconv.Atoi(*tail)
+		if err != nil || n < 0 {
+			fmt.Printf("Error: invalid --tail value %q\n", *tail)
+			os.Exit(1)
+		}
+		// A zero Tail means everything, so skip the existing lines by time
+		// instead
+		if n == 0 {
+			if !*follow {
+				return
+			}
+			if now := time.Now(); now.After(opts.Since) {
+				opts.Since = now
+			}
+		}
+		opts.Tail = n
+	}
+
+	cont, err := container.Find(logsFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	driver, err := cont.LogDriver()
+	if err != nil {
+		fmt.Printf("Error opening logs: %s\n", err)
+		os.Exit(1)
+	}
+	defer driver.Close()
+
+	if *follow {
+		opts.Done = followDone(ctx, cont)
+	}
+
+	err = logdriver.ReadLogs(driver, opts, func(msg *logdriver.Message) error {
+		out := os.Stdout
+		if msg.Source == "stderr" {
+			out = os.Stderr
+		}
+		_, err := fmt.Fprintf(out, "%s\n", msg.Line)
+		return err
+	})
+	if errors.Is(err, logdriver.ErrReadNotSupported) {
+		fmt.Printf("Error: the %s log driver does not support reading\n", cont.LogConfig.Type)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error reading logs: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// followDone returns a channel closed once the container has exited or is
+// gone, or ctx is cancelled, to stop following its logs
+func followDone(ctx context.Context, cont *container.Container) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				close(done)
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+			c, err := container.Load(cont.ID)
+			if err != nil || !c.IsRunning() {
+				close(done)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// parseSince parses an RFC 3339 timestamp, a Unix timestamp or a duration
+// relative to now
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since value %q", value)
+}
@@ -2,38 +2,75 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/config"
 	"github.com/bensdz/floka/pkg/container"
 	"github.com/bensdz/floka/pkg/fimage"
 	"github.com/bensdz/floka/pkg/flokafile"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/network"
+	"github.com/bensdz/floka/pkg/volume"
+	"github.com/bensdz/floka/pkg/webhook"
 )
 
 func main() {
-	
-
-	// Define command line flags
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [ARG...]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  run         Run a command in a new container\n")
-		fmt.Fprintf(os.Stderr, "  pull        Pull an image from a registry\n")
-		fmt.Fprintf(os.Stderr, "  build       Build an image from a Flokafile\n")
-		fmt.Fprintf(os.Stderr, "  images      List images\n")
-		fmt.Fprintf(os.Stderr, "  ps          List containers\n")
-		fmt.Fprintf(os.Stderr, "  help        Show help\n")
+	// The confine helper executes a container command right away, before
+	// logging and the data root are set up and leave their variables in
+	// its environment
+	if len(os.Args) > 1 && os.Args[1] == "confine" {
+		runConfined(os.Args[2:])
 	}
+
+	flag.Usage = usage
+	
+	logLevel := flag.String("log-level", logging.EnvOr(logging.LevelEnv, logging.DefaultLevel), "Log level (debug, info, warn, error)")
+	logFormat := flag.String("log-format", logging.EnvOr(logging.FormatEnv, "text"), "Log format (text, json)")
+	debug := flag.Bool("debug", false, "Enable debug logging (same as --log-level debug)")
+	quiet := flag.Bool("quiet", false, "Only show errors and no pull or build progress")
+	flag.BoolVar(quiet, "q", false, "Same as --quiet")
+	dataRoot := flag.String("data-root", "", "Directory holding images, containers, volumes and networks (default $FLOKA_ROOT, the config file, or "+config.DefaultRoot+")")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 	
 	// Parse command line arguments
 	flag.Parse()
 	
+	if *showVersion {
+		fmt.Println(shortVersion())
+		return
+	}
+	
+	if *debug {
+		*logLevel = "debug"
+	} else if *quiet {
+		*logLevel = "error"
+		fimage.Progress = io.Discard
+	}
+	if err := logging.Setup(*logLevel, *logFormat, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	
+	if err := config.Init(*dataRoot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	
 	if flag.NArg() < 1 {
 		flag.Usage()
 		os.Exit(1)
@@ -41,329 +78,554 @@ func main() {
 	
 	command := flag.Arg(0)
 	
-	switch command {
-
-	case "test":
-
-	case "run":
-		if flag.NArg() < 2 {
-			fmt.Println("Error: 'run' requires at least 1 argument")
-			fmt.Println("Usage: floka run [OPTIONS] IMAGE [COMMAND] [ARG...]")
-			os.Exit(1)
-		}
-		
-		// Parse run options
-		runFlags := flag.NewFlagSet("run", flag.ExitOnError)
-		memLimit := runFlags.String("m", "", "Memory limit (e.g., 512m, 1g)")
-		cpuShares := runFlags.Int("c", 0, "CPU shares (relative weight)")
-		
-		// Find where the options end and the image/command begins
-		var optArgs []string
-		remainingArgs := flag.Args()[1:] // Skip "run"
-		imageArgPos := 0
-		
-		for i, arg := range remainingArgs {
-			if !strings.HasPrefix(arg, "-") {
-				imageArgPos = i
-				break
-			}
-			optArgs = append(optArgs, arg)
-		}
-		
-		if len(optArgs) > 0 {
-			runFlags.Parse(optArgs)
-		}
-		
-		// Extract image and command
-		if imageArgPos >= len(remainingArgs) {
-			fmt.Println("Error: IMAGE argument required")
-			os.Exit(1)
-		}
-		
-		imageName := remainingArgs[imageArgPos]
-		var cmdArgs []string
-		if imageArgPos+1 < len(remainingArgs) {
-			cmdArgs = remainingArgs[imageArgPos+1:]
-		}
+	// The first Ctrl-C cancels the command, which then cleans up after
+	// itself; a second one kills floka right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	
+	c := findCommand(command)
+	if c == nil {
+		fmt.Printf("Error: unknown command '%s'\n", command)
+		flag.Usage()
+		os.Exit(1)
+	}
+	c.run(ctx, flag.Args()[1:])
+}
 
-		runContainerWithOpts(imageName, cmdArgs, *memLimit, *cpuShares)
+// runCommand handles "floka run [OPTIONS] IMAGE [COMMAND] [ARG...]". Flags
+// are parsed up to the image name, everything after it belongs to the
+// container command.
+func runCommand(ctx context.Context, args []string) {
+	runFlags := newFlagSet("run", "[OPTIONS] IMAGE [COMMAND] [ARG...]")
+	var runOpts runOptions
+	addRunFlags(runFlags, &runOpts)
+	runFlags.BoolVar(&runOpts.detach, "d", false, "Run the container in the background and print its ID")
+	runFlags.BoolVar(&runOpts.remove, "rm", false, "Remove the container and its anonymous volumes once it exits")
+	spec := runFlags.String("schedule", "", "Make a schedule, named by --name, running a container at the times of a cron expression instead")
+	overlap := runFlags.String("overlap", "", "What a scheduled run does while the previous one still runs: skip (default), allow or replace")
+	parseLeadingFlags(runFlags, args)
+	runOpts.visit(runFlags)
+	if runOpts.remove && runOpts.detach {
+		fmt.Println("Error: --rm can't be used with -d, remove detached containers with 'floka rm'")
+		os.Exit(exitCodeError)
+	}
+	
+	if runFlags.NArg() < 1 {
+		fmt.Println("Error: 'run' requires at least 1 argument")
+		fmt.Println("Usage: floka run [OPTIONS] IMAGE [COMMAND] [ARG...]")
+		os.Exit(exitCodeError)
+	}
+	
+	if *spec != "" {
+		createSchedule(runFlags, args, runOpts, *spec, *overlap)
+		return
+	}
+	if *overlap != "" {
+		fmt.Println("Error: --overlap only applies to --schedule")
+		os.Exit(exitCodeError)
+	}
+	
+	imageName := runFlags.Arg(0)
+	cmdArgs := runFlags.Args()[1:]
+	
+	// Keep stdout for the container's output
+	if fimage.Progress == os.Stdout {
+		fimage.Progress = os.Stderr
+	}
 
+	runContainerWithOpts(ctx, imageName, cmdArgs, runOpts)
+}
+
+// buildCommand handles "floka build [OPTIONS] [PATH]"
+func buildCommand(ctx context.Context, args []string) {
+	buildFlags := newFlagSet("build", "-t NAME[:TAG] [-f FLOKAFILE] [--build-arg KEY=VALUE] [--target STAGE] [PATH]\n       floka build --check [-f FLOKAFILE] [PATH]")
+	tagFlag := buildFlags.String("t", "", "Name and optionally a tag in the 'name:tag' format")
+	fileFlag := buildFlags.String("f", "", "Path to the Flokafile (default PATH/flokafile)")
+	var buildArgs stringList
+	buildFlags.Var(&buildArgs, "build-arg", "Set a build-time variable KEY=VALUE, or KEY to pass ours on (repeatable)")
+	target := buildFlags.String("target", "", "Build the image of this stage instead of the last one")
+	check := buildFlags.Bool("check", false, "Check the Flokafile for problems instead of building it")
+	parseFlags(buildFlags, args)
 	
-	case "pull":
-		if flag.NArg() < 2 {
-			fmt.Println("Error: 'pull' requires 1 argument")
-			fmt.Println("Usage: floka pull IMAGE[:TAG]")
-			os.Exit(1)
-		}
-		imageName := flag.Arg(1)
-		tag := "latest"
-		if strings.Contains(imageName, ":") {
-			parts := strings.Split(imageName, ":")
-			imageName = parts[0]
-			tag = parts[1]
+	if (*tagFlag == "" && !*check) || buildFlags.NArg() > 1 {
+		fmt.Println("Error: 'build' requires a tag and at most one build context")
+		fmt.Println("Usage: floka build -t NAME[:TAG] [-f FLOKAFILE] [--build-arg KEY=VALUE] [--target STAGE] [PATH]")
+		fmt.Println("       floka build --check [-f FLOKAFILE] [PATH]")
+		os.Exit(1)
+	}
+	
+	path := "."
+	if buildFlags.NArg() > 0 {
+		path = buildFlags.Arg(0)
+	}
+	
+	if *check {
+		checkFlokafile(*fileFlag, path)
+		return
+	}
+	
+	buildImage(ctx, fimage.BuildOptions{
+		Flokafile:  *fileFlag,
+		ContextDir: path,
+		Tag:        *tagFlag,
+		BuildArgs:  buildArgs,
+		Target:     *target,
+	})
+}
+
+// containerizeCommand is the internal command started by container.Run
+// in the container's new namespaces, with the container command as its
+// arguments
+func containerizeCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Error: not enough arguments for containerize")
+		fmt.Println("Usage: containerize COMMAND [ARG...]")
+		os.Exit(exitCodeError)
+	}
+	runContainerized(args)
+}
+
+// shimCommand is the internal command started by "floka run -d" to
+// supervise a detached container. The fd 3 pipe reports when it is
+// running.
+func shimCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: shim CONTAINER")
+		os.Exit(1)
+	}
+	registerWebhooks()
+	if err := container.Supervise(ctx, args[0], os.NewFile(3, "ready")); err != nil {
+		logging.L().Debug("container exited", "container", args[0], "err", err)
+		os.Exit(1)
+	}
+}
+
+// addRunFlags registers the flags "floka run" and "floka create" share
+func addRunFlags(fs *flag.FlagSet, o *runOptions) {
+	fs.StringVar(&o.memLimit, "m", "", "Memory limit (e.g., 512m, 1g)")
+	fs.StringVar(&o.memSwap, "memory-swap", "", "Memory plus swap limit (e.g., 1g), -1 for unlimited swap")
+	fs.StringVar(&o.memReservation, "memory-reservation", "", "Memory soft limit (e.g., 256m)")
+	fs.BoolVar(&o.oomKillDisable, "oom-kill-disable", false, "Don't OOM kill processes over the memory limit (cgroup v1 only)")
+	fs.IntVar(&o.oomScoreAdj, "oom-score-adj", 0, "Adjust the OOM score of the container's processes (-1000 to 1000)")
+	fs.IntVar(&o.cpuShares, "c", 0, "CPU shares (relative weight)")
+	fs.StringVar(&o.cpus, "cpus", "", "CPU time quota in CPUs (e.g., 1.5)")
+	fs.Int64Var(&o.pidsLimit, "pids-limit", 0, "Maximum number of processes, 0 for none")
+	fs.StringVar(&o.cidFile, "cidfile", "", "Write the container's full ID to this file, which must not exist")
+	fs.StringVar(&o.cgroupParent, "cgroup-parent", "", "Cgroup path or systemd slice to create the container's cgroup in (default floka)")
+	fs.Var(&o.volumes, "v", "Mount a volume NAME:/path[:ro], a host directory or file /host/path:/path[:ro], or /path for an anonymous volume (repeatable)")
+	fs.StringVar(&o.logDriver, "log-driver", "", "Log driver for the container (json-file, journald, syslog, none)")
+	fs.Var(&o.logOpts, "log-opt", "Log driver option KEY=VALUE (repeatable)")
+	fs.BoolVar(&o.interactive, "i", false, "Keep stdin of a detached container open for floka attach")
+	fs.BoolVar(&o.interactive, "interactive", false, "Same as -i")
+	fs.BoolVar(&o.tty, "t", false, "Run the command on a pseudo-terminal")
+	fs.BoolVar(&o.tty, "tty", false, "Same as -t")
+	fs.StringVar(&o.name, "name", "", "Assign a name to the container")
+	fs.Var(&o.env, "e", "Set an environment variable KEY=VALUE, or KEY to pass ours on (repeatable)")
+	fs.Var(&o.env, "env", "Same as -e")
+	fs.Var(&o.envFiles, "env-file", "Read environment variables from a file (repeatable)")
+	fs.Var(&o.publish, "p", "Publish a container port [[HOST_IP:]HOST_PORT:]PORT[/PROTO] (repeatable)")
+	fs.Var(&o.publish, "publish", "Same as -p")
+	fs.StringVar(&o.pod, "pod", "", "Add the container to a pod made with pod create, sharing its network, IPC and UTS namespaces")
+	fs.StringVar(&o.network, "network", "", "Network to connect the container to, bridge (default) or one made with network create, or none, host or container:NAME|ID")
+	fs.StringVar(&o.hostname, "h", "", "Container hostname (default derived from the container ID)")
+	fs.StringVar(&o.hostname, "hostname", "", "Same as -h")
+	fs.Var(&o.dns, "dns", "Set a name server of the container instead of the host's (repeatable)")
+	fs.Var(&o.dnsSearch, "dns-search", "Set a DNS search domain instead of the host's, . for none (repeatable)")
+	fs.Var(&o.addHosts, "add-host", "Add a HOST:IP entry to the container's /etc/hosts (repeatable)")
+	fs.BoolVar(&o.publishAll, "P", false, "Publish the ports the image exposes on free host ports")
+	fs.BoolVar(&o.publishAll, "publish-all", false, "Same as -P")
+	fs.Var(&o.labels, "l", "Set a label KEY=VALUE on the container (repeatable)")
+	fs.Var(&o.labels, "label", "Same as -l")
+	fs.StringVar(&o.user, "u", "", "Run as a user NAME|UID[:GROUP|GID] of the image instead of its default")
+	fs.StringVar(&o.user, "user", "", "Same as -u")
+	fs.Var(&o.capAdd, "cap-add", "Add a capability to the default set, or ALL (repeatable)")
+	fs.Var(&o.capDrop, "cap-drop", "Drop a capability from the default set, or ALL (repeatable)")
+	fs.BoolVar(&o.init, "init", false, "Run an init process in the container that forwards signals and reaps zombies (default from the config file)")
+	fs.BoolVar(&o.privileged, "privileged", false, "Keep all capabilities and run without a seccomp profile")
+	fs.Var(&o.devices, "device", "Add a host device HOST_PATH[:CONTAINER_PATH][:PERMISSIONS] to the container (repeatable)")
+	fs.Var(&o.securityOpts, "security-opt", "Security option: seccomp=unconfined or seccomp=PROFILE.json (repeatable)")
+	fs.StringVar(&o.restart, "restart", "no", "Restart policy when the container exits (no, on-failure[:max], always, unless-stopped)")
+}
+
+// runOptions holds the flags given to "floka run" and "floka create"
+type runOptions struct {
+	memLimit     string
+	memSwap      string
+	memReservation string
+	oomKillDisable bool
+	oomScoreAdj  int
+	cpuShares    int
+	cpus         string
+	pidsLimit    int64
+	cgroupParent string
+	volumes      stringList
+	logDriver    string
+	logOpts      stringList
+	detach       bool
+	interactive  bool
+	tty          bool
+	name         string
+	env          stringList
+	envFiles     stringList
+	publish      stringList
+	publishAll   bool
+	network      string
+	hostname     string
+	dns          stringList
+	dnsSearch    stringList
+	addHosts     stringList
+	labels       stringList
+	restart      string
+	user         string
+	capAdd       stringList
+	capDrop      stringList
+	privileged   bool
+	init         bool
+	initSet      bool // --init was given, the config file's default doesn't apply
+	securityOpts stringList
+	devices      stringList
+	quiet        bool // Don't print the ID of a detached container
+	cidFile      string
+	remove       bool // Remove the container once it exits
+	pod          string
+	entrypoint   []string // Replaces the image's entrypoint, and its command with it, when not nil
+}
+
+// visit records which of the flags were given, after parsing
+func (o *runOptions) visit(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "init" {
+			o.initSet = true
 		}
-		// fmt.Printf("Pulling image %s with tag %s...\n", imageName, tag)
-		_, err := fimage.Pull(imageName, tag)
+	})
+}
+
+// runContainerWithOpts runs a container with the specified resource options
+func runContainerWithOpts(ctx context.Context, imageName string, command []string, runOpts runOptions) {
+	// SIGTERM and a closed terminal cancel the run like Ctrl-C does, so
+	// that floka still records the container's exit, and removes it with
+	// --rm, before exiting; the container itself gets the signals from
+	// container.Start
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	
+	image, command, opts, volumes := containerOpts(ctx, imageName, command, runOpts)
+	
+	// Our terminal passes keys on to the container's as they are typed,
+	// Ctrl-C included
+	restoreTerminal := func() {}
+	if runOpts.tty && !runOpts.detach && term.IsTerminal(os.Stdin.Fd()) {
+		restore, err := term.MakeRaw(os.Stdin.Fd())
 		if err != nil {
-			fmt.Printf("Error pulling image: %s\n", err)
-			os.Exit(1)
+			logging.L().Warn("failed to put terminal in raw mode", "err", err)
+		} else {
+			restoreTerminal = func() { restore() }
 		}
+	}
 	
-		
-	case "images":
-		fmt.Println("REPOSITORY          TAG                 IMAGE ID            PATH")
-		//check for folders in the images directory
-		imagesDir := "images"
-		files, err := os.ReadDir(imagesDir)
-		if err != nil {
-			fmt.Printf("Error reading images directory: %v\n", err)
-			os.Exit(1)
-		}
-		for _, file := range files {
-			if file.IsDir() {
-				imageName := file.Name()
-				imagePath := filepath.Join(imagesDir, imageName)
-				// Check if the image has a tag
-				tag := "latest"
-				if strings.Contains(imageName, ":") {
-					parts := strings.Split(imageName, ":")
-					imageName = parts[0]
-					tag = parts[1]
-				}
-				// Get the image ID (using the directory name as a simple ID)
-				imageID := imageName
-				
-				// Display the image information
-				displayID := imageID
-				if len(imageID) > 12 {
-					displayID = imageID[:12]
-				}
-				fmt.Printf("%-20s %-20s %-20s %s\n", imageName, tag, displayID, imagePath);
-			}
+	cont, err := container.Run(ctx, image, command, opts) // Get the container object, use := for cont
+	restoreTerminal()
+	
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && cont != nil {
+		// The command itself failed; exit with its code, as it would
+		// have outside a container
+		if runOpts.remove {
+			removeContainer(cont, volumes)
 		}
-		
-	case "ps":
-		fmt.Println("CONTAINER ID        IMAGE               COMMAND             STATUS              PORTS")
-		
-		containers, err := container.ListContainers()
-		if err != nil {
-			fmt.Printf("Error listing containers: %v\n", err)
-			os.Exit(1)
-		}
-		
-		for _, cont := range containers {
-			// Format command string (truncate if too long)
-			cmdStr := strings.Join(cont.Command, " ")
-			if len(cmdStr) > 20 {
-				cmdStr = cmdStr[:17] + "..."
-			}
-			
-			// Print container info in tabular format
-			fmt.Printf("%-20s %-20s %-20s %-20s\n", 
-			cont.ID[:12], 
-			cont.Image, 
-			cmdStr,
-			cont.Status)
-		}
-		
-		// case "build":
-		// 	buildFlags := flag.NewFlagSet("build", flag.ExitOnError)
-		// 	tagFlag := buildFlags.String("t", "", "Name and optionally a tag in the 'name:tag' format")
-		// 	fileFlag := buildFlags.String("f", "flokafile", "Name of the Flokafile")
-			
-		// 	buildFlags.Parse(flag.Args()[1:])
-			
-		// 	if *tagFlag == "" {
-		// 		fmt.Println("Error: 'build' requires a tag")
-		// 		fmt.Println("Usage: floka build -t NAME[:TAG] [PATH]")
-		// 		os.Exit(1)
-		// 	}
-			
-		// 	path := "."
-		// 	if buildFlags.NArg() > 0 {
-		// 		path = buildFlags.Arg(0)
-		// 	}
-			
-		// 	buildImage(*fileFlag, path, *tagFlag)
-
-	case "containerize":
-		// This is an internal command called by the container.Run method
-		// It receives the command to run in the containerized environment
-		if flag.NArg() < 2 {
-			fmt.Println("Error: not enough arguments for containerize")
-			fmt.Println("Usage: containerize COMMAND [ARG...]")
-			os.Exit(1)
-		}
-		
-		// Get the command to execute
-		// The first argument to "containerize" is the command itself,
-		// followed by its arguments.
-		command := flag.Args()[1:]
-		
-		// Run the command in the containerized environment
-		// rootfs is no longer passed as Chroot is handled by the caller
-		runContainerized(command)
-
-	case "help":
-		flag.Usage()
-		
-	default:
-		fmt.Printf("Error: unknown command '%s'\n", command)
-		flag.Usage()
-		os.Exit(1)
+		os.Exit(cont.ExitCode)
+	}
+	if err != nil {
+		fmt.Printf("Error running container: %s\n", err)
+		// If container.Run failed before fully creating the container object, cont might be nil.
+		// container.Run should ideally handle cleanup of its partial work if it errors out.
+		// If cont is non-nil here, it means Run returned an error *after* creating the container struct,
+		// which might imply a failure during the start phase.
+		if cont != nil {
+			// Attempt cleanup if container object exists but Run failed during its operation
+			// This is a best-effort cleanup.
+			_ = cont.Remove() // Ignore error from remove here as we're already in an error path
+		}
+		releaseVolumes(volumes)
+		os.Exit(exitCodeError)
+	}
+	
+	// A detached container keeps running under its shim and stays around
+	// after it exits, so its logs can still be read
+	if runOpts.detach {
+		if !runOpts.quiet {
+			fmt.Println(cont.ID)
+		}
+		return
+	}
+	
+	// Otherwise the exited container is kept for ps -a, logs and inspect
+	if runOpts.remove {
+		removeContainer(cont, volumes)
 	}
 }
 
-// func runContainerized(args []string) {
-	// 	if len(args) < 2 {
-		// 		fmt.Println("Error: not enough arguments for containerize")
-		// 		os.Exit(1)
-		// 	}
-
-// 	rootfs := args[0]
-// 	command := args[1:]
-
-// 	// Set up the mount namespace
-// 	if err := setupContainerFilesystem(rootfs); err != nil {
-// 		fmt.Printf("Failed to set up container filesystem: %v\n", err)
-// 		os.Exit(1)
-// 	}
-
-// 	// Make sure we unmount old_root even if something fails
-// 	defer func() {
-// 		_ = syscall.Unmount("/old_root", syscall.MNT_DETACH)
-// 		_ = os.RemoveAll("/old_root") // optional: clean up dir
-// 	}()
-
-// 	// Set hostname
-// 	_ = syscall.Sethostname([]byte("floka-container"))
-
-// 	// Change to root
-// 	if err := os.Chdir("/"); err != nil {
-// 		fmt.Printf("Failed to chdir to new root: %v\n", err)
-// 		os.Exit(1)
-// 	}
-
-// 	// Execute the container command
-// 	cmd := exec.Command(command[0], command[1:]...)
-// 	cmd.Stdin = os.Stdin
-// 	cmd.Stdout = os.Stdout
-// 	cmd.Stderr = os.Stderr
-
-// 	if err := cmd.Run(); err != nil {
-// 		if exitErr, ok := err.(*exec.ExitError); ok {
-// 			os.Exit(exitErr.ExitCode())
-// 		} else {
-// 			fmt.Printf("Error running container command: %v\n", err)
-// 			os.Exit(1)
-// 		}
-// 	}
-// }
-
-// func setupContainerFilesystem(rootfs string) error {
-// 	mountPoints := []string{"proc", "sys", "dev", "old_root"}
-// 	for _, dir := range mountPoints {
-// 		path := filepath.Join(rootfs, dir)
-// 		if err := os.MkdirAll(path, 0755); err != nil {
-// 			return fmt.Errorf("failed to create %s: %w", path, err)
-// 		}
-// 	}
-
-// 	// Bind mount the rootfs to itself to make it a mount point
-// 	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-// 		return fmt.Errorf("bind mount rootfs failed: %w", err)
-// 	}
-
-// 	if err := os.Chdir(rootfs); err != nil {
-// 		return fmt.Errorf("failed to chdir to rootfs: %w", err)
-// 	}
-
-// 	if err := syscall.PivotRoot(".", "old_root"); err != nil {
-// 		return fmt.Errorf("pivot_root failed: %w", err)
-// 	}
-
-// 	_ = os.Chdir("/") // switch to new root
-
-// 	// Mount basic filesystems
-// 	if err := syscall.Mount("proc", "/proc", "proc", 0, ""); err != nil {
-// 		return fmt.Errorf("failed to mount /proc: %w", err)
-// 	}
-// 	if err := syscall.Mount("sysfs", "/sys", "sysfs", 0, ""); err != nil {
-// 		return fmt.Errorf("failed to mount /sys: %w", err)
-// 	}
-// 	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", 0, ""); err != nil {
-// 		return fmt.Errorf("failed to mount /dev: %w", err)
-// 	}
-
-// 	return nil
-// }
-
+// removeContainer removes a container run with --rm and releases its
+// volumes
+func removeContainer(cont *container.Container, volumes []*volume.Volume) {
+	if err := cont.Remove(); err != nil {
+		logging.L().Warn("failed to remove container", "container", cont.ID, "err", err)
+		return
+	}
+	releaseVolumes(volumes)
+}
 
-// runContainerWithOpts runs a container with the specified resource options
-func runContainerWithOpts(imageName string, command []string, memLimit string, cpuShares int) {
+// containerOpts turns the options of "floka run" or "floka create" into
+// those of the container, pulling the image if needed, and returns them
+// with the image reference and the command. The volumes returned are to be
+// released if the container isn't created.
+func containerOpts(ctx context.Context, imageName string, command []string, runOpts runOptions) (string, []string, *container.ContainerOpts, []*volume.Volume) {
 	var opts container.ContainerOpts
 	
+	// Fail before pulling the image rather than after creating the
+	// container
+	if runOpts.cidFile != "" {
+		if _, err := os.Stat(runOpts.cidFile); err == nil {
+			fmt.Printf("Error: container ID file %s already exists\n", runOpts.cidFile)
+			os.Exit(exitCodeError)
+		}
+		opts.CIDFile = runOpts.cidFile
+	}
+	
 	// Parse memory limit (e.g., "512m", "1g")
-	if memLimit != "" {
-		bytes, err := parseMemoryLimit(memLimit)
+	if runOpts.memLimit != "" {
+		bytes, err := parseMemoryLimit(runOpts.memLimit)
 		if err != nil {
 			fmt.Printf("Error parsing memory limit: %s\n", err)
-			os.Exit(1)
+			os.Exit(exitCodeError)
 		}
 		opts.Memory = bytes
 	}
+	if runOpts.memSwap == "-1" {
+		opts.MemorySwap = -1
+	} else if runOpts.memSwap != "" {
+		bytes, err := parseMemoryLimit(runOpts.memSwap)
+		if err != nil {
+			fmt.Printf("Error parsing memory swap limit: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		opts.MemorySwap = bytes
+	}
+	if runOpts.memReservation != "" {
+		bytes, err := parseMemoryLimit(runOpts.memReservation)
+		if err != nil {
+			fmt.Printf("Error parsing memory reservation: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		opts.MemoryReservation = bytes
+	}
+	opts.OOMKillDisable = runOpts.oomKillDisable
+	opts.OOMScoreAdj = runOpts.oomScoreAdj
 	
 	// Set CPU shares
-	if cpuShares > 0 {
-		opts.CPUShares = int64(cpuShares)
+	if runOpts.cpuShares > 0 {
+		opts.CPUShares = int64(runOpts.cpuShares)
 	}
+	if runOpts.cpus != "" {
+		nanoCPUs, err := parseCPUs(runOpts.cpus)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		opts.NanoCPUs = nanoCPUs
+	}
+	opts.PidsLimit = runOpts.pidsLimit
 	
-	// If no command specified, use default
-	if len(command) == 0 {
-		command = []string{"/bin/sh"}
+	opts.CgroupParent = runOpts.cgroupParent
+	
+	// Network and name resolution
+	opts.Pod = runOpts.pod
+	opts.NetworkMode = runOpts.network
+	opts.Hostname = runOpts.hostname
+	opts.DNS = runOpts.dns
+	opts.DNSSearch = runOpts.dnsSearch
+	opts.ExtraHosts = runOpts.addHosts
+	
+	// Log driver and its options
+	opts.LogConfig.Type = runOpts.logDriver
+	if len(runOpts.logOpts) > 0 {
+		opts.LogConfig.Config = map[string]string{}
+		for _, o := range runOpts.logOpts {
+			key, value, ok := strings.Cut(o, "=")
+			if !ok {
+				fmt.Printf("Error: invalid log option %q, expected KEY=VALUE\n", o)
+				os.Exit(exitCodeError)
+			}
+			opts.LogConfig.Config[key] = value
+		}
+	}
+	
+	// Security options
+	opts.CapAdd = runOpts.capAdd
+	opts.CapDrop = runOpts.capDrop
+	opts.Privileged = runOpts.privileged
+	for _, o := range runOpts.securityOpts {
+		key, value, _ := strings.Cut(o, "=")
+		switch {
+		case key == "seccomp" && value != "":
+			opts.Seccomp = value
+		default:
+			fmt.Printf("Error: invalid security option %q, expected seccomp=unconfined or seccomp=PROFILE.json\n", o)
+			os.Exit(exitCodeError)
+		}
+	}
+	for _, spec := range runOpts.devices {
+		devices, err := container.ParseDevice(spec)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		opts.Devices = append(opts.Devices, devices...)
 	}
-		
+	
+	imageName, tag := fimage.ParseReference(imageName)
+
+	// Published ports
+	for _, spec := range runOpts.publish {
+		mapping, err := network.ParsePortSpec(spec)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		opts.Ports = append(opts.Ports, mapping)
+	}
+	
 	// Pull the image if needed
-	img, err := fimage.Pull(imageName, "latest") // Assuming "latest" if no tag is specified
+	img, err := fimage.Pull(ctx, imageName, tag, registryProgress())
 	if err != nil {
-		// Check if the error is because the image is not found
-		if strings.Contains(err.Error(), "not found locally") {
-			fmt.Printf("Error: Image '%s:latest' not found locally. Please pull or build it first.\n", imageName)
+		if errors.Is(err, fimage.ErrImageNotFound) {
+			fmt.Printf("Error: Image '%s:%s' not found locally or in its registry. Please pull or build it first.\n", imageName, tag)
 		} else {
 			fmt.Printf("Error preparing image: %s\n", err)
 		}
-		os.Exit(1)
+		os.Exit(exitCodeError)
 	}
 	
-	cont, err := container.Run(img.RootDir, command, &opts) // Get the container object, use := for cont
+	// The image's entrypoint and default command apply, with /bin/sh as
+	// the last resort
+	if runOpts.entrypoint != nil {
+		command = append(append([]string{}, runOpts.entrypoint...), command...)
+	} else {
+		command = img.Command(command)
+	}
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
+	}
+	
+	// With -P, exposed ports -p doesn't publish get a free host port
+	if runOpts.publishAll {
+		published := map[string]bool{}
+		for _, m := range opts.Ports {
+			published[fmt.Sprintf("%d/%s", m.ContainerPort, m.Protocol)] = true
+		}
+		for _, port := range img.ExposedPorts {
+			if published[port] {
+				continue
+			}
+			mapping, err := network.ParsePortSpec(port)
+			if err != nil {
+				fmt.Printf("Error: image %s:%s exposes %s\n", imageName, tag, err)
+				os.Exit(exitCodeError)
+			}
+			opts.Ports = append(opts.Ports, mapping)
+		}
+	}
+	
+	// Resolve volumes requested with -v and declared by the image
+	mounts, volumes, err := prepareVolumes(runOpts.volumes, img.Volumes)
 	if err != nil {
-		fmt.Printf("Error running container: %s\n", err)
-		// If container.Run failed before fully creating the container object, cont might be nil.
-		// container.Run should ideally handle cleanup of its partial work if it errors out.
-		// If cont is non-nil here, it means Run returned an error *after* creating the container struct,
-		// which might imply a failure during the start phase.
-		if cont != nil {
-			// Attempt cleanup if container object exists but Run failed during its operation
-			// This is a best-effort cleanup.
-			// fmt.Printf("Attempting cleanup for partially created/failed container %s\n", cont.ID)
-			_ = cont.Remove() // Ignore error from remove here as we're already in an error path
+		releaseVolumes(volumes)
+		fmt.Printf("Error preparing volumes: %s\n", err)
+		os.Exit(exitCodeError)
+	}
+	opts.Mounts = mounts
+	
+	opts.Detach = runOpts.detach
+	opts.Tty = runOpts.tty
+	opts.OpenStdin = runOpts.interactive
+	opts.Name = runOpts.name
+	opts.Init = runOpts.init
+	if !runOpts.initSet {
+		cfg, err := config.Load(config.Path())
+		if err != nil {
+			logging.L().Warn("ignoring config file", "err", err)
+		} else {
+			opts.Init = cfg.Init
 		}
-		os.Exit(1)
 	}
+	opts.RestartPolicy = runOpts.restart
 	
-	// Ensure cleanup after the command has run successfully or if a panic occurs
-	if cont != nil {
-		defer func() {
-			// fmt.Printf("Cleaning up container %s...\n", cont.ID) // Optional: log cleanup
-			if removeErr := cont.Remove(); removeErr != nil {
-				fmt.Printf("Warning: failed to remove container %s: %v\n", cont.ID, removeErr)
-			} // else {
-				// fmt.Printf("Container %s removed.\n", cont.ID) // Optional: log successful removal
-			// }
-		}()
+	// The image's labels apply unless --label overrides them
+	if len(runOpts.labels) > 0 || len(img.Labels) > 0 {
+		opts.Labels = map[string]string{}
+		for key, value := range img.Labels {
+			opts.Labels[key] = value
+		}
+		for _, l := range runOpts.labels {
+			key, value, _ := strings.Cut(l, "=")
+			if key == "" {
+				releaseVolumes(volumes)
+				fmt.Printf("Error: invalid label %q, expected KEY=VALUE\n", l)
+				os.Exit(exitCodeError)
+			}
+			opts.Labels[key] = value
+		}
 	}
 	
-	// fmt.Printf("Container started: %s (PID: %d)\n", cont.ID, cont.Pid) // This was already commented
+	// Variables from the image, then env files, then -e, later ones winning
+	envLists := [][]string{}
+	for _, path := range runOpts.envFiles {
+		fileEnv, err := readEnvFile(path)
+		if err != nil {
+			releaseVolumes(volumes)
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(exitCodeError)
+		}
+		envLists = append(envLists, fileEnv)
+	}
+	cliEnv, err := parseEnv(runOpts.env)
+	if err != nil {
+		releaseVolumes(volumes)
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(exitCodeError)
+	}
+	opts.Env = container.MergeEnv(img.Env, append(envLists, cliEnv)...)
+	opts.WorkingDir = img.WorkingDir
+	opts.User = img.User
+	if runOpts.user != "" {
+		opts.User = runOpts.user
+	}
+	
+	registerWebhooks()
+	
+	opts.Layers, err = img.LayerDirs()
+	if err != nil {
+		releaseVolumes(volumes)
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(exitCodeError)
+	}
+	opts.ImageID = img.ID
+	
+	return img.Name + ":" + img.Tag, command, &opts, volumes
+}
+
+// registerWebhooks notifies any configured webhooks about container
+// lifecycle events
+func registerWebhooks() {
+	endpoints, err := webhook.LoadEndpoints(webhook.ConfigPath())
+	if err != nil {
+		logging.L().Warn("ignoring webhooks", "err", err)
+	} else if len(endpoints) > 0 {
+		container.AddEventHook(webhook.Hook(endpoints))
+	}
 }
 
 // parseMemoryLimit parses a human-readable memory limit to bytes
@@ -391,36 +653,139 @@ func parseMemoryLimit(limit string) (int64, error) {
 	return value * multiplier, nil
 }
 
+// parseCPUs parses a CPU time quota given as a number of CPUs, such as
+// 1.5, to billionths of a CPU
+func parseCPUs(cpus string) (int64, error) {
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid CPU quota: %s", cpus)
+	}
+	return int64(value * 1e9), nil
+}
 
-func buildImage(flokafilePath, contextPath, tag string) {
-	fullPath := fmt.Sprintf("%s/%s", contextPath, flokafilePath)
-	
-	// Parse the Flokafile
-	Flokafile, err := flokafile.Parse(fullPath)
+// buildImage builds an image, taking build args given as KEY alone from
+// our environment
+func buildImage(ctx context.Context, opts fimage.BuildOptions) {
+	var err error
+	opts.BuildArgs, err = parseEnv(opts.BuildArgs)
 	if err != nil {
-		fmt.Printf("Error parsing Flokafile: %s\n", err)
+		fmt.Printf("Error: invalid build arg: %s\n", err)
 		os.Exit(1)
 	}
-	
-	// Execute the Flokafile instructions
-	if err := Flokafile.Execute(); err != nil {
-		fmt.Printf("Error executing Flokafile: %s\n", err)
+	img, err := fimage.Build(ctx, opts)
+	if err != nil {
+		fmt.Printf("Error building image: %s\n", err)
 		os.Exit(1)
 	}
 	
-	// Build the image
-	img, err := fimage.Build(fullPath, tag)
+	logging.L().Debug("image built", "image", img.Name+":"+img.Tag, "size", img.Size)
+}
+
+// checkFlokafile reports the problems of a Flokafile, exiting with status 1
+// if there are any
+func checkFlokafile(file, contextDir string) {
+	if file == "" {
+		file = fimage.FindFlokafile(contextDir)
+	}
+	problems, err := flokafile.Validate(file)
 	if err != nil {
-		fmt.Printf("Error building image: %s\n", err)
+		fmt.Printf("Error: %s\n", err)
 		os.Exit(1)
 	}
-	
-	fmt.Printf("Image built: %s:%s\n", img.Name, img.Tag)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("No problems found in %s\n", file)
+}
+
+// containerEnv is the environment of processes started inside a container
+var containerEnv = []string{
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	"HOME=/",
+	"PWD=/",
+	"TERM=xterm",
+}
+
+// workingDir returns the directory container processes start in, / unless
+// the image or container sets one
+func workingDir(dir string) string {
+	if dir == "" {
+		return "/"
+	}
+	return dir
+}
+
+// containerUser resolves the user container processes run as against the
+// container's /etc/passwd, returning their credentials, nil to stay root,
+// and the variables the user sets
+func containerUser(spec string) (*syscall.Credential, []string, error) {
+	if spec == "" {
+		return nil, nil, nil
+	}
+	user, err := container.ResolveUser("/", spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	var env []string
+	if user.Home != "" {
+		env = append(env, "HOME="+user.Home)
+	}
+	return &syscall.Credential{Uid: user.Uid, Gid: user.Gid, Groups: user.Groups}, env, nil
 }
 
 func runContainerized(command []string) {
-	// This function is now running *inside* the chrooted environment.
-	// Mount essential filesystems required for most processes.
+	// floka closes the setup pipe once we are in the container's cgroups
+	// and its network is ready
+	setup := os.NewFile(3, "setup")
+	io.Copy(io.Discard, setup)
+	setup.Close()
+
+	// A container sharing the network of another one joins its network
+	// namespace, and one of a pod the IPC and UTS namespaces of the pod's
+	// infra container too, open from fd 4 on. Only this thread does, and
+	// it is the one starting the command.
+	if joined := os.Getenv(container.JoinNSVar); joined != "" {
+		runtime.LockOSThread()
+		flags := map[string]int{"net": unix.CLONE_NEWNET, "ipc": unix.CLONE_NEWIPC, "uts": unix.CLONE_NEWUTS}
+		for i, name := range strings.Split(joined, ",") {
+			ns := os.NewFile(uintptr(4+i), name)
+			if err := unix.Setns(int(ns.Fd()), flags[name]); err != nil {
+				logging.L().Error("failed to join namespace", "namespace", name, "err", err)
+				os.Exit(exitCodeError)
+			}
+			ns.Close()
+		}
+	}
+
+	// The seccomp profile may be a file of the host
+	privileged := os.Getenv(container.PrivilegedVar) != ""
+	conf := confinement{
+		caps:       container.ParseCapabilities(os.Getenv(container.CapsVar)),
+		noNewPrivs: !privileged,
+	}
+	var err error
+	conf.seccomp, err = container.SeccompFilter(os.Getenv(container.SeccompVar), conf.caps)
+	if err != nil {
+		logging.L().Error("failed to load seccomp profile", "err", err)
+		os.Exit(exitCodeError)
+	}
+
+	// This function is now running in the container's new namespaces,
+	// but still sees the host's filesystem. Switch to the container's.
+	if err := pivotRoot(os.Getenv("FLOKA_ROOTFS")); err != nil {
+		logging.L().Error("failed to enter container rootfs", "err", err)
+		os.Exit(exitCodeError)
+	}
+
+	// Mount essential filesystems required for most processes. Only
+	// privileged containers may change the kernel's settings in /sys.
+	var sysFlags uintptr = syscall.MS_RDONLY
+	if privileged {
+		sysFlags = 0
+	}
 	mounts := []struct {
 		source string
 		target string
@@ -429,124 +794,306 @@ func runContainerized(command []string) {
 		data   string
 	}{
 		{"proc", "/proc", "proc", 0, ""},
-		{"sysfs", "/sys", "sysfs", 0, ""},
+		{"sysfs", "/sys", "sysfs", sysFlags, ""},
 		{"tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID | syscall.MS_STRICTATIME, "mode=755,size=65536k"},
 	}
 
 	for _, m := range mounts {
+		logging.L().Debug("mounting in container", "source", m.source, "target", m.target, "fstype", m.fstype)
 		if err := syscall.Mount(m.source, m.target, m.fstype, m.flags, m.data); err != nil {
-			fmt.Printf("Error mounting %s in container: %v\n", m.target, err)
+			logging.L().Error("failed to mount in container", "target", m.target, "fstype", m.fstype, "err", err)
 			for i := len(mounts) - 1; i >= 0; i-- {
 				syscall.Unmount(mounts[i].target, syscall.MNT_DETACH)
 			}
-			os.Exit(1)
+			os.Exit(exitCodeError)
 		}
 	}
 	defer syscall.Unmount("/dev", syscall.MNT_DETACH)
 	defer syscall.Unmount("/sys", syscall.MNT_DETACH)
 	defer syscall.Unmount("/proc", syscall.MNT_DETACH)
 
-	containerHostname := "floka-container"
+	devices, err := container.DevicesFromProcess()
+	if err == nil {
+		err = container.CreateDevices(devices)
+	}
+	if err != nil {
+		logging.L().Error("failed to create devices", "err", err)
+		os.Exit(exitCodeError)
+	}
+
+	if !privileged {
+		if err := protectKernelPaths(); err != nil {
+			logging.L().Error("failed to protect kernel files", "err", err)
+			os.Exit(exitCodeError)
+		}
+	}
+
+	containerHostname := os.Getenv(container.HostnameVar)
+	if containerHostname == "" {
+		containerHostname = "floka-container"
+	}
 	if err := syscall.Sethostname([]byte(containerHostname)); err != nil {
-		// fmt.Printf("Warning: failed to set hostname to '%s': %v\n", containerHostname, err)
+		logging.L().Debug("failed to set hostname", "hostname", containerHostname, "err", err)
 	}
 
 	devPtsDir := "/dev/pts"
 	if err := os.MkdirAll(devPtsDir, 0755); err == nil {
 		if err := syscall.Mount("devpts", devPtsDir, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
-			fmt.Printf("Warning: could not mount /dev/pts: %v\n", err)
+			logging.L().Warn("could not mount /dev/pts", "err", err)
 		} else {
 			defer syscall.Unmount(devPtsDir, syscall.MNT_DETACH)
 		}
 	} else {
-		fmt.Printf("Warning: could not create %s directory: %v\n", devPtsDir, err)
+		logging.L().Warn("could not create directory", "path", devPtsDir, "err", err)
+	}
+
+	if os.Getenv(container.PauseVar) != "" {
+		os.Exit(runPause())
 	}
 
 	if len(command) == 0 {
-		os.Exit(1)
+		logging.L().Error("empty command in containerize")
+		os.Exit(exitCodeError)
 	}
 
-	fmt.Printf("--- DIAGNOSTIC: runContainerized ---\n")
-	fmt.Printf("Received command: %v\n", command)
+	extraEnv, err := container.EnvFromProcess()
+	if err != nil {
+		logging.L().Error("failed to read container environment", "err", err)
+		os.Exit(exitCodeError)
+	}
+	credential, userEnv, err := containerUser(os.Getenv(container.UserVar))
+	if err != nil {
+		logging.L().Error("failed to resolve container user", "err", err)
+		os.Exit(exitCodeError)
+	}
+	workDir := workingDir(os.Getenv(container.WorkDirVar))
+	env := container.MergeEnv(containerEnv, []string{"PWD=" + workDir, "HOSTNAME=" + containerHostname}, userEnv, extraEnv)
+	// Like the image's layers, the directory may not have it yet
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		logging.L().Error("failed to create working directory", "dir", workDir, "err", err)
+		os.Exit(exitCodeError)
+	}
 
-	var cmdToExec string
-	var cmdArgsToExec []string
-	var err error // Declare err once for os.Stat calls within this scope
+	// exec.Command resolves the executable with our own PATH, which is still
+	// the host's, so switch to the container's PATH first
+	path, _ := container.LookupEnv(env, "PATH")
+	os.Setenv("PATH", path)
 
-	if len(command) > 0 && (command[0] == "bash" || strings.HasSuffix(command[0], "/bash")) {
-		absoluteBashPath := "/bin/bash"
-		fmt.Printf("Attempting to use absolute path for bash: %s\n", absoluteBashPath)
-		if _, err = os.Stat(absoluteBashPath); err == nil { // Use existing err
-			fmt.Printf("Found %s, will use it.\n", absoluteBashPath)
-			cmdToExec = absoluteBashPath
-			if len(command) > 1 {
-				cmdArgsToExec = command[1:]
+	cmd, err := containerProcess(command, credential, conf)
+	if err != nil {
+		logging.L().Error("failed to prepare container command", "err", err)
+		os.Exit(exitCodeCannotInvoke)
+	}
+	if cmd.Err != nil {
+		logging.L().Error("command not found in container", "command", command[0], "err", cmd.Err)
+		os.Exit(exitCodeNotFound)
+	}
+	logging.L().Debug("executing container command", "path", cmd.Path, "args", cmd.Args[1:], "env", env)
+	
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = workDir
+	cmd.Env = env
+	
+	if os.Getenv(container.InitVar) != "" {
+		os.Exit(runInit(cmd))
+	}
+	if err := cmd.Run(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			// A command killed by a signal exits as a shell would report it
+			if ws, ok := exitError.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				os.Exit(128 + int(ws.Signal()))
 			}
+			os.Exit(exitError.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "Error executing command in container: %s\n", err)
+		os.Exit(execExitCode(err))
+	}
+}
+
+// pivotRoot makes rootfs the root of our mount namespace and detaches the
+// host's filesystem, so nothing outside rootfs stays reachable
+func pivotRoot(rootfs string) error {
+	if rootfs == "" {
+		return fmt.Errorf("no container rootfs given")
+	}
+
+	// Keep mount events from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount rootfs: %w", err)
+	}
+
+	oldRoot, err := os.MkdirTemp(rootfs, ".pivot_root")
+	if err != nil {
+		return fmt.Errorf("failed to create old root directory: %w", err)
+	}
+	if err := syscall.PivotRoot(rootfs, oldRoot); err != nil {
+		os.Remove(oldRoot)
+		return fmt.Errorf("pivot_root failed: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("failed to change to the new root: %w", err)
+	}
+
+	oldRoot = "/" + filepath.Base(oldRoot)
+	logging.L().Debug("detaching host filesystem", "path", oldRoot)
+	if err := syscall.Unmount(oldRoot, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to unmount old root: %w", err)
+	}
+	return os.Remove(oldRoot)
+}
+
+// maskedPaths are hidden from containers, Docker's list: they tell about
+// the host's hardware, memory and kernel or let processes control it.
+// Files are replaced by /dev/null, directories by an empty directory.
+var maskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// readonlyPaths can be read but not written by containers
+var readonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// protectKernelPaths mounts over maskedPaths and makes readonlyPaths
+// read-only. Paths the kernel doesn't have are skipped.
+func protectKernelPaths() error {
+	// Masked files are bound to /dev/null
+	for _, path := range maskedPaths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "size=0")
 		} else {
-			fmt.Printf("Could not stat %s: %v. Falling back to command[0]: %s\n", absoluteBashPath, err, command[0])
-			cmdToExec = command[0]
-			if len(command) > 1 {
-				cmdArgsToExec = command[1:]
-			}
+			err = syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
 		}
-	} else if len(command) > 0 {
-		cmdToExec = command[0]
-		if len(command) > 1 {
-			cmdArgsToExec = command[1:]
+		if err != nil {
+			return fmt.Errorf("failed to mask %s: %w", path, err)
 		}
-	} else {
-		fmt.Println("CRITICAL ERROR: Empty command in runContainerized")
+	}
+	for _, path := range readonlyPaths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", path, err)
+		}
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// runNsexec runs a command inside the namespaces of a running container.
+// It is the helper re-executed by container.Exec.
+func runNsexec(args []string) {
+	nsexecFlags := flag.NewFlagSet("nsexec", flag.ExitOnError)
+	tty := nsexecFlags.Bool("tty", false, "Make stdin the controlling terminal of the command")
+	user := nsexecFlags.String("user", "", "Run as this user instead of the container's")
+	nsexecFlags.Parse(args)
+
+	if nsexecFlags.NArg() < 2 {
+		fmt.Println("Error: not enough arguments for nsexec")
+		fmt.Println("Usage: nsexec [--tty] [--user USER] CONTAINER COMMAND [ARG...]")
 		os.Exit(1)
 	}
-	
-	fmt.Printf("Final command to exec: %s\n", cmdToExec)
-	fmt.Printf("Final arguments to exec: %v\n", cmdArgsToExec)
 
-	bashCheckPath := "/bin/bash"
-	bi, statErr := os.Stat(bashCheckPath)
-	if statErr != nil {
-		fmt.Printf("os.Stat(%s) error: %v\n", bashCheckPath, statErr)
-	} else {
-		fmt.Printf("os.Stat(%s): Name: %s, Size: %d, Mode: %s, IsDir: %t\n", bashCheckPath, bi.Name(), bi.Size(), bi.Mode(), bi.IsDir())
+	cont, err := container.Load(nsexecFlags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
 	}
-	
-	usrBinBashCheckPath := "/usr/bin/bash"
-	ubi, statErr := os.Stat(usrBinBashCheckPath)
-	if statErr != nil {
-		fmt.Printf("os.Stat(%s) error: %v\n", usrBinBashCheckPath, statErr)
-	} else {
-		fmt.Printf("os.Stat(%s): Name: %s, Size: %d, Mode: %s, IsDir: %t\n", usrBinBashCheckPath, ubi.Name(), ubi.Size(), ubi.Mode(), ubi.IsDir())
+	// The command gets the container's capabilities and seccomp profile,
+	// read before its mount namespace hides our files
+	conf := confinement{caps: cont.Capabilities(), noNewPrivs: !cont.Privileged}
+	conf.seccomp, err = container.SeccompFilter(cont.Seccomp, conf.caps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := cont.EnterNamespaces(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
 	}
 
-	cmd := exec.Command(cmdToExec, cmdArgsToExec...)
-	
+	command := nsexecFlags.Args()[1:]
+	if *user == "" {
+		*user = cont.User
+	}
+	credential, userEnv, err := containerUser(*user)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	workDir := workingDir(cont.WorkingDir)
+	env := container.MergeEnv(containerEnv, []string{"PWD=" + workDir}, userEnv, cont.Env)
+	path, _ := container.LookupEnv(env, "PATH")
+	os.Setenv("PATH", path)
+
+	cmd, err := containerProcess(command, credential, conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(exitCodeCannotInvoke)
+	}
+	if cmd.Err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", cmd.Err)
+		os.Exit(exitCodeNotFound)
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Dir = "/"
-	cmd.Env = []string{
-		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
-		"HOME=/",
-		"PWD=/",
-		"TERM=xterm",
+	cmd.Dir = workDir
+	cmd.Env = env
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
 	}
-	fmt.Printf("Environment PATH for exec: %s\n", getPathFromEnv(cmd.Env))
-	fmt.Printf("--- END DIAGNOSTIC: runContainerized ---\n")
-	
-	if err = cmd.Run(); err != nil { // Assign to existing err
+	if *tty {
+		cmd.SysProcAttr.Setsid, cmd.SysProcAttr.Setctty, cmd.SysProcAttr.Ctty = true, true, 0
+	}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing command in container: %s\n", err)
+		os.Exit(execExitCode(err))
+	}
+	// floka exec sends SIGTERM when it is cancelled
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	go func() {
+		for sig := range sigCh {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitError.ExitCode())
 		}
-		fmt.Printf("Error executing command in container: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error executing command in container: %s\n", err)
+		os.Exit(exitCodeCannotInvoke)
 	}
 }
-
-func getPathFromEnv(env []string) string {
-	for _, e := range env {
-		if strings.HasPrefix(e, "PATH=") {
-			return strings.TrimPrefix(e, "PATH=")
-		}
-	}
-	return "PATH_NOT_FOUND_IN_ENV_ARRAY"
-}
\ No newline at end of file
@@ -0,0 +1,142 @@
+// cmd/network.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// networkCommand handles "floka network create|ls|inspect|rm"
+func networkCommand(args []string) {
+	if len(args) < 1 {
+		networkUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		networkUsage()
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		createFlags := newFlagSet("network create", "[--driver DRIVER] [--subnet CIDR] [--gateway IP] [--label KEY=VALUE] NAME")
+		driver := createFlags.String("driver", network.DriverBridge, "Driver of the network, bridge or the name of a network driver plugin")
+		subnet := createFlags.String("subnet", "", "Subnet in CIDR notation, a free one when empty")
+		gateway := createFlags.String("gateway", "", "Address of the bridge in the subnet, its first address when empty")
+		var labels stringList
+		createFlags.Var(&labels, "label", "Set a label KEY=VALUE on the network (repeatable)")
+		parseFlags(createFlags, args[1:])
+		if createFlags.NArg() != 1 {
+			fmt.Println("Usage: floka network create [--driver DRIVER] [--subnet CIDR] [--gateway IP] [--label KEY=VALUE] NAME")
+			os.Exit(1)
+		}
+
+		var labelMap map[string]string
+		for _, l := range labels {
+			key, value, _ := strings.Cut(l, "=")
+			if labelMap == nil {
+				labelMap = map[string]string{}
+			}
+			labelMap[key] = value
+		}
+
+		n, err := network.Create(createFlags.Arg(0), *driver, *subnet, *gateway, labelMap)
+		if err != nil {
+			fmt.Printf("Error creating network: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(n.ID)
+
+	case "ls", "list":
+		lsFlags := newFlagSet("network ls", "[--format json|TEMPLATE]")
+		format := lsFlags.String("format", "", "Print each network as json or using a Go template")
+		parseFlags(lsFlags, args[1:])
+		rowFormat := parseListFormat(*format)
+
+		networks, err := network.List()
+		if err != nil {
+			fmt.Printf("Error listing networks: %s\n", err)
+			os.Exit(1)
+		}
+		if rowFormat != nil {
+			for _, n := range networks {
+				rowFormat.print(n)
+			}
+			return
+		}
+		fmt.Printf("%-14s %-20s %-10s %-18s %s\n", "NETWORK ID", "NAME", "DRIVER", "SUBNET", "BRIDGE")
+		for _, n := range networks {
+			id := n.ID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			fmt.Printf("%-14s %-20s %-10s %-18s %s\n", id, n.Name, n.Driver, n.Subnet, n.Bridge)
+		}
+
+	case "inspect":
+		if len(args) < 2 {
+			fmt.Println("Usage: floka network inspect NETWORK...")
+			os.Exit(1)
+		}
+		type networkInfo struct {
+			*network.Network
+			Containers map[string]string // Container IDs by address
+		}
+		var infos []networkInfo
+		for _, name := range args[1:] {
+			n, err := network.Get(name)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			containers, err := n.Containers()
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			infos = append(infos, networkInfo{n, containers})
+		}
+		out, _ := json.MarshalIndent(infos, "", "    ")
+		fmt.Println(string(out))
+
+	case "rm", "remove":
+		if len(args) < 2 {
+			fmt.Println("Usage: floka network rm NETWORK...")
+			os.Exit(1)
+		}
+		failed := false
+		for _, name := range args[1:] {
+			n, err := network.Get(name)
+			if err == nil {
+				err = n.Remove()
+			}
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	default:
+		networkUsage()
+		os.Exit(1)
+	}
+}
+
+func networkUsage() {
+	fmt.Println("Usage: floka network COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  create [--driver DRIVER] [--subnet CIDR] [--gateway IP] NAME  Create a network")
+	fmt.Println("  ls [--format json|TEMPLATE]                                   List networks")
+	fmt.Println("  inspect NETWORK...                                            Show network details and connected containers")
+	fmt.Println("  rm NETWORK...                                                 Remove networks no container uses")
+}
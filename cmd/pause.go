@@ -0,0 +1,52 @@
+// cmd/pause.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// pauseCommand handles "floka pause CONTAINER..." and, with unpause set,
+// "floka unpause CONTAINER..."
+func pauseCommand(args []string, unpause bool) {
+	name := "pause"
+	if unpause {
+		name = "unpause"
+	}
+	pauseFlags := newFlagSet(name, "CONTAINER...")
+	parseFlags(pauseFlags, args)
+
+	if pauseFlags.NArg() < 1 {
+		fmt.Printf("Error: '%s' requires at least 1 argument\n", name)
+		fmt.Printf("Usage: floka %s CONTAINER...\n", name)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range pauseFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+
+		if unpause {
+			err = cont.Unpause()
+		} else {
+			err = cont.Pause()
+		}
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(cont.ID)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
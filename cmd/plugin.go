@@ -0,0 +1,33 @@
+// cmd/plugin.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/plugin"
+)
+
+// pluginCommand handles "floka plugin ls"
+func pluginCommand(args []string) {
+	if len(args) == 1 && isHelp(args[0]) {
+		fmt.Println("Usage: floka plugin ls")
+		return
+	}
+	if len(args) < 1 || (args[0] != "ls" && args[0] != "list") {
+		fmt.Println("Usage: floka plugin ls")
+		os.Exit(1)
+	}
+
+	plugins, err := plugin.Discover(plugin.Dir())
+	if err != nil {
+		fmt.Printf("Error listing plugins: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-20s %-10s %s\n", "NAME", "TRANSPORT", "IMPLEMENTS")
+	for _, p := range plugins {
+		fmt.Printf("%-20s %-10s %s\n", p.Name, p.Transport, strings.Join(p.Implements, ", "))
+	}
+}
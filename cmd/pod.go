@@ -0,0 +1,227 @@
+// cmd/pod.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// podCommand handles "floka pod create|start|stop|ls|rm"
+func podCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		podUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		podUsage()
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		podCreate(args[1:])
+
+	case "start":
+		if len(args) < 2 {
+			fmt.Println("Usage: floka pod start POD...")
+			os.Exit(1)
+		}
+		forEachPod(args[1:], func(pod *container.Pod) error {
+			_, err := pod.Start()
+			return err
+		})
+
+	case "stop":
+		stopFlags := newFlagSet("pod stop", "[-t SECONDS] POD...")
+		timeout := stopFlags.Int("t", int(container.DefaultStopTimeout/time.Second), "Seconds to wait for each container to stop before killing it")
+		stopFlags.IntVar(timeout, "time", *timeout, "Seconds to wait for each container to stop before killing it")
+		parseFlags(stopFlags, args[1:])
+		if stopFlags.NArg() < 1 {
+			fmt.Println("Usage: floka pod stop [-t SECONDS] POD...")
+			os.Exit(1)
+		}
+		if *timeout < 0 {
+			fmt.Println("Error: stop timeout must not be negative")
+			os.Exit(1)
+		}
+		forEachPod(stopFlags.Args(), func(pod *container.Pod) error {
+			_, err := pod.Stop(ctx, time.Duration(*timeout)*time.Second)
+			return err
+		})
+
+	case "ls", "list":
+		lsFlags := newFlagSet("pod ls", "[--format json|TEMPLATE]")
+		format := lsFlags.String("format", "", "Print each pod as json or using a Go template")
+		parseFlags(lsFlags, args[1:])
+		podList(parseListFormat(*format))
+
+	case "rm", "remove":
+		rmFlags := newFlagSet("pod rm", "[-f] POD...")
+		force := rmFlags.Bool("f", false, "Stop and remove running pods")
+		rmFlags.BoolVar(force, "force", false, "Stop and remove running pods")
+		parseFlags(rmFlags, args[1:])
+		if rmFlags.NArg() < 1 {
+			fmt.Println("Usage: floka pod rm [-f] POD...")
+			os.Exit(1)
+		}
+		forEachPod(rmFlags.Args(), func(pod *container.Pod) error {
+			removed, err := pod.Remove(ctx, *force)
+			for _, cont := range removed {
+				releaseVolumes(containerVolumes(cont))
+			}
+			return err
+		})
+
+	default:
+		podUsage()
+		os.Exit(1)
+	}
+}
+
+// podCreate handles "floka pod create", creating a pod whose infra
+// container starts with the pod or its first container
+func podCreate(args []string) {
+	createFlags := newFlagSet("pod create", "[--name NAME] [-p PORT] [--network NETWORK] [--share-pid] [--label KEY=VALUE]")
+	var opts container.PodOpts
+	var publish, labels, dns, dnsSearch, addHosts stringList
+	createFlags.StringVar(&opts.Name, "name", "", "Assign a name to the pod (default generated)")
+	createFlags.Var(&publish, "p", "Publish a port of the pod's containers [[HOST_IP:]HOST_PORT:]PORT[/PROTO] (repeatable)")
+	createFlags.Var(&publish, "publish", "Same as -p")
+	createFlags.StringVar(&opts.NetworkMode, "network", "", "Network to connect the pod to, bridge (default) or one made with network create, or none or host")
+	createFlags.StringVar(&opts.Hostname, "hostname", "", "Hostname of the pod's containers (default the pod name)")
+	createFlags.Var(&dns, "dns", "Set a name server of the pod instead of the host's (repeatable)")
+	createFlags.Var(&dnsSearch, "dns-search", "Set a DNS search domain instead of the host's, . for none (repeatable)")
+	createFlags.Var(&addHosts, "add-host", "Add a HOST:IP entry to the pod's /etc/hosts (repeatable)")
+	createFlags.StringVar(&opts.CgroupParent, "cgroup-parent", "", "Cgroup path or systemd slice to create the infra container's cgroup in")
+	createFlags.BoolVar(&opts.SharePID, "share-pid", false, "Share the PID namespace between the pod's containers")
+	createFlags.Var(&labels, "l", "Set a label KEY=VALUE on the pod (repeatable)")
+	createFlags.Var(&labels, "label", "Same as -l")
+	parseFlags(createFlags, args)
+	if createFlags.NArg() > 0 {
+		fmt.Println("Error: 'pod create' accepts no arguments")
+		fmt.Println("Usage: floka pod create [--name NAME] [-p PORT] [--network NETWORK] [--share-pid] [--label KEY=VALUE]")
+		os.Exit(1)
+	}
+
+	for _, spec := range publish {
+		mapping, err := network.ParsePortSpec(spec)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		opts.Ports = append(opts.Ports, mapping)
+	}
+	for _, l := range labels {
+		key, value, _ := strings.Cut(l, "=")
+		if key == "" {
+			fmt.Printf("Error: invalid label %q, expected KEY=VALUE\n", l)
+			os.Exit(1)
+		}
+		if opts.Labels == nil {
+			opts.Labels = map[string]string{}
+		}
+		opts.Labels[key] = value
+	}
+	opts.DNS, opts.DNSSearch, opts.ExtraHosts = dns, dnsSearch, addHosts
+
+	pod, err := container.CreatePod(&opts)
+	if err != nil {
+		fmt.Printf("Error creating pod: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(pod.Name)
+}
+
+// podRow holds the columns of a pod in "floka pod ls"
+type podRow struct {
+	Name       string
+	Status     string
+	InfraID    string
+	Containers []string // Names, or IDs, of the pod's other containers
+	Ports      string
+	Labels     map[string]string
+	CreatedAt  string
+}
+
+// podList prints the pods, as a table or with the given format
+func podList(rowFormat *listFormat) {
+	pods, err := container.ListPods()
+	if err != nil {
+		fmt.Printf("Error listing pods: %s\n", err)
+		os.Exit(1)
+	}
+
+	var rows []podRow
+	for _, pod := range pods {
+		infra := newPsRow(pod.Infra)
+		row := podRow{
+			Name:       pod.Name,
+			Status:     pod.Status(),
+			InfraID:    pod.Infra.ID,
+			Containers: []string{},
+			Ports:      infra.Ports,
+			Labels:     infra.Labels,
+			CreatedAt:  infra.CreatedAt,
+		}
+		for _, cont := range pod.Containers {
+			name := cont.Name
+			if name == "" {
+				name = cont.ID[:12]
+			}
+			row.Containers = append(row.Containers, name)
+		}
+		rows = append(rows, row)
+	}
+
+	if rowFormat != nil {
+		for _, row := range rows {
+			rowFormat.print(row)
+		}
+		return
+	}
+	fmt.Printf("%-20s %-10s %-14s %-30s %s\n", "NAME", "STATUS", "INFRA ID", "PORTS", "CONTAINERS")
+	for _, row := range rows {
+		fmt.Printf("%-20s %-10s %-14s %-30s %s\n", row.Name, row.Status, row.InfraID[:12], row.Ports, strings.Join(row.Containers, ", "))
+	}
+}
+
+// forEachPod runs fn on the pods given by name, printing the name of each
+// it succeeds on. It exits with an error if it fails on any.
+func forEachPod(names []string, fn func(*container.Pod) error) {
+	failed := false
+	for _, name := range names {
+		pod, err := container.FindPod(name)
+		if err == nil {
+			err = fn(pod)
+		}
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func podUsage() {
+	fmt.Println("Usage: floka pod COMMAND")
+	fmt.Println("")
+	fmt.Println("Pods are groups of containers sharing a network, IPC and UTS namespace,")
+	fmt.Println("and with --share-pid their processes. Add containers with run --pod POD.")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  create [--name NAME] [-p PORT] [--share-pid]  Create a pod")
+	fmt.Println("  start POD...                                  Start pods and their containers")
+	fmt.Println("  stop [-t SECONDS] POD...                      Stop the containers of pods")
+	fmt.Println("  ls [--format json|TEMPLATE]                   List pods")
+	fmt.Println("  rm [-f] POD...                                Remove pods and their containers")
+}
@@ -0,0 +1,66 @@
+// cmd/port.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// portCommand handles "floka port CONTAINER [PRIVATE_PORT[/PROTO]]"
+func portCommand(args []string) {
+	portFlags := newFlagSet("port", "CONTAINER [PRIVATE_PORT[/PROTO]]")
+	parseFlags(portFlags, args)
+
+	if portFlags.NArg() < 1 || portFlags.NArg() > 2 {
+		fmt.Println("Error: 'port' requires 1 or 2 arguments")
+		fmt.Println("Usage: floka port CONTAINER [PRIVATE_PORT[/PROTO]]")
+		os.Exit(1)
+	}
+
+	cont, err := container.Find(portFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	wantPort, wantProto := 0, ""
+	if portFlags.NArg() == 2 {
+		portStr, proto, _ := strings.Cut(portFlags.Arg(1), "/")
+		wantPort, err = strconv.Atoi(portStr)
+		if err != nil {
+			fmt.Printf("Error: invalid port %q\n", portFlags.Arg(1))
+			os.Exit(1)
+		}
+		wantProto = proto
+		if wantProto == "" {
+			wantProto = "tcp"
+		}
+	}
+
+	found := false
+	for _, m := range cont.Ports {
+		if wantPort != 0 && (m.ContainerPort != wantPort || m.Protocol != wantProto) {
+			continue
+		}
+		found = true
+		hostIP := m.HostIP
+		if hostIP == "" {
+			hostIP = "0.0.0.0"
+		}
+		hostAddr := net.JoinHostPort(hostIP, strconv.Itoa(m.HostPort))
+		if wantPort != 0 {
+			fmt.Println(hostAddr)
+		} else {
+			fmt.Printf("%d/%s -> %s\n", m.ContainerPort, m.Protocol, hostAddr)
+		}
+	}
+	if wantPort != 0 && !found {
+		fmt.Printf("Error: no public port '%d/%s' published for %s\n", wantPort, wantProto, cont.ID)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,103 @@
+// cmd/progress.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+const (
+	// progressBarWidth is the number of characters between the brackets
+	// of a progress bar
+	progressBarWidth = 30
+	// progressRedraw is how often progress bars are redrawn while bytes
+	// come in
+	progressRedraw = 100 * time.Millisecond
+)
+
+// registryProgress returns the reporter for pulls and pushes writing to
+// fimage.Progress: progress bars on a terminal, text lines otherwise
+func registryProgress() fimage.ProgressReporter {
+	if f, ok := fimage.Progress.(*os.File); ok && term.IsTerminal(f.Fd()) {
+		return &progressBars{w: f, layers: map[string]fimage.LayerProgress{}}
+	}
+	return nil
+}
+
+// progressBars draws a line per layer of a pull, redrawing them in place
+// as they progress
+type progressBars struct {
+	mu     sync.Mutex
+	w      io.Writer
+	order  []string
+	layers map[string]fimage.LayerProgress
+	drawn  int // Lines drawn, to move back over
+	last   time.Time
+}
+
+func (p *progressBars) Message(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Messages go below the layers, which are done with
+	p.order, p.drawn = nil, 0
+	p.layers = map[string]fimage.LayerProgress{}
+	fmt.Fprintln(p.w, msg)
+}
+
+func (p *progressBars) Layer(l fimage.LayerProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prev, ok := p.layers[l.ID]
+	if !ok {
+		p.order = append(p.order, l.ID)
+	}
+	p.layers[l.ID] = l
+	// Bytes coming in are only drawn every so often
+	if ok && prev.Phase == l.Phase && time.Since(p.last) < progressRedraw {
+		return
+	}
+	p.draw()
+}
+
+// draw moves the cursor back to the first layer line and redraws them all
+func (p *progressBars) draw() {
+	var b strings.Builder
+	if p.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawn)
+	}
+	for _, id := range p.order {
+		b.WriteString("\x1b[2K")
+		b.WriteString(formatLayerProgress(p.layers[id]))
+		b.WriteString("\n")
+	}
+	io.WriteString(p.w, b.String())
+	p.drawn = len(p.order)
+	p.last = time.Now()
+}
+
+// formatLayerProgress formats a layer line, e.g.
+// 3f2a1b4c5d6e: Downloading [=========>          ] 12.3MB/40MB
+func formatLayerProgress(l fimage.LayerProgress) string {
+	if l.Phase != fimage.PhaseDownloading && l.Phase != fimage.PhaseExtracting && l.Phase != fimage.PhasePushing {
+		return l.ID + ": " + l.Phase
+	}
+	if l.Total <= 0 {
+		return fmt.Sprintf("%s: %-11s %s", l.ID, l.Phase, humanSize(l.Current))
+	}
+	filled := int(l.Current * progressBarWidth / l.Total)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("%s: %-11s [%s] %s/%s", l.ID, l.Phase, bar, humanSize(l.Current), humanSize(l.Total))
+}
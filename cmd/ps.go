@@ -0,0 +1,152 @@
+// cmd/ps.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// psRow is what a floka ps --format template is executed with, and what
+// --format json prints
+type psRow struct {
+	ID        string
+	Image     string
+	Command   string
+	Status    string // Human readable, e.g. "Up 5 minutes"
+	State     string // running, paused, restarting, stopped, created or failed
+	Ports     string
+	Names     string
+	Labels    map[string]string
+	CreatedAt string
+}
+
+// psCommand handles "floka ps [-a] [-q] [--filter KEY=VALUE] [--format json|TEMPLATE]"
+func psCommand(args []string) {
+	psFlags := newFlagSet("ps", "[-a] [-q] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+	all := psFlags.Bool("a", false, "Show all containers, not only running ones")
+	psFlags.BoolVar(all, "all", false, "Same as -a")
+	quiet := psFlags.Bool("q", false, "Only print container IDs")
+	psFlags.BoolVar(quiet, "quiet", false, "Same as -q")
+	var filters stringList
+	psFlags.Var(&filters, "filter", "Filter containers by status=, name=, label=KEY[=VALUE], ancestor= or id= (repeatable)")
+	psFlags.Var(&filters, "f", "Same as --filter")
+	format := psFlags.String("format", "", "Print each container as json or using a Go template")
+	parseFlags(psFlags, args)
+
+	if psFlags.NArg() > 0 {
+		fmt.Println("Error: 'ps' accepts no arguments")
+		fmt.Println("Usage: floka ps [-a] [-q] [--filter KEY=VALUE] [--format json|TEMPLATE]")
+		os.Exit(1)
+	}
+
+	opts, err := parsePsFilters(filters)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	// Filtering on status covers stopped containers as well
+	if !*all && len(opts.Status) == 0 {
+		opts.Status = []string{"running", "paused", "restarting"}
+	}
+
+	rowFormat := parseListFormat(*format)
+
+	containers, err := container.ListContainers(opts)
+	if err != nil {
+		fmt.Printf("Error listing containers: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *quiet {
+		for _, cont := range containers {
+			fmt.Println(cont.ID)
+		}
+		return
+	}
+
+	if rowFormat != nil {
+		for _, cont := range containers {
+			rowFormat.print(newPsRow(cont))
+		}
+		return
+	}
+
+	fmt.Println("CONTAINER ID        IMAGE               COMMAND             STATUS                       PORTS               NAMES")
+	for _, cont := range containers {
+		row := newPsRow(cont)
+		// Truncate long commands to keep the columns aligned
+		cmdStr := row.Command
+		if len(cmdStr) > 20 {
+			cmdStr = cmdStr[:17] + "..."
+		}
+		fmt.Printf("%-20s %-20s %-20s %-28s %-20s %s\n",
+			cont.ID[:12],
+			cont.Image,
+			cmdStr,
+			row.Status,
+			row.Ports,
+			row.Names)
+	}
+}
+
+// newPsRow formats the columns of a container
+func newPsRow(cont *container.Container) psRow {
+	var ports []string
+	for _, m := range cont.Ports {
+		ports = append(ports, m.String())
+	}
+	labels := cont.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return psRow{
+		ID:        cont.ID,
+		Image:     cont.ImageRef(),
+		Command:   strings.Join(cont.Command, " "),
+		Status:    cont.StatusText(),
+		State:     cont.Status,
+		Ports:     strings.Join(ports, ", "),
+		Names:     cont.Name,
+		Labels:    labels,
+		CreatedAt: cont.Created.Format(time.RFC3339),
+	}
+}
+
+// parsePsFilters turns --filter values into list options. Several status
+// filters match containers in any of the states, all other filters must
+// match.
+func parsePsFilters(filters []string) (*container.ListOptions, error) {
+	opts := &container.ListOptions{}
+	for _, f := range filters {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid filter %q, expected KEY=VALUE", f)
+		}
+		switch key {
+		case "status":
+			switch value {
+			case "exited":
+				value = "stopped"
+			case "running", "paused", "restarting", "stopped", "created", "failed":
+			default:
+				return nil, fmt.Errorf("invalid status %q, expected running, paused, restarting, exited, created or failed", value)
+			}
+			opts.Status = append(opts.Status, value)
+		case "name":
+			opts.Name = value
+		case "label":
+			opts.Labels = append(opts.Labels, value)
+		case "ancestor":
+			opts.Ancestor = value
+		case "id":
+			opts.IDPrefix = value
+		default:
+			return nil, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	return opts, nil
+}
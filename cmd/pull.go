@@ -0,0 +1,38 @@
+// cmd/pull.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// pullCommand handles "floka pull [-q] IMAGE[:TAG]"
+func pullCommand(ctx context.Context, args []string) {
+	pullFlags := newFlagSet("pull", "[-q] IMAGE[:TAG]")
+	quiet := pullFlags.Bool("q", false, "Only print the image reference, without progress")
+	pullFlags.BoolVar(quiet, "quiet", false, "Same as -q")
+	parseFlags(pullFlags, args)
+
+	if pullFlags.NArg() != 1 {
+		fmt.Println("Error: 'pull' requires 1 argument")
+		fmt.Println("Usage: floka pull [-q] IMAGE[:TAG]")
+		os.Exit(1)
+	}
+
+	if *quiet {
+		fimage.Progress = io.Discard
+	}
+	imageName, tag := fimage.ParseReference(pullFlags.Arg(0))
+	img, err := fimage.Pull(ctx, imageName, tag, registryProgress())
+	if err != nil {
+		fmt.Printf("Error pulling image: %s\n", err)
+		os.Exit(1)
+	}
+	if *quiet {
+		fmt.Println(img.Name + ":" + img.Tag)
+	}
+}
@@ -0,0 +1,27 @@
+// cmd/push.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// pushCommand handles "floka push IMAGE[:TAG]"
+func pushCommand(ctx context.Context, args []string) {
+	pushFlags := newFlagSet("push", "IMAGE[:TAG]")
+	parseFlags(pushFlags, args)
+
+	if pushFlags.NArg() != 1 {
+		fmt.Println("Error: 'push' requires 1 argument")
+		fmt.Println("Usage: floka push IMAGE[:TAG]")
+		os.Exit(1)
+	}
+
+	if err := fimage.Push(ctx, pushFlags.Arg(0), registryProgress()); err != nil {
+		fmt.Printf("Error pushing image: %s\n", err)
+		os.Exit(1)
+	}
+}
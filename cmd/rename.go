@@ -0,0 +1,30 @@
+// cmd/rename.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// renameCommand handles "floka rename CONTAINER NEW_NAME"
+func renameCommand(args []string) {
+	renameFlags := newFlagSet("rename", "CONTAINER NEW_NAME")
+	parseFlags(renameFlags, args)
+
+	if renameFlags.NArg() != 2 {
+		fmt.Println("Error: 'rename' requires exactly 2 arguments")
+		fmt.Println("Usage: floka rename CONTAINER NEW_NAME")
+		os.Exit(1)
+	}
+
+	cont, err := container.Find(renameFlags.Arg(0))
+	if err == nil {
+		err = cont.Rename(renameFlags.Arg(1))
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+// cmd/rm.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/volume"
+)
+
+// rmCommand handles "floka rm [-f] CONTAINER..."
+func rmCommand(ctx context.Context, args []string) {
+	rmFlags := newFlagSet("rm", "[-f] CONTAINER...")
+	force := rmFlags.Bool("f", false, "Stop and remove running containers")
+	rmFlags.BoolVar(force, "force", false, "Stop and remove running containers")
+	parseFlags(rmFlags, args)
+
+	if rmFlags.NArg() < 1 {
+		fmt.Println("Error: 'rm' requires at least 1 argument")
+		fmt.Println("Usage: floka rm [-f] CONTAINER...")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range rmFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		if cont.PodInfra {
+			fmt.Printf("Error: container %s is the infra container of pod %s, remove the pod with floka pod rm\n", cont.ID, cont.Pod)
+			failed = true
+			continue
+		}
+
+		if cont.IsRunning() {
+			if !*force {
+				fmt.Printf("Error: container %s is running, stop it first or use -f\n", cont.ID)
+				failed = true
+				continue
+			}
+			if err := cont.Stop(ctx, container.DefaultStopTimeout); err != nil {
+				fmt.Printf("Error stopping container %s: %s\n", cont.ID, err)
+				failed = true
+				continue
+			}
+		}
+
+		if err := cont.Remove(); err != nil {
+			fmt.Printf("Error removing container %s: %s\n", cont.ID, err)
+			failed = true
+			continue
+		}
+		releaseVolumes(containerVolumes(cont))
+		fmt.Println(cont.ID)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// containerVolumes looks up the volumes mounted into a container. Volumes
+// that no longer exist are skipped.
+func containerVolumes(cont *container.Container) []*volume.Volume {
+	var volumes []*volume.Volume
+	for _, m := range cont.Mounts {
+		if m.Type != "volume" {
+			continue
+		}
+		v, err := volume.Get(m.Name)
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
@@ -0,0 +1,51 @@
+// cmd/rmi.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// rmiCommand handles "floka rmi [-f] IMAGE..."
+func rmiCommand(args []string) {
+	rmiFlags := newFlagSet("rmi", "[-f] IMAGE...")
+	force := rmiFlags.Bool("f", false, "Remove images used by containers or with several references")
+	rmiFlags.BoolVar(force, "force", false, "Remove images used by containers or with several references")
+	parseFlags(rmiFlags, args)
+
+	if rmiFlags.NArg() < 1 {
+		fmt.Println("Error: 'rmi' requires at least 1 argument")
+		fmt.Println("Usage: floka rmi [-f] IMAGE...")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range rmiFlags.Args() {
+		img, err := fimage.Lookup(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+
+		result, err := img.Remove(*force)
+		if result != nil {
+			for _, tag := range result.Untagged {
+				fmt.Printf("Untagged: %s\n", tag)
+			}
+			for _, id := range result.Deleted {
+				fmt.Printf("Deleted: %s\n", id)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error removing image %s: %s\n", ref, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+// cmd/save.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// saveCommand handles "floka save [-o FILE] IMAGE..."
+func saveCommand(args []string) {
+	saveFlags := newFlagSet("save", "[-o FILE] IMAGE...")
+	output := saveFlags.String("o", "", "Write to a file instead of stdout")
+	saveFlags.StringVar(output, "output", "", "Write to a file instead of stdout")
+	parseFlags(saveFlags, args)
+
+	if saveFlags.NArg() < 1 {
+		fmt.Println("Error: 'save' requires at least 1 argument")
+		fmt.Println("Usage: floka save [-o FILE] IMAGE...")
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output == "" {
+		if term.IsTerminal(os.Stdout.Fd()) {
+			fmt.Println("Error: refusing to write an image archive to a terminal, use -o or redirect stdout")
+			os.Exit(1)
+		}
+	} else {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := fimage.Save(w, saveFlags.Args()); err != nil {
+		if *output != "" {
+			os.Remove(*output)
+		}
+		fmt.Fprintf(os.Stderr, "Error saving images: %s\n", err)
+		os.Exit(1)
+	}
+}
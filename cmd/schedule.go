@@ -0,0 +1,285 @@
+// cmd/schedule.go
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/schedule"
+)
+
+// scheduleFlags are the flags of "floka run" that make a schedule rather
+// than run a container, and those a schedule sets on its containers
+var scheduleFlags = []string{"schedule", "overlap", "name", "d"}
+
+// createSchedule handles "floka run --schedule CRON", storing the run
+// flags, image and command in a schedule and starting its scheduler
+func createSchedule(runFlags *flag.FlagSet, args []string, runOpts runOptions, spec, overlap string) {
+	if runOpts.remove || runOpts.cidFile != "" {
+		fmt.Println("Error: --rm and --cidfile can't be used with --schedule, the containers of old runs are removed by the schedule")
+		os.Exit(exitCodeError)
+	}
+	if runOpts.pod != "" {
+		// Each run gets a container name of its own, a pod would collect
+		// them all
+		fmt.Println("Error: --pod can't be used with --schedule")
+		os.Exit(exitCodeError)
+	}
+
+	s := &schedule.Schedule{
+		Name:    runOpts.name,
+		Spec:    spec,
+		Overlap: overlap,
+		Options: withoutFlags(runFlags, args[:len(args)-runFlags.NArg()], scheduleFlags...),
+		Image:   runFlags.Arg(0),
+		Command: runFlags.Args()[1:],
+	}
+	if err := schedule.Create(s); err != nil {
+		fmt.Printf("Error creating schedule: %s\n", err)
+		os.Exit(exitCodeError)
+	}
+	if err := s.Launch(); err != nil {
+		fmt.Printf("Error starting schedule: %s\n", err)
+		s.Remove()
+		os.Exit(exitCodeError)
+	}
+	fmt.Println(s.Name)
+}
+
+// withoutFlags returns the flags in args, which fs parsed, leaving out
+// those with the given names and their values
+func withoutFlags(fs *flag.FlagSet, args []string, names ...string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			kept = append(kept, args[i:]...)
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		n := 1
+		if f := fs.Lookup(name); f != nil && !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				// The value is the next argument
+				n = 2
+			}
+		}
+		end := min(i+n, len(args))
+		if !contains(names, name) {
+			kept = append(kept, args[i:end]...)
+		}
+		i = end - 1
+	}
+	return kept
+}
+
+// scheduleCommand handles "floka schedule ls|history|rm"
+func scheduleCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		scheduleUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		scheduleUsage()
+		return
+	}
+
+	switch args[0] {
+	case "ls", "list":
+		lsFlags := newFlagSet("schedule ls", "[--format json|TEMPLATE]")
+		format := lsFlags.String("format", "", "Print each schedule as json or using a Go template")
+		parseFlags(lsFlags, args[1:])
+		scheduleList(parseListFormat(*format))
+
+	case "history":
+		historyFlags := newFlagSet("schedule history", "[--format json|TEMPLATE] SCHEDULE")
+		format := historyFlags.String("format", "", "Print each run as json or using a Go template")
+		parseFlags(historyFlags, args[1:])
+		if historyFlags.NArg() != 1 {
+			fmt.Println("Usage: floka schedule history [--format json|TEMPLATE] SCHEDULE")
+			os.Exit(1)
+		}
+		scheduleHistory(historyFlags.Arg(0), parseListFormat(*format))
+
+	case "rm", "remove":
+		rmFlags := newFlagSet("schedule rm", "SCHEDULE...")
+		parseFlags(rmFlags, args[1:])
+		if rmFlags.NArg() < 1 {
+			fmt.Println("Usage: floka schedule rm SCHEDULE...")
+			os.Exit(1)
+		}
+		failed := false
+		for _, name := range rmFlags.Args() {
+			s, err := schedule.Get(name)
+			if err == nil {
+				err = s.Remove()
+			}
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	default:
+		scheduleUsage()
+		os.Exit(1)
+	}
+}
+
+// scheduleRow holds the columns of a schedule in "floka schedule ls"
+type scheduleRow struct {
+	Name     string
+	Schedule string
+	Overlap  string
+	Status   string // active, or inactive when its scheduler doesn't run
+	NextRun  string
+	LastRun  string
+	Image    string
+	Command  string
+	Created  string
+
+	next, last time.Time
+}
+
+// scheduleList prints the schedules, as a table or with the given format
+func scheduleList(rowFormat *listFormat) {
+	schedules, err := schedule.List()
+	if err != nil {
+		fmt.Printf("Error listing schedules: %s\n", err)
+		os.Exit(1)
+	}
+
+	var rows []scheduleRow
+	for _, s := range schedules {
+		row := scheduleRow{
+			Name:     s.Name,
+			Schedule: s.Spec,
+			Overlap:  s.Overlap,
+			Status:   "inactive",
+			Image:    s.Image,
+			Command:  strings.Join(s.Command, " "),
+			Created:  s.Created.Format(time.RFC3339),
+		}
+		if s.Active() {
+			row.Status = "active"
+			row.next = s.Next(time.Now())
+			row.NextRun = row.next.Format(time.RFC3339)
+		}
+		if len(s.History) > 0 {
+			row.last = s.History[len(s.History)-1].Time
+			row.LastRun = row.last.Format(time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+
+	if rowFormat != nil {
+		for _, row := range rows {
+			rowFormat.print(row)
+		}
+		return
+	}
+	fmt.Printf("%-20s %-16s %-9s %-17s %-17s %s\n", "NAME", "SCHEDULE", "STATUS", "NEXT RUN", "LAST RUN", "IMAGE")
+	for _, row := range rows {
+		fmt.Printf("%-20s %-16s %-9s %-17s %-17s %s\n", row.Name, row.Schedule, row.Status, tableTime(row.next), tableTime(row.last), row.Image)
+	}
+}
+
+// runRow holds the columns of a run in "floka schedule history"
+type runRow struct {
+	Time      string
+	Container string // ID of the container run, empty if there is none
+	Status    string // Of the container, or skipped or the error of the run
+
+	time time.Time
+}
+
+// scheduleHistory prints the runs a schedule remembers, oldest first
+func scheduleHistory(name string, rowFormat *listFormat) {
+	s, err := schedule.Get(name)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	var rows []runRow
+	for _, r := range s.History {
+		row := runRow{Time: r.Time.Format(time.RFC3339), Container: r.Container, time: r.Time}
+		switch {
+		case r.Skipped:
+			row.Status = "Skipped, the previous run was still running"
+		case r.Error != "":
+			row.Status = "Failed: " + r.Error
+		default:
+			if cont, err := container.Find(r.Container); err == nil {
+				row.Status = cont.StatusText()
+			} else {
+				row.Status = "Removed"
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if rowFormat != nil {
+		for _, row := range rows {
+			rowFormat.print(row)
+		}
+		return
+	}
+	fmt.Printf("%-17s %-14s %s\n", "TIME", "CONTAINER", "STATUS")
+	for _, row := range rows {
+		id := "-"
+		if row.Container != "" {
+			id = row.Container[:min(12, len(row.Container))]
+		}
+		fmt.Printf("%-17s %-14s %s\n", tableTime(row.time), id, row.Status)
+	}
+}
+
+// tableTime formats a time for the schedule tables, - for none
+func tableTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// schedulerCommand is the internal command started for each schedule,
+// running its containers at the times it gives until it is removed or
+// the scheduler is stopped with SIGTERM
+func schedulerCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: scheduler SCHEDULE")
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM)
+	defer stop()
+	if err := schedule.Supervise(ctx, args[0]); err != nil {
+		logging.L().Error("scheduler exited", "schedule", args[0], "err", err)
+		os.Exit(1)
+	}
+}
+
+func scheduleUsage() {
+	fmt.Println("Usage: floka schedule COMMAND")
+	fmt.Println("")
+	fmt.Println("Schedules run a container at the times of a cron expression, and are")
+	fmt.Println("made with run --schedule CRON [--overlap skip|allow|replace].")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  ls [--format json|TEMPLATE]                List schedules")
+	fmt.Println("  history [--format json|TEMPLATE] SCHEDULE  List the last runs of a schedule")
+	fmt.Println("  rm SCHEDULE...                             Remove schedules, keeping their containers")
+}
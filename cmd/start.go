@@ -0,0 +1,61 @@
+// cmd/start.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// startCommand handles "floka start [-a [-i] [--detach-keys KEYS]] CONTAINER..."
+func startCommand(ctx context.Context, args []string) {
+	startFlags := newFlagSet("start", "[-a [-i] [--detach-keys KEYS]] CONTAINER...")
+	attach := startFlags.Bool("a", false, "Attach to the container's output and exit with its exit code")
+	startFlags.BoolVar(attach, "attach", false, "Same as -a")
+	interactive := startFlags.Bool("i", false, "Attach our stdin to the container as well")
+	startFlags.BoolVar(interactive, "interactive", false, "Same as -i")
+	detachKeys := startFlags.String("detach-keys", container.DefaultDetachKeys, "Key sequence detaching from the container when attached")
+	parseFlags(startFlags, args)
+
+	if startFlags.NArg() < 1 {
+		fmt.Println("Error: 'start' requires at least 1 argument")
+		fmt.Println("Usage: floka start [-a [-i] [--detach-keys KEYS]] CONTAINER...")
+		os.Exit(1)
+	}
+	if (*attach || *interactive) && startFlags.NArg() > 1 {
+		fmt.Println("Error: only one container can be started attached")
+		os.Exit(1)
+	}
+	keys, err := container.ParseDetachKeys(*detachKeys)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range startFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+
+		if err := cont.Launch(); err != nil {
+			fmt.Printf("Error starting container %s: %s\n", cont.ID, err)
+			failed = true
+			continue
+		}
+		if *attach || *interactive {
+			attachContainer(ctx, cont, keys, *interactive)
+			return
+		}
+		fmt.Println(cont.ID)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
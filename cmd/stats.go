@@ -0,0 +1,155 @@
+// cmd/stats.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// statsInterval is how often floka stats samples the containers, and how
+// far apart the two samples the CPU usage is computed from are
+const statsInterval = time.Second
+
+// statsRow is what a floka stats --format template is executed with, and
+// what --format json prints
+type statsRow struct {
+	ID         string
+	Name       string
+	CPUPerc    float64 // Percent of one CPU, over 100 when using several
+	MemUsage   int64   // Bytes
+	MemLimit   int64   // Bytes
+	MemPerc    float64
+	BlockRead  int64 // Bytes
+	BlockWrite int64 // Bytes
+	PIDs       int64
+}
+
+// statsCommand handles "floka stats [--no-stream] [--format json|TEMPLATE]
+// [CONTAINER...]", showing the resource usage of the given containers, or
+// of all running ones
+func statsCommand(ctx context.Context, args []string) {
+	statsFlags := newFlagSet("stats", "[--no-stream] [--format json|TEMPLATE] [CONTAINER...]")
+	noStream := statsFlags.Bool("no-stream", false, "Print a single sample instead of refreshing the table")
+	format := statsFlags.String("format", "", "Print each sample as json or using a Go template, instead of the table")
+	parseFlags(statsFlags, args)
+	rowFormat := parseListFormat(*format)
+
+	// Containers given by name are expected to keep running, the running
+	// ones are looked up again on every refresh
+	refs := statsFlags.Args()
+	listContainers := func() ([]*container.Container, error) {
+		if len(refs) == 0 {
+			return container.ListContainers(&container.ListOptions{Status: []string{"running", "paused"}})
+		}
+		var containers []*container.Container
+		for _, ref := range refs {
+			cont, err := container.Find(ref)
+			if err != nil {
+				return nil, err
+			}
+			containers = append(containers, cont)
+		}
+		return containers, nil
+	}
+
+	// Clearing the screen only makes sense on a terminal
+	stream := !*noStream && term.IsTerminal(os.Stdout.Fd())
+	prev := map[string]*container.Stats{}
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		containers, err := listContainers()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		samples := map[string]*container.Stats{}
+		for _, cont := range containers {
+			stats, err := cont.Stats()
+			if errors.Is(err, container.ErrContainerNotRunning) && len(refs) == 0 {
+				// Exited since it was listed
+				continue
+			}
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			samples[cont.ID] = stats
+		}
+
+		// The CPU column needs a previous sample, so the first one is
+		// only shown once the second is in
+		if len(prev) > 0 || len(samples) == 0 {
+			if rowFormat != nil {
+				for _, cont := range containers {
+					if stats, ok := samples[cont.ID]; ok {
+						rowFormat.print(newStatsRow(cont, stats, prev[cont.ID]))
+					}
+				}
+			} else {
+				if stream {
+					fmt.Print("\033[2J\033[H")
+				}
+				printStats(containers, samples, prev)
+			}
+			if !stream {
+				return
+			}
+		}
+		prev = samples
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// printStats prints a table of the latest samples of the containers
+func printStats(containers []*container.Container, samples, prev map[string]*container.Stats) {
+	fmt.Printf("%-15s %-20s %-8s %-22s %-8s %-22s %s\n",
+		"CONTAINER ID", "NAME", "CPU %", "MEM USAGE / LIMIT", "MEM %", "BLOCK I/O", "PIDS")
+	for _, cont := range containers {
+		stats, ok := samples[cont.ID]
+		if !ok {
+			continue
+		}
+		row := newStatsRow(cont, stats, prev[cont.ID])
+		name := row.Name
+		if name == "" {
+			name = "--"
+		}
+		fmt.Printf("%-15s %-20s %-8s %-22s %-8s %-22s %d\n",
+			row.ID[:12],
+			name,
+			fmt.Sprintf("%.2f%%", row.CPUPerc),
+			humanSize(row.MemUsage)+" / "+humanSize(row.MemLimit),
+			fmt.Sprintf("%.2f%%", row.MemPerc),
+			humanSize(row.BlockRead)+" / "+humanSize(row.BlockWrite),
+			row.PIDs)
+	}
+}
+
+// newStatsRow returns the figures of a container's latest sample, the CPU
+// usage being computed since the previous one
+func newStatsRow(cont *container.Container, stats, prev *container.Stats) statsRow {
+	return statsRow{
+		ID:         cont.ID,
+		Name:       cont.Name,
+		CPUPerc:    stats.CPUPercent(prev),
+		MemUsage:   stats.MemoryUsage,
+		MemLimit:   stats.MemoryLimit,
+		MemPerc:    stats.MemoryPercent(),
+		BlockRead:  stats.BlockRead,
+		BlockWrite: stats.BlockWrite,
+		PIDs:       stats.PIDs,
+	}
+}
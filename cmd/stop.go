@@ -0,0 +1,50 @@
+// cmd/stop.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// stopCommand handles "floka stop [-t SECONDS] CONTAINER..."
+func stopCommand(ctx context.Context, args []string) {
+	stopFlags := newFlagSet("stop", "[-t SECONDS] CONTAINER...")
+	timeout := stopFlags.Int("t", int(container.DefaultStopTimeout/time.Second), "Seconds to wait for the container to stop before killing it")
+	stopFlags.IntVar(timeout, "time", *timeout, "Seconds to wait for the container to stop before killing it")
+	parseFlags(stopFlags, args)
+
+	if stopFlags.NArg() < 1 {
+		fmt.Println("Error: 'stop' requires at least 1 argument")
+		fmt.Println("Usage: floka stop [-t SECONDS] CONTAINER...")
+		os.Exit(1)
+	}
+	if *timeout < 0 {
+		fmt.Println("Error: stop timeout must not be negative")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range stopFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+
+		if err := cont.Stop(ctx, time.Duration(*timeout)*time.Second); err != nil {
+			fmt.Printf("Error stopping container %s: %s\n", cont.ID, err)
+			failed = true
+			continue
+		}
+		fmt.Println(cont.ID)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,170 @@
+// cmd/system.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/schedule"
+	"github.com/bensdz/floka/pkg/volume"
+)
+
+// systemCommand handles "floka system prune [-a] [--volumes]",
+// "floka system info [--format json|TEMPLATE]", "floka system shutdown
+// [-t SECONDS]", "floka system restore" and "floka system export"
+func systemCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		systemUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		systemUsage()
+		return
+	}
+
+	switch args[0] {
+	case "prune":
+		pruneFlags := newFlagSet("system prune", "[-a] [--volumes]")
+		all := pruneFlags.Bool("a", false, "Remove all images not used by a container, not just untagged ones")
+		pruneFlags.BoolVar(all, "all", false, "Remove all images not used by a container, not just untagged ones")
+		volumes := pruneFlags.Bool("volumes", false, "Remove volumes not used by a container too")
+		parseFlags(pruneFlags, args[1:])
+		systemPrune(*all, *volumes)
+
+	case "info":
+		infoFlags := newFlagSet("system info", "[--format json|TEMPLATE]")
+		format := infoFlags.String("format", "", "Print the information as json or using a Go template")
+		parseFlags(infoFlags, args[1:])
+		systemInfo(parseListFormat(*format))
+
+	case "shutdown":
+		shutdownFlags := newFlagSet("system shutdown", "[-t SECONDS]")
+		timeout := shutdownFlags.Int("t", int(container.DefaultStopTimeout/time.Second), "Seconds to wait for each container to stop before killing it")
+		shutdownFlags.IntVar(timeout, "time", *timeout, "Seconds to wait for each container to stop before killing it")
+		parseFlags(shutdownFlags, args[1:])
+		if *timeout < 0 {
+			fmt.Println("Error: stop timeout must not be negative")
+			os.Exit(1)
+		}
+		// Schedulers go first, so that no container starts meanwhile
+		scheduleErr := schedule.Shutdown()
+		stopped, err := container.Shutdown(ctx, time.Duration(*timeout)*time.Second)
+		for _, id := range stopped {
+			fmt.Println(id)
+		}
+		if err = errors.Join(scheduleErr, err); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "restore":
+		restoreFlags := newFlagSet("system restore", "")
+		parseFlags(restoreFlags, args[1:])
+		started, err := container.Restore()
+		for _, id := range started {
+			fmt.Println(id)
+		}
+		schedules, scheduleErr := schedule.Restore()
+		for _, name := range schedules {
+			fmt.Println(name)
+		}
+		if err = errors.Join(err, scheduleErr); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "export":
+		if err := container.ExportState(os.Stdout); err != nil {
+			fmt.Printf("Error exporting container state: %s\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		systemUsage()
+		os.Exit(1)
+	}
+}
+
+func systemUsage() {
+	fmt.Println("Usage: floka system COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  prune [-a] [--volumes]         Remove stopped containers, unused images and what crashed runs left behind")
+	fmt.Println("  info [--format json|TEMPLATE]  Show what floka can use on this host and what it stores")
+	fmt.Println("  shutdown [-t SECONDS]          Stop all running containers, those joining another's network first")
+	fmt.Println("  restore                        Start the containers whose restart policy asks for it after a reboot")
+	fmt.Println("  export                         Print the container store as JSON, for debugging")
+}
+
+// systemPrune removes stopped containers, then images, then optionally
+// volumes, as each frees what the next may be held by, and finally the
+// mounts and cgroups left behind by crashed runs
+func systemPrune(all, volumes bool) {
+	var total int64
+	failed := false
+
+	reclaimed, err := pruneContainers()
+	total += reclaimed
+	if err != nil {
+		fmt.Printf("Error pruning containers: %s\n", err)
+		failed = true
+	}
+
+	if volumes {
+		removed, reclaimed, err := volume.Prune()
+		for _, name := range removed {
+			fmt.Printf("Deleted volume: %s\n", name)
+		}
+		total += reclaimed
+		if err != nil {
+			fmt.Printf("Error pruning volumes: %s\n", err)
+			failed = true
+		}
+	}
+
+	reclaimed, err = pruneImages(all)
+	total += reclaimed
+	if err != nil {
+		fmt.Printf("Error pruning images: %s\n", err)
+		failed = true
+	}
+
+	stale, err := container.CleanupStale()
+	if stale != nil {
+		for _, m := range stale.Mounts {
+			fmt.Printf("Unmounted: %s\n", m)
+		}
+		for _, dir := range stale.Dirs {
+			fmt.Printf("Deleted directory: %s\n", dir)
+		}
+		for _, id := range stale.Cgroups {
+			fmt.Printf("Deleted cgroup: %s\n", id)
+		}
+		total += stale.Size
+	}
+	if err != nil {
+		fmt.Printf("Error cleaning up after crashed runs: %s\n", err)
+		failed = true
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(total))
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// humanSize formats a number of bytes with decimal units, e.g. "1.234MB"
+func humanSize(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	size := float64(n)
+	i := 0
+	for size >= 1000 && i < len(units)-1 {
+		size /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, units[i])
+}
@@ -0,0 +1,26 @@
+// cmd/tag.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/fimage"
+)
+
+// tagCommand handles "floka tag SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]"
+func tagCommand(args []string) {
+	tagFlags := newFlagSet("tag", "SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]")
+	parseFlags(tagFlags, args)
+
+	if tagFlags.NArg() != 2 {
+		fmt.Println("Error: 'tag' requires exactly 2 arguments")
+		fmt.Println("Usage: floka tag SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]")
+		os.Exit(1)
+	}
+
+	if err := fimage.Tag(tagFlags.Arg(0), tagFlags.Arg(1)); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
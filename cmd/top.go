@@ -0,0 +1,82 @@
+// cmd/top.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// topCommand handles "floka top CONTAINER", listing the processes running
+// in a container
+func topCommand(args []string) {
+	topFlags := newFlagSet("top", "CONTAINER")
+	parseFlags(topFlags, args)
+
+	if topFlags.NArg() != 1 {
+		fmt.Println("Error: 'top' requires 1 argument")
+		fmt.Println("Usage: floka top CONTAINER")
+		os.Exit(1)
+	}
+
+	cont, err := container.Find(topFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	processes, err := cont.Processes()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Users are those of the host, as the container shares its user IDs
+	users := map[int]string{}
+	userName := func(uid int) string {
+		if name, ok := users[uid]; ok {
+			return name
+		}
+		name := strconv.Itoa(uid)
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+		users[uid] = name
+		return name
+	}
+
+	fmt.Printf("%-10s %-8s %-8s %-6s %-10s %-6s %-9s %s\n",
+		"USER", "PID", "PPID", "%CPU", "RSS", "STIME", "TIME", "CMD")
+	for _, p := range processes {
+		fmt.Printf("%-10s %-8d %-8d %-6.1f %-10s %-6s %-9s %s\n",
+			userName(p.UID),
+			p.PID,
+			p.PPID,
+			p.CPUPercent,
+			humanSize(p.RSS),
+			startTime(p.Started),
+			cpuTime(p.CPUTime),
+			p.Command)
+	}
+}
+
+// startTime formats when a process started as ps does: the time of day
+// for processes started today, the date for older ones
+func startTime(t time.Time) string {
+	if t.Format("2006-01-02") != time.Now().Format("2006-01-02") {
+		return t.Format("Jan02")
+	}
+	return t.Format("15:04")
+}
+
+// cpuTime formats the CPU time a process used as [DD-]HH:MM:SS
+func cpuTime(d time.Duration) string {
+	s := int64(d / time.Second)
+	if s >= 24*3600 {
+		return fmt.Sprintf("%d-%02d:%02d:%02d", s/(24*3600), s/3600%24, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
@@ -0,0 +1,80 @@
+// cmd/update.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// updateCommand handles "floka update [OPTIONS] CONTAINER...", changing the
+// resource limits of containers, running or not
+func updateCommand(args []string) {
+	updateFlags := newFlagSet("update", "[OPTIONS] CONTAINER...")
+	memLimit := updateFlags.String("m", "", "Memory limit (e.g., 512m, 1g)")
+	memSwap := updateFlags.String("memory-swap", "", "Memory plus swap limit (e.g., 1g), -1 for unlimited swap")
+	memReservation := updateFlags.String("memory-reservation", "", "Memory soft limit (e.g., 256m)")
+	cpuShares := updateFlags.Int64("c", 0, "CPU shares (relative weight)")
+	cpus := updateFlags.String("cpus", "", "CPU time quota in CPUs (e.g., 1.5), 0 to lift it")
+	pidsLimit := updateFlags.Int64("pids-limit", 0, "Maximum number of processes, -1 to lift it")
+	parseFlags(updateFlags, args)
+
+	if updateFlags.NArg() < 1 {
+		fmt.Println("Error: 'update' requires at least 1 argument")
+		fmt.Println("Usage: floka update [OPTIONS] CONTAINER...")
+		os.Exit(1)
+	}
+
+	res := &container.ResourceUpdate{CPUShares: *cpuShares, PidsLimit: *pidsLimit}
+	var err error
+	if *memLimit != "" {
+		if res.Memory, err = parseMemoryLimit(*memLimit); err != nil {
+			fmt.Printf("Error parsing memory limit: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *memSwap == "-1" {
+		res.MemorySwap = -1
+	} else if *memSwap != "" {
+		if res.MemorySwap, err = parseMemoryLimit(*memSwap); err != nil {
+			fmt.Printf("Error parsing memory swap limit: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *memReservation != "" {
+		if res.MemoryReservation, err = parseMemoryLimit(*memReservation); err != nil {
+			fmt.Printf("Error parsing memory reservation: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *cpus == "0" {
+		res.NanoCPUs = -1
+	} else if *cpus != "" {
+		if res.NanoCPUs, err = parseCPUs(*cpus); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *res == (container.ResourceUpdate{}) {
+		fmt.Println("Error: 'update' requires at least one limit to change")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range updateFlags.Args() {
+		cont, err := container.Find(ref)
+		if err == nil {
+			err = cont.UpdateResources(res)
+		}
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(cont.ID)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+// cmd/version.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set when building a release with
+//
+//	go build -ldflags "-X main.version=1.0.0 -X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd
+//
+// Without them the commit go build stamps from git is shown.
+var (
+	version   = "dev"
+	gitCommit = ""
+	buildDate = ""
+)
+
+// versionInfo is what floka version shows, and what --format json prints
+type versionInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+	OS        string
+	Arch      string
+}
+
+// currentVersion returns the build metadata of this floka binary
+func currentVersion() versionInfo {
+	v := versionInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		revision := ""
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if v.GitCommit == "" && revision != "" {
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			if modified {
+				revision += "-dirty"
+			}
+			v.GitCommit = revision
+		}
+	}
+	return v
+}
+
+// versionCommand handles "floka version [--format json|TEMPLATE]"
+func versionCommand(args []string) {
+	versionFlags := newFlagSet("version", "[--format json|TEMPLATE]")
+	format := versionFlags.String("format", "", "Print the version as json or using a Go template")
+	parseFlags(versionFlags, args)
+
+	if versionFlags.NArg() > 0 {
+		fmt.Println("Error: 'version' accepts no arguments")
+		fmt.Println("Usage: floka version [--format json|TEMPLATE]")
+		os.Exit(1)
+	}
+
+	v := currentVersion()
+	if f := parseListFormat(*format); f != nil {
+		f.print(v)
+		return
+	}
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	fmt.Printf("Version:     %s\n", v.Version)
+	fmt.Printf("Git commit:  %s\n", orUnknown(v.GitCommit))
+	fmt.Printf("Built:       %s\n", orUnknown(v.BuildDate))
+	fmt.Printf("Go version:  %s\n", v.GoVersion)
+	fmt.Printf("OS/Arch:     %s/%s\n", v.OS, v.Arch)
+}
+
+// shortVersion is what --version prints, e.g. "floka version 1.0.0,
+// build 1a2b3c4"
+func shortVersion() string {
+	v := currentVersion()
+	if v.GitCommit == "" {
+		return "floka version " + v.Version
+	}
+	return "floka version " + v.Version + ", build " + v.GitCommit
+}
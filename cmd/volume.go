@@ -0,0 +1,259 @@
+// cmd/volume.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/volume"
+)
+
+// volumeCommand handles "floka volume create|ls|inspect|rm|prune"
+func volumeCommand(args []string) {
+	if len(args) < 1 {
+		volumeUsage()
+		os.Exit(1)
+	}
+	if isHelp(args[0]) {
+		volumeUsage()
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		createFlags := newFlagSet("volume create", "[--driver DRIVER] [--opt KEY=VALUE] [NAME]")
+		driver := createFlags.String("driver", volume.DefaultDriver, "Volume driver name")
+		var driverOpts stringList
+		createFlags.Var(&driverOpts, "opt", "Driver option KEY=VALUE (repeatable)")
+		parseFlags(createFlags, args[1:])
+
+		opts := map[string]string{}
+		for _, o := range driverOpts {
+			key, value, ok := strings.Cut(o, "=")
+			if !ok {
+				fmt.Printf("Error: invalid driver option %q, expected KEY=VALUE\n", o)
+				os.Exit(1)
+			}
+			opts[key] = value
+		}
+
+		v, err := volume.Create(createFlags.Arg(0), *driver, opts)
+		if err != nil {
+			fmt.Printf("Error creating volume: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(v.Name)
+
+	case "ls", "list":
+		lsFlags := newFlagSet("volume ls", "[--format json|TEMPLATE]")
+		format := lsFlags.String("format", "", "Print each volume as json or using a Go template")
+		parseFlags(lsFlags, args[1:])
+		rowFormat := parseListFormat(*format)
+
+		volumes, err := volume.List()
+		if err != nil {
+			fmt.Printf("Error listing volumes: %s\n", err)
+			os.Exit(1)
+		}
+		if rowFormat != nil {
+			for _, v := range volumes {
+				rowFormat.print(v)
+			}
+			return
+		}
+		fmt.Printf("%-20s %s\n", "DRIVER", "VOLUME NAME")
+		for _, v := range volumes {
+			fmt.Printf("%-20s %s\n", v.Driver, v.Name)
+		}
+
+	case "inspect":
+		if len(args) < 2 {
+			fmt.Println("Usage: floka volume inspect VOLUME...")
+			os.Exit(1)
+		}
+		var volumes []*volume.Volume
+		for _, name := range args[1:] {
+			v, err := volume.Get(name)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			volumes = append(volumes, v)
+		}
+		out, _ := json.MarshalIndent(volumes, "", "    ")
+		fmt.Println(string(out))
+
+	case "rm", "remove":
+		if len(args) < 2 {
+			fmt.Println("Usage: floka volume rm VOLUME...")
+			os.Exit(1)
+		}
+		failed := false
+		for _, name := range args[1:] {
+			v, err := volume.Get(name)
+			if err == nil {
+				err = v.Remove()
+			}
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	case "prune":
+		removed, reclaimed, err := volume.Prune()
+		for _, name := range removed {
+			fmt.Printf("Deleted volume: %s\n", name)
+		}
+		fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+		if err != nil {
+			fmt.Printf("Error pruning volumes: %s\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		volumeUsage()
+		os.Exit(1)
+	}
+}
+
+func volumeUsage() {
+	fmt.Println("Usage: floka volume COMMAND")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  create [--driver D] [--opt K=V] [NAME]  Create a volume")
+	fmt.Println("  ls [--format json|TEMPLATE]             List volumes")
+	fmt.Println("  inspect VOLUME...                       Show volume details")
+	fmt.Println("  rm VOLUME...                            Remove volumes")
+	fmt.Println("  prune                                   Remove all unused volumes")
+}
+
+// prepareVolumes turns "-v" specs and the image's VOLUME declarations into
+// container mounts. Host paths become bind mounts, named volumes that
+// don't exist yet are created, and image volumes not covered by a spec get
+// an anonymous volume. It returns
+// the mounts and the volumes that back them.
+func prepareVolumes(specs []string, imageVolumes []string) ([]container.Mount, []*volume.Volume, error) {
+	var mounts []container.Mount
+	var volumes []*volume.Volume
+	covered := map[string]bool{}
+
+	add := func(v *volume.Volume, destination string, readOnly bool) error {
+		source, err := v.Mount()
+		if err != nil {
+			return fmt.Errorf("failed to mount volume %s: %w", v.Name, err)
+		}
+		mounts = append(mounts, container.Mount{
+			Type:        "volume",
+			Name:        v.Name,
+			Source:      source,
+			Destination: destination,
+			ReadOnly:    readOnly,
+		})
+		volumes = append(volumes, v)
+		covered[filepath.Clean(destination)] = true
+		return nil
+	}
+
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		var name, destination string
+		readOnly := false
+
+		switch len(parts) {
+		case 1:
+			destination = parts[0]
+		case 2, 3:
+			name, destination = parts[0], parts[1]
+			if len(parts) == 3 {
+				switch parts[2] {
+				case "ro":
+					readOnly = true
+				case "rw":
+				default:
+					return mounts, volumes, fmt.Errorf("invalid volume mode %q in %s", parts[2], spec)
+				}
+			}
+		default:
+			return mounts, volumes, fmt.Errorf("invalid volume specification: %s", spec)
+		}
+
+		if !filepath.IsAbs(destination) {
+			return mounts, volumes, fmt.Errorf("volume destination must be an absolute path: %s", spec)
+		}
+		// Paths are bind mounted from the host, anything else names a volume
+		if strings.HasPrefix(name, "/") || strings.HasPrefix(name, ".") {
+			source, err := filepath.Abs(name)
+			if err != nil {
+				return mounts, volumes, fmt.Errorf("invalid host path in %s: %w", spec, err)
+			}
+			if _, err := os.Stat(source); err != nil {
+				return mounts, volumes, fmt.Errorf("bind mount source %s does not exist", source)
+			}
+			mounts = append(mounts, container.Mount{
+				Type:        "bind",
+				Source:      source,
+				Destination: destination,
+				ReadOnly:    readOnly,
+			})
+			covered[filepath.Clean(destination)] = true
+			continue
+		}
+
+		var v *volume.Volume
+		var err error
+		if name != "" {
+			v, err = volume.Get(name)
+		}
+		if name == "" || errors.Is(err, volume.ErrVolumeNotFound) {
+			v, err = volume.Create(name, "", nil)
+		}
+		if err != nil {
+			return mounts, volumes, err
+		}
+		if err := add(v, destination, readOnly); err != nil {
+			return mounts, volumes, err
+		}
+	}
+
+	for _, destination := range imageVolumes {
+		if covered[filepath.Clean(destination)] {
+			continue
+		}
+		v, err := volume.Create("", "", nil)
+		if err != nil {
+			return mounts, volumes, err
+		}
+		if err := add(v, destination, false); err != nil {
+			return mounts, volumes, err
+		}
+	}
+
+	return mounts, volumes, nil
+}
+
+// releaseVolumes unmounts volumes from their drivers and removes the
+// anonymous ones once their container is gone
+func releaseVolumes(volumes []*volume.Volume) {
+	for _, v := range volumes {
+		if err := v.Unmount(); err != nil {
+			logging.L().Warn("failed to unmount volume", "volume", v.Name, "err", err)
+		}
+		if v.Anonymous {
+			if err := v.Remove(); err != nil {
+				logging.L().Warn("failed to remove volume", "volume", v.Name, "err", err)
+			}
+		}
+	}
+}
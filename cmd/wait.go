@@ -0,0 +1,45 @@
+// cmd/wait.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// waitCommand handles "floka wait CONTAINER...", printing the exit code of
+// each container once it has exited
+func waitCommand(ctx context.Context, args []string) {
+	waitFlags := newFlagSet("wait", "CONTAINER...")
+	parseFlags(waitFlags, args)
+
+	if waitFlags.NArg() < 1 {
+		fmt.Println("Error: 'wait' requires at least 1 argument")
+		fmt.Println("Usage: floka wait CONTAINER...")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range waitFlags.Args() {
+		cont, err := container.Find(ref)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+
+		exitCode, err := cont.Wait(ctx)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(exitCode)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
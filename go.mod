@@ -1,3 +1,8 @@
 module github.com/bensdz/floka
 
 go 1.22.2
+
+require (
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sys v0.30.0
+)
@@ -0,0 +1,133 @@
+// internal/fsutil/copy.go
+package fsutil
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// CopyTree copies the file or directory tree at src to dst, preserving
+// modes, ownership, timestamps, symlinks, hard links and device nodes.
+// Existing files in dst are replaced.
+func CopyTree(src, dst string) error {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	links := map[inode]string{}
+
+	type dirTimes struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTimes
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("unsupported file info for %s", path)
+		}
+
+		if !info.IsDir() {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to replace %s: %w", target, err)
+			}
+		}
+
+		// Files with several names are linked again instead of copied twice
+		if !info.IsDir() && st.Nlink > 1 {
+			key := inode{uint64(st.Dev), uint64(st.Ino)}
+			if first, ok := links[key]; ok {
+				return os.Link(first, target)
+			}
+			links[key] = target
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTimes{target, info.ModTime()})
+		case mode.IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return os.Lchown(target, int(st.Uid), int(st.Gid))
+		case mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe) != 0:
+			if err := syscall.Mknod(target, st.Mode, int(st.Rdev)); err != nil {
+				return fmt.Errorf("failed to create device %s: %w", target, err)
+			}
+		default:
+			// Sockets can't be copied
+			return nil
+		}
+
+		if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", target, err)
+		}
+		// Chmod after chown, which clears setuid and setgid bits
+		if err := os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", target, err)
+		}
+		if !mode.IsDir() {
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Creating entries updates the parent, so set directory times last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return nil
+}
+
+// copyFile copies the contents of a regular file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,44 @@
+// internal/fsutil/size.go
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DirSize returns the total size of the files below path. Mounts below
+// path are not entered, and files that can't be read are skipped.
+func DirSize(path string) int64 {
+	root, err := os.Lstat(path)
+	if err != nil {
+		return 0
+	}
+	rootStat, ok := root.Sys().(*syscall.Stat_t)
+	if !ok {
+		return root.Size()
+	}
+
+	var size int64
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Dev != rootStat.Dev {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,262 @@
+// internal/netlink/netlink.go
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// vethInfoPeer is the IFLA_INFO_DATA attribute describing the peer of a
+// new veth device
+const vethInfoPeer = 1
+
+var seq uint32
+
+// attr is a netlink route attribute, possibly holding nested attributes
+type attr struct {
+	typ      uint16
+	data     []byte
+	children []*attr
+}
+
+func newAttr(typ uint16, data []byte) *attr {
+	return &attr{typ: typ, data: data}
+}
+
+func stringAttr(typ uint16, s string) *attr {
+	return newAttr(typ, append([]byte(s), 0))
+}
+
+func uint32Attr(typ uint16, v uint32) *attr {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return newAttr(typ, b)
+}
+
+func (a *attr) add(child *attr) *attr {
+	a.children = append(a.children, child)
+	return a
+}
+
+// encode returns the attribute with its header and padding
+func (a *attr) encode() []byte {
+	payload := a.data
+	for _, c := range a.children {
+		payload = append(payload, c.encode()...)
+	}
+	length := unix.SizeofRtAttr + len(payload)
+	b := make([]byte, align(length))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(b[2:4], a.typ)
+	copy(b[unix.SizeofRtAttr:], payload)
+	return b
+}
+
+func align(n int) int {
+	return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
+
+// request sends a netlink route message and waits for the kernel's ack
+func request(typ uint16, flags uint16, header []byte, attrs ...*attr) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	payload := append([]byte{}, header...)
+	for _, a := range attrs {
+		payload = append(payload, a.encode()...)
+	}
+	n := atomic.AddUint32(&seq, 1)
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], typ)
+	binary.NativeEndian.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], n)
+	msg = append(msg, payload...)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to send netlink request: %w", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		nr, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read netlink reply: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:nr])
+		if err != nil {
+			return fmt.Errorf("failed to parse netlink reply: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != n || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4]))
+			if errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
+
+// ifInfomsg encodes the header of link messages
+func ifInfomsg(index int, flags, change uint32) []byte {
+	msg := unix.IfInfomsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  flags,
+		Change: change,
+	}
+	return (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&msg))[:]
+}
+
+// AddBridge creates a bridge device
+func AddBridge(name string) error {
+	info := newAttr(unix.IFLA_LINKINFO, nil).add(stringAttr(unix.IFLA_INFO_KIND, "bridge"))
+	err := request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, ifInfomsg(0, 0, 0),
+		stringAttr(unix.IFLA_IFNAME, name), info)
+	if err != nil {
+		return fmt.Errorf("failed to create bridge %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddVeth creates a pair of connected veth devices
+func AddVeth(name, peer string) error {
+	peerInfo := newAttr(vethInfoPeer, ifInfomsg(0, 0, 0)).add(stringAttr(unix.IFLA_IFNAME, peer))
+	info := newAttr(unix.IFLA_LINKINFO, nil).
+		add(stringAttr(unix.IFLA_INFO_KIND, "veth")).
+		add(newAttr(unix.IFLA_INFO_DATA, nil).add(peerInfo))
+	err := request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, ifInfomsg(0, 0, 0),
+		stringAttr(unix.IFLA_IFNAME, name), info)
+	if err != nil {
+		return fmt.Errorf("failed to create veth pair %s/%s: %w", name, peer, err)
+	}
+	return nil
+}
+
+// DeleteLink removes a network device
+func DeleteLink(index int) error {
+	if err := request(unix.RTM_DELLINK, 0, ifInfomsg(index, 0, 0)); err != nil {
+		return fmt.Errorf("failed to delete link %d: %w", index, err)
+	}
+	return nil
+}
+
+// SetUp brings a network device up
+func SetUp(index int) error {
+	if err := request(unix.RTM_NEWLINK, 0, ifInfomsg(index, unix.IFF_UP, unix.IFF_UP)); err != nil {
+		return fmt.Errorf("failed to bring up link %d: %w", index, err)
+	}
+	return nil
+}
+
+// SetMaster attaches a network device to a bridge
+func SetMaster(index, master int) error {
+	if err := request(unix.RTM_NEWLINK, 0, ifInfomsg(index, 0, 0), uint32Attr(unix.IFLA_MASTER, uint32(master))); err != nil {
+		return fmt.Errorf("failed to attach link %d to %d: %w", index, master, err)
+	}
+	return nil
+}
+
+// SetName renames a network device, which must be down
+func SetName(index int, name string) error {
+	if err := request(unix.RTM_NEWLINK, 0, ifInfomsg(index, 0, 0), stringAttr(unix.IFLA_IFNAME, name)); err != nil {
+		return fmt.Errorf("failed to rename link %d to %s: %w", index, name, err)
+	}
+	return nil
+}
+
+// SetNsPid moves a network device into the network namespace of a process
+func SetNsPid(index, pid int) error {
+	if err := request(unix.RTM_NEWLINK, 0, ifInfomsg(index, 0, 0), uint32Attr(unix.IFLA_NET_NS_PID, uint32(pid))); err != nil {
+		return fmt.Errorf("failed to move link %d to the namespace of %d: %w", index, pid, err)
+	}
+	return nil
+}
+
+// AddAddr assigns an IPv4 address to a network device
+func AddAddr(index int, addr *net.IPNet) error {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return fmt.Errorf("only IPv4 addresses are supported: %s", addr)
+	}
+	ones, _ := addr.Mask.Size()
+	msg := unix.IfAddrmsg{
+		Family:    unix.AF_INET,
+		Prefixlen: uint8(ones),
+		Index:     uint32(index),
+	}
+	header := (*[unix.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:]
+	err := request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, header,
+		newAttr(unix.IFA_LOCAL, ip), newAttr(unix.IFA_ADDRESS, ip))
+	if err != nil {
+		return fmt.Errorf("failed to add address %s: %w", addr, err)
+	}
+	return nil
+}
+
+// AddDefaultRoute adds an IPv4 default route through gateway
+func AddDefaultRoute(gateway net.IP) error {
+	gw := gateway.To4()
+	if gw == nil {
+		return fmt.Errorf("only IPv4 gateways are supported: %s", gateway)
+	}
+	msg := unix.RtMsg{
+		Family:   unix.AF_INET,
+		Table:    unix.RT_TABLE_MAIN,
+		Protocol: unix.RTPROT_BOOT,
+		Scope:    unix.RT_SCOPE_UNIVERSE,
+		Type:     unix.RTN_UNICAST,
+	}
+	header := (*[unix.SizeofRtMsg]byte)(unsafe.Pointer(&msg))[:]
+	if err := request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, header, newAttr(unix.RTA_GATEWAY, gw)); err != nil {
+		return fmt.Errorf("failed to add default route via %s: %w", gateway, err)
+	}
+	return nil
+}
+
+// InNetns runs fn with the calling goroutine inside the network namespace
+// of pid, then switches back
+func InNetns(pid int, fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		return fmt.Errorf("failed to open current network namespace: %w", err)
+	}
+	defer origin.Close()
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace of %d: %w", pid, err)
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to enter network namespace of %d: %w", pid, err)
+	}
+	fnErr := fn()
+	if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+		// The thread is stuck in the wrong namespace; keep it locked so
+		// that it exits with the goroutine instead of being reused
+		runtime.LockOSThread()
+		return fmt.Errorf("failed to return to the host network namespace: %w", err)
+	}
+	return fnErr
+}
@@ -0,0 +1,296 @@
+// internal/yaml/yaml.go
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document with its comment and indentation
+// stripped
+type yamlLine struct {
+	num    int // 1-based line number, for errors
+	indent int
+	text   string
+}
+
+// Parse parses the subset of YAML compose files and Kubernetes manifests
+// are written in: block mappings and sequences, single-line flow
+// sequences and mappings, and plain or quoted scalars. Mappings become
+// map[string]interface{}, sequences []interface{}, scalars strings and
+// null values nil. Anchors, tags, multi-line scalars and multiple
+// documents are not supported.
+func Parse(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		if strings.HasPrefix(raw, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text := strings.TrimSpace(stripComment(raw))
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*") || strings.HasPrefix(text, "!") ||
+			strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">") {
+			return nil, fmt.Errorf("line %d: anchors, tags and block scalars are not supported", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	value, err := p.parseNode(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return value, nil
+}
+
+// stripComment removes a # comment from a line, leaving # in quoted
+// strings and in the middle of words alone
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseNode parses the block mapping or sequence starting at the current
+// line, whose entries are indented by indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseSequence parses the "- item" lines indented by indent
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+
+		switch {
+		case item == "":
+			// The item is the block below
+			p.pos++
+			var value interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if value, err = p.parseNode(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			items = append(items, value)
+		case mappingKey(item) >= 0 && !strings.HasPrefix(item, "[") && !strings.HasPrefix(item, "{"):
+			// "- key: value" starts a mapping whose other keys line up
+			// with key
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(item), text: item}
+			value, err := p.parseMapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		default:
+			value, err := parseFlow(item, line.num)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			p.pos++
+		}
+	}
+	return items, nil
+}
+
+// parseMapping parses the "key: value" lines indented by indent
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		colon := mappingKey(line.text)
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		key, err := parseScalar(strings.TrimSpace(line.text[:colon]), line.num)
+		if err != nil {
+			return nil, err
+		}
+		keyStr, _ := key.(string)
+		if _, ok := m[keyStr]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, keyStr)
+		}
+		rest := strings.TrimSpace(line.text[colon+1:])
+		p.pos++
+
+		var value interface{}
+		switch {
+		case rest != "":
+			if value, err = parseFlow(rest, line.num); err != nil {
+				return nil, err
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			if value, err = p.parseNode(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text):
+			// A sequence may line up with the key it belongs to
+			if value, err = p.parseSequence(indent); err != nil {
+				return nil, err
+			}
+		}
+		m[keyStr] = value
+	}
+	return m, nil
+}
+
+// mappingKey returns the index of the colon ending the key of a
+// "key: value" line, or -1 if the line is not one
+func mappingKey(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// parseFlow parses a value given on a single line: a flow sequence, a
+// flow mapping or a scalar
+func parseFlow(s string, num int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: unterminated flow sequence", num)
+		}
+		items := []interface{}{}
+		parts, err := splitFlow(s[1:len(s)-1], num)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			item, err := parseFlow(part, num)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("line %d: unterminated flow mapping", num)
+		}
+		m := map[string]interface{}{}
+		parts, err := splitFlow(s[1:len(s)-1], num)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			colon := mappingKey(part)
+			if colon < 0 {
+				return nil, fmt.Errorf("line %d: expected \"key: value\" in flow mapping", num)
+			}
+			key, err := parseScalar(strings.TrimSpace(part[:colon]), num)
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseFlow(strings.TrimSpace(part[colon+1:]), num)
+			if err != nil {
+				return nil, err
+			}
+			keyStr, _ := key.(string)
+			m[keyStr] = value
+		}
+		return m, nil
+	}
+	return parseScalar(s, num)
+}
+
+// splitFlow splits the content of a flow collection on its top-level
+// commas
+func splitFlow(s string, num int) ([]string, error) {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("line %d: unbalanced flow collection", num)
+	}
+	// A trailing comma is allowed
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts, nil
+}
+
+// parseScalar parses a plain or quoted scalar. Plain scalars are kept as
+// written, numbers and booleans included, except null and ~.
+func parseScalar(s string, num int) (interface{}, error) {
+	switch {
+	case s == "" || s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return nil, nil
+	case strings.HasPrefix(s, `"`):
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid double-quoted string %s", num, s)
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: unterminated single-quoted string %s", num, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
@@ -0,0 +1,432 @@
+// pkg/compose/compose.go
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bensdz/floka/internal/yaml"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Labels set on the containers of a project, telling which project and
+// service they belong to
+const (
+	LabelProject = "floka.compose.project"
+	LabelService = "floka.compose.service"
+)
+
+// DefaultFiles are the names of the compose file looked for in the
+// current directory, in order
+var DefaultFiles = []string{"compose.yaml", "compose.yml", "floka-compose.yaml", "floka-compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Project is a set of services defined in a compose file
+type Project struct {
+	Name     string
+	Dir      string // Directory of the compose file, relative paths are resolved in it
+	Services map[string]*Service
+}
+
+// Service is a container of a project
+type Service struct {
+	Name        string
+	Image       string   // Image to run, or the name to give the built image
+	Build       *Build   // Build the image from a Flokafile instead of pulling it
+	Command     []string // Overrides the image's CMD
+	Environment []string // KEY=VALUE, or KEY to take the value from floka's environment
+	Volumes     []string // As given to floka run -v, with host paths made absolute
+	Ports       []string // As given to floka run -p
+	DependsOn   []string // Services started before this one
+	Restart     string   // Restart policy
+}
+
+// Build says how to build the image of a service
+type Build struct {
+	Context   string   // Absolute path of the build context
+	Flokafile string   // Path of the Flokafile, <Context>/flokafile when empty
+	Args      []string // KEY=VALUE build args
+	Target    string   // Stage to build, the last one when empty
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// FindFile returns the first of DefaultFiles in dir
+func FindFile(dir string) (string, error) {
+	for _, name := range DefaultFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no compose file found in %s, expected one of %s", dir, strings.Join(DefaultFiles, ", "))
+}
+
+// Load reads a compose file. The project is named name or, when empty,
+// after the directory of the file.
+func Load(path, name string) (*Project, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+	doc, err := yaml.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), ""), "_-")
+	if name == "" {
+		return nil, fmt.Errorf("invalid project name, give one with --project-name")
+	}
+	project := &Project{Name: name, Dir: dir, Services: map[string]*Service{}}
+
+	top, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+	for key := range top {
+		switch key {
+		case "services":
+		case "version", "volumes":
+			// The version is obsolete and volumes are created on first use
+		case "networks":
+			logging.L().Warn("ignoring networks, all containers share the floka0 network", "file", path)
+		default:
+			return nil, fmt.Errorf("%s: unsupported top-level key %q", path, key)
+		}
+	}
+	services, ok := top["services"].(map[string]interface{})
+	if !ok || len(services) == 0 {
+		return nil, fmt.Errorf("%s: no services defined", path)
+	}
+	for serviceName, def := range services {
+		service, err := project.parseService(serviceName, def)
+		if err != nil {
+			return nil, fmt.Errorf("%s: service %s: %w", path, serviceName, err)
+		}
+		project.Services[serviceName] = service
+	}
+
+	for _, service := range project.Services {
+		for _, dep := range service.DependsOn {
+			if _, ok := project.Services[dep]; !ok {
+				return nil, fmt.Errorf("%s: service %s depends on undefined service %s", path, service.Name, dep)
+			}
+		}
+	}
+	if _, err := project.Order(nil); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// parseService reads the definition of a service
+func (p *Project) parseService(name string, def interface{}) (*Service, error) {
+	if invalidNameChars.MatchString(name) {
+		return nil, fmt.Errorf("invalid service name, only [a-z0-9_-] are allowed")
+	}
+	fields, ok := def.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping")
+	}
+	service := &Service{Name: name}
+	var err error
+	for key, value := range fields {
+		switch key {
+		case "image":
+			service.Image, err = stringValue(value)
+		case "build":
+			service.Build, err = p.parseBuild(value)
+		case "command":
+			service.Command, err = commandValue(value)
+		case "environment":
+			service.Environment, err = environmentValue(value)
+		case "volumes":
+			var volumes []string
+			if volumes, err = listValue(value); err == nil {
+				service.Volumes = p.resolveVolumes(volumes)
+			}
+		case "ports":
+			service.Ports, err = listValue(value)
+		case "depends_on":
+			service.DependsOn, err = dependsOnValue(value)
+		case "restart":
+			service.Restart, err = stringValue(value)
+		default:
+			err = fmt.Errorf("unsupported key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if service.Image == "" && service.Build == nil {
+		return nil, fmt.Errorf("either image or build is required")
+	}
+	return service, nil
+}
+
+// parseBuild reads a build context given as a path or as a mapping with
+// context, dockerfile or flokafile, args and target
+func (p *Project) parseBuild(value interface{}) (*Build, error) {
+	var context, flokafile, target string
+	var args []string
+	switch v := value.(type) {
+	case string:
+		context = v
+	case map[string]interface{}:
+		for key, field := range v {
+			if key == "args" {
+				var err error
+				if args, err = environmentValue(field); err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				continue
+			}
+			s, err := stringValue(field)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			switch key {
+			case "context":
+				context = s
+			case "flokafile", "dockerfile":
+				flokafile = s
+			case "target":
+				target = s
+			default:
+				return nil, fmt.Errorf("unsupported key %q", key)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("expected a path or a mapping")
+	}
+	if context == "" {
+		context = "."
+	}
+	build := &Build{Context: p.path(context), Args: args, Target: target}
+	if flokafile != "" {
+		build.Flokafile = filepath.Join(build.Context, flokafile)
+		if filepath.IsAbs(flokafile) {
+			build.Flokafile = flokafile
+		}
+	}
+	return build, nil
+}
+
+// path resolves a path of the compose file against its directory
+func (p *Project) path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.Dir, path)
+}
+
+// resolveVolumes makes the relative host paths of volume specifications
+// absolute. Like floka run -v, sources starting with / or . are host paths.
+func (p *Project) resolveVolumes(specs []string) []string {
+	resolved := make([]string, len(specs))
+	for i, spec := range specs {
+		source, rest, ok := strings.Cut(spec, ":")
+		if ok && strings.HasPrefix(source, ".") {
+			spec = p.path(source) + ":" + rest
+		}
+		resolved[i] = spec
+	}
+	return resolved
+}
+
+// ImageRef returns the image a service runs, its own name for built images
+// without one
+func (p *Project) ImageRef(s *Service) string {
+	if s.Image != "" {
+		return s.Image
+	}
+	return p.Name + "-" + s.Name
+}
+
+// ContainerName returns the name of the container of a service
+func (p *Project) ContainerName(s *Service) string {
+	return p.Name + "-" + s.Name + "-1"
+}
+
+// Order returns the given services, or all of them when names is empty,
+// along with the services they depend on, each after its dependencies.
+// Services without an order between them are sorted by name.
+func (p *Project) Order(names []string) ([]*Service, error) {
+	if len(names) == 0 {
+		for name := range p.Services {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var order []*Service
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if done[name] {
+			return nil
+		}
+		service, ok := p.Services[name]
+		if !ok {
+			return fmt.Errorf("no such service: %s", name)
+		}
+		path = append(path, name)
+		if visiting[name] {
+			return fmt.Errorf("circular dependency between services: %s", strings.Join(path, " -> "))
+		}
+		visiting[name] = true
+		deps := append([]string(nil), service.DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		done[name] = true
+		order = append(order, service)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// stringValue reads a scalar
+func stringValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("expected a string")
+}
+
+// listValue reads a sequence of scalars
+func listValue(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		if value == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("expected a list")
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings")
+		}
+		list[i] = s
+	}
+	return list, nil
+}
+
+// commandValue reads a command given as a list or as a string split into
+// words like a shell would
+func commandValue(value interface{}) ([]string, error) {
+	if s, ok := value.(string); ok {
+		return splitWords(s)
+	}
+	return listValue(value)
+}
+
+// environmentValue reads variables given as a KEY=VALUE list or as a
+// mapping
+func environmentValue(value interface{}) ([]string, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return listValue(value)
+	}
+	var env []string
+	for key, v := range m {
+		s, err := stringValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		// A variable without a value comes from floka's environment
+		if v == nil {
+			env = append(env, key)
+		} else {
+			env = append(env, key+"="+s)
+		}
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// dependsOnValue reads dependencies given as a list or as a mapping of
+// services to conditions, which are ignored
+func dependsOnValue(value interface{}) ([]string, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return listValue(value)
+	}
+	var deps []string
+	for name := range m {
+		deps = append(deps, name)
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// splitWords splits a command into words, honouring single and double
+// quotes and backslash escapes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,269 @@
+// pkg/config/config.go
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Environment variables overriding the configuration
+const (
+	RootEnv   = "FLOKA_ROOT"   // Data root
+	ConfigEnv = "FLOKA_CONFIG" // Path of the config file
+)
+
+// DefaultRoot is the data root when running as root
+const DefaultRoot = "/var/lib/floka"
+
+// Config is the content of the floka config file
+type Config struct {
+	DataRoot string `json:"data-root,omitempty"` // Directory holding images, containers, volumes and networks
+	Init     bool   `json:"init,omitempty"`      // Run containers with an init process unless --init=false is given
+
+	CNIConfDir string `json:"cni-conf-dir,omitempty"` // Directory of the CNI network configurations, DefaultCNIConfDir when empty
+	CNIBinDir  string `json:"cni-bin-dir,omitempty"`  // Directories of the CNI plugins separated by colons, DefaultCNIBinDir when empty
+
+	RegistryMirrors     []string          `json:"registry-mirrors,omitempty"`      // Registries tried in order before Docker Hub for its images
+	LogDriver           string            `json:"log-driver,omitempty"`            // Log driver of containers run without --log-driver
+	LogOpts             map[string]string `json:"log-opts,omitempty"`              // Options of LogDriver, or of json-file without it
+	CgroupParent        string            `json:"cgroup-parent,omitempty"`         // Cgroup of containers run without --cgroup-parent
+	DefaultAddressPools []AddressPool     `json:"default-address-pools,omitempty"` // Where networks created without --subnet get theirs
+}
+
+// AddressPool is a range the subnets of networks are taken from, each of
+// Size bits of prefix, e.g. {"base": "10.10.0.0/16", "size": 24}
+type AddressPool struct {
+	Base string `json:"base"`
+	Size int    `json:"size"`
+}
+
+// Problems returns what is wrong with the config file at path: a file
+// that can't be read, settings floka doesn't know, which it ignores, and
+// the values Validate rejects
+func Problems(path string) []error {
+	cfg, err := Load(path)
+	if err != nil {
+		return []error{err}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg.Validate()
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return []error{fmt.Errorf("failed to parse config file %s: %w", path, err)}
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		known[name] = true
+	}
+	var errs []error
+	for name := range fields {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("unknown setting %q is ignored", name))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return append(errs, cfg.Validate()...)
+}
+
+// Validate returns the problems of the settings that can be checked
+// without the packages using them: those other packages check are
+// reported by them as they apply the settings
+func (c *Config) Validate() []error {
+	var errs []error
+	for _, mirror := range c.RegistryMirrors {
+		if _, err := MirrorURL(mirror); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, pool := range c.DefaultAddressPools {
+		if _, err := pool.Subnet(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// MirrorURL returns the scheme and host of a registry mirror, given as a
+// URL or a HOST[:PORT], which is then reached over HTTPS
+func MirrorURL(mirror string) (*url.URL, error) {
+	raw := mirror
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry mirror %q: %w", mirror, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry mirror %q: expected http:// or https:// and a host", mirror)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid registry mirror %q: it must not have a path", mirror)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// Subnet returns the base of the pool, checking Size fits it
+func (p AddressPool) Subnet() (*net.IPNet, error) {
+	_, base, err := net.ParseCIDR(p.Base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool base %q: %w", p.Base, err)
+	}
+	if base.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid address pool base %q: it is not an IPv4 subnet", p.Base)
+	}
+	if ones, _ := base.Mask.Size(); p.Size < ones || p.Size > 30 {
+		return nil, fmt.Errorf("invalid address pool %s: size %d must be between %d and 30", p.Base, p.Size, ones)
+	}
+	return base, nil
+}
+
+// Where CNI network configurations and plugins are found by default
+const (
+	DefaultCNIConfDir = "/etc/cni/net.d"
+	DefaultCNIBinDir  = "/opt/cni/bin"
+)
+
+var (
+	mu      sync.Mutex
+	root    string
+	current *Config
+)
+
+// Path returns the location of the config file: $FLOKA_CONFIG, else
+// /etc/floka/config.json for root and $XDG_CONFIG_HOME/floka/config.json
+// for other users
+func Path() string {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path
+	}
+	if os.Geteuid() == 0 {
+		return "/etc/floka/config.json"
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "/etc/floka/config.json"
+	}
+	return filepath.Join(dir, "floka", "config.json")
+}
+
+// Load reads a config file. A missing file is an empty configuration.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Current returns the content of the config file, read when first needed.
+// Every floka command reads it anew, so that changes apply from the next
+// one on. A file that can't be read is reported and taken as empty.
+func Current() *Config {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		cfg, err := Load(Path())
+		if err != nil {
+			logging.L().Warn("ignoring config file", "err", err)
+			cfg = &Config{}
+		}
+		current = cfg
+	}
+	return current
+}
+
+// defaultRoot returns the data root used when nothing is configured,
+// $XDG_DATA_HOME/floka or ~/.local/share/floka for users other than root
+func defaultRoot() string {
+	if os.Geteuid() == 0 {
+		return DefaultRoot
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "floka")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return DefaultRoot
+	}
+	return filepath.Join(home, ".local", "share", "floka")
+}
+
+// resolve picks the data root from, in order, dataRoot (the --data-root
+// flag), $FLOKA_ROOT, the config file and the default
+func resolve(dataRoot string) (string, error) {
+	if dataRoot == "" {
+		dataRoot = os.Getenv(RootEnv)
+	}
+	if dataRoot == "" {
+		cfg, err := Load(Path())
+		if err != nil {
+			return "", err
+		}
+		dataRoot = cfg.DataRoot
+	}
+	if dataRoot == "" {
+		dataRoot = defaultRoot()
+	}
+	abs, err := filepath.Abs(dataRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid data root %q: %w", dataRoot, err)
+	}
+	return abs, nil
+}
+
+// Init sets the data root, giving dataRoot precedence over the environment
+// and the config file, and exports it for child floka processes
+func Init(dataRoot string) error {
+	resolved, err := resolve(dataRoot)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	root = resolved
+	mu.Unlock()
+	os.Setenv(RootEnv, resolved)
+	return nil
+}
+
+// Root returns the data root. Without Init it is resolved from the
+// environment and the config file on first use.
+func Root() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if root == "" {
+		resolved, err := resolve("")
+		if err != nil {
+			logging.L().Warn("ignoring config file", "err", err)
+			resolved, _ = filepath.Abs(defaultRoot())
+		}
+		root = resolved
+	}
+	return root
+}
+
+// DataPath joins path elements to the data root
+func DataPath(elem ...string) string {
+	return filepath.Join(append([]string{Root()}, elem...)...)
+}
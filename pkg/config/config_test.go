@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string // Beginnings of the problems
+	}{
+		{"valid", `{"data-root": "/srv/floka", "registry-mirrors": ["mirror.example.com", "http://10.0.0.1:5000/"], "log-driver": "syslog", "log-opts": {"tag": "x"}, "cgroup-parent": "apps", "default-address-pools": [{"base": "10.10.0.0/16", "size": 24}]}`, nil},
+		{"unknown settings", `{"default-runtime": "runc", "debug": true, "init": true}`, []string{
+			`unknown setting "debug" is ignored`,
+			`unknown setting "default-runtime" is ignored`,
+		}},
+		{"invalid mirrors", `{"registry-mirrors": ["ftp://mirror", "https://", "https://mirror/v2"]}`, []string{
+			`invalid registry mirror "ftp://mirror": expected http:// or https:// and a host`,
+			`invalid registry mirror "https://": expected http:// or https:// and a host`,
+			`invalid registry mirror "https://mirror/v2": it must not have a path`,
+		}},
+		{"invalid address pools", `{"default-address-pools": [{"base": "10.0.0.0", "size": 24}, {"base": "fd00::/64", "size": 80}, {"base": "10.0.0.0/16", "size": 8}, {"base": "10.0.0.0/16", "size": 31}]}`, []string{
+			`invalid address pool base "10.0.0.0": invalid CIDR address: 10.0.0.0`,
+			`invalid address pool base "fd00::/64": it is not an IPv4 subnet`,
+			`invalid address pool 10.0.0.0/16: size 8 must be between 16 and 30`,
+			`invalid address pool 10.0.0.0/16: size 31 must be between 16 and 30`,
+		}},
+		{"not JSON", `{"init": }`, []string{"failed to parse config file"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, err := range Problems(path) {
+				got = append(got, err.Error())
+			}
+			ok := len(got) == len(tt.want)
+			for i := 0; ok && i < len(got); i++ {
+				ok = strings.HasPrefix(got[i], tt.want[i])
+			}
+			if !ok {
+				t.Errorf("problems are %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemsMissingFile(t *testing.T) {
+	if errs := Problems(filepath.Join(t.TempDir(), "config.json")); len(errs) > 0 {
+		t.Errorf("a missing config file has problems: %v", errs)
+	}
+}
+
+func TestMirrorURL(t *testing.T) {
+	tests := []struct {
+		mirror string
+		want   string
+	}{
+		{"mirror.example.com", "https://mirror.example.com"},
+		{"mirror.example.com:5000", "https://mirror.example.com:5000"},
+		{"http://10.0.0.1:5000", "http://10.0.0.1:5000"},
+		{"https://mirror.example.com/", "https://mirror.example.com"},
+	}
+
+	for _, tt := range tests {
+		u, err := MirrorURL(tt.mirror)
+		if err != nil {
+			t.Errorf("MirrorURL(%q) failed: %v", tt.mirror, err)
+			continue
+		}
+		if u.String() != tt.want {
+			t.Errorf("MirrorURL(%q) = %s, want %s", tt.mirror, u, tt.want)
+		}
+	}
+}
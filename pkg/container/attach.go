@@ -0,0 +1,465 @@
+// pkg/container/attach.go
+package container
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// DefaultDetachKeys is the key sequence detaching "floka attach" from a
+// container
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// ErrDetached is returned by Attach when the detach keys were read
+var ErrDetached = errors.New("detached from container")
+
+// Streams of the frames of the attach socket. Each frame is a header of
+// the stream and three zero bytes, the size of the payload as a big-endian
+// uint32, then the payload.
+const (
+	attachStdin  byte = 0
+	attachStdout byte = 1
+	attachStderr byte = 2
+	attachResize byte = 3 // Rows and columns as big-endian uint16s
+)
+
+// attachMaxFrame is the largest payload of a frame of the attach socket
+const attachMaxFrame = 1 << 20
+
+// attachWriteTimeout is how long a client of the attach socket may block
+// the container's output before it is dropped
+const attachWriteTimeout = 5 * time.Second
+
+// TerminalSize is the size of the terminal of a container
+type TerminalSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// AttachOptions are the streams Attach connects to the container's
+type AttachOptions struct {
+	Stdin      io.Reader // Sent to the container's stdin when it was run with -i, nil to send nothing
+	Stdout     io.Writer
+	Stderr     io.Writer
+	DetachKeys []byte              // Sequence read from Stdin that detaches, none when empty
+	Resize     <-chan TerminalSize // Sizes of the terminal of a container run with -t
+}
+
+// stdio holds the ends of the container's stdio that floka keeps
+type stdio interface {
+	// stdin is given to the container as it is when it has no terminal,
+	// nil for /dev/null
+	stdin() *os.File
+	stdout() io.Writer
+	stderr() io.Writer
+	// setTerminal is told of the pty master of each run of a container
+	// with a terminal, and of nil once the run exited
+	setTerminal(master *os.File)
+}
+
+// ParseDetachKeys parses a comma-separated key sequence like
+// ctrl-p,ctrl-q, each key being a character or ctrl- and one of a-z, @,
+// [, \, ], ^ or _
+func ParseDetachKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		ctrl, isCtrl := strings.CutPrefix(key, "ctrl-")
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case isCtrl && len(ctrl) == 1 && ctrl[0] >= 'a' && ctrl[0] <= 'z':
+			keys = append(keys, ctrl[0]-'a'+1)
+		case isCtrl && len(ctrl) == 1 && strings.Contains("@[\\]^_", ctrl):
+			keys = append(keys, ctrl[0]-'@')
+		default:
+			return nil, fmt.Errorf("invalid detach key %q, expected a character or ctrl-<a-z|@|[|\\|]|^|_>", key)
+		}
+	}
+	return keys, nil
+}
+
+// attachSocket returns the socket the shim of a detached container serves
+// its stdio on
+func (c *Container) attachSocket() string {
+	return config.DataPath("containers", c.ID, "attach.sock")
+}
+
+// Attach connects the given streams to the stdio of a running detached
+// container until it exits, or the detach keys are read from opts.Stdin,
+// in which case it returns ErrDetached. Output of the container is only
+// seen from when Attach connects.
+func (c *Container) Attach(opts *AttachOptions) error {
+	if c.Status != "running" && c.Status != "paused" && c.Status != "restarting" {
+		return fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+	conn, err := net.Dial("unix", c.attachSocket())
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("container %s can't be attached to, only containers run with -d can", c.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to container %s: %w", c.ID, err)
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(stream byte, p []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(conn, stream, p)
+	}
+
+	detachedCh := make(chan struct{})
+	if opts.Stdin != nil {
+		go func() {
+			if copyInput(opts.Stdin, opts.DetachKeys, func(p []byte) error { return send(attachStdin, p) }) {
+				close(detachedCh)
+				conn.Close()
+			}
+		}()
+	}
+	if opts.Resize != nil {
+		go func() {
+			for size := range opts.Resize {
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint16(payload, size.Rows)
+				binary.BigEndian.PutUint16(payload[2:], size.Cols)
+				if send(attachResize, payload) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	for {
+		stream, payload, err := readFrame(conn)
+		if err != nil {
+			select {
+			case <-detachedCh:
+				return ErrDetached
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read from container %s: %w", c.ID, err)
+		}
+		switch stream {
+		case attachStdout:
+			stdout.Write(payload)
+		case attachStderr:
+			stderr.Write(payload)
+		}
+	}
+}
+
+// copyInput sends what is read from r until it ends or the detach keys
+// are read, which it reports. Keys that start the sequence are held back
+// until it is clear whether the sequence follows.
+func copyInput(r io.Reader, keys []byte, send func([]byte) error) bool {
+	buf := make([]byte, 32*1024)
+	matched := 0
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			var out []byte
+			for _, b := range buf[:n] {
+				if len(keys) > 0 && b == keys[matched] {
+					matched++
+					if matched == len(keys) {
+						if len(out) > 0 {
+							send(out)
+						}
+						return true
+					}
+					continue
+				}
+				out = append(out, keys[:matched]...)
+				matched = 0
+				if len(keys) > 0 && b == keys[0] {
+					matched = 1
+					continue
+				}
+				out = append(out, b)
+			}
+			if len(out) > 0 && send(out) != nil {
+				return false
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// writeFrame writes a payload of a stream to the attach socket
+func writeFrame(w io.Writer, stream byte, p []byte) error {
+	frame := make([]byte, 8+len(p))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(p)))
+	copy(frame[8:], p)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame of the attach socket
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > attachMaxFrame {
+		return 0, nil, fmt.Errorf("attach frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// attachServer holds the stdio of a detached container in its shim and
+// relays them to the clients of its attach socket, across restarts
+type attachServer struct {
+	c        *Container
+	listener net.Listener
+	stdinR   *os.File // The container's stdin when run with -i
+	stdinW   *os.File
+
+	mu       sync.Mutex
+	clients  map[net.Conn]bool
+	terminal *os.File      // pty master of the current run
+	size     *term.Winsize // Last size a client gave the terminal
+}
+
+// serveAttach starts serving the container's attach socket
+func (c *Container) serveAttach() (*attachServer, error) {
+	path := c.attachSocket()
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on attach socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		logging.L().Warn("failed to restrict attach socket", "path", path, "err", err)
+	}
+
+	s := &attachServer{c: c, listener: listener, clients: map[net.Conn]bool{}}
+	if c.OpenStdin && !c.Tty {
+		if s.stdinR, s.stdinW, err = os.Pipe(); err != nil {
+			listener.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+	}
+	go s.accept()
+	return s, nil
+}
+
+// accept serves the clients of the attach socket until it is closed
+func (s *attachServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+		logging.L().Debug("client attached", "container", s.c.ID)
+		go s.serve(conn)
+	}
+}
+
+// serve passes on the input of a client until it disconnects
+func (s *attachServer) serve(conn net.Conn) {
+	defer s.drop(conn)
+	for {
+		stream, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch stream {
+		case attachStdin:
+			if !s.c.OpenStdin {
+				continue
+			}
+			s.mu.Lock()
+			w := s.stdinW
+			if s.c.Tty {
+				w = s.terminal
+			}
+			s.mu.Unlock()
+			if w != nil {
+				w.Write(payload)
+			}
+		case attachResize:
+			if len(payload) != 4 || !s.c.Tty {
+				continue
+			}
+			s.mu.Lock()
+			s.size = &term.Winsize{Rows: binary.BigEndian.Uint16(payload), Cols: binary.BigEndian.Uint16(payload[2:])}
+			if s.terminal != nil {
+				term.SetWinsize(s.terminal.Fd(), s.size)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// drop disconnects a client
+func (s *attachServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[conn] {
+		delete(s.clients, conn)
+		conn.Close()
+		logging.L().Debug("client detached", "container", s.c.ID)
+	}
+}
+
+// broadcast sends output of the container to every client
+func (s *attachServer) broadcast(stream byte, p []byte) {
+	s.mu.Lock()
+	var clients []net.Conn
+	for conn := range s.clients {
+		clients = append(clients, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range clients {
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if err := writeFrame(conn, stream, p); err != nil {
+			s.drop(conn)
+		}
+	}
+}
+
+// close stops serving the attach socket and disconnects the clients
+func (s *attachServer) close() {
+	s.listener.Close()
+	os.Remove(s.c.attachSocket())
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = map[net.Conn]bool{}
+	s.mu.Unlock()
+	if s.stdinW != nil {
+		s.stdinW.Close()
+		s.stdinR.Close()
+	}
+}
+
+func (s *attachServer) stdin() *os.File { return s.stdinR }
+
+func (s *attachServer) stdout() io.Writer { return streamWriter{s, attachStdout} }
+
+func (s *attachServer) stderr() io.Writer { return streamWriter{s, attachStderr} }
+
+func (s *attachServer) setTerminal(master *os.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.terminal = master
+	if master != nil && s.size != nil {
+		term.SetWinsize(master.Fd(), s.size)
+	}
+}
+
+// streamWriter writes to a stream of the attach socket's clients
+type streamWriter struct {
+	s      *attachServer
+	stream byte
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.s.broadcast(w.stream, p)
+	return len(p), nil
+}
+
+// localStdio is the stdio of floka itself, which containers run in the
+// foreground use. The terminal of each run gets what is read from floka's
+// stdin and the size of floka's terminal.
+type localStdio struct {
+	mu       sync.Mutex
+	once     sync.Once
+	terminal *os.File
+}
+
+var local = &localStdio{}
+
+func (l *localStdio) stdin() *os.File { return os.Stdin }
+
+func (l *localStdio) stdout() io.Writer { return os.Stdout }
+
+func (l *localStdio) stderr() io.Writer { return os.Stderr }
+
+func (l *localStdio) setTerminal(master *os.File) {
+	l.mu.Lock()
+	l.terminal = master
+	l.mu.Unlock()
+	if master == nil {
+		return
+	}
+	l.resize()
+
+	// A single reader of our stdin serves the runs of a restarting
+	// container one after the other
+	l.once.Do(func() {
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		go func() {
+			for range resize {
+				l.resize()
+			}
+		}()
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := os.Stdin.Read(buf)
+				if n > 0 {
+					l.mu.Lock()
+					if l.terminal != nil {
+						l.terminal.Write(buf[:n])
+					}
+					l.mu.Unlock()
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+}
+
+// resize gives the current terminal the size of floka's
+func (l *localStdio) resize() {
+	ws, err := term.GetWinsize(os.Stdin.Fd())
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.terminal != nil {
+		term.SetWinsize(l.terminal.Fd(), ws)
+	}
+}
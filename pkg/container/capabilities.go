@@ -0,0 +1,207 @@
+// pkg/container/capabilities.go
+package container
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// CapsVar passes the capabilities of the container to "floka
+// containerize", comma-separated, or AllCapabilities to keep them all
+const CapsVar = "FLOKA_CONTAINER_CAPS"
+
+// AllCapabilities stands for every capability in --cap-add and --cap-drop
+const AllCapabilities = "ALL"
+
+// capabilities are the capabilities floka knows, by name
+var capabilities = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// DefaultCapabilities are the capabilities of containers, Docker's
+// default set. Those left out let processes change the host or escape the
+// container, such as CAP_SYS_ADMIN, CAP_NET_ADMIN or CAP_SYS_MODULE.
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// NormalizeCapabilities returns capability names as the kernel spells
+// them, accepting them in any case and without their CAP_ prefix, and ALL
+func NormalizeCapabilities(names []string) ([]string, error) {
+	var normalized []string
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if name != AllCapabilities && !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		if _, ok := capabilities[name]; !ok && name != AllCapabilities {
+			return nil, fmt.Errorf("unknown capability %s", name)
+		}
+		normalized = append(normalized, name)
+	}
+	return normalized, nil
+}
+
+// FormatCapabilities returns caps as CapsVar gives them
+func FormatCapabilities(caps []string) string {
+	if caps == nil {
+		return AllCapabilities
+	}
+	return strings.Join(caps, ",")
+}
+
+// ParseCapabilities parses capabilities given as in CapsVar, returning
+// nil for all of them
+func ParseCapabilities(s string) []string {
+	switch s {
+	case AllCapabilities:
+		return nil
+	case "":
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// Capabilities returns the capabilities the container's processes have,
+// nil for a privileged container, which keeps them all. Like Docker's,
+// --cap-add ALL keeps all but those dropped and --cap-drop ALL only those
+// added; otherwise those added are added to the default set and those
+// dropped removed from it.
+func (c *Container) Capabilities() []string {
+	if c.Privileged {
+		return nil
+	}
+	var caps []string
+	switch {
+	case slices.Contains(c.CapAdd, AllCapabilities):
+		for name := range capabilities {
+			if !slices.Contains(c.CapDrop, name) {
+				caps = append(caps, name)
+			}
+		}
+	case slices.Contains(c.CapDrop, AllCapabilities):
+		caps = append(caps, c.CapAdd...)
+	default:
+		for _, name := range DefaultCapabilities {
+			if !slices.Contains(c.CapDrop, name) {
+				caps = append(caps, name)
+			}
+		}
+		for _, name := range c.CapAdd {
+			if !slices.Contains(caps, name) {
+				caps = append(caps, name)
+			}
+		}
+	}
+	slices.Sort(caps)
+	if caps == nil {
+		caps = []string{}
+	}
+	return caps
+}
+
+// DropCapabilities removes the capabilities not in caps from the bounding
+// set of the calling thread, which limits those of the commands it
+// executes. It needs CAP_SETPCAP.
+func DropCapabilities(caps []string) error {
+	for c := 0; ; c++ {
+		_, err := unix.PrctlRetInt(unix.PR_CAPBSET_READ, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			// Past the last capability of the kernel
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read capability bounding set: %w", err)
+		}
+		if capabilityIn(c, caps) {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("failed to drop capability %d: %w", c, err)
+		}
+	}
+}
+
+// SetCapabilities sets the effective and permitted capabilities of the
+// calling thread to caps and clears its inheritable ones, so that an
+// executed command can't get back capabilities of the bounding set
+// dropped
+func SetCapabilities(caps []string) error {
+	var data [2]unix.CapUserData
+	for _, name := range caps {
+		c := capabilities[name]
+		data[c/32].Effective |= 1 << (c % 32)
+		data[c/32].Permitted |= 1 << (c % 32)
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+	return nil
+}
+
+func capabilityIn(c int, caps []string) bool {
+	for _, name := range caps {
+		if n, ok := capabilities[name]; ok && n == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+// pkg/container/cgroup.go
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// DefaultCgroupParent is the cgroup containers are created in unless
+// another parent is given
+const DefaultCgroupParent = "floka"
+
+// CheckCgroupParent reports whether parent can hold container cgroups: a
+// path below the root of the cgroup hierarchies, or a systemd slice name
+// such as "machine.slice" or "user-1000.slice"
+func CheckCgroupParent(parent string) error {
+	if parent == "" {
+		return nil
+	}
+	if strings.HasSuffix(parent, ".slice") {
+		_, err := expandSlice(parent)
+		return err
+	}
+	for _, elem := range strings.Split(parent, "/") {
+		if elem == ".." {
+			return fmt.Errorf("invalid cgroup parent %q: it must not leave the cgroup hierarchy", parent)
+		}
+	}
+	if strings.Trim(parent, "/") == "" {
+		return fmt.Errorf("invalid cgroup parent %q: it must not be the root cgroup", parent)
+	}
+	return nil
+}
+
+// cgroupName returns the path of a container's cgroup relative to the root
+// of the cgroup hierarchies. Under a systemd slice, the container gets a
+// scope named as systemd would.
+func cgroupName(parent, containerID string) string {
+	if parent == "" {
+		parent = DefaultCgroupParent
+	}
+	if strings.HasSuffix(parent, ".slice") {
+		if slice, err := expandSlice(parent); err == nil {
+			return filepath.Join(slice, "floka-"+containerID+".scope")
+		}
+	}
+	return strings.TrimPrefix(filepath.Join("/", parent, containerID), "/")
+}
+
+// expandSlice returns the path of a systemd slice, every dash in its name
+// starting a nested slice: "user-1000.slice" is user.slice/user-1000.slice
+func expandSlice(slice string) (string, error) {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, "-") ||
+		strings.HasSuffix(name, "-") || strings.Contains(name, "--") {
+		return "", fmt.Errorf("invalid systemd slice %q", slice)
+	}
+	var path []string
+	prefix := ""
+	for _, part := range strings.Split(name, "-") {
+		prefix += part
+		path = append(path, prefix+".slice")
+		prefix += "-"
+	}
+	return filepath.Join(path...), nil
+}
+
+// cgroup returns the path of the container's cgroup relative to the root
+// of the cgroup hierarchies
+func (c *Container) cgroup() string {
+	return cgroupName(c.CgroupParent, c.ID)
+}
+
+// enableCgroupControllers enables the cgroupV2Controllers for the children
+// of every cgroup v2 directory from root down to parent. It fails if one
+// of the required controllers can't be enabled, and skips the others where
+// the kernel or a parent doesn't provide them.
+func enableCgroupControllers(root, parent string, required []string) error {
+	dirs := []string{parent}
+	for dir := parent; dir != root && dir != filepath.Dir(dir); {
+		dir = filepath.Dir(dir)
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	isRequired := map[string]bool{}
+	for _, controller := range required {
+		isRequired[controller] = true
+	}
+	skipped := map[string]bool{}
+	for _, dir := range dirs {
+		// cgroup.controllers lists those the parent makes available, which
+		// are the only ones subtree_control accepts
+		available, err := readCgroupList(filepath.Join(dir, "cgroup.controllers"))
+		if err != nil {
+			return fmt.Errorf("failed to read available cgroup controllers: %w", err)
+		}
+		enabled, err := readCgroupList(filepath.Join(dir, "cgroup.subtree_control"))
+		if err != nil {
+			return fmt.Errorf("failed to read enabled cgroup controllers: %w", err)
+		}
+
+		for _, controller := range cgroupV2Controllers {
+			if skipped[controller] || enabled[controller] {
+				continue
+			}
+			if !available[controller] {
+				if isRequired[controller] {
+					return fmt.Errorf("cgroup controller %s is not available in %s", controller, dir)
+				}
+				logging.L().Debug("cgroup controller not available", "controller", controller, "path", dir)
+				skipped[controller] = true
+				continue
+			}
+			err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+			if err == nil {
+				continue
+			}
+			// A cgroup other than the root can't both have processes and
+			// pass controllers on to its children
+			if errors.Is(err, syscall.EBUSY) {
+				err = fmt.Errorf("%w, it has processes of its own", err)
+			}
+			if isRequired[controller] {
+				return fmt.Errorf("failed to enable cgroup controller %s in %s: %w", controller, dir, err)
+			}
+			logging.L().Debug("failed to enable cgroup controller", "controller", controller, "path", dir, "err", err)
+			skipped[controller] = true
+		}
+	}
+	return nil
+}
+
+// readCgroupList reads a cgroup file listing names separated by spaces,
+// such as cgroup.controllers
+func readCgroupList(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, name := range strings.Fields(string(data)) {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// openCgroupForClone opens a container's cgroup v2 directory to start a
+// process right in it with CLONE_INTO_CGROUP, which appeared in Linux 5.7.
+// It returns nil where that isn't possible.
+func openCgroupForClone(cgroup string) *os.File {
+	cgroupPath := "/sys/fs/cgroup"
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err != nil {
+		return nil
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return nil
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(unix.ByteSliceToString(uts.Release[:]), "%d.%d", &major, &minor); err != nil {
+		return nil
+	}
+	if major < 5 || major == 5 && minor < 7 {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(cgroupPath, cgroup))
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// cpuPeriod is the period in microseconds over which the CPU time quota
+// of containers is enforced, as Docker has it
+const cpuPeriod = 100000
+
+// minCPUQuota is the lowest CPU time quota per period the kernel accepts
+const minCPUQuota = 1000
+
+// cpuQuota returns the CPU time per cpuPeriod, in microseconds, of a quota
+// given in billionths of a CPU
+func cpuQuota(nanoCPUs int64) int64 {
+	return nanoCPUs * cpuPeriod / 1e9
+}
+
+// cpuWeight converts Docker-style CPU shares (2-262144) to a cgroup v2
+// weight (1-10000)
+func cpuWeight(shares int64) int64 {
+	weight := 1 + ((shares-2)*9998)/262142
+	if weight < 1 {
+		weight = 1
+	}
+	if weight > 10000 {
+		weight = 10000
+	}
+	return weight
+}
@@ -2,53 +2,340 @@
 package container
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/internal/term"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logdriver"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/network"
 )
 
 // Container represents a running container
 type Container struct {
     ID      string
-    Image   string
+    Name    string // Optional unique name given with --name
+    Image   string // Image name:tag, or the image rootfs path for containers of older versions
+    ImageID string `json:",omitempty"` // ID of the image, which keeps it from being removed
+    Layers  []string `json:",omitempty"` // Read-only image layers under the rootfs, top first
     Command []string
+    Env     []string // KEY=VALUE variables of the container's processes
+    WorkingDir string `json:",omitempty"` // Directory processes start in, / when empty
+    User    string `json:",omitempty"` // User processes run as, NAME|UID[:GROUP|GID], root when empty
+    Labels  map[string]string `json:",omitempty"` // Metadata given with --label
     Status  string
     Pid     int
+    PidStartTime uint64 `json:",omitempty"` // Start time of Pid, which tells it from a later process reusing the PID
+    SupervisorPid int `json:",omitempty"` // floka process waiting for the container and recording its exit
+    SupervisorStartTime uint64 `json:",omitempty"` // Start time of SupervisorPid
+    Mounts  []Mount
+    LogConfig LogConfig
+    NetworkMode string `json:",omitempty"` // Network name, none, host or container:<id>, bridge when empty
+    Network   *network.Endpoint `json:",omitempty"` // Address on the container's network
+    Hostname  string   `json:",omitempty"` // Hostname given with --hostname, derived from the ID when empty
+    DNS       []string `json:",omitempty"` // Name servers given with --dns, the host's when empty
+    DNSSearch []string `json:",omitempty"` // Search domains given with --dns-search, the host's when empty
+    ExtraHosts []string `json:",omitempty"` // HOST:IP entries added to /etc/hosts
+    Ports     []network.PortMapping `json:",omitempty"` // Ports published on the host
+    StorageDriver string // How the rootfs is provided, see StorageOverlay
+    
+    Memory    int64 // Memory limit in bytes, 0 for none
+    MemorySwap int64 `json:",omitempty"` // Memory plus swap limit in bytes, -1 for unlimited swap, 0 for the default
+    MemoryReservation int64 `json:",omitempty"` // Memory soft limit in bytes, 0 for none
+    OOMKillDisable bool `json:",omitempty"` // Keeps the OOM killer from killing processes over the limit (cgroup v1 only)
+    OOMScoreAdj int `json:",omitempty"` // oom_score_adj of the container's processes
+    CPUShares int64 // CPU shares, 0 for the default weight
+    NanoCPUs int64 `json:",omitempty"` // CPU time quota in billionths of a CPU, 0 for none
+    PidsLimit int64 `json:",omitempty"` // Maximum number of processes, 0 for none
+    CgroupParent string `json:",omitempty"` // Cgroup the container's cgroup is created in, DefaultCgroupParent when empty
+    
+    RestartPolicy   string `json:",omitempty"` // When to start the container again once it exited, see RestartAlways
+    RestartCount    int    `json:",omitempty"` // Restarts done under the restart policy
+    ManuallyStopped bool   `json:",omitempty"` // Stopped with Stop, which keeps it from being restarted
+    StoppedByShutdown bool `json:",omitempty"` // Stopped by Shutdown, which Restore doesn't take as stopped by hand
+    Privileged      bool     `json:",omitempty"` // Keeps all capabilities and runs unconfined
+    CapAdd          []string `json:",omitempty"` // Capabilities added to the default ones, see Capabilities
+    CapDrop         []string `json:",omitempty"` // Capabilities removed from the default ones
+    Seccomp         string `json:",omitempty"` // Seccomp profile, the default one when empty, SeccompUnconfined, or the path of the container's copy of a profile file
+    Devices         []Device `json:",omitempty"` // Host devices added to the default ones
+    EtcMountPoints  []string `json:",omitempty"` // /etc files created in a bind rootfs to mount the generated ones on
+    Init            bool     `json:",omitempty"` // containerize forwards signals to the command and reaps zombies
+    Tty             bool     `json:",omitempty"` // The command runs on a pseudo-terminal
+    OpenStdin       bool     `json:",omitempty"` // Detached, the command's stdin takes what clients attached to it send
+    Pod             string   `json:",omitempty"` // Name of the pod the container belongs to, see CreatePod
+    PodInfra        bool     `json:",omitempty"` // Holds the namespaces of the pod instead of running a command
+    PodSharePID     bool     `json:",omitempty"` // Of an infra container, the pod's containers share its PID namespace
+    
+    Created    time.Time
+    StartedAt  time.Time
+    FinishedAt time.Time
+    ExitCode   int
+    OOMKilled  bool `json:",omitempty"` // The OOM killer killed a process of the container during its last run
+    
+    started func() // Called once the container process is running
+    streams stdio  // Ends of the container's stdio, floka's own when nil
+}
+
+// LogConfig selects the log driver that receives the container's output
+type LogConfig struct {
+    Type   string
+    Config map[string]string
 }
 
 type ContainerOpts struct {
+    Name      string // Unique name for the container, none when empty
+    Env       []string // KEY=VALUE variables on top of the default environment
+    WorkingDir string // Directory the command runs in, / when empty
+    User      string // User the command runs as, NAME|UID[:GROUP|GID], root when empty
+    Labels    map[string]string // Metadata to attach to the container
+    NetworkMode string // Network name, none, host or container:<name|id>, bridge when empty
+    Ports     []network.PortMapping // Container ports to publish on the host
+    Hostname  string // Hostname of the container, derived from its ID when empty
+    DNS       []string // Name servers of the container, the host's when empty
+    DNSSearch []string // DNS search domains, the host's when empty, "." for none
+    ExtraHosts []string // HOST:IP entries to add to /etc/hosts
     Memory    int64 // Memory limit in bytes
+    MemorySwap int64 // Memory plus swap limit in bytes, at least Memory, or -1 for unlimited swap
+    MemoryReservation int64 // Memory soft limit in bytes, reclaimed last under memory pressure
+    OOMKillDisable bool // Don't OOM kill processes over the memory limit, cgroup v1 only
+    OOMScoreAdj int // oom_score_adj of the container's processes, -1000 to 1000
     CPUShares int64 // CPU shares (relative weight)
+    NanoCPUs  int64 // CPU time quota in billionths of a CPU, 0 for none
+    PidsLimit int64 // Maximum number of processes, 0 for none
+    CgroupParent string // Cgroup path or systemd slice to create the container's cgroup in, DefaultCgroupParent when empty
+    Mounts    []Mount // Volumes to mount into the container
+    LogConfig LogConfig // Log driver, json-file when empty
+    Detach    bool // Run the container in the background instead of waiting for it
+    StorageDriver string // Rootfs storage driver, overlay falling back to vfs when empty
+    Layers    []string // Image layer directories stacked into the rootfs, top first
+    ImageID   string // ID of the image the layers belong to
+    RestartPolicy string // no, on-failure[:max], always or unless-stopped
+    Seccomp   string // Seccomp profile file, or SeccompUnconfined, the default profile when empty
+    CapAdd    []string // Capabilities to add to the default set, or ALL
+    CapDrop   []string // Capabilities to remove from the default set, or ALL
+    Privileged bool // Keep all capabilities and run without a seccomp profile
+    Devices   []Device // Host devices to create in the container, see ParseDevice
+    Init      bool // Forward signals to the command and reap zombies in the container
+    Tty       bool // Run the command on a pseudo-terminal
+    OpenStdin bool // Keep the stdin of a detached container open for clients attaching to it
+    CIDFile   string // File to write the container's ID to once it is created, which must not exist
+    Pod       string // Pod whose namespaces the container joins, see CreatePod
+    
+    infraOf   string // Pod the container is created as the infra container of
+    sharePID  bool // The infra container's pod shares its PID namespace
 }
 
-// Run creates and starts a new container
-func Run(image string, command []string, opts *ContainerOpts) (*Container, error) {
+// Run creates and starts a new container from the image whose layers are
+// given in opts. Unless it is detached, cancelling ctx stops the container
+// like Stop would.
+func Run(ctx context.Context, image string, command []string, opts *ContainerOpts) (*Container, error) {
+    container, err := Create(image, command, opts)
+    if err != nil {
+        return container, err
+    }
+    
+    // Start the container process, either under a background shim or
+    // attached to us
+    if opts != nil && opts.Detach {
+        if err := container.startDetached(); err != nil {
+            return container, err
+        }
+        return container, nil
+    }
+    rootfs := config.DataPath("containers", container.ID, "rootfs")
+    if err := container.supervise(ctx, rootfs); err != nil {
+    	return container, err
+    }
+    
+    return container, nil
+}
+
+// Create sets up a new container from the image whose layers are given in
+// opts, its rootfs, mounts, cgroup and network address, and records it
+// with the created status without starting it; Launch starts it. When it
+// fails after the container was recorded, the container is returned along
+// with the error, for the caller to remove.
+func Create(image string, command []string, opts *ContainerOpts) (*Container, error) {
+    if opts != nil && opts.Name != "" {
+        if err := checkName(opts.Name); err != nil {
+            return nil, err
+        }
+    }
+    if opts != nil {
+        if _, _, err := parseRestartPolicy(opts.RestartPolicy); err != nil {
+            return nil, err
+        }
+        if err := checkMemoryOpts(opts); err != nil {
+            return nil, err
+        }
+        if err := checkLimitOpts(opts); err != nil {
+            return nil, err
+        }
+        if err := checkNameResolutionOpts(opts); err != nil {
+            return nil, err
+        }
+    }
+    // The config file's defaults apply to what the container doesn't set
+    cfg := config.Current()
+    cgroupParent := cfg.CgroupParent
+    if opts != nil && opts.CgroupParent != "" {
+        cgroupParent = opts.CgroupParent
+        if err := CheckCgroupParent(cgroupParent); err != nil {
+            return nil, err
+        }
+    } else if err := CheckCgroupParent(cgroupParent); err != nil {
+        return nil, fmt.Errorf("config file: %w", err)
+    }
+    logConfig := LogConfig{Type: cfg.LogDriver, Config: cfg.LogOpts}
+    if logConfig.Type == "" {
+        logConfig.Type = logdriver.DefaultDriver
+    }
+    if opts != nil && opts.LogConfig.Type != "" {
+        logConfig = opts.LogConfig
+    }
+    networkMode, networkTarget := NetworkBridge, (*Container)(nil)
+    var pod *Pod
+    if opts != nil {
+        // The containers of a pod share the network of its infra container
+        mode := opts.NetworkMode
+        if opts.Pod != "" {
+            if mode != "" {
+                return nil, fmt.Errorf("conflicting options: the containers of a pod share its network, which --network can't change")
+            }
+            if len(opts.Ports) > 0 {
+                return nil, fmt.Errorf("conflicting options: the ports of a pod's containers are published with pod create -p")
+            }
+            var err error
+            if pod, err = FindPod(opts.Pod); err != nil {
+                return nil, err
+            }
+            mode = networkContainerPrefix + pod.Infra.ID
+        }
+        var err error
+        if networkMode, networkTarget, err = resolveNetworkMode(mode); err != nil {
+            return nil, err
+        }
+        if err := checkNetworkOpts(networkMode, opts); err != nil {
+            return nil, err
+        }
+    }
+    var capAdd, capDrop []string
+    if opts != nil {
+        var err error
+        if capAdd, err = NormalizeCapabilities(opts.CapAdd); err != nil {
+            return nil, err
+        }
+        if capDrop, err = NormalizeCapabilities(opts.CapDrop); err != nil {
+            return nil, err
+        }
+    }
+    var seccompProfile []byte
+    if opts != nil && !opts.Privileged && opts.Seccomp != "" && opts.Seccomp != SeccompUnconfined {
+        // The container keeps a copy, the file may change before it restarts
+        data, err := os.ReadFile(opts.Seccomp)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read seccomp profile: %w", err)
+        }
+        if _, err := ParseSeccompProfile(data); err != nil {
+            return nil, fmt.Errorf("invalid seccomp profile %s: %w", opts.Seccomp, err)
+        }
+        seccompProfile = data
+    }
+    
     containerID := generateID()
     
-    // Set up container directories relative to the current working directory
-    containersDir := "containers"
+    // Set up container directories under the data root
+    containersDir := config.DataPath("containers")
     containerDir := filepath.Join(containersDir, containerID)
     rootfs := filepath.Join(containerDir, "rootfs")
     
-    if err := os.MkdirAll(rootfs, 0755); err != nil {
-        return nil, fmt.Errorf("failed to create container filesystem: %w", err)
+    // Give the container its own writable view of the image
+    storageDriver := ""
+    var layers []string
+    if opts != nil {
+        storageDriver = opts.StorageDriver
+        layers = opts.Layers
     }
-    
-    // Create a simple container structure
-    if err := prepareRootfs(rootfs, image); err != nil {
+    storageDriver, err := mountRootfs(containerDir, layers, storageDriver)
+    if err != nil {
+        return nil, fmt.Errorf("failed to prepare rootfs: %w", err)
+    }
+    if err := prepareRootfs(rootfs); err != nil {
+        unmountRootfs(containerDir, storageDriver)
         return nil, fmt.Errorf("failed to prepare rootfs: %w", err)
     }
     
     container := &Container{
         ID:      containerID,
         Image:   image,
+        Layers:  layers,
         Command: command,
         Status:  "created",
+        LogConfig: logConfig,
+        CgroupParent: cgroupParent,
+        StorageDriver: storageDriver,
+        Created: time.Now(),
+    }
+    if opts != nil {
+        container.Name = opts.Name
+        container.ImageID = opts.ImageID
+        container.Env = opts.Env
+        container.WorkingDir = opts.WorkingDir
+        container.User = opts.User
+        container.Labels = opts.Labels
+        container.NetworkMode = networkMode
+        container.Ports = opts.Ports
+        container.Hostname = opts.Hostname
+        container.DNS = opts.DNS
+        container.DNSSearch = opts.DNSSearch
+        container.ExtraHosts = opts.ExtraHosts
+        container.Memory = opts.Memory
+        container.MemorySwap = opts.MemorySwap
+        container.MemoryReservation = opts.MemoryReservation
+        container.OOMKillDisable = opts.OOMKillDisable
+        container.OOMScoreAdj = opts.OOMScoreAdj
+        container.CPUShares = opts.CPUShares
+        container.NanoCPUs = opts.NanoCPUs
+        container.PidsLimit = opts.PidsLimit
+        container.RestartPolicy = opts.RestartPolicy
+        container.Privileged = opts.Privileged
+        container.CapAdd = capAdd
+        container.CapDrop = capDrop
+        container.Devices = opts.Devices
+        container.Init = opts.Init
+        container.Tty = opts.Tty
+        container.OpenStdin = opts.OpenStdin
+        if pod != nil {
+            container.Pod = pod.Name
+        }
+        if opts.infraOf != "" {
+            container.Pod, container.PodInfra, container.PodSharePID = opts.infraOf, true, opts.sharePID
+        }
+    }
+    
+    // Mount volumes on top of the image
+    if opts != nil && len(opts.Mounts) > 0 {
+        if err := mountVolumes(rootfs, opts.Mounts); err != nil {
+            unmountRootfs(containerDir, storageDriver)
+            return nil, err
+        }
+        container.Mounts = opts.Mounts
     }
     
     // Save container metadata in consistent location
@@ -56,100 +343,120 @@ func Run(image string, command []string, opts *ContainerOpts) (*Container, error
     if err := os.MkdirAll(metadataDir, 0755); err != nil {
         return nil, fmt.Errorf("failed to create metadata directory: %w", err)
     }
+    if opts != nil && (opts.Seccomp == SeccompUnconfined || opts.Privileged) {
+        container.Seccomp = SeccompUnconfined
+    } else if seccompProfile != nil {
+        container.Seccomp = filepath.Join(metadataDir, "seccomp.json")
+        if err := os.WriteFile(container.Seccomp, seccompProfile, 0644); err != nil {
+            return nil, fmt.Errorf("failed to save seccomp profile: %w", err)
+        }
+    }
     
-    // Save the initial metadata
-    if err := container.updateMetadata(); err != nil {
+    // Save the initial metadata, checking the name again under the store
+    // lock since another container may have taken it meanwhile
+    unlockStore, err := lockStore()
+    if err != nil {
+        return nil, err
+    }
+    if opts != nil && opts.Name != "" {
+        if err := checkName(opts.Name); err != nil {
+            unlockStore()
+            unmountVolumes(rootfs, container.Mounts)
+            unmountRootfs(containerDir, storageDriver)
+            os.RemoveAll(containerDir)
+            return nil, err
+        }
+    }
+    err = storePut(container, true)
+    unlockStore()
+    if err != nil {
         return nil, fmt.Errorf("failed to save container metadata: %w", err)
     }
     
     // Set up cgroups if options provided
     if opts != nil {
-        if err := setupCgroups(containerID, opts); err != nil {
+        if err := setupCgroups(container.cgroup(), opts); err != nil {
             return nil, fmt.Errorf("failed to set up cgroups: %w", err)
         }
     }
     
-    // Start the container process
-    if err := container.Start(rootfs); err != nil {
-    	return container, err
+    // Reserve an address on the container's network, connected once the
+    // container process exists
+    switch {
+    case networkMode == NetworkNone:
+    case networkMode == NetworkHost:
+        if container.Hostname == "" {
+            container.Hostname, _ = os.Hostname()
+        }
+    case networkTarget != nil:
+        container.Hostname = networkTarget.hostname()
+    default:
+        endpoint, err := network.Allocate(networkMode, containerID)
+        if err != nil {
+            return container, fmt.Errorf("failed to allocate network address: %w", err)
+        }
+        container.Network = endpoint
     }
     
-    return container, nil
-}
-
-// updateMetadata updates the container's metadata file with current state
-func (c *Container) updateMetadata() error {
-	metadataDir := filepath.Join("containers", c.ID, "metadata")
-    if err := os.MkdirAll(metadataDir, 0755); err != nil {
-        return fmt.Errorf("failed to create metadata directory: %w", err)
+    // Give the container its own hostname, hosts and resolv.conf, which
+    // need its address
+    if err := container.mountEtcFiles(rootfs); err != nil {
+        return container, err
     }
-    
-    metadataFile := filepath.Join(metadataDir, "container.json")
-    
-    metadata := map[string]interface{}{
-        "ID":      c.ID,
-        "Image":   c.Image,
-        "Command": c.Command,
-        "Status":  c.Status,
-        "Pid":     c.Pid,
-        "Updated": time.Now().Format(time.RFC3339),
+    if err := container.updateMetadata(); err != nil {
+        return container, fmt.Errorf("failed to save container metadata: %w", err)
     }
     
-    metadataJSON, err := json.Marshal(metadata)
-    if err != nil {
-        return fmt.Errorf("failed to serialize container metadata: %w", err)
+    // The ID is there for scripts before a foreground container runs
+    if opts != nil && opts.CIDFile != "" {
+        if err := writeCIDFile(opts.CIDFile, container.ID); err != nil {
+            return container, err
+        }
     }
     
-    return os.WriteFile(metadataFile, metadataJSON, 0644)
+    container.logEvent("create", nil)
+    return container, nil
 }
 
-// prepareRootfs sets up the root filesystem for the container
-func prepareRootfs(rootfs, image string) error {
-	// 1. Create the rootfs directory if it doesn't exist
-	if err := os.MkdirAll(rootfs, 0755); err != nil {
-		return fmt.Errorf("failed to create rootfs: %w", err)
+// writeCIDFile writes a container ID to a file that must not exist yet, so
+// that another container's file isn't overwritten
+func writeCIDFile(path, id string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create container ID file: %w", err)
 	}
-
-	// 2. Bind mount the image directory to rootfs
-	if err := syscall.Mount(image, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("failed to bind mount image to rootfs: %w %s", err, image)
+	if _, err := f.WriteString(id); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write container ID file: %w", err)
 	}
+	return f.Close()
+}
 
-	// 3. Create standard mount points
-	mountPoints := []string{"proc", "sys", "dev", "tmp", "usr/local/bin"}
+// updateMetadata saves the container's current state in the container
+// store. The record is created by Run; a container that was removed is
+// not saved again.
+func (c *Container) updateMetadata() error {
+	return storePut(c, false)
+}
+
+// prepareRootfs sets up the mounted root filesystem for the container
+func prepareRootfs(rootfs string) error {
+	// Create the standard mount points. The filesystems are mounted by
+	// the container process itself, in its own mount namespace.
+	mountPoints := []string{"proc", "sys", "dev", "tmp"}
 	for _, dir := range mountPoints {
 		path := filepath.Join(rootfs, dir)
 		if err := os.MkdirAll(path, 0755); err != nil {
 			return fmt.Errorf("failed to create %s in rootfs: %w", dir, err)
 		}
 	}
-
-	// 4. Copy the floka executable into the container's rootfs
-	hostExecutablePath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("failed to get host executable path: %w", err)
-	}
-
-	containerExecutablePath := filepath.Join(rootfs, "usr", "local", "bin", "floka")
-
-	input, err := os.ReadFile(hostExecutablePath)
-	if err != nil {
-		return fmt.Errorf("failed to read host executable: %w", err)
-	}
-
-	if err := os.WriteFile(containerExecutablePath, input, 0755); err != nil {
-		return fmt.Errorf("failed to write executable to rootfs: %w", err)
-	}
-
-
-	// mounting these filesystems in the container process itself
-	// after unsharing the mount namespace, not here in the parent process
-
 	return nil
 }
 
-// setupCgroups configures resource limits for the container
-func setupCgroups(containerID string, opts *ContainerOpts) error {
+// setupCgroups creates a container's cgroup, given relative to the root of
+// the cgroup hierarchies, and configures its resource limits
+func setupCgroups(cgroup string, opts *ContainerOpts) error {
     // Create cgroup directories
     cgroupPath := filepath.Join("/sys/fs/cgroup")
     
@@ -159,9 +466,34 @@ func setupCgroups(containerID string, opts *ContainerOpts) error {
         isUnifiedCgroupV2 = true
     }
     
+    logging.L().Debug("setting up cgroups", "cgroup", cgroup, "v2", isUnifiedCgroupV2,
+        "memory", opts.Memory, "memory_swap", opts.MemorySwap, "memory_reservation", opts.MemoryReservation,
+        "cpu_shares", opts.CPUShares, "nano_cpus", opts.NanoCPUs, "pids_limit", opts.PidsLimit)
+    
     if isUnifiedCgroupV2 {
         // Cgroup v2 approach
-        containerCgroupDir := filepath.Join(cgroupPath, "floka", containerID)
+        containerCgroupDir := filepath.Join(cgroupPath, cgroup)
+        if err := os.MkdirAll(filepath.Dir(containerCgroupDir), 0755); err != nil {
+            return fmt.Errorf("failed to create cgroup directory (v2): %w", err)
+        }
+        
+        // Controllers must be enabled from the root down to the parent
+        // cgroup for the container's limits and stats files to exist.
+        // Those the limits given need must be there, the others are only
+        // used for stats.
+        var required []string
+        if opts.Memory > 0 || opts.MemorySwap != 0 || opts.MemoryReservation > 0 {
+            required = append(required, "memory")
+        }
+        if opts.CPUShares > 0 || opts.NanoCPUs > 0 {
+            required = append(required, "cpu")
+        }
+        if opts.PidsLimit > 0 {
+            required = append(required, "pids")
+        }
+        if err := enableCgroupControllers(cgroupPath, filepath.Dir(containerCgroupDir), required); err != nil {
+            return err
+        }
         if err := os.MkdirAll(containerCgroupDir, 0755); err != nil {
             return fmt.Errorf("failed to create cgroup directory (v2): %w", err)
         }
@@ -174,28 +506,57 @@ func setupCgroups(containerID string, opts *ContainerOpts) error {
             }
         }
         
+        // The swap limit of cgroup v2 leaves out memory
+        if opts.MemorySwap != 0 {
+            swap := "max"
+            if opts.MemorySwap > 0 {
+                swap = strconv.FormatInt(opts.MemorySwap-opts.Memory, 10)
+            }
+            if err := os.WriteFile(filepath.Join(containerCgroupDir, "memory.swap.max"), []byte(swap), 0644); err != nil {
+                return fmt.Errorf("failed to set memory swap limit: %w", err)
+            }
+        }
+        
+        // Memory below memory.low is only reclaimed when nothing else can be
+        if opts.MemoryReservation > 0 {
+            memLowPath := filepath.Join(containerCgroupDir, "memory.low")
+            if err := os.WriteFile(memLowPath, []byte(strconv.FormatInt(opts.MemoryReservation, 10)), 0644); err != nil {
+                return fmt.Errorf("failed to set memory reservation: %w", err)
+            }
+        }
+        
+        // Cgroup v2 has no way to keep the OOM killer away
+        if opts.OOMKillDisable {
+            logging.L().Warn("--oom-kill-disable is only supported with cgroup v1, ignoring it", "cgroup", cgroup)
+        }
+        
         // Set CPU weight
         if opts.CPUShares > 0 {
             cpuWeightPath := filepath.Join(containerCgroupDir, "cpu.weight")
-            // Convert Docker-style shares (2-262144) to cgroup v2 weight (1-10000)
-            weight := 1 + ((opts.CPUShares - 2) * 9998) / 262142
-            if weight < 1 {
-                weight = 1
+            if err := os.WriteFile(cpuWeightPath, []byte(strconv.FormatInt(cpuWeight(opts.CPUShares), 10)), 0644); err != nil {
+                return fmt.Errorf("failed to set CPU weight: %w", err)
             }
-            if weight > 10000 {
-                weight = 10000
+        }
+        
+        // The quota is the CPU time the container gets per period
+        if opts.NanoCPUs > 0 {
+            quota := fmt.Sprintf("%d %d", cpuQuota(opts.NanoCPUs), cpuPeriod)
+            if err := os.WriteFile(filepath.Join(containerCgroupDir, "cpu.max"), []byte(quota), 0644); err != nil {
+                return fmt.Errorf("failed to set CPU quota: %w", err)
             }
-            
-            if err := os.WriteFile(cpuWeightPath, []byte(strconv.FormatInt(weight, 10)), 0644); err != nil {
-                return fmt.Errorf("failed to set CPU weight: %w", err)
+        }
+        
+        if opts.PidsLimit > 0 {
+            if err := os.WriteFile(filepath.Join(containerCgroupDir, "pids.max"), []byte(strconv.FormatInt(opts.PidsLimit, 10)), 0644); err != nil {
+                return fmt.Errorf("failed to set pids limit: %w", err)
             }
         }
     } else {
         // Cgroup v1 approach
-        subsystems := []string{"memory", "cpu"}
+        subsystems := cgroupV1Subsystems
         
         for _, subsystem := range subsystems {
-            subsystemPath := filepath.Join(cgroupPath, subsystem, "floka", containerID)
+            subsystemPath := filepath.Join(cgroupPath, subsystem, cgroup)
             if err := os.MkdirAll(subsystemPath, 0755); err != nil {
                 return fmt.Errorf("failed to create cgroup directory (v1): %w", err)
             }
@@ -209,6 +570,26 @@ func setupCgroups(containerID string, opts *ContainerOpts) error {
                         return fmt.Errorf("failed to set memory limit: %w", err)
                     }
                 }
+                // The memory and swap limit can't be below the memory
+                // limit, so it is set after it
+                if opts.MemorySwap != 0 {
+                    memswPath := filepath.Join(subsystemPath, "memory.memsw.limit_in_bytes")
+                    if err := os.WriteFile(memswPath, []byte(strconv.FormatInt(opts.MemorySwap, 10)), 0644); err != nil {
+                        return fmt.Errorf("failed to set memory swap limit: %w", err)
+                    }
+                }
+                if opts.MemoryReservation > 0 {
+                    softLimitPath := filepath.Join(subsystemPath, "memory.soft_limit_in_bytes")
+                    if err := os.WriteFile(softLimitPath, []byte(strconv.FormatInt(opts.MemoryReservation, 10)), 0644); err != nil {
+                        return fmt.Errorf("failed to set memory reservation: %w", err)
+                    }
+                }
+                if opts.OOMKillDisable {
+                    oomControlPath := filepath.Join(subsystemPath, "memory.oom_control")
+                    if err := os.WriteFile(oomControlPath, []byte("1"), 0644); err != nil {
+                        return fmt.Errorf("failed to disable the OOM killer: %w", err)
+                    }
+                }
             case "cpu":
                 if opts.CPUShares > 0 {
                     // Set CPU shares
@@ -217,74 +598,413 @@ func setupCgroups(containerID string, opts *ContainerOpts) error {
                         return fmt.Errorf("failed to set CPU shares: %w", err)
                     }
                 }
+                if opts.NanoCPUs > 0 {
+                    if err := os.WriteFile(filepath.Join(subsystemPath, "cpu.cfs_period_us"), []byte(strconv.Itoa(cpuPeriod)), 0644); err != nil {
+                        return fmt.Errorf("failed to set CPU period: %w", err)
+                    }
+                    if err := os.WriteFile(filepath.Join(subsystemPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(cpuQuota(opts.NanoCPUs), 10)), 0644); err != nil {
+                        return fmt.Errorf("failed to set CPU quota: %w", err)
+                    }
+                }
+            case "pids":
+                if opts.PidsLimit > 0 {
+                    if err := os.WriteFile(filepath.Join(subsystemPath, "pids.max"), []byte(strconv.FormatInt(opts.PidsLimit, 10)), 0644); err != nil {
+                        return fmt.Errorf("failed to set pids limit: %w", err)
+                    }
+                }
             }
         }
     }
     
+    // Only the default devices and those given may be used
+    if err := setupDeviceCgroup(cgroup, opts.Devices, opts.Privileged); err != nil {
+        return fmt.Errorf("failed to set up device access: %w", err)
+    }
+    
     return nil
 }
 
-// Start the container process (making it exported)
-func (c *Container) Start(rootfs string) error {
-	
-	// The path to the executable *inside* the container's chrooted environment
-	containerExecutableInternalPath := "/usr/local/bin/floka"
-   
-    // Use self-exec trick to enter namespaces, using the path inside the container
-    cmd := exec.Command(containerExecutableInternalPath)
+// checkMemoryOpts reports memory options that don't fit together
+func checkMemoryOpts(opts *ContainerOpts) error {
+    if opts.MemorySwap > 0 {
+        if opts.Memory <= 0 {
+            return fmt.Errorf("a memory swap limit requires a memory limit")
+        }
+        if opts.MemorySwap < opts.Memory {
+            return fmt.Errorf("memory swap limit %d is below the memory limit %d", opts.MemorySwap, opts.Memory)
+        }
+    } else if opts.MemorySwap < -1 {
+        return fmt.Errorf("invalid memory swap limit %d", opts.MemorySwap)
+    }
+    if opts.MemoryReservation < 0 {
+        return fmt.Errorf("invalid memory reservation %d", opts.MemoryReservation)
+    }
+    if opts.Memory > 0 && opts.MemoryReservation > opts.Memory {
+        return fmt.Errorf("memory reservation %d is above the memory limit %d", opts.MemoryReservation, opts.Memory)
+    }
+    if opts.OOMScoreAdj < -1000 || opts.OOMScoreAdj > 1000 {
+        return fmt.Errorf("invalid OOM score adjustment %d, expected -1000 to 1000", opts.OOMScoreAdj)
+    }
+    return nil
+}
+
+// checkLimitOpts reports invalid CPU quota and process limits
+func checkLimitOpts(opts *ContainerOpts) error {
+    if opts.NanoCPUs < 0 || (opts.NanoCPUs > 0 && cpuQuota(opts.NanoCPUs) < minCPUQuota) {
+        return fmt.Errorf("invalid CPU quota %.3f, expected at least 0.01 CPU", float64(opts.NanoCPUs)/1e9)
+    }
+    if opts.PidsLimit < 0 {
+        return fmt.Errorf("invalid pids limit %d", opts.PidsLimit)
+    }
+    return nil
+}
+
+// Start the container process and wait for it to exit. Cancelling ctx
+// sends the container SIGTERM, then SIGKILL after DefaultStopTimeout.
+func (c *Container) Start(ctx context.Context, rootfs string) error {
+	// Held until the container is recorded as running, not while it runs
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	locked := true
+	defer func() {
+		if locked {
+			unlock()
+		}
+	}()
+
+	if c.IsRunning() {
+		return fmt.Errorf("%w: %s", ErrContainerRunning, c.ID)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get host executable path: %w", err)
+	}
+	rootfs, err = filepath.Abs(rootfs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rootfs path: %w", err)
+	}
    
-    // Format should be:
-    // /usr/local/bin/floka containerize <command> <args>...
-    // The rootfs is handled by Chroot in SysProcAttr
-    cmd.Args = []string{containerExecutableInternalPath, "containerize"}
+    // Use self-exec trick to enter namespaces:
+    // floka containerize <command> <args>...
+    // It pivots into the rootfs it finds in FLOKA_ROOTFS.
+    cmd := exec.CommandContext(ctx, self, "containerize")
     cmd.Args = append(cmd.Args, c.Command...)
-   
-    // Also pass rootfs as an environment variable as a backup
-    // This might not be strictly necessary anymore if chroot works as expected for all cases
+    // A signal passed on to the container, below, may be what cancelled
+    // ctx; the container needn't get SIGTERM on top of it
+    var proxied atomic.Bool
+    cmd.Cancel = func() error {
+        if proxied.Load() {
+            return nil
+        }
+        return cmd.Process.Signal(syscall.SIGTERM)
+    }
+    cmd.WaitDelay = DefaultStopTimeout
     cmd.Env = append(os.Environ(), fmt.Sprintf("FLOKA_ROOTFS=%s", rootfs))
     
-    cmd.Stdin = os.Stdin
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
+    // The container's own variables are applied by containerize
+    envJSON, err := json.Marshal(c.Env)
+    if err != nil {
+        return fmt.Errorf("failed to serialize container environment: %w", err)
+    }
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", EnvVar, envJSON))
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", WorkDirVar, c.WorkingDir))
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", UserVar, c.User))
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", HostnameVar, c.hostname()))
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", SeccompVar, c.Seccomp))
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", CapsVar, FormatCapabilities(c.Capabilities())))
+    if c.Privileged {
+        cmd.Env = append(cmd.Env, PrivilegedVar+"=1")
+    }
+    if c.Init {
+        cmd.Env = append(cmd.Env, InitVar+"=1")
+    }
+    if c.PodInfra {
+        cmd.Env = append(cmd.Env, PauseVar+"=1")
+    }
+    devicesJSON, err := json.Marshal(c.Devices)
+    if err != nil {
+        return fmt.Errorf("failed to serialize container devices: %w", err)
+    }
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", DevicesVar, devicesJSON))
+    
+    streams := c.streams
+    if streams == nil {
+        streams = local
+    }
+    stdout, stderr := streams.stdout(), streams.stderr()
+    if stdin := streams.stdin(); stdin != nil {
+        cmd.Stdin = stdin
+    }
+    
+    // containerize waits until this pipe (fd 3) is closed, so that it only
+    // sets up the container once it is in its cgroups, and the command only
+    // runs once the container's network is set up
+    setupR, setupW, err := os.Pipe()
+    if err != nil {
+        return fmt.Errorf("failed to create pipe: %w", err)
+    }
+    defer setupW.Close()
+    cmd.ExtraFiles = []*os.File{setupR}
+    
+    // Copy the output to the log driver as well, unless logging is disabled
+    if c.LogConfig.Type != "none" {
+        driver, err := c.LogDriver()
+        if err != nil {
+            c.Status = "failed"
+            if updateErr := c.updateMetadata(); updateErr != nil {
+                logging.L().Warn("failed to update container metadata", "container", c.ID, "err", updateErr)
+            }
+            return fmt.Errorf("failed to set up log driver: %w", err)
+        }
+        defer driver.Close()
+        
+        stdoutLog := logdriver.NewWriter(driver, "stdout")
+        stderrLog := logdriver.NewWriter(driver, "stderr")
+        defer stdoutLog.Close()
+        defer stderrLog.Close()
+        stdout = io.MultiWriter(stdout, stdoutLog)
+        stderr = io.MultiWriter(stderr, stderrLog)
+    }
+    cmd.Stdout = stdout
+    cmd.Stderr = stderr
     
-    // Set up namespaces
+    // A container with a terminal gets the slave end of a new pty as its
+    // stdio and controlling terminal; what it writes there is read from
+    // the master end as its stdout
+    var terminal, terminalSlave *os.File
+    if c.Tty {
+        terminal, terminalSlave, err = term.OpenPTY()
+        if err != nil {
+            c.Status = "failed"
+            if updateErr := c.updateMetadata(); updateErr != nil {
+                logging.L().Warn("failed to update container metadata", "container", c.ID, "err", updateErr)
+            }
+            return err
+        }
+        defer terminal.Close()
+        defer terminalSlave.Close()
+        cmd.Stdin, cmd.Stdout, cmd.Stderr = terminalSlave, terminalSlave, terminalSlave
+    }
+    
+    // Set up namespaces. Containers sharing the network of the host or
+    // of another container get no network namespace of their own,
+    // containerize joins the other container's, and those of a pod its
+    // IPC and UTS namespaces too. A pod sharing its PID namespace has its
+    // containers started right in it.
     cmd.SysProcAttr = &syscall.SysProcAttr{
         Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID |
-        	syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
-        Chroot: rootfs, // Set the root filesystem for the container
+        	syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC,
        }
+    var pidns *os.File
+    switch {
+    case c.networkMode() == NetworkHost:
+    case c.networkContainer() != "":
+        nsFiles, nsNames, pidNamespace, err := c.openJoinedNamespaces()
+        if err != nil {
+            c.Status = "failed"
+            if updateErr := c.updateMetadata(); updateErr != nil {
+                logging.L().Warn("failed to update container metadata", "container", c.ID, "err", updateErr)
+            }
+            return err
+        }
+        for _, f := range nsFiles {
+            defer f.Close()
+        }
+        cmd.ExtraFiles = append(cmd.ExtraFiles, nsFiles...)
+        cmd.Env = append(cmd.Env, JoinNSVar+"="+strings.Join(nsNames, ","))
+        if pidNamespace != nil {
+            defer pidNamespace.Close()
+            pidns = pidNamespace
+            cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWPID
+        }
+    default:
+        cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+    }
+    if c.Tty {
+        cmd.SysProcAttr.Setsid, cmd.SysProcAttr.Setctty, cmd.SysProcAttr.Ctty = true, true, 0
+    }
+    
+    logging.L().Debug("starting container process", "container", c.ID, "rootfs", rootfs,
+        "cloneflags", fmt.Sprintf("%#x", cmd.SysProcAttr.Cloneflags), "args", cmd.Args)
     
-    if err := cmd.Start(); err != nil {
+    // Pass the signals floka gets on to the container process, so that
+    // Ctrl-C, a closed terminal or stopping floka (e.g. from a systemd
+    // unit) stop the container while floka stays to clean up after it.
+    // They are caught from here on so that none kills floka meanwhile.
+    sigCh := make(chan os.Signal, 4)
+    signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+    defer func() {
+        signal.Stop(sigCh)
+        close(sigCh)
+    }()
+    
+    // Where the kernel can, the process starts right in the container's
+    // cgroup, so that not even the Go runtime of containerize escapes its
+    // limits
+    cgroupDir := openCgroupForClone(c.cgroup())
+    if cgroupDir != nil {
+        defer cgroupDir.Close()
+        cmd.SysProcAttr.UseCgroupFD = true
+        cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+    }
+    
+    err = startInPIDNamespace(cmd, pidns)
+    if err != nil && cgroupDir != nil && (errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EPERM)) {
+        // clone3 may be blocked, e.g. by the seccomp profile of a
+        // container floka itself runs in. A command can't be started
+        // twice, start a copy.
+        logging.L().Debug("failed to start container process in its cgroup, retrying", "container", c.ID, "err", err)
+        cmd = copyCmd(ctx, cmd)
+        cmd.SysProcAttr.UseCgroupFD = false
+        err = startInPIDNamespace(cmd, pidns)
+    }
+    setupR.Close()
+    if err != nil {
         c.Status = "failed"
         // Update metadata with failed status
         if updateErr := c.updateMetadata(); updateErr != nil {
-            fmt.Printf("Warning: failed to update container metadata: %s\n", updateErr)
+            logging.L().Warn("failed to update container metadata", "container", c.ID, "err", updateErr)
         }
         return fmt.Errorf("failed to start container: %w", err)
     }
     
+    // Reading the master fails with EIO once the container is gone
+    var terminalDone chan struct{}
+    if c.Tty {
+        terminalSlave.Close()
+        terminalDone = make(chan struct{})
+        go func() {
+            io.Copy(stdout, terminal)
+            close(terminalDone)
+        }()
+        streams.setTerminal(terminal)
+        defer streams.setTerminal(nil)
+    }
+    
     c.Pid = cmd.Process.Pid
+    c.PidStartTime = processStartTime(c.Pid)
+    c.SupervisorPid = os.Getpid()
+    c.SupervisorStartTime = processStartTime(c.SupervisorPid)
+    go func() {
+        for sig := range sigCh {
+            proxied.Store(true)
+            // The command gets them, containerize would die of them
+            // without passing them on
+            pid := cmd.Process.Pid
+            if child := commandPid(pid); child > 1 {
+                pid = child
+            }
+            _ = syscall.Kill(pid, sig.(syscall.Signal))
+        }
+    }()
+    
+    // Otherwise add process to cgroups while containerize waits for the
+    // setup pipe, so that the container is set up and the command and
+    // everything it forks run inside them
+    if !cmd.SysProcAttr.UseCgroupFD {
+        if err := addProcessToCgroups(c.cgroup(), c.Pid); err != nil {
+        	logging.L().Warn("failed to add process to cgroups", "container", c.ID, "pid", c.Pid, "err", err)
+        }
+    }
+    // The cgroup counts kills since it was created, not since this run
+    oomKills := oomKillCount(c.cgroup())
+    c.OOMKilled = false
+    
+    // The command inherits the score of containerize
+    if c.OOMScoreAdj != 0 {
+        scorePath := filepath.Join("/proc", strconv.Itoa(c.Pid), "oom_score_adj")
+        if err := os.WriteFile(scorePath, []byte(strconv.Itoa(c.OOMScoreAdj)), 0644); err != nil {
+            logging.L().Warn("failed to set OOM score adjustment", "container", c.ID, "pid", c.Pid, "err", err)
+        }
+    }
+    
+    if c.Network != nil {
+        err := network.Connect(c.ID, c.Pid, c.Network)
+        if err == nil {
+            // Published ports stay reachable as long as we wait for the container
+            var unpublish func()
+            if unpublish, err = network.Publish(c.Network, c.Ports); err == nil {
+                defer network.Unpublish(c.Network, c.Ports)
+                defer unpublish()
+            }
+        }
+        if err != nil {
+            cmd.Process.Kill()
+            cmd.Wait()
+            if disconnectErr := network.Disconnect(c.ID); disconnectErr != nil {
+                logging.L().Warn("failed to disconnect container network", "container", c.ID, "err", disconnectErr)
+            }
+            c.Status = "failed"
+            if updateErr := c.updateMetadata(); updateErr != nil {
+                logging.L().Warn("failed to update container metadata", "container", c.ID, "err", updateErr)
+            }
+            return fmt.Errorf("failed to set up container network: %w", err)
+        }
+    } else if c.networkMode() == NetworkNone {
+        if err := network.SetupLoopback(c.Pid); err != nil {
+            logging.L().Warn("failed to set up loopback interface", "container", c.ID, "err", err)
+        }
+    }
+    setupW.Close()
+    
     c.Status = "running"
+    c.StartedAt = time.Now()
+    c.ManuallyStopped = false
+    c.StoppedByShutdown = false
     
     // Update metadata with running status and PID
     if err := c.updateMetadata(); err != nil {
-        fmt.Printf("Warning: failed to update container metadata: %s\n", err)
+        logging.L().Warn("failed to update container metadata", "container", c.ID, "err", err)
     }
+    unlock()
+    locked = false
     
-    // Add process to cgroups
-    if err := addProcessToCgroups(c.ID, c.Pid); err != nil {
-    	fmt.Printf("Warning: failed to add process to cgroups: %s\n", err)
+    c.logEvent("start", nil)
+    if c.started != nil {
+    	c.started()
     }
     
     // Wait for the command to complete. This is crucial for seeing its output
     // and for the parent process to not exit prematurely.
     waitErr := cmd.Wait()
+    if terminalDone != nil {
+        <-terminalDone
+    }
+    if c.Network != nil {
+        if err := network.Disconnect(c.ID); err != nil {
+            logging.L().Warn("failed to disconnect container network", "container", c.ID, "err", err)
+        }
+    }
    
-    // Update status after command completion
+    // Update status after command completion. Whether Stop ended it is
+    // only known to the metadata Stop wrote, and Rename and
+    // UpdateResources may have changed the name and limits meanwhile.
+    exitCode := exitStatus(cmd.ProcessState)
+    if unlock, err := c.lock(); err == nil {
+        defer unlock()
+    }
+    if cur, err := Load(c.ID); err == nil {
+        c.ManuallyStopped, c.StoppedByShutdown = cur.ManuallyStopped, cur.StoppedByShutdown
+        c.Name = cur.Name
+        c.Memory, c.MemorySwap, c.MemoryReservation = cur.Memory, cur.MemorySwap, cur.MemoryReservation
+        c.CPUShares, c.NanoCPUs, c.PidsLimit = cur.CPUShares, cur.NanoCPUs, cur.PidsLimit
+    }
     c.Status = "stopped"
+    c.FinishedAt = time.Now()
+    c.ExitCode = exitCode
+    c.OOMKilled = oomKillCount(c.cgroup()) > oomKills
     if err := c.updateMetadata(); err != nil {
-    	fmt.Printf("Warning: failed to update container metadata after stop: %s\n", err)
+    	logging.L().Warn("failed to update container metadata after stop", "container", c.ID, "err", err)
+    }
+    
+    logging.L().Debug("container process exited", "container", c.ID, "exit_code", exitCode, "oom_killed", c.OOMKilled)
+    if c.OOMKilled {
+    	c.emit("oom", exitCode)
     }
+    c.emit("die", exitCode)
    
     if waitErr != nil {
     
@@ -294,127 +1014,112 @@ func (c *Container) Start(rootfs string) error {
     return nil // Container start was initiated, command has now run.
 }
 
-// ListContainers returns a list of all containers
-func ListContainers() ([]*Container, error) {
-	containersDir := "containers"
-    if _, err := os.Stat(containersDir); os.IsNotExist(err) {
-        // No containers directory exists yet
-        return []*Container{}, nil
-    }
-    
-    entries, err := os.ReadDir(containersDir)
-    if err != nil {
-        return nil, fmt.Errorf("failed to read containers directory: %w", err)
+// startInPIDNamespace starts cmd, in the PID namespace pidns unless it is
+// nil. A thread joining a PID namespace only puts the processes it starts
+// there, so cmd is started from a thread of its own that joins it and
+// goes away afterwards.
+func startInPIDNamespace(cmd *exec.Cmd, pidns *os.File) error {
+    if pidns == nil {
+        return cmd.Start()
     }
-    
-    var containers []*Container
-    
-    for _, entry := range entries {
-        if !entry.IsDir() {
-            continue
-        }
-        
-        containerID := entry.Name()
-        metadataFile := filepath.Join(containersDir, containerID, "metadata", "container.json")
-        
-        data, err := os.ReadFile(metadataFile)
-        if err != nil {
-            fmt.Printf("Warning: could not read metadata for container %s: %s\n", containerID, err)
-            continue
+    errCh := make(chan error, 1)
+    go func() {
+        // Never unlocked, the thread exits with the goroutine
+        runtime.LockOSThread()
+        if err := unix.Setns(int(pidns.Fd()), unix.CLONE_NEWPID); err != nil {
+            errCh <- fmt.Errorf("failed to join PID namespace: %w", err)
+            return
         }
-        
-        var metadata map[string]interface{}
-        if err := json.Unmarshal(data, &metadata); err != nil {
-            fmt.Printf("Warning: could not parse metadata for container %s: %s\n", containerID, err)
-            continue
-        }
-        
-        // Extract the command as a string slice
-        var cmd []string
-        if cmdJSON, ok := metadata["Command"].([]interface{}); ok {
-            for _, c := range cmdJSON {
-                if str, ok := c.(string); ok {
-                    cmd = append(cmd, str)
-                }
-            }
-        }
-        
-        container := &Container{
-            ID:      containerID,
-            Image:   metadata["Image"].(string),
-            Command: cmd,
-            Status:  metadata["Status"].(string),
-        }
-        
-        // Try to get PID if present
-        if pid, ok := metadata["Pid"].(float64); ok {
-            container.Pid = int(pid)
-        }
-        
-        containers = append(containers, container)
+        errCh <- cmd.Start()
+    }()
+    return <-errCh
+}
+
+// copyCmd returns an unstarted copy of a command whose Start failed
+func copyCmd(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+    c := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+    c.Args = cmd.Args
+    c.Env = cmd.Env
+    c.Dir = cmd.Dir
+    c.Stdin = cmd.Stdin
+    c.Stdout = cmd.Stdout
+    c.Stderr = cmd.Stderr
+    c.ExtraFiles = cmd.ExtraFiles
+    c.Cancel = cmd.Cancel
+    c.WaitDelay = cmd.WaitDelay
+    if cmd.SysProcAttr != nil {
+        attr := *cmd.SysProcAttr
+        c.SysProcAttr = &attr
     }
-    
-    return containers, nil
-   }
-   
-   // Load attempts to load an existing container's metadata by its ID.
-   func Load(containerID string) (*Container, error) {
-    metadataFile := filepath.Join("containers", containerID, "metadata", "container.json")
-   
-    if _, err := os.Stat(metadataFile); os.IsNotExist(err) {
-    	return nil, fmt.Errorf("container '%s' not found: %w", containerID, err)
+    return c
+}
+
+// ListContainers returns the containers selected by opts; nil opts lists all
+func ListContainers(opts *ListOptions) ([]*Container, error) {
+    if opts == nil {
+        opts = &ListOptions{}
     }
-   
-    data, err := os.ReadFile(metadataFile)
+
+    containers, err := storeList(opts)
     if err != nil {
-    	return nil, fmt.Errorf("failed to read metadata for container %s: %w", containerID, err)
-    }
-   
-    var metadataMap map[string]interface{}
-    if err := json.Unmarshal(data, &metadataMap); err != nil {
-    	return nil, fmt.Errorf("failed to parse metadata for container %s: %w", containerID, err)
-    }
-   
-    // Extract the command as a string slice
-    var cmd []string
-    if cmdJSON, ok := metadataMap["Command"].([]interface{}); ok {
-    	for _, c := range cmdJSON {
-    		if str, ok := c.(string); ok {
-    			cmd = append(cmd, str)
-    		}
-    	}
-    }
-   
-    // Ensure Image and Status are strings
-    image, ok := metadataMap["Image"].(string)
-    if !ok {
-    	return nil, fmt.Errorf("metadata for container %s has invalid Image field", containerID)
+        return nil, err
     }
-    status, ok := metadataMap["Status"].(string)
-    if !ok {
-    	return nil, fmt.Errorf("metadata for container %s has invalid Status field", containerID)
-    }
-   
-   
-    container := &Container{
-    	ID:      containerID,
-    	Image:   image,
-    	Command: cmd,
-    	Status:  status,
-    }
-   
-    // Try to get PID if present
-    if pidVal, pidExists := metadataMap["Pid"]; pidExists {
-    	if pid, ok := pidVal.(float64); ok { // JSON numbers are float64
-    		container.Pid = int(pid)
-    	}
+    for _, container := range containers {
+        container.reconcile()
     }
     
-    return container, nil
-   }
-   
-   
-func addProcessToCgroups(containerID string, pid int) error {
+    return opts.apply(containers)
+}
+
+// Load attempts to load an existing container's metadata by its ID.
+func Load(containerID string) (*Container, error) {
+	container, err := storeGet(containerID)
+	if err != nil {
+		return nil, err
+	}
+	container.reconcile()
+
+	return container, nil
+}
+
+// Find loads the container whose ID or name is ref or, failing that, the
+// only container whose ID starts with ref
+func Find(ref string) (*Container, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty container reference")
+	}
+	if c, err := Load(ref); err == nil {
+		return c, nil
+	}
+
+	if validName.MatchString(ref) {
+		named, err := ListContainers(&ListOptions{Name: ref})
+		if err != nil {
+			return nil, err
+		}
+		if len(named) > 0 {
+			return named[0], nil
+		}
+	}
+
+	matches, err := ListContainers(&ListOptions{IDPrefix: ref})
+	if err != nil {
+		return nil, err
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("multiple containers match prefix %s", ref)
+	}
+}
+
+
+// addProcessToCgroups moves a process into a container's cgroup, given
+// relative to the root of the cgroup hierarchies
+func addProcessToCgroups(cgroup string, pid int) error {
     cgroupPath := filepath.Join("/sys/fs/cgroup")
     pidStr := strconv.Itoa(pid)
     
@@ -426,13 +1131,15 @@ func addProcessToCgroups(containerID string, pid int) error {
     
     if isUnifiedCgroupV2 {
         // Cgroup v2
-        cgroupProcsPath := filepath.Join(cgroupPath, "floka", containerID, "cgroup.procs")
+        cgroupProcsPath := filepath.Join(cgroupPath, cgroup, "cgroup.procs")
         return os.WriteFile(cgroupProcsPath, []byte(pidStr), 0644)
     } else {
         // Cgroup v1
-        subsystems := []string{"memory", "cpu"}
+        subsystems := cgroupV1Subsystems
         for _, subsystem := range subsystems {
-            cgroupProcsPath := filepath.Join(cgroupPath, subsystem, "floka", containerID, "tasks")
+            // tasks would move the main thread alone, leaving the others
+            // to fork the command outside of the cgroup
+            cgroupProcsPath := filepath.Join(cgroupPath, subsystem, cgroup, "cgroup.procs")
             if err := os.WriteFile(cgroupProcsPath, []byte(pidStr), 0644); err != nil {
                 return err
             }
@@ -442,70 +1149,155 @@ func addProcessToCgroups(containerID string, pid int) error {
     return nil
 }
 
-// Stop terminates a running container
-func (c *Container) Stop() error {
-    fmt.Printf("Stopping container %s\n", c.ID)
+// DefaultStopTimeout is how long Stop waits after SIGTERM before sending SIGKILL
+const DefaultStopTimeout = 10 * time.Second
+
+// Stop terminates a running container. It sends SIGTERM and, if the process
+// is still alive after timeout, SIGKILL. Cancelling ctx stops waiting.
+func (c *Container) Stop(ctx context.Context, timeout time.Duration) error {
+    unlock, err := c.lock()
+    if err == nil {
+        defer unlock()
+    } else if !errors.Is(err, ErrContainerNotFound) {
+        return err
+    }
+    return c.stop(ctx, timeout)
+}
+
+// stop is Stop for callers holding the container lock
+func (c *Container) stop(ctx context.Context, timeout time.Duration) error {
+    logging.L().Debug("stopping container", "container", c.ID, "pid", c.Pid, "timeout", timeout)
     
-    if c.Pid > 0 {
-        // Send SIGTERM first
-        if err := syscall.Kill(c.Pid, syscall.SIGTERM); err != nil {
-            // If SIGTERM fails, try SIGKILL
-            if err := syscall.Kill(c.Pid, syscall.SIGKILL); err != nil {
+    // Tell the supervisor not to restart the container, whatever its
+    // restart policy
+    c.ManuallyStopped = true
+    if err := c.updateMetadata(); err != nil && !errors.Is(err, ErrContainerNotFound) {
+        logging.L().Warn("failed to update container metadata", "container", c.ID, "err", err)
+    }
+    
+    // PID 1 is never a container process as seen from the host, so a
+    // corrupt or stale record must not make us signal init
+    supervised := false
+    if c.alive() {
+        // The floka process that started the container waits for it and
+        // records its exit itself, possibly removing it right after
+        supervised = parentPid(c.Pid) > 1
+        
+        // A frozen process would only see the signal once thawed
+        if c.Status == "paused" {
+            if err := setFrozen(c.cgroup(), false); err != nil {
+                logging.L().Warn("failed to unpause container", "container", c.ID, "err", err)
+            }
+        }
+        
+        // Send SIGTERM first and give the process a chance to exit cleanly.
+        // It goes to the command, as Kill sends signals: containerize would
+        // die of it without passing it on. SIGKILL takes down the whole
+        // container with containerize.
+        // An infra container has no command, its children are orphans
+        // of the pod's other containers
+        termPid := c.Pid
+        if child := commandPid(c.Pid); child > 1 && !c.PodInfra {
+            termPid = child
+        }
+        if err := syscall.Kill(termPid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+            logging.L().Warn("failed to send SIGTERM", "container", c.ID, "pid", termPid, "err", err)
+        }
+        // Without a supervisor nobody sees the real exit status, assume
+        // the signal we sent ended the process
+        c.ExitCode = 128 + int(syscall.SIGTERM)
+        
+        if !waitForExit(ctx, c.Pid, timeout) {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            logging.L().Debug("container did not stop in time, sending SIGKILL", "container", c.ID, "pid", c.Pid)
+            if err := syscall.Kill(c.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
                 return fmt.Errorf("failed to kill container process: %w", err)
             }
+            c.ExitCode = 128 + int(syscall.SIGKILL)
+            if !waitForExit(ctx, c.Pid, 5*time.Second) {
+                return fmt.Errorf("container process %d did not exit after SIGKILL", c.Pid)
+            }
         }
     }
     
     c.Status = "stopped"
+    if supervised {
+        return nil
+    }
+    if c.FinishedAt.IsZero() {
+        c.FinishedAt = time.Now()
+    }
     
     // Update metadata with stopped status
-    if err := c.updateMetadata(); err != nil {
-        fmt.Printf("Warning: failed to update container metadata: %s\n", err)
+    if err := c.updateMetadata(); err != nil && !errors.Is(err, ErrContainerNotFound) {
+        logging.L().Warn("failed to update container metadata", "container", c.ID, "err", err)
     }
     
     return nil
 }
 
-// Remove deletes a container
+// Remove deletes a container. It takes no context since it is what
+// cleans up after cancelled operations.
 func (c *Container) Remove() error {
-    fmt.Printf("Removing container %s\n", c.ID)
+    logging.L().Debug("removing container", "container", c.ID)
+    
+    // A container removed already has nothing left to lock
+    unlock, err := c.lock()
+    if err == nil {
+        defer unlock()
+    } else if !errors.Is(err, ErrContainerNotFound) {
+        return err
+    }
     
     // Ensure container is stopped
-    if c.Status == "running" {
-        if err := c.Stop(); err != nil {
+    if c.Status == "running" || c.Status == "paused" {
+        if err := c.stop(context.Background(), DefaultStopTimeout); err != nil {
             return err
         }
     }
     
     // Clean up cgroups
-    if err := cleanupCgroups(c.ID); err != nil {
-        fmt.Printf("Warning: failed to clean up cgroups: %s\n", err)
+    if err := cleanupCgroups(c.cgroup()); err != nil {
+        logging.L().Warn("failed to clean up cgroups", "container", c.ID, "err", err)
+    }
+    
+    // The veth pair went away with the container's network namespace,
+    // only its address is still reserved
+    if c.Network != nil {
+        network.Unpublish(c.Network, c.Ports)
+        if err := network.Release(c.Network, c.ID); err != nil {
+            logging.L().Warn("failed to release network address", "container", c.ID, "err", err)
+        }
     }
     
     // Unmount the container's rootfs before removing the directory
-    containerDir := filepath.Join("containers", c.ID)
+    containerDir := config.DataPath("containers", c.ID)
     rootfsPath := filepath.Join(containerDir, "rootfs")
-   
-    // Check if rootfsPath actually exists and is a mount point before unmounting
-    // This is a basic check; a more robust check would involve parsing /proc/mounts
-    if _, err := os.Stat(rootfsPath); err == nil {
-    	// fmt.Printf("Attempting to unmount: %s\n", rootfsPath) // Optional: for debugging
-    	if err := syscall.Unmount(rootfsPath, syscall.MNT_DETACH); err != nil {
-    		// Log the error but proceed with RemoveAll, as it might still be a "device or resource busy"
-    		// which os.RemoveAll might also encounter.
-    		// The MNT_DETACH flag attempts a lazy unmount.
-    		fmt.Printf("Warning: failed to unmount %s: %v. Proceeding with removal attempt.\n", rootfsPath, err)
-    	} else {
-    		// fmt.Printf("Successfully unmounted %s\n", rootfsPath) // Optional: for debugging
-    	}
-    }
+    c.unmountEtcFiles(rootfsPath)
+    unmountVolumes(rootfsPath, c.Mounts)
+    unmountRootfs(containerDir, c.StorageDriver)
    
     // Remove container filesystem
-    return os.RemoveAll(containerDir)
+    unlockStore, err := lockStore()
+    if err != nil {
+        return err
+    }
+    err = os.RemoveAll(containerDir)
+    if err == nil {
+        err = storeDelete(c.ID)
+    }
+    unlockStore()
+    if err != nil {
+        return err
+    }
+    c.logEvent("destroy", nil)
+    return nil
    }
    
-   // cleanupCgroups removes the container's cgroup directories
-func cleanupCgroups(containerID string) error {
+   // cleanupCgroups removes a container's cgroup directories
+func cleanupCgroups(cgroup string) error {
     cgroupPath := filepath.Join("/sys/fs/cgroup")
     
     // Check if we're using cgroup v2
@@ -516,12 +1308,12 @@ func cleanupCgroups(containerID string) error {
     
     if isUnifiedCgroupV2 {
         // Cgroup v2
-        return os.RemoveAll(filepath.Join(cgroupPath, "floka", containerID))
+        return os.RemoveAll(filepath.Join(cgroupPath, cgroup))
     } else {
         // Cgroup v1
-        subsystems := []string{"memory", "cpu"}
+        subsystems := cgroupV1Subsystems
         for _, subsystem := range subsystems {
-            if err := os.RemoveAll(filepath.Join(cgroupPath, subsystem, "floka", containerID)); err != nil {
+            if err := os.RemoveAll(filepath.Join(cgroupPath, subsystem, cgroup)); err != nil {
                 return err
             }
         }
@@ -530,6 +1322,99 @@ func cleanupCgroups(containerID string) error {
     return nil
 }
 
+// IsRunning reports whether the container is running, paused or not, and
+// its process still exists
+func (c *Container) IsRunning() bool {
+    return (c.Status == "running" || c.Status == "paused") && c.alive()
+}
+
+// alive reports whether the container process still exists. PID 1 is
+// never a container process as seen from the host.
+func (c *Container) alive() bool {
+    return c.Pid > 1 && processStarted(c.Pid, c.PidStartTime)
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+    return syscall.Kill(pid, 0) == nil
+}
+
+// processStarted reports whether the process with the given PID exists
+// and, unless startTime is 0, is the one that started at startTime rather
+// than a later one that got its PID
+func processStarted(pid int, startTime uint64) bool {
+    if !processAlive(pid) {
+        return false
+    }
+    return startTime == 0 || processStartTime(pid) == startTime
+}
+
+// processStartTime returns when a process started, in clock ticks since
+// boot, or 0 if it can't be read
+func processStartTime(pid int) uint64 {
+    data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+    if err != nil {
+        return 0
+    }
+    // The start time is the 22nd field, the 20th after the command name
+    i := strings.LastIndexByte(string(data), ')')
+    if i < 0 {
+        return 0
+    }
+    fields := strings.Fields(string(data[i+1:]))
+    if len(fields) < 20 {
+        return 0
+    }
+    startTime, _ := strconv.ParseUint(fields[19], 10, 64)
+    return startTime
+}
+
+// parentPid returns the parent PID of a process, or 0 if it can't be read
+func parentPid(pid int) int {
+    data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+    if err != nil {
+        return 0
+    }
+    // The command name may contain spaces, so parse after its closing paren:
+    // "pid (comm) state ppid ..."
+    i := strings.LastIndexByte(string(data), ')')
+    if i < 0 {
+        return 0
+    }
+    fields := strings.Fields(string(data[i+1:]))
+    if len(fields) < 2 {
+        return 0
+    }
+    ppid, _ := strconv.Atoi(fields[1])
+    return ppid
+}
+
+// waitForExit polls until the process is gone, timeout elapses or ctx is
+// cancelled. It reports whether the process exited.
+func waitForExit(ctx context.Context, pid int, timeout time.Duration) bool {
+    deadline := time.Now().Add(timeout)
+    for processAlive(pid) {
+        if time.Now().After(deadline) {
+            return false
+        }
+        select {
+        case <-ctx.Done():
+            return false
+        case <-time.After(100 * time.Millisecond):
+        }
+    }
+    return true
+}
+
+// LogDriver opens the log driver configured for the container
+func (c *Container) LogDriver() (logdriver.Driver, error) {
+    return logdriver.New(c.LogConfig.Type, logdriver.Info{
+        ContainerID: c.ID,
+        LogDir:      config.DataPath("containers", c.ID, "logs"),
+        Options:     c.LogConfig.Config,
+    })
+}
+
 // generateID creates a unique container ID
 func generateID() string {
     return fmt.Sprintf("cont_%d", time.Now().UnixNano())
@@ -0,0 +1,166 @@
+// pkg/container/copy.go
+package container
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// rootfs returns the host path of the container's root. Volumes are
+// mounted below it for as long as the container exists, so it shows what
+// the container sees whether it is running or not.
+func (c *Container) rootfs() (string, error) {
+	containerDir := config.DataPath("containers", c.ID)
+	rootfs := filepath.Join(containerDir, "rootfs")
+	if c.StorageDriver == StorageVFS {
+		return rootfs, nil
+	}
+
+	// A mounted rootfs is on another device than the directory holding it
+	var dirStat, rootStat syscall.Stat_t
+	if err := syscall.Stat(containerDir, &dirStat); err != nil {
+		return "", fmt.Errorf("failed to read container %s: %w", c.ID, err)
+	}
+	if err := syscall.Stat(rootfs, &rootStat); err != nil {
+		return "", fmt.Errorf("failed to read rootfs of container %s: %w", c.ID, err)
+	}
+	if dirStat.Dev == rootStat.Dev {
+		return "", fmt.Errorf("rootfs of container %s is not mounted", c.ID)
+	}
+	return rootfs, nil
+}
+
+// resolvePath returns the host path of a path in the container, following
+// symlinks as the container would so that it can't point outside of its
+// root. Unless follow is set, a symlink in the last component is kept.
+func (c *Container) resolvePath(path string, follow bool) (string, error) {
+	rootfs, err := c.rootfs()
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Clean("/" + path)
+	if follow || path == "/" {
+		return fsutil.SecureJoin(rootfs, path)
+	}
+	dir, err := fsutil.SecureJoin(rootfs, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// CopyFrom copies the file or directory src of the container to dst on the
+// host, keeping ownership, modes and timestamps. A dst that is an existing
+// directory receives src under its base name, or its contents when src
+// ends in "/.".
+func (c *Container) CopyFrom(src, dst string) error {
+	logging.L().Debug("copying from container", "container", c.ID, "src", src, "dst", dst)
+	hostSrc, err := c.resolvePath(src, false)
+	if err != nil {
+		return fmt.Errorf("invalid source path %s: %w", src, err)
+	}
+	if _, err := os.Lstat(hostSrc); err != nil {
+		return fmt.Errorf("no such file or directory in container %s: %s", c.ID, src)
+	}
+	target, err := copyTarget(hostSrc, src, dst)
+	if err != nil {
+		return err
+	}
+	if err := fsutil.CopyTree(hostSrc, target); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+// CopyTo copies the file or directory src on the host to dst in the
+// container, as CopyFrom does the other way. Directories of the container
+// are never followed through a symlink out of its root.
+func (c *Container) CopyTo(src, dst string) error {
+	logging.L().Debug("copying to container", "container", c.ID, "src", src, "dst", dst)
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	rootfs, err := c.rootfs()
+	if err != nil {
+		return err
+	}
+	hostDst, err := fsutil.SecureJoin(rootfs, dst)
+	if err != nil {
+		return fmt.Errorf("invalid destination path %s: %w", dst, err)
+	}
+	if strings.HasSuffix(dst, "/") {
+		hostDst += "/"
+	}
+	target, err := copyTarget(src, src, hostDst)
+	if err != nil {
+		return err
+	}
+
+	// CopyTree creates directories with MkdirAll, which would follow a
+	// symlink the container put where one of them goes
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(target, rel)
+		resolved, err := fsutil.SecureJoin(rootfs, strings.TrimPrefix(dir, rootfs))
+		if err != nil {
+			return err
+		}
+		if resolved != dir {
+			return fmt.Errorf("%s is a symlink in the container", strings.TrimPrefix(dir, rootfs))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy to %s: %w", dst, err)
+	}
+	if err := fsutil.CopyTree(src, target); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+// copyTarget returns where a copy of src goes given its destination dst,
+// as cp -r would: into dst if it is a directory, as dst otherwise. name is
+// src as the user gave it.
+func copyTarget(src, name, dst string) (string, error) {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	dstInfo, err := os.Stat(dst)
+	switch {
+	case err == nil && dstInfo.IsDir():
+		// "dir/." copies the contents of dir
+		if srcInfo.IsDir() && (strings.HasSuffix(name, "/.") || name == ".") {
+			return dst, nil
+		}
+		return filepath.Join(dst, filepath.Base(src)), nil
+	case err == nil:
+		if srcInfo.IsDir() {
+			return "", fmt.Errorf("cannot copy a directory to file %s", dst)
+		}
+		return dst, nil
+	case !os.IsNotExist(err):
+		return "", err
+	case strings.HasSuffix(dst, "/") && !srcInfo.IsDir():
+		return "", fmt.Errorf("destination directory %s does not exist", dst)
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.Clean(dst))); err != nil {
+		return "", fmt.Errorf("destination directory %s does not exist", filepath.Dir(filepath.Clean(dst)))
+	}
+	return filepath.Clean(dst), nil
+}
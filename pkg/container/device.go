@@ -0,0 +1,254 @@
+// pkg/container/device.go
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DevicesVar passes the devices given with --device to "floka
+// containerize", as JSON
+const DevicesVar = "FLOKA_CONTAINER_DEVICES"
+
+// Device is a device node created in a container
+type Device struct {
+	PathOnHost        string `json:",omitempty"`
+	PathInContainer   string
+	CgroupPermissions string // What the container may do with it: r to read, w to write, m to create nodes
+	Type              string // c for a character device, b for a block device
+	Major             int64
+	Minor             int64
+	FileMode          os.FileMode // Permissions of the node
+	Uid               uint32
+	Gid               uint32
+}
+
+// deviceRule lets a container use devices, Major or Minor -1 matching
+// any. Type c matches character devices, b block ones.
+type deviceRule struct {
+	Type        string
+	Major       int64
+	Minor       int64
+	Permissions string
+}
+
+func (r deviceRule) String() string {
+	number := func(n int64) string {
+		if n < 0 {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%s %s:%s %s", r.Type, number(r.Major), number(r.Minor), r.Permissions)
+}
+
+// DefaultDevices are the device nodes every container gets
+var DefaultDevices = []Device{
+	{PathInContainer: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{PathInContainer: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{PathInContainer: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{PathInContainer: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{PathInContainer: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{PathInContainer: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// defaultDeviceRules are the devices containers may use besides those
+// given with --device, Docker's: the default nodes, pseudo-terminals and
+// tun, and creating any node, which can't be opened unless allowed
+var defaultDeviceRules = []deviceRule{
+	{Type: "c", Major: -1, Minor: -1, Permissions: "m"},
+	{Type: "b", Major: -1, Minor: -1, Permissions: "m"},
+	{Type: "c", Major: 1, Minor: 3, Permissions: "rwm"},
+	{Type: "c", Major: 1, Minor: 5, Permissions: "rwm"},
+	{Type: "c", Major: 1, Minor: 7, Permissions: "rwm"},
+	{Type: "c", Major: 1, Minor: 8, Permissions: "rwm"},
+	{Type: "c", Major: 1, Minor: 9, Permissions: "rwm"},
+	{Type: "c", Major: 5, Minor: 0, Permissions: "rwm"},
+	{Type: "c", Major: 5, Minor: 1, Permissions: "rwm"},
+	{Type: "c", Major: 5, Minor: 2, Permissions: "rwm"},
+	{Type: "c", Major: 136, Minor: -1, Permissions: "rwm"},
+	{Type: "c", Major: 10, Minor: 200, Permissions: "rwm"},
+}
+
+// ParseDevice parses a --device HOST_PATH[:CONTAINER_PATH][:PERMISSIONS]
+// option, permissions being made of r, w and m, rwm when not given. A
+// directory gives all the devices below it.
+func ParseDevice(spec string) ([]Device, error) {
+	parts := strings.Split(spec, ":")
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 1:
+	case 2:
+		if validDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid device %q, expected HOST_PATH[:CONTAINER_PATH][:PERMISSIONS]", spec)
+	}
+	if !validDevicePermissions(permissions) {
+		return nil, fmt.Errorf("invalid device permissions %q, expected r, w and m", permissions)
+	}
+	if !filepath.IsAbs(hostPath) || !filepath.IsAbs(containerPath) {
+		return nil, fmt.Errorf("invalid device %q, paths must be absolute", spec)
+	}
+
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find device: %w", err)
+	}
+	if !info.IsDir() {
+		device, err := deviceFromPath(hostPath)
+		if err != nil {
+			return nil, err
+		}
+		if device == nil {
+			return nil, fmt.Errorf("%s is not a device", hostPath)
+		}
+		device.PathInContainer = filepath.Clean(containerPath)
+		device.CgroupPermissions = permissions
+		return []Device{*device}, nil
+	}
+
+	var devices []Device
+	err = filepath.WalkDir(hostPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		device, err := deviceFromPath(path)
+		if err != nil || device == nil {
+			return err
+		}
+		rel, _ := filepath.Rel(hostPath, path)
+		device.PathInContainer = filepath.Join(containerPath, rel)
+		device.CgroupPermissions = permissions
+		devices = append(devices, *device)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices of %s: %w", hostPath, err)
+	}
+	return devices, nil
+}
+
+func validDevicePermissions(s string) bool {
+	return s != "" && strings.Trim(s, "rwm") == ""
+}
+
+// deviceFromPath returns the device node at path, nil if it isn't one
+func deviceFromPath(path string) (*Device, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return nil, fmt.Errorf("failed to read device %s: %w", path, err)
+	}
+	device := &Device{
+		PathOnHost: path,
+		Major:      int64(unix.Major(uint64(st.Rdev))),
+		Minor:      int64(unix.Minor(uint64(st.Rdev))),
+		FileMode:   os.FileMode(st.Mode & 0777),
+		Uid:        st.Uid,
+		Gid:        st.Gid,
+	}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		device.Type = "c"
+	case unix.S_IFBLK:
+		device.Type = "b"
+	default:
+		return nil, nil
+	}
+	return device, nil
+}
+
+// CreateDevices creates the default device nodes and devices in /dev,
+// and the links of /dev to /proc and /dev/pts. It runs inside the
+// container.
+func CreateDevices(devices []Device) error {
+	for _, d := range append(append([]Device{}, DefaultDevices...), devices...) {
+		if err := os.MkdirAll(filepath.Dir(d.PathInContainer), 0755); err != nil {
+			return fmt.Errorf("failed to create directory of %s: %w", d.PathInContainer, err)
+		}
+		mode := uint32(d.FileMode.Perm()) | unix.S_IFCHR
+		if d.Type == "b" {
+			mode = uint32(d.FileMode.Perm()) | unix.S_IFBLK
+		}
+		os.Remove(d.PathInContainer)
+		if err := unix.Mknod(d.PathInContainer, mode, int(unix.Mkdev(uint32(d.Major), uint32(d.Minor)))); err != nil {
+			return fmt.Errorf("failed to create device %s: %w", d.PathInContainer, err)
+		}
+		// The umask may have taken permissions away
+		if err := os.Chmod(d.PathInContainer, d.FileMode.Perm()); err != nil {
+			return fmt.Errorf("failed to create device %s: %w", d.PathInContainer, err)
+		}
+		if err := os.Lchown(d.PathInContainer, int(d.Uid), int(d.Gid)); err != nil {
+			return fmt.Errorf("failed to create device %s: %w", d.PathInContainer, err)
+		}
+	}
+
+	links := [][2]string{
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+		{"pts/ptmx", "/dev/ptmx"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], link[1]); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create %s: %w", link[1], err)
+		}
+	}
+	return nil
+}
+
+// DevicesFromProcess returns the devices "floka containerize" was started
+// with
+func DevicesFromProcess() ([]Device, error) {
+	data := os.Getenv(DevicesVar)
+	if data == "" {
+		return nil, nil
+	}
+	var devices []Device
+	if err := json.Unmarshal([]byte(data), &devices); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", DevicesVar, err)
+	}
+	return devices, nil
+}
+
+// setupDeviceCgroup lets the processes of a container use only the
+// default devices and those given, unless it is privileged. Cgroup v1 has
+// rules for it, cgroup v2 an eBPF program deciding.
+func setupDeviceCgroup(cgroup string, devices []Device, privileged bool) error {
+	if privileged {
+		return nil
+	}
+	rules := append([]deviceRule{}, defaultDeviceRules...)
+	for _, d := range devices {
+		rules = append(rules, deviceRule{Type: d.Type, Major: d.Major, Minor: d.Minor, Permissions: d.CgroupPermissions})
+	}
+
+	cgroupPath := "/sys/fs/cgroup"
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err == nil {
+		return attachDeviceProgram(filepath.Join(cgroupPath, cgroup), rules)
+	}
+
+	devicesDir := filepath.Join(cgroupPath, "devices", cgroup)
+	if err := os.WriteFile(filepath.Join(devicesDir, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("failed to deny devices: %w", err)
+	}
+	for _, rule := range rules {
+		if err := os.WriteFile(filepath.Join(devicesDir, "devices.allow"), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("failed to allow device %s: %w", rule, err)
+		}
+	}
+	return nil
+}
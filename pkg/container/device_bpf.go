@@ -0,0 +1,166 @@
+// pkg/container/device_bpf.go
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Cgroup v2 has no devices.allow: an eBPF program attached to the cgroup
+// decides on each access to a device. It is given the access type, major
+// and minor in a bpf_cgroup_dev_ctx and returns 1 to allow it, 0 to deny
+// it.
+
+// bpfInsn is an eBPF instruction, struct bpf_insn
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // Destination register in the low bits, source in the high ones
+	Off  int16
+	Imm  int32
+}
+
+// eBPF opcodes the device program uses
+const (
+	bpfLdxMemW   = unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W
+	bpfAndImm    = unix.BPF_ALU64 | unix.BPF_AND | unix.BPF_K
+	bpfRshImm    = unix.BPF_ALU64 | unix.BPF_RSH | unix.BPF_K
+	bpfMovImm    = unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K
+	bpfMovReg    = unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X
+	bpfJneImm    = unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K
+	bpfJneReg    = unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X
+	bpfExit      = unix.BPF_JMP | unix.BPF_EXIT
+	nextRuleJump = -1 // Placeholder for a jump to the next rule
+)
+
+func ebpf(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm}
+}
+
+// deviceProgram compiles rules into an eBPF program allowing the accesses
+// one of them matches and denying the others
+func deviceProgram(rules []deviceRule) []bpfInsn {
+	// R2 = device type, R3 = access, R4 = major, R5 = minor
+	prog := []bpfInsn{
+		ebpf(bpfLdxMemW, 2, 1, 0, 0),
+		ebpf(bpfAndImm, 2, 0, 0, 0xffff),
+		ebpf(bpfLdxMemW, 3, 1, 0, 0),
+		ebpf(bpfRshImm, 3, 0, 0, 16),
+		ebpf(bpfLdxMemW, 4, 1, 4, 0),
+		ebpf(bpfLdxMemW, 5, 1, 8, 0),
+	}
+	for _, rule := range rules {
+		var block []bpfInsn
+		switch rule.Type {
+		case "c":
+			block = append(block, ebpf(bpfJneImm, 2, 0, nextRuleJump, unix.BPF_DEVCG_DEV_CHAR))
+		case "b":
+			block = append(block, ebpf(bpfJneImm, 2, 0, nextRuleJump, unix.BPF_DEVCG_DEV_BLOCK))
+		}
+		var access int32
+		for _, p := range rule.Permissions {
+			switch p {
+			case 'r':
+				access |= unix.BPF_DEVCG_ACC_READ
+			case 'w':
+				access |= unix.BPF_DEVCG_ACC_WRITE
+			case 'm':
+				access |= unix.BPF_DEVCG_ACC_MKNOD
+			}
+		}
+		if access != unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE|unix.BPF_DEVCG_ACC_MKNOD {
+			// Every access asked for must be allowed
+			block = append(block,
+				ebpf(bpfMovReg, 1, 3, 0, 0),
+				ebpf(bpfAndImm, 1, 0, 0, access),
+				ebpf(bpfJneReg, 1, 3, nextRuleJump, 0),
+			)
+		}
+		if rule.Major >= 0 {
+			block = append(block, ebpf(bpfJneImm, 4, 0, nextRuleJump, int32(rule.Major)))
+		}
+		if rule.Minor >= 0 {
+			block = append(block, ebpf(bpfJneImm, 5, 0, nextRuleJump, int32(rule.Minor)))
+		}
+		block = append(block, ebpf(bpfMovImm, 0, 0, 0, 1), ebpf(bpfExit, 0, 0, 0, 0))
+		for i := range block {
+			if block[i].Off == nextRuleJump {
+				block[i].Off = int16(len(block) - i - 1)
+			}
+		}
+		prog = append(prog, block...)
+	}
+	return append(prog, ebpf(bpfMovImm, 0, 0, 0, 0), ebpf(bpfExit, 0, 0, 0, 0))
+}
+
+// bpfProgLoadAttr is the part of union bpf_attr BPF_PROG_LOAD reads
+type bpfProgLoadAttr struct {
+	ProgType    uint32
+	InsnCnt     uint32
+	Insns       uint64
+	License     uint64
+	LogLevel    uint32
+	LogSize     uint32
+	LogBuf      uint64
+	KernVersion uint32
+	ProgFlags   uint32
+}
+
+// bpfProgAttachAttr is the part of union bpf_attr BPF_PROG_ATTACH reads
+type bpfProgAttachAttr struct {
+	TargetFd     uint32
+	AttachBpfFd  uint32
+	AttachType   uint32
+	AttachFlags  uint32
+	ReplaceBpfFd uint32
+}
+
+// attachDeviceProgram loads the program compiled from rules and attaches
+// it to the cgroup v2 directory given. Programs of the cgroups above it
+// keep applying.
+func attachDeviceProgram(cgroupDir string, rules []deviceRule) error {
+	prog := deviceProgram(rules)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.NativeEndian, prog)
+	insns := buf.Bytes()
+	license := []byte("MIT\x00")
+	logBuf := make([]byte, 64*1024)
+	load := bpfProgLoadAttr{
+		ProgType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		InsnCnt:  uint32(len(prog)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel: 1,
+		LogSize:  uint32(len(logBuf)),
+		LogBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return fmt.Errorf("failed to load device program: %w: %s", errno, unix.ByteSliceToString(logBuf))
+	}
+	progFile := os.NewFile(fd, "device-program")
+	defer progFile.Close()
+
+	cgroup, err := os.Open(cgroupDir)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	defer cgroup.Close()
+	attach := bpfProgAttachAttr{
+		TargetFd:    uint32(cgroup.Fd()),
+		AttachBpfFd: uint32(fd),
+		AttachType:  unix.BPF_CGROUP_DEVICE,
+		AttachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach)); errno != 0 {
+		return fmt.Errorf("failed to attach device program: %w", errno)
+	}
+	return nil
+}
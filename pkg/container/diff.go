@@ -0,0 +1,280 @@
+// pkg/container/diff.go
+package container
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// Kinds of changes Diff reports, as docker diff prints them
+const (
+	ChangeModify = "C"
+	ChangeAdd    = "A"
+	ChangeDelete = "D"
+)
+
+// Change is a path of the container's filesystem that differs from its
+// image
+type Change struct {
+	Path string // Absolute path in the container
+	Kind string // ChangeModify, ChangeAdd or ChangeDelete
+}
+
+// diffSkipped are the directories the runtime fills, left out of diffs
+var diffSkipped = []string{"/proc", "/sys", "/dev"}
+
+// Diff lists the paths of the container's filesystem that were added,
+// changed or deleted since it was created from its image, sorted by path.
+// With overlay storage they are read from the container's upper directory,
+// with vfs storage the rootfs copy is compared with the image layers.
+// Volumes and the /etc files floka generates are left out.
+func (c *Container) Diff() ([]Change, error) {
+	containerDir := config.DataPath("containers", c.ID)
+	skipped := append([]string{}, diffSkipped...)
+	for _, m := range append(c.Mounts, c.etcMounts()...) {
+		skipped = append(skipped, filepath.Clean(m.Destination))
+	}
+	skip := func(p string) bool {
+		for _, s := range skipped {
+			if p == s || strings.HasPrefix(p, s+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var changes []Change
+	var err error
+	switch c.StorageDriver {
+	case StorageOverlay:
+		changes, err = overlayChanges(filepath.Join(containerDir, "upper"), c.Layers, skip)
+	case StorageVFS:
+		changes, err = copyChanges(filepath.Join(containerDir, "rootfs"), c.Layers, skip)
+	default:
+		return nil, fmt.Errorf("diff is not supported for containers with %s storage", c.StorageDriver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare container %s with its image: %w", c.ID, err)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// overlayChanges lists the changes an overlay upper directory holds over
+// the layers below it: whiteouts are deletions, and other entries changes
+// of what the layers have at their path or additions
+func overlayChanges(upper string, layers []string, skip func(string) bool) ([]Change, error) {
+	var changes []Change
+	err := filepath.WalkDir(upper, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		if skip(name) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		_, inLower := lowerEntry(layers, rel)
+		switch {
+		case isWhiteout(d):
+			if inLower {
+				changes = append(changes, Change{name, ChangeDelete})
+			}
+		case !inLower:
+			changes = append(changes, Change{name, ChangeAdd})
+		default:
+			changes = append(changes, Change{name, ChangeModify})
+			// What an opaque directory doesn't have again was deleted
+			if d.IsDir() && isOpaque(path) {
+				for _, child := range lowerNames(layers, rel) {
+					if _, err := os.Lstat(filepath.Join(path, child)); os.IsNotExist(err) && !skip(name+"/"+child) {
+						changes = append(changes, Change{name + "/" + child, ChangeDelete})
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// copyChanges lists the changes of a rootfs copied from the layers by
+// comparing it with them. Directories count as changed when their mode or
+// owner did, their entries being reported themselves.
+func copyChanges(rootfs string, layers []string, skip func(string) bool) ([]Change, error) {
+	var changes []Change
+	err := filepath.WalkDir(rootfs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		if skip(name) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		lower, inLower := lowerEntry(layers, rel)
+		if !inLower {
+			changes = append(changes, Change{name, ChangeAdd})
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fileChanged(info, lower, path, filepath.Join(lower.layer, rel)) {
+			changes = append(changes, Change{name, ChangeModify})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Paths the layers show that the copy doesn't have anymore, those in
+	// deleted directories being covered by the directory
+	seen := map[string]bool{}
+	for _, layer := range layers {
+		err := filepath.WalkDir(layer, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(layer, path)
+			if err != nil || rel == "." || seen[rel] {
+				return err
+			}
+			seen[rel] = true
+			name := "/" + filepath.ToSlash(rel)
+			if skip(name) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if _, visible := lowerEntry(layers, rel); !visible {
+				return nil
+			}
+			if _, err := os.Lstat(filepath.Join(rootfs, rel)); !os.IsNotExist(err) {
+				return nil
+			}
+			if _, err := os.Lstat(filepath.Join(rootfs, filepath.Dir(rel))); err == nil {
+				changes = append(changes, Change{name, ChangeDelete})
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// layerEntry is a file of an image layer
+type layerEntry struct {
+	fs.FileInfo
+	layer string // Layer directory holding the file
+}
+
+// lowerEntry returns what the layers, top first, show at rel as overlayfs
+// would: the file of the topmost layer having one there, unless a whiteout,
+// an opaque directory or a file in place of a parent directory hides it
+func lowerEntry(layers []string, rel string) (layerEntry, bool) {
+	for _, layer := range layers {
+		info, err := os.Lstat(filepath.Join(layer, rel))
+		if err == nil {
+			if isWhiteout(fs.FileInfoToDirEntry(info)) {
+				return layerEntry{}, false
+			}
+			return layerEntry{info, layer}, true
+		}
+		if hiddenBelow(layer, rel) {
+			return layerEntry{}, false
+		}
+	}
+	return layerEntry{}, false
+}
+
+// hiddenBelow reports whether a layer lacking rel hides the lower layers'
+// rel, with a parent directory that is opaque, whited out or not a
+// directory
+func hiddenBelow(layer, rel string) bool {
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		path := filepath.Join(layer, dir)
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() || isOpaque(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerNames returns the names of the entries the layers show in the
+// directory rel
+func lowerNames(layers []string, rel string) []string {
+	candidates := map[string]bool{}
+	for _, layer := range layers {
+		entries, _ := os.ReadDir(filepath.Join(layer, rel))
+		for _, entry := range entries {
+			candidates[entry.Name()] = true
+		}
+	}
+	var names []string
+	for name := range candidates {
+		if _, ok := lowerEntry(layers, filepath.Join(rel, name)); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileChanged reports whether a file of a rootfs copy differs from the
+// layer file it was copied from
+func fileChanged(info fs.FileInfo, lower layerEntry, path, lowerPath string) bool {
+	if info.Mode() != lower.Mode() {
+		return true
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	lowerSt, lowerOk := lower.Sys().(*syscall.Stat_t)
+	if !ok || !lowerOk {
+		return true
+	}
+	if st.Uid != lowerSt.Uid || st.Gid != lowerSt.Gid || st.Rdev != lowerSt.Rdev {
+		return true
+	}
+	if info.IsDir() {
+		return false
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		lowerTarget, lowerErr := os.Readlink(lowerPath)
+		return err != nil || lowerErr != nil || target != lowerTarget
+	}
+	return info.Size() != lower.Size() || !info.ModTime().Equal(lower.ModTime())
+}
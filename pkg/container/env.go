@@ -0,0 +1,76 @@
+// pkg/container/env.go
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvVar passes the container environment to "floka containerize", which
+// keeps it away from its own environment
+const EnvVar = "FLOKA_CONTAINER_ENV"
+
+// WorkDirVar passes the working directory of the container to "floka
+// containerize"
+const WorkDirVar = "FLOKA_CONTAINER_WORKDIR"
+
+// UserVar passes the user of the container to "floka containerize"
+const UserVar = "FLOKA_CONTAINER_USER"
+
+// PrivilegedVar is set to 1 for "floka containerize" of a privileged
+// container
+const PrivilegedVar = "FLOKA_CONTAINER_PRIVILEGED"
+
+// InitVar is set to 1 for "floka containerize" of a container run with
+// --init, which then acts as its init process
+const InitVar = "FLOKA_CONTAINER_INIT"
+
+// MergeEnv returns base with the KEY=VALUE entries of each override list
+// applied in order. A later value for a key replaces the earlier one in
+// place, new keys are appended.
+func MergeEnv(base []string, overrides ...[]string) []string {
+	merged := append([]string{}, base...)
+	index := map[string]int{}
+	for i, kv := range merged {
+		key, _, _ := strings.Cut(kv, "=")
+		index[key] = i
+	}
+	for _, list := range overrides {
+		for _, kv := range list {
+			key, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// LookupEnv returns the value of key in a KEY=VALUE list
+func LookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// EnvFromProcess returns the container environment "floka containerize"
+// was started with
+func EnvFromProcess() ([]string, error) {
+	data := os.Getenv(EnvVar)
+	if data == "" {
+		return nil, nil
+	}
+	var env []string
+	if err := json.Unmarshal([]byte(data), &env); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvVar, err)
+	}
+	return env, nil
+}
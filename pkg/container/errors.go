@@ -0,0 +1,14 @@
+// pkg/container/errors.go
+package container
+
+import "errors"
+
+// Errors callers can test for with errors.Is
+var (
+	ErrContainerNotFound   = errors.New("no such container")
+	ErrContainerRunning    = errors.New("container is running")
+	ErrContainerNotRunning = errors.New("container is not running")
+	ErrContainerPaused     = errors.New("container is paused")
+	ErrAlreadyExists       = errors.New("already exists")
+	ErrPodNotFound         = errors.New("no such pod")
+)
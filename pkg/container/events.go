@@ -0,0 +1,94 @@
+// pkg/container/events.go
+package container
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/events"
+)
+
+// Event describes a change in a container's lifecycle
+type Event struct {
+	Type        string // Event type, e.g. "die" or "oom"
+	ContainerID string
+	Image       string
+	ExitCode    int
+	Time        time.Time
+}
+
+// EventHook is called synchronously for every lifecycle event
+type EventHook func(Event)
+
+var eventHooks []EventHook
+
+// AddEventHook registers a function that is notified of lifecycle events
+func AddEventHook(hook EventHook) {
+	eventHooks = append(eventHooks, hook)
+}
+
+// logEvent records an event about the container in the events log, with
+// its image and name and the given attributes
+func (c *Container) logEvent(action string, attributes map[string]string) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attributes["image"] = c.ImageRef()
+	if c.Name != "" {
+		attributes["name"] = c.Name
+	}
+	events.Log(events.TypeContainer, action, c.ID, attributes)
+}
+
+// emit records an event about the container in the events log and sends
+// it to all registered hooks
+func (c *Container) emit(eventType string, exitCode int) {
+	var attributes map[string]string
+	if eventType == "die" || eventType == "oom" {
+		attributes = map[string]string{"exitCode": strconv.Itoa(exitCode)}
+	}
+	c.logEvent(eventType, attributes)
+
+	event := Event{
+		Type:        eventType,
+		ContainerID: c.ID,
+		Image:       c.ImageRef(),
+		ExitCode:    exitCode,
+		Time:        time.Now(),
+	}
+	for _, hook := range eventHooks {
+		hook(event)
+	}
+}
+
+// oomKillCount returns how many processes of a container's cgroup the
+// kernel OOM killer has killed since the cgroup was created
+func oomKillCount(cgroup string) int64 {
+	cgroupPath := "/sys/fs/cgroup"
+
+	// Cgroup v2 reports kills in memory.events, v1 in memory.oom_control
+	eventsFile := filepath.Join(cgroupPath, cgroup, "memory.events")
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err != nil {
+		eventsFile = filepath.Join(cgroupPath, "memory", cgroup, "memory.oom_control")
+	}
+
+	f, err := os.Open(eventsFile)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
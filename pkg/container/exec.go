@@ -0,0 +1,157 @@
+// pkg/container/exec.go
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// namespaces joined by Exec, in the order they are entered. The mount
+// namespace goes last since the others are looked up through /proc.
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", syscall.CLONE_NEWIPC},
+	{"uts", syscall.CLONE_NEWUTS},
+	{"net", syscall.CLONE_NEWNET},
+	{"pid", syscall.CLONE_NEWPID},
+	{"mnt", syscall.CLONE_NEWNS},
+}
+
+// ExecOptions configures a command started with Exec
+type ExecOptions struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool   // Stdin is a terminal that becomes the command's controlling terminal
+	User   string // User to run as instead of the container's, NAME|UID[:GROUP|GID]
+}
+
+// Exec runs a command inside the namespaces of a running container and
+// returns its exit code. The namespaces are joined by a re-executed
+// "floka nsexec" helper so the calling process stays where it is.
+// Cancelling ctx kills the command.
+func (c *Container) Exec(ctx context.Context, command []string, opts *ExecOptions) (int, error) {
+	if len(command) == 0 {
+		return -1, fmt.Errorf("no command specified")
+	}
+	if !c.IsRunning() {
+		return -1, fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+	if c.Status == "paused" {
+		return -1, fmt.Errorf("%w: %s, unpause it first", ErrContainerPaused, c.ID)
+	}
+	if opts == nil {
+		opts = &ExecOptions{}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return -1, fmt.Errorf("failed to get host executable path: %w", err)
+	}
+
+	args := []string{"nsexec"}
+	if opts.TTY {
+		args = append(args, "--tty")
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	args = append(args, c.ID)
+	args = append(args, command...)
+
+	// The helper passes SIGTERM on to the command
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = DefaultStopTimeout
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+
+	logging.L().Debug("executing in container", "container", c.ID, "pid", c.Pid, "args", command)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, fmt.Errorf("failed to execute in container: %w", err)
+	}
+	return 0, nil
+}
+
+// EnterNamespaces moves the calling thread into the container's cgroup,
+// namespaces and root directory, so that processes started from it run
+// inside the container. The goroutine stays locked to its thread for good;
+// this is meant for the nsexec helper, which runs one command and exits.
+func (c *Container) EnterNamespaces() error {
+	runtime.LockOSThread()
+
+	if !c.alive() {
+		return fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+
+	if err := addProcessToCgroups(c.cgroup(), os.Getpid()); err != nil {
+		logging.L().Warn("failed to add process to cgroups", "container", c.ID, "err", err)
+	}
+
+	// Open everything up front, /proc/<pid> can't be reached from inside
+	// the container's mount namespace
+	procDir := filepath.Join("/proc", strconv.Itoa(c.Pid))
+	root, err := os.Open(filepath.Join(procDir, "root"))
+	if err != nil {
+		return fmt.Errorf("failed to open container root: %w", err)
+	}
+	defer root.Close()
+
+	nsFiles := make([]*os.File, len(execNamespaces))
+	for i, ns := range execNamespaces {
+		f, err := os.Open(filepath.Join(procDir, "ns", ns.name))
+		if err != nil {
+			return fmt.Errorf("failed to open %s namespace: %w", ns.name, err)
+		}
+		defer f.Close()
+		nsFiles[i] = f
+	}
+
+	// A thread can only join a mount namespace if it doesn't share its
+	// filesystem attributes with the other threads of the process
+	if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+		return fmt.Errorf("failed to unshare filesystem attributes: %w", err)
+	}
+
+	for i, ns := range execNamespaces {
+		if err := unix.Setns(int(nsFiles[i].Fd()), ns.flag); err != nil {
+			return fmt.Errorf("failed to join %s namespace: %w", ns.name, err)
+		}
+	}
+
+	// The container is chrooted inside its mount namespace, follow it
+	if err := syscall.Fchdir(int(root.Fd())); err != nil {
+		return fmt.Errorf("failed to enter container root: %w", err)
+	}
+	if err := syscall.Chroot("."); err != nil {
+		return fmt.Errorf("failed to chroot into container: %w", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("failed to change directory: %w", err)
+	}
+
+	return nil
+}
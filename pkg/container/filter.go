@@ -0,0 +1,125 @@
+// pkg/container/filter.go
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// ListOptions filters, sorts and paginates the result of ListContainers.
+// The zero value lists every container ordered by ID.
+type ListOptions struct {
+	Status   []string // Only containers in one of these states
+	Ancestor string   // Only containers created from this image (name or name:tag)
+	IDPrefix string   // Only containers whose ID starts with this prefix
+	Name     string   // Only the container with this name
+	Labels   []string // Only containers with all of these labels, KEY or KEY=VALUE
+	SortBy   string   // "id" (default), "image" or "status"
+	Reverse  bool     // Reverse the sort order
+	Offset   int      // Number of matching containers to skip
+	Limit    int      // Maximum number of containers to return, 0 for no limit
+}
+
+// ImageRef returns the name:tag of the image the container was created from.
+// Containers of older versions hold the image rootfs path
+// (images/<name:tag>/rootfs) instead.
+func (c *Container) ImageRef() string {
+	if !filepath.IsAbs(c.Image) {
+		return c.Image
+	}
+	imageDir := filepath.Dir(c.Image)
+	// Names may contain slashes (registry/repo:tag)
+	if rel, err := filepath.Rel(config.DataPath("images"), imageDir); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filepath.Base(imageDir)
+}
+
+// matches reports whether the container passes all filters in opts
+func (o *ListOptions) matches(c *Container) bool {
+	if len(o.Status) > 0 {
+		found := false
+		for _, s := range o.Status {
+			if s == c.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if o.Ancestor != "" {
+		ancestor := o.Ancestor
+		if !strings.Contains(ancestor, ":") {
+			ancestor += ":latest"
+		}
+		if c.ImageRef() != ancestor {
+			return false
+		}
+	}
+
+	if o.IDPrefix != "" && !strings.HasPrefix(c.ID, o.IDPrefix) {
+		return false
+	}
+
+	if o.Name != "" && c.Name != o.Name {
+		return false
+	}
+
+	for _, label := range o.Labels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, ok := c.Labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply filters, sorts and paginates containers according to opts
+func (o *ListOptions) apply(containers []*Container) ([]*Container, error) {
+	var less func(a, b *Container) bool
+	switch o.SortBy {
+	case "", "id":
+		less = func(a, b *Container) bool { return a.ID < b.ID }
+	case "image":
+		less = func(a, b *Container) bool { return a.ImageRef() < b.ImageRef() }
+	case "status":
+		less = func(a, b *Container) bool { return a.Status < b.Status }
+	default:
+		return nil, fmt.Errorf("unknown sort key: %s", o.SortBy)
+	}
+	if o.Offset < 0 || o.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
+	}
+
+	filtered := []*Container{}
+	for _, c := range containers {
+		if o.matches(c) {
+			filtered = append(filtered, c)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if o.Reverse {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+
+	if o.Offset >= len(filtered) {
+		return []*Container{}, nil
+	}
+	filtered = filtered[o.Offset:]
+	if o.Limit > 0 && o.Limit < len(filtered) {
+		filtered = filtered[:o.Limit]
+	}
+	return filtered, nil
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListOptionsMatches(t *testing.T) {
+	root := useTempRoot(t)
+	c := &Container{
+		ID:     "a1b2c3",
+		Name:   "web",
+		Image:  "nginx:1.25",
+		Status: "running",
+		Labels: map[string]string{"app": "shop", "tier": ""},
+	}
+	legacy := &Container{ID: "d4e5f6", Image: filepath.Join(root, "images", "library/redis:7", "rootfs"), Status: "stopped"}
+
+	tests := []struct {
+		name string
+		c    *Container
+		opts ListOptions
+		want bool
+	}{
+		{"no filters", c, ListOptions{}, true},
+		{"status", c, ListOptions{Status: []string{"running"}}, true},
+		{"one of the statuses", c, ListOptions{Status: []string{"stopped", "running"}}, true},
+		{"other status", c, ListOptions{Status: []string{"stopped", "paused"}}, false},
+		{"ancestor with tag", c, ListOptions{Ancestor: "nginx:1.25"}, true},
+		{"ancestor without tag is latest", c, ListOptions{Ancestor: "nginx"}, false},
+		{"other ancestor", c, ListOptions{Ancestor: "nginx:1.24"}, false},
+		{"ancestor of an older container", legacy, ListOptions{Ancestor: "library/redis:7"}, true},
+		{"ID prefix", c, ListOptions{IDPrefix: "a1b"}, true},
+		{"other ID prefix", c, ListOptions{IDPrefix: "b2"}, false},
+		{"name", c, ListOptions{Name: "web"}, true},
+		{"name is not a prefix", c, ListOptions{Name: "we"}, false},
+		{"label key", c, ListOptions{Labels: []string{"app"}}, true},
+		{"label key with an empty value", c, ListOptions{Labels: []string{"tier"}}, true},
+		{"label value", c, ListOptions{Labels: []string{"app=shop"}}, true},
+		{"empty label value", c, ListOptions{Labels: []string{"tier="}}, true},
+		{"other label value", c, ListOptions{Labels: []string{"app=blog"}}, false},
+		{"all labels needed", c, ListOptions{Labels: []string{"app=shop", "env"}}, false},
+		{"all filters", c, ListOptions{Status: []string{"running"}, Ancestor: "nginx:1.25", IDPrefix: "a1", Name: "web", Labels: []string{"app=shop"}}, true},
+		{"all filters but one", c, ListOptions{Status: []string{"running"}, Ancestor: "nginx:1.25", IDPrefix: "a1", Name: "db", Labels: []string{"app=shop"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.matches(tt.c); got != tt.want {
+				t.Errorf("matches returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListOptionsApply(t *testing.T) {
+	containers := []*Container{
+		{ID: "c3", Image: "alpine:3", Status: "running"},
+		{ID: "a1", Image: "nginx:1", Status: "stopped"},
+		{ID: "b2", Image: "busybox:1", Status: "running"},
+		{ID: "d4", Image: "alpine:3", Status: "created"},
+	}
+
+	tests := []struct {
+		name    string
+		opts    ListOptions
+		want    []string
+		wantErr bool
+	}{
+		{"by ID", ListOptions{}, []string{"a1", "b2", "c3", "d4"}, false},
+		{"by ID reversed", ListOptions{SortBy: "id", Reverse: true}, []string{"d4", "c3", "b2", "a1"}, false},
+		{"by image, stable", ListOptions{SortBy: "image"}, []string{"c3", "d4", "b2", "a1"}, false},
+		{"by status", ListOptions{SortBy: "status"}, []string{"d4", "c3", "b2", "a1"}, false},
+		{"filtered then sorted", ListOptions{Status: []string{"running"}, Reverse: true}, []string{"c3", "b2"}, false},
+		{"offset", ListOptions{Offset: 1}, []string{"b2", "c3", "d4"}, false},
+		{"limit", ListOptions{Limit: 2}, []string{"a1", "b2"}, false},
+		{"offset and limit", ListOptions{Offset: 1, Limit: 2}, []string{"b2", "c3"}, false},
+		{"limit over the count", ListOptions{Offset: 2, Limit: 5}, []string{"c3", "d4"}, false},
+		{"offset past the end", ListOptions{Offset: 4}, []string{}, false},
+		{"no match", ListOptions{Name: "web"}, []string{}, false},
+		{"unknown sort key", ListOptions{SortBy: "name"}, nil, true},
+		{"negative offset", ListOptions{Offset: -1}, nil, true},
+		{"negative limit", ListOptions{Limit: -1}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.apply(containers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("apply succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, c := range got {
+				ids = append(ids, c.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("apply returned %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
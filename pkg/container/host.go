@@ -0,0 +1,117 @@
+// pkg/container/host.go
+package container
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// HostInfo describes the kernel features containers depend on
+type HostInfo struct {
+	KernelVersion      string
+	CgroupVersion      int      // 1 or 2, 0 without a cgroup filesystem
+	CgroupControllers  []string // Controllers containers can be put in
+	MissingControllers []string // Controllers floka uses that containers can't be put in
+	Overlay            bool     // Container rootfs can be overlay mounts
+	OverlayError       string   `json:",omitempty"` // Why they can't, containers then getting copies of their image
+	UserNamespaces     bool
+	Seccomp            bool
+}
+
+// Host checks the kernel features containers depend on. It mounts an
+// overlayfs in the data root to find out whether the data root can hold
+// the upper directories of containers.
+func Host() *HostInfo {
+	info := &HostInfo{}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		info.KernelVersion = unix.ByteSliceToString(uts.Release[:])
+	}
+
+	wanted := cgroupV1Subsystems
+	if controllers, err := readCgroupList("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		info.CgroupVersion = 2
+		wanted = cgroupV2Controllers
+		for controller := range controllers {
+			info.CgroupControllers = append(info.CgroupControllers, controller)
+		}
+	} else {
+		for _, subsystem := range cgroupV1Subsystems {
+			if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", subsystem, "tasks")); err == nil {
+				info.CgroupVersion = 1
+				info.CgroupControllers = append(info.CgroupControllers, subsystem)
+			}
+		}
+	}
+	sort.Strings(info.CgroupControllers)
+	for _, controller := range wanted {
+		i := sort.SearchStrings(info.CgroupControllers, controller)
+		if i == len(info.CgroupControllers) || info.CgroupControllers[i] != controller {
+			info.MissingControllers = append(info.MissingControllers, controller)
+		}
+	}
+
+	if err := checkOverlay(); err != nil {
+		info.OverlayError = err.Error()
+	} else {
+		info.Overlay = true
+	}
+
+	// Without the limit the kernel has no user namespaces
+	if data, err := os.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil {
+		n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		info.UserNamespaces = n > 0
+	}
+	info.Seccomp = kernelHasSeccomp()
+	return info
+}
+
+// checkOverlay mounts an overlayfs with its upper directory in the data
+// root, as containers get them
+func checkOverlay() error {
+	if err := os.MkdirAll(config.Root(), 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(config.Root(), "overlay-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	merged := filepath.Join(dir, "merged")
+	data := "lowerdir=" + filepath.Join(dir, "lower") + ",upperdir=" + filepath.Join(dir, "upper") + ",workdir=" + filepath.Join(dir, "work")
+	if err := syscall.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return err
+	}
+	return syscall.Unmount(merged, syscall.MNT_DETACH)
+}
+
+// kernelHasSeccomp reports whether the kernel has seccomp filters, which
+// /proc/self/status only shows a Seccomp field for when it does
+func kernelHasSeccomp() bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Seccomp:") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+// pkg/container/hosts.go
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// HostnameVar passes the hostname of the container to "floka containerize"
+const HostnameVar = "FLOKA_CONTAINER_HOSTNAME"
+
+// hostResolvConf is read for the name servers of containers given no --dns
+const hostResolvConf = "/etc/resolv.conf"
+
+// hostHosts is the base of /etc/hosts for containers on the host network
+const hostHosts = "/etc/hosts"
+
+// defaultDNS is used when the host only has name servers on its loopback
+// interface, which containers can't reach from their network namespace
+var defaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// etcFiles are generated for each container in its directory and bind
+// mounted over the image's, unless a volume is mounted there
+var etcFiles = []string{"hostname", "hosts", "resolv.conf"}
+
+var validHostname = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// CheckHostname reports whether name can be a container's hostname
+func CheckHostname(name string) error {
+	if len(name) > 64 || !validHostname.MatchString(name) {
+		return fmt.Errorf("invalid hostname %q", name)
+	}
+	return nil
+}
+
+// ParseExtraHost splits an --add-host entry, HOST:IP, the IP address
+// being IPv4 or IPv6
+func ParseExtraHost(spec string) (string, string, error) {
+	host, ip, ok := strings.Cut(spec, ":")
+	if !ok || host == "" {
+		return "", "", fmt.Errorf("invalid extra host %q, expected HOST:IP", spec)
+	}
+	if err := CheckHostname(host); err != nil {
+		return "", "", fmt.Errorf("invalid extra host %q: %w", spec, err)
+	}
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid extra host %q: invalid IP address %q", spec, ip)
+	}
+	return host, ip, nil
+}
+
+// checkNameResolutionOpts validates the hostname, name servers and extra
+// hosts of opts
+func checkNameResolutionOpts(opts *ContainerOpts) error {
+	if opts.Hostname != "" {
+		if err := CheckHostname(opts.Hostname); err != nil {
+			return err
+		}
+	}
+	for _, server := range opts.DNS {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	for _, domain := range opts.DNSSearch {
+		if domain != "." && CheckHostname(domain) != nil {
+			return fmt.Errorf("invalid DNS search domain %q", domain)
+		}
+	}
+	for _, spec := range opts.ExtraHosts {
+		if _, _, err := ParseExtraHost(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostname returns the container's hostname, from --hostname or its ID
+func (c *Container) hostname() string {
+	if c.Hostname != "" {
+		return c.Hostname
+	}
+	return strings.ReplaceAll(c.ID, "_", "-")
+}
+
+// writeEtcFiles generates the container's /etc/hostname, /etc/hosts and
+// /etc/resolv.conf in its directory. On the host network, /etc/hosts
+// starts from the host's.
+func (c *Container) writeEtcFiles() error {
+	dir := config.DataPath("containers", c.ID)
+	hostname := c.hostname()
+
+	var hosts bytes.Buffer
+	if c.networkMode() == NetworkHost {
+		data, err := os.ReadFile(hostHosts)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", hostHosts, err)
+		}
+		hosts.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			hosts.WriteByte('\n')
+		}
+	}
+	if hosts.Len() == 0 {
+		hosts.WriteString("127.0.0.1\tlocalhost\n")
+		hosts.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+		hosts.WriteString("fe00::0\tip6-localnet\n")
+		hosts.WriteString("ff00::0\tip6-mcastprefix\n")
+		hosts.WriteString("ff02::1\tip6-allnodes\n")
+		hosts.WriteString("ff02::2\tip6-allrouters\n")
+	}
+	for _, spec := range c.ExtraHosts {
+		host, ip, err := ParseExtraHost(spec)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&hosts, "%s\t%s\n", ip, host)
+	}
+	if c.Network != nil {
+		fmt.Fprintf(&hosts, "%s\t%s\n", c.Network.IPAddress, hostname)
+	}
+
+	resolvConf, err := c.resolvConf()
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"hostname":    []byte(hostname + "\n"),
+		"hosts":       hosts.Bytes(),
+		"resolv.conf": resolvConf,
+	}
+	for _, name := range etcFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), files[name], 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolvConf returns the container's resolv.conf: the host's, without the
+// name servers on its loopback interface unless the container is on the
+// host network, with the name servers and search domains given with --dns
+// and --dns-search in place of the host's
+func (c *Container) resolvConf() ([]byte, error) {
+	hostNetwork := c.networkMode() == NetworkHost
+	var servers, search, options []string
+	data, err := os.ReadFile(hostResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", hostResolvConf, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if ip := net.ParseIP(fields[1]); ip != nil && (hostNetwork || !ip.IsLoopback()) {
+				servers = append(servers, fields[1])
+			}
+		case "search", "domain":
+			// The last of them wins
+			search = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+
+	if len(c.DNS) > 0 {
+		servers = c.DNS
+	}
+	if len(servers) == 0 {
+		logging.L().Debug("no usable name server on the host, using the default ones", "container", c.ID, "servers", defaultDNS)
+		servers = defaultDNS
+	}
+	if len(c.DNSSearch) > 0 {
+		search = c.DNSSearch
+		// "." alone clears the search domains
+		if len(search) == 1 && search[0] == "." {
+			search = nil
+		}
+	}
+
+	var conf bytes.Buffer
+	if len(search) > 0 {
+		fmt.Fprintf(&conf, "search %s\n", strings.Join(search, " "))
+	}
+	for _, server := range servers {
+		fmt.Fprintf(&conf, "nameserver %s\n", server)
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&conf, "options %s\n", strings.Join(options, " "))
+	}
+	return conf.Bytes(), nil
+}
+
+// etcMounts returns the bind mounts of the generated /etc files, leaving
+// out those a volume of the container is mounted on. Containers joining
+// the network of another container share its files.
+func (c *Container) etcMounts() []Mount {
+	dir := config.DataPath("containers", c.ID)
+	if id := c.networkContainer(); id != "" {
+		dir = config.DataPath("containers", id)
+	}
+	var mounts []Mount
+	for _, name := range etcFiles {
+		dest := "/etc/" + name
+		covered := false
+		for _, m := range c.Mounts {
+			d := filepath.Clean(m.Destination)
+			if d == dest || strings.HasPrefix(dest, d+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			mounts = append(mounts, Mount{Type: "bind", Source: filepath.Join(dir, name), Destination: dest})
+		}
+	}
+	return mounts
+}
+
+// mountEtcFiles generates the container's /etc files and mounts them in
+// rootfs. On a bind rootfs, the files it creates as mount points are
+// recorded, so that they don't stay in the directory, such as the one of
+// an image build.
+func (c *Container) mountEtcFiles(rootfs string) error {
+	if c.networkContainer() == "" {
+		if err := c.writeEtcFiles(); err != nil {
+			return fmt.Errorf("failed to generate /etc files: %w", err)
+		}
+	}
+	var mounts []Mount
+	for _, m := range c.etcMounts() {
+		// The container joined may be of a version that had none
+		if _, err := os.Stat(m.Source); err != nil {
+			logging.L().Debug("skipping /etc file", "container", c.ID, "path", m.Source, "err", err)
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	if c.StorageDriver == StorageBind {
+		for _, m := range mounts {
+			target, err := fsutil.SecureJoin(rootfs, m.Destination)
+			if err != nil {
+				return fmt.Errorf("invalid mount destination %s: %w", m.Destination, err)
+			}
+			if _, err := os.Lstat(target); os.IsNotExist(err) {
+				c.EtcMountPoints = append(c.EtcMountPoints, m.Destination)
+			}
+		}
+	}
+	return mountVolumes(rootfs, mounts)
+}
+
+// unmountEtcFiles detaches the generated /etc files from rootfs and
+// removes the mount points mountEtcFiles created
+func (c *Container) unmountEtcFiles(rootfs string) {
+	unmountVolumes(rootfs, c.etcMounts())
+	for _, dest := range c.EtcMountPoints {
+		target, err := fsutil.SecureJoin(rootfs, dest)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			logging.L().Warn("failed to remove mount point", "path", target, "err", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// pkg/container/inspect.go
+package container
+
+import (
+	"time"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logdriver"
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// InspectInfo is the full state of a container
+type InspectInfo struct {
+	ID            string
+	Name          string   `json:",omitempty"`
+	Image         string   // Image name:tag
+	ImageID       string   `json:",omitempty"` // ID of the image
+	Rootfs        string   // Root filesystem of the container
+	Layers        []string `json:",omitempty"` // Image layers under the rootfs, top first
+	Command       []string
+	Env           []string
+	WorkingDir    string `json:",omitempty"` // Directory processes start in
+	Labels        map[string]string
+	Created       time.Time
+	Driver        string // Storage driver of the rootfs
+	State         State
+	Mounts        []Mount
+	Pod           string            `json:",omitempty"` // Pod the container belongs to
+	PodInfra      bool              `json:",omitempty"` // The container holds the pod's namespaces
+	NetworkMode   string            // Network name, none, host or container:<id>
+	Network       *network.Endpoint `json:",omitempty"`
+	Ports         []network.PortMapping
+	Hostname      string
+	DNS           []string `json:",omitempty"` // Name servers given with --dns
+	DNSSearch     []string `json:",omitempty"` // Search domains given with --dns-search
+	ExtraHosts    []string `json:",omitempty"` // HOST:IP entries added to /etc/hosts
+	Resources     Resources
+	RestartPolicy RestartPolicyInfo
+	RestartCount  int
+	Privileged    bool
+	Init          bool     // An init process forwards signals to the command and reaps zombies
+	Tty           bool     // The command runs on a pseudo-terminal
+	OpenStdin     bool     // Attached clients can write to the command's stdin
+	CapAdd        []string `json:",omitempty"` // Capabilities added to the default ones
+	CapDrop       []string `json:",omitempty"` // Capabilities removed from the default ones
+	SecurityOpt   []string `json:",omitempty"` // Security options, such as seccomp=unconfined
+	Devices       []Device `json:",omitempty"` // Host devices added to the default ones
+	LogConfig     LogConfig
+	LogPath       string `json:",omitempty"` // Log file of the json-file driver
+}
+
+// State is the runtime state of a container
+type State struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	Pid        int
+	ExitCode   int
+	OOMKilled  bool // The OOM killer killed a process of the container during its last run
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RestartPolicyInfo is a container's restart policy, split as Docker
+// shows it
+type RestartPolicyInfo struct {
+	Name              string // no, on-failure, always or unless-stopped
+	MaximumRetryCount int    // Restarts allowed by on-failure, 0 for any number
+}
+
+// Resources are the cgroup limits of a container
+type Resources struct {
+	Memory            int64
+	MemorySwap        int64 // Memory plus swap limit, -1 for unlimited swap
+	MemoryReservation int64 // Memory soft limit
+	OOMKillDisable    bool
+	OOMScoreAdj       int
+	CPUShares         int64
+	NanoCPUs          int64  `json:",omitempty"` // CPU time quota in billionths of a CPU
+	PidsLimit         int64  `json:",omitempty"` // Maximum number of processes
+	CgroupParent      string // Cgroup path or systemd slice the container's cgroup is in
+}
+
+// Inspect returns the full state of the container with the given ID or
+// unique ID prefix
+func Inspect(ref string) (*InspectInfo, error) {
+	c, err := Find(ref)
+	if err != nil {
+		return nil, err
+	}
+	return c.Inspect(), nil
+}
+
+// Inspect returns the full state of the container
+func (c *Container) Inspect() *InspectInfo {
+	info := &InspectInfo{
+		ID:         c.ID,
+		Name:       c.Name,
+		Image:      c.ImageRef(),
+		ImageID:    c.ImageID,
+		Rootfs:     config.DataPath("containers", c.ID, "rootfs"),
+		Layers:     c.Layers,
+		Command:    c.Command,
+		Env:        c.Env,
+		Labels:     c.Labels,
+		WorkingDir: c.WorkingDir,
+		Created:    c.Created,
+		Driver:     c.StorageDriver,
+		State: State{
+			Status:     c.Status,
+			Running:    c.IsRunning(),
+			Paused:     c.Status == "paused",
+			Restarting: c.Status == "restarting",
+			Pid:        c.Pid,
+			ExitCode:   c.ExitCode,
+			OOMKilled:  c.OOMKilled,
+			StartedAt:  c.StartedAt,
+			FinishedAt: c.FinishedAt,
+		},
+		Mounts:      c.Mounts,
+		Pod:         c.Pod,
+		PodInfra:    c.PodInfra,
+		NetworkMode: c.networkMode(),
+		Network:     c.Network,
+		Ports:       c.Ports,
+		Hostname:    c.hostname(),
+		DNS:         c.DNS,
+		DNSSearch:   c.DNSSearch,
+		ExtraHosts:  c.ExtraHosts,
+		Resources: Resources{
+			Memory:            c.Memory,
+			MemorySwap:        c.MemorySwap,
+			MemoryReservation: c.MemoryReservation,
+			OOMKillDisable:    c.OOMKillDisable,
+			OOMScoreAdj:       c.OOMScoreAdj,
+			CPUShares:         c.CPUShares,
+			NanoCPUs:          c.NanoCPUs,
+			PidsLimit:         c.PidsLimit,
+			CgroupParent:      c.CgroupParent,
+		},
+		RestartCount: c.RestartCount,
+		Privileged:   c.Privileged,
+		Init:         c.Init,
+		Tty:          c.Tty,
+		OpenStdin:    c.OpenStdin,
+		CapAdd:       c.CapAdd,
+		CapDrop:      c.CapDrop,
+		Devices:      c.Devices,
+		LogConfig:    c.LogConfig,
+	}
+	info.RestartPolicy.Name, info.RestartPolicy.MaximumRetryCount, _ = parseRestartPolicy(c.RestartPolicy)
+	if info.RestartPolicy.Name == "" {
+		info.RestartPolicy.Name = RestartNo
+	}
+	if c.Seccomp != "" {
+		info.SecurityOpt = append(info.SecurityOpt, "seccomp="+c.Seccomp)
+	}
+	if info.Mounts == nil {
+		info.Mounts = []Mount{}
+	}
+	if info.Labels == nil {
+		info.Labels = map[string]string{}
+	}
+	if info.Ports == nil {
+		info.Ports = []network.PortMapping{}
+	}
+	if c.LogConfig.Type == "" || c.LogConfig.Type == logdriver.DefaultDriver {
+		info.LogPath = config.DataPath("containers", c.ID, "logs", "container.log")
+	}
+	return info
+}
@@ -0,0 +1,106 @@
+// pkg/container/kill.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// signals maps the names floka kill accepts to signals
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+}
+
+// ParseSignal parses a signal given by name, with or without its SIG
+// prefix and in any case, or by number
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// Kill sends a signal to the container's command. The process floka
+// records as the container's is the "floka containerize" helper running
+// as PID 1 of the container, which starts the command and waits for it,
+// so the signal goes to its child. The container's restart policy applies
+// if the signal ends it.
+func (c *Container) Kill(sig syscall.Signal) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+	if c.Status == "paused" {
+		return fmt.Errorf("%w: %s, unpause it first", ErrContainerPaused, c.ID)
+	}
+
+	pid := c.Pid
+	if child := commandPid(c.Pid); child > 1 {
+		pid = child
+	}
+	logging.L().Debug("killing container", "container", c.ID, "pid", pid, "signal", sig)
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("failed to send %s to container %s: %w", sig, c.ID, err)
+	}
+	c.logEvent("kill", map[string]string{"signal": strconv.Itoa(int(sig))})
+	return nil
+}
+
+// commandPid returns the PID of the command the containerize process pid
+// started, or 0 if it has not started it yet or it has exited
+func commandPid(pid int) int {
+	// Children are listed by the thread that forked them, any of the Go
+	// runtime's
+	files, _ := filepath.Glob(filepath.Join("/proc", strconv.Itoa(pid), "task", "*", "children"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			child, _ := strconv.Atoi(fields[0])
+			return child
+		}
+	}
+	return 0
+}
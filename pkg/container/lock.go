@@ -0,0 +1,54 @@
+// pkg/container/lock.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it, and
+// returns the function releasing it. Locks are held by an open file, so
+// taking a lock twice blocks even within one process.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// lockStore keeps other floka processes from creating or removing
+// containers until the returned function is called, so that names stay
+// unique
+func lockStore() (func(), error) {
+	containersDir := config.DataPath("containers")
+	if err := os.MkdirAll(containersDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create containers directory: %w", err)
+	}
+	unlock, err := lockFile(filepath.Join(containersDir, ".lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock containers: %w", err)
+	}
+	return unlock, nil
+}
+
+// lock keeps other floka processes from starting, stopping or removing
+// the container until the returned function is called
+func (c *Container) lock() (func(), error) {
+	unlock, err := lockFile(config.DataPath("containers", c.ID, "lock"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, c.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock container %s: %w", c.ID, err)
+	}
+	return unlock, nil
+}
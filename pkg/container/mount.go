@@ -0,0 +1,102 @@
+// pkg/container/mount.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Mount describes a host directory mounted into the container
+type Mount struct {
+	Type        string // "volume" for named or anonymous volumes, "bind" for host paths
+	Name        string // Volume name
+	Source      string // Path on the host
+	Destination string // Absolute path inside the container
+	ReadOnly    bool
+}
+
+// mountVolumes bind mounts every volume into the container rootfs
+func mountVolumes(rootfs string, mounts []Mount) error {
+	for i, m := range mounts {
+		if !filepath.IsAbs(m.Destination) {
+			unmountVolumes(rootfs, mounts[:i])
+			return fmt.Errorf("mount destination must be an absolute path: %s", m.Destination)
+		}
+
+		target, err := fsutil.SecureJoin(rootfs, m.Destination)
+		if err != nil {
+			unmountVolumes(rootfs, mounts[:i])
+			return fmt.Errorf("invalid mount destination %s: %w", m.Destination, err)
+		}
+		if err := createMountPoint(m, target); err != nil {
+			unmountVolumes(rootfs, mounts[:i])
+			return err
+		}
+
+		if err := syscall.Mount(m.Source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			unmountVolumes(rootfs, mounts[:i])
+			return fmt.Errorf("failed to mount %s on %s: %w", m.Source, m.Destination, err)
+		}
+		if m.ReadOnly {
+			// Bind mounts ignore MS_RDONLY until remounted
+			flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_REC)
+			if err := syscall.Mount("", target, "", flags, ""); err != nil {
+				unmountVolumes(rootfs, mounts[:i+1])
+				return fmt.Errorf("failed to make %s read-only: %w", m.Destination, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createMountPoint makes sure the target of a mount exists in the rootfs,
+// as a file for host files and as a directory otherwise
+func createMountPoint(m Mount, target string) error {
+	if m.Type != "bind" {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create mount point %s: %w", m.Destination, err)
+		}
+		return nil
+	}
+
+	if !filepath.IsAbs(m.Source) {
+		return fmt.Errorf("bind mount source must be an absolute path: %s", m.Source)
+	}
+	info, err := os.Stat(m.Source)
+	if err != nil {
+		return fmt.Errorf("invalid bind mount source: %w", err)
+	}
+	if info.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create mount point %s: %w", m.Destination, err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create mount point %s: %w", m.Destination, err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create mount point %s: %w", m.Destination, err)
+	}
+	return f.Close()
+}
+
+// unmountVolumes detaches the given mounts from the rootfs in reverse order
+func unmountVolumes(rootfs string, mounts []Mount) {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		target, err := fsutil.SecureJoin(rootfs, mounts[i].Destination)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && !os.IsNotExist(err) {
+			logging.L().Warn("failed to unmount volume", "target", target, "err", err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// pkg/container/name.go
+package container
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// validName matches the container names Docker accepts
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// checkName makes sure name is valid and not used by another container
+func checkName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	existing, err := ListContainers(&ListOptions{Name: name})
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("container name %q %w: in use by container %s", name, ErrAlreadyExists, existing[0].ID)
+	}
+	return nil
+}
+
+// Rename gives the container a new name, which no other container may
+// have. The container keeps running under it.
+func (c *Container) Rename(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if name == c.Name {
+		return fmt.Errorf("container %s is already named %q", c.ID, name)
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := storeRename(c.ID, name); err != nil {
+		return err
+	}
+
+	oldName := c.Name
+	c.Name = name
+	c.logEvent("rename", map[string]string{"oldName": oldName})
+	return nil
+}
@@ -0,0 +1,90 @@
+// pkg/container/netmode.go
+package container
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// Network modes of containers
+const (
+	NetworkBridge = network.DefaultNetwork // Own network namespace connected to the floka0 bridge, the default
+	NetworkNone   = "none"                 // Own network namespace with only the loopback interface
+	NetworkHost   = "host"                 // The host's network namespace
+
+	// networkContainerPrefix starts the mode of containers joining the
+	// network namespace of another container, container:<id>
+	networkContainerPrefix = "container:"
+)
+
+// JoinNSVar lists, comma separated, the namespaces "floka containerize"
+// joins, open as fd 4 onwards in that order: the network namespace of the
+// container a container:<id> container shares, and the IPC and UTS
+// namespaces of the infra container of a pod
+const JoinNSVar = "FLOKA_CONTAINER_JOIN_NS"
+
+// resolveNetworkMode checks a --network mode and returns it with the
+// container of container:<name|id> given by its full ID, and that
+// container when there is one. Other modes than none, host and
+// container:<name|id> name the network the container is connected to.
+func resolveNetworkMode(mode string) (string, *Container, error) {
+	switch mode {
+	case "":
+		return NetworkBridge, nil, nil
+	case NetworkNone, NetworkHost:
+		return mode, nil, nil
+	}
+	ref, ok := strings.CutPrefix(mode, networkContainerPrefix)
+	if !ok {
+		if _, err := network.Get(mode); err != nil {
+			return "", nil, fmt.Errorf("invalid network mode %q, expected none, host, container:<name|id> or a network: %w", mode, err)
+		}
+		return mode, nil, nil
+	}
+	if ref == "" {
+		return "", nil, fmt.Errorf("invalid network mode %q, expected container:<name|id>", mode)
+	}
+	target, err := Find(ref)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid network mode %q: %w", mode, err)
+	}
+	return networkContainerPrefix + target.ID, target, nil
+}
+
+// networkMode returns the container's network mode, the default network
+// for containers of older versions
+func (c *Container) networkMode() string {
+	if c.NetworkMode == "" {
+		return NetworkBridge
+	}
+	return c.NetworkMode
+}
+
+// networkContainer returns the ID of the container whose network namespace
+// the container joins, if any
+func (c *Container) networkContainer() string {
+	id, ok := strings.CutPrefix(c.NetworkMode, networkContainerPrefix)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// checkNetworkOpts rejects options that need a network of the container's
+// own in the other network modes
+func checkNetworkOpts(mode string, opts *ContainerOpts) error {
+	if mode != NetworkNone && mode != NetworkHost && !strings.HasPrefix(mode, networkContainerPrefix) {
+		return nil
+	}
+	if len(opts.Ports) > 0 {
+		return fmt.Errorf("conflicting options: ports can only be published on a network, not with network mode %s", mode)
+	}
+	if strings.HasPrefix(mode, networkContainerPrefix) {
+		if opts.Hostname != "" || len(opts.DNS) > 0 || len(opts.DNSSearch) > 0 || len(opts.ExtraHosts) > 0 {
+			return fmt.Errorf("conflicting options: hostname, DNS and extra hosts come from the container whose network is joined")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// pkg/container/pause.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// freezeTimeout is how long Pause waits for the kernel to stop every
+// process of the container
+const freezeTimeout = 5 * time.Second
+
+// Pause suspends all processes of a running container with the cgroup
+// freezer. They keep their memory and resume where they were on Unpause.
+func (c *Container) Pause() error {
+	if c.Status == "paused" {
+		return fmt.Errorf("%w: %s", ErrContainerPaused, c.ID)
+	}
+	if !c.IsRunning() {
+		return fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+	logging.L().Debug("pausing container", "container", c.ID)
+	if err := setFrozen(c.cgroup(), true); err != nil {
+		// Leave no process half frozen
+		setFrozen(c.cgroup(), false)
+		return fmt.Errorf("failed to pause container %s: %w", c.ID, err)
+	}
+
+	c.Status = "paused"
+	if err := c.updateMetadata(); err != nil {
+		setFrozen(c.cgroup(), false)
+		return fmt.Errorf("failed to update container metadata: %w", err)
+	}
+	c.emit("pause", 0)
+	return nil
+}
+
+// Unpause resumes the processes of a paused container
+func (c *Container) Unpause() error {
+	if c.Status != "paused" {
+		return fmt.Errorf("container %s is not paused", c.ID)
+	}
+	logging.L().Debug("unpausing container", "container", c.ID)
+	if err := setFrozen(c.cgroup(), false); err != nil {
+		return fmt.Errorf("failed to unpause container %s: %w", c.ID, err)
+	}
+
+	c.Status = "running"
+	if err := c.updateMetadata(); err != nil {
+		return fmt.Errorf("failed to update container metadata: %w", err)
+	}
+	c.emit("unpause", 0)
+	return nil
+}
+
+// setFrozen freezes or thaws the container's cgroup, through cgroup.freeze
+// on cgroup v2 and the freezer controller on v1, and waits until the
+// kernel is done
+func setFrozen(cgroup string, frozen bool) error {
+	cgroupPath := "/sys/fs/cgroup"
+
+	// The state is read back until the kernel reports it reached: v2 has
+	// it in cgroup.events, v1 in freezer.state, which goes through FREEZING
+	var file, value, doneFile, done string
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err == nil {
+		dir := filepath.Join(cgroupPath, cgroup)
+		file, value = filepath.Join(dir, "cgroup.freeze"), "0"
+		doneFile, done = filepath.Join(dir, "cgroup.events"), "frozen 0"
+		if frozen {
+			value, done = "1", "frozen 1"
+		}
+	} else {
+		dir := filepath.Join(cgroupPath, "freezer", cgroup)
+		file, value = filepath.Join(dir, "freezer.state"), "THAWED"
+		if frozen {
+			value = "FROZEN"
+		}
+		doneFile, done = file, value
+	}
+
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		if err := os.WriteFile(file, []byte(value), 0644); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(doneFile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == done {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", done)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,310 @@
+// pkg/container/pod.go
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// A pod is a group of containers sharing the network, IPC and UTS
+// namespaces, and optionally the PID namespace, of the pod's infra
+// container. The infra container runs no command of an image, only
+// containerize holding the namespaces until it is stopped, and owns what
+// belongs to the namespaces: the pod's network address, published ports
+// and hostname. The other containers join it as container:<id> containers
+// join network namespaces, and are started and stopped in the same order.
+
+// PauseVar is set to 1 for "floka containerize" of a pod's infra
+// container, which waits to be stopped instead of running a command
+const PauseVar = "FLOKA_CONTAINER_PAUSE"
+
+// podInfraSuffix is appended to the name of a pod to name its infra
+// container
+const podInfraSuffix = "-infra"
+
+// PodOpts configures a pod created with CreatePod
+type PodOpts struct {
+	Name         string                // Unique name of the pod, generated when empty
+	NetworkMode  string                // Network name, none or host, bridge when empty
+	Ports        []network.PortMapping // Ports of the pod's containers to publish on the host
+	Hostname     string                // Hostname of the pod's containers, the pod name when empty
+	DNS          []string              // Name servers of the pod, the host's when empty
+	DNSSearch    []string              // DNS search domains, the host's when empty, "." for none
+	ExtraHosts   []string              // HOST:IP entries to add to /etc/hosts
+	Labels       map[string]string     // Metadata to attach to the pod
+	CgroupParent string                // Cgroup to create the infra container's cgroup in
+	SharePID     bool                  // The pod's containers see each other's processes
+}
+
+// Pod is a pod and its containers
+type Pod struct {
+	Name       string
+	Infra      *Container   // Container holding the pod's namespaces
+	Containers []*Container // The other containers of the pod, oldest first
+}
+
+// CreatePod creates a pod with its infra container, which isn't started
+// until the pod or one of its containers is
+func CreatePod(opts *PodOpts) (*Pod, error) {
+	if opts == nil {
+		opts = &PodOpts{}
+	}
+	name := opts.Name
+	if name == "" {
+		name = generateID()[:12]
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid pod name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if _, err := FindPod(name); err == nil {
+		return nil, fmt.Errorf("pod name %q %w", name, ErrAlreadyExists)
+	}
+	if strings.HasPrefix(opts.NetworkMode, networkContainerPrefix) {
+		return nil, fmt.Errorf("invalid network mode %q for a pod, which has a network namespace of its own", opts.NetworkMode)
+	}
+	hostname := opts.Hostname
+	if hostname == "" && opts.NetworkMode != NetworkHost {
+		hostname = name
+	}
+
+	infra, err := Create("", []string{"pause"}, &ContainerOpts{
+		Name:         name + podInfraSuffix,
+		Labels:       opts.Labels,
+		NetworkMode:  opts.NetworkMode,
+		Ports:        opts.Ports,
+		Hostname:     hostname,
+		DNS:          opts.DNS,
+		DNSSearch:    opts.DNSSearch,
+		ExtraHosts:   opts.ExtraHosts,
+		CgroupParent: opts.CgroupParent,
+		// An empty directory, there are no layers to copy
+		StorageDriver: StorageVFS,
+		infraOf:       name,
+		sharePID:      opts.SharePID,
+	})
+	if err != nil {
+		if infra != nil {
+			infra.Remove()
+		}
+		return nil, err
+	}
+	return &Pod{Name: name, Infra: infra}, nil
+}
+
+// ListPods returns the pods sorted by name
+func ListPods() ([]*Pod, error) {
+	containers, err := ListContainers(nil)
+	if err != nil {
+		return nil, err
+	}
+	return groupPods(containers), nil
+}
+
+// FindPod returns the pod with the given name
+func FindPod(name string) (*Pod, error) {
+	pods, err := ListPods()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pods {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrPodNotFound, name)
+}
+
+// groupPods groups containers into pods. Containers whose pod has no
+// infra container anymore are left out.
+func groupPods(containers []*Container) []*Pod {
+	byName := map[string]*Pod{}
+	for _, c := range containers {
+		if c.PodInfra {
+			byName[c.Pod] = &Pod{Name: c.Pod, Infra: c}
+		}
+	}
+	var pods []*Pod
+	for _, p := range byName {
+		pods = append(pods, p)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	byAge := append([]*Container(nil), containers...)
+	sort.SliceStable(byAge, func(i, j int) bool { return byAge[i].Created.Before(byAge[j].Created) })
+	for _, c := range byAge {
+		if p := byName[c.Pod]; p != nil && !c.PodInfra && c.networkContainer() == p.Infra.ID {
+			p.Containers = append(p.Containers, c)
+		}
+	}
+	return pods
+}
+
+// Status returns created or stopped as long as the infra container is,
+// running when all containers of the pod run, and degraded when only some
+// of them do
+func (p *Pod) Status() string {
+	if !p.Infra.IsRunning() {
+		return p.Infra.Status
+	}
+	for _, c := range p.Containers {
+		if !c.IsRunning() {
+			return "degraded"
+		}
+	}
+	return "running"
+}
+
+// Start starts the infra container of the pod if it isn't running, then
+// the containers of the pod that aren't, in the background under their
+// shims. It returns the IDs of the containers started.
+func (p *Pod) Start() ([]string, error) {
+	var started []string
+	if !p.Infra.IsRunning() {
+		if err := p.Infra.Launch(); err != nil {
+			return nil, fmt.Errorf("failed to start infra container %s: %w", p.Infra.ID, err)
+		}
+		started = append(started, p.Infra.ID)
+	}
+	var errs []error
+	for _, c := range p.Containers {
+		if c.IsRunning() || c.Status == "restarting" {
+			continue
+		}
+		if err := c.Launch(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to start container %s: %w", c.ID, err))
+			continue
+		}
+		started = append(started, c.ID)
+	}
+	return started, errors.Join(errs...)
+}
+
+// Stop stops the containers of the pod at the same time, then its infra
+// container. Each gets timeout to exit after SIGTERM. It returns the IDs of
+// the containers stopped.
+func (p *Pod) Stop(ctx context.Context, timeout time.Duration) ([]string, error) {
+	var stopped []string
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range p.Containers {
+		if !c.IsRunning() && c.Status != "restarting" {
+			continue
+		}
+		wg.Add(1)
+		go func(c *Container) {
+			defer wg.Done()
+			err := c.Stop(ctx, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to stop container %s: %w", c.ID, err))
+				return
+			}
+			stopped = append(stopped, c.ID)
+		}(c)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return stopped, errors.Join(errs...)
+	}
+	if p.Infra.IsRunning() {
+		if err := p.Infra.Stop(ctx, timeout); err != nil {
+			return stopped, fmt.Errorf("failed to stop infra container %s: %w", p.Infra.ID, err)
+		}
+		stopped = append(stopped, p.Infra.ID)
+	}
+	return stopped, nil
+}
+
+// Remove removes the containers of the pod, then its infra container.
+// Running containers are only stopped first with force. It returns the
+// containers removed, whose volumes the caller may release.
+func (p *Pod) Remove(ctx context.Context, force bool) ([]*Container, error) {
+	if !force {
+		for _, c := range append([]*Container{p.Infra}, p.Containers...) {
+			if c.IsRunning() || c.Status == "restarting" {
+				return nil, fmt.Errorf("%w: %s of pod %s, stop the pod first or use -f", ErrContainerRunning, c.ID, p.Name)
+			}
+		}
+	} else if _, err := p.Stop(ctx, DefaultStopTimeout); err != nil {
+		return nil, err
+	}
+
+	var removed []*Container
+	for _, c := range p.Containers {
+		if err := c.Remove(); err != nil {
+			return removed, fmt.Errorf("failed to remove container %s: %w", c.ID, err)
+		}
+		removed = append(removed, c)
+	}
+	if err := p.Infra.Remove(); err != nil {
+		return removed, fmt.Errorf("failed to remove infra container %s: %w", p.Infra.ID, err)
+	}
+	return append(removed, p.Infra), nil
+}
+
+// openJoinedNamespaces opens the namespaces the container joins: the
+// network namespace of the container whose network it shares and, for a
+// container of a pod, the IPC and UTS namespaces of the infra container,
+// which is started first if it isn't running. It returns their names in
+// the order of the files, and the PID namespace to start the container in
+// when the pod shares it.
+func (c *Container) openJoinedNamespaces() ([]*os.File, []string, *os.File, error) {
+	target, err := Load(c.networkContainer())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to find the container whose network is joined: %w", err)
+	}
+	if !target.alive() && c.Pod != "" && target.PodInfra {
+		logging.L().Debug("starting infra container of pod", "pod", c.Pod, "container", target.ID)
+		if err := target.Launch(); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to start infra container of pod %s: %w", c.Pod, err)
+		}
+		if target, err = Load(target.ID); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if !target.alive() {
+		return nil, nil, nil, fmt.Errorf("%w: %s, whose network is joined", ErrContainerNotRunning, target.ID)
+	}
+
+	names := []string{"net"}
+	if c.Pod != "" {
+		names = append(names, "ipc", "uts")
+	}
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	nsDir := filepath.Join("/proc", strconv.Itoa(target.Pid), "ns")
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(nsDir, name))
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("failed to open the %s namespace of container %s: %w", name, target.ID, err)
+		}
+		files = append(files, f)
+	}
+
+	var pidns *os.File
+	if c.Pod != "" && target.PodSharePID {
+		if pidns, err = os.Open(filepath.Join(nsDir, "pid")); err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("failed to open the pid namespace of container %s: %w", target.ID, err)
+		}
+	}
+	return files, names, pidns, nil
+}
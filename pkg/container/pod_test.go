@@ -0,0 +1,73 @@
+package container
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupPods(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	infra := func(id, pod string) *Container {
+		return &Container{ID: id, Pod: pod, PodInfra: true, Created: base}
+	}
+	member := func(id, pod, infraID string, minute int) *Container {
+		return &Container{ID: id, Pod: pod, NetworkMode: networkContainerPrefix + infraID, Created: base.Add(time.Duration(minute) * time.Minute)}
+	}
+
+	containers := []*Container{
+		member("web-b", "web", "web-infra", 2),
+		infra("web-infra", "web"),
+		{ID: "plain", Created: base},
+		member("web-a", "web", "web-infra", 1),
+		infra("db-infra", "db"),
+		member("orphan", "gone", "gone-infra", 1),
+		member("stale", "web", "old-web-infra", 3),
+	}
+
+	type pod struct {
+		Name       string
+		Infra      string
+		Containers []string
+	}
+	var got []pod
+	for _, p := range groupPods(containers) {
+		g := pod{Name: p.Name, Infra: p.Infra.ID}
+		for _, c := range p.Containers {
+			g.Containers = append(g.Containers, c.ID)
+		}
+		got = append(got, g)
+	}
+	want := []pod{
+		{Name: "db", Infra: "db-infra"},
+		{Name: "web", Infra: "web-infra", Containers: []string{"web-a", "web-b"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pods are %+v, want %+v", got, want)
+	}
+}
+
+func TestPodStatus(t *testing.T) {
+	// The test process stands in for the container processes
+	running := func() *Container {
+		return &Container{Status: "running", Pid: os.Getpid()}
+	}
+	tests := []struct {
+		name string
+		pod  *Pod
+		want string
+	}{
+		{"created", &Pod{Infra: &Container{Status: "created"}, Containers: []*Container{{Status: "created"}}}, "created"},
+		{"stopped", &Pod{Infra: &Container{Status: "stopped"}}, "stopped"},
+		{"running", &Pod{Infra: running(), Containers: []*Container{running(), running()}}, "running"},
+		{"degraded", &Pod{Infra: running(), Containers: []*Container{running(), {Status: "stopped"}}}, "degraded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pod.Status(); got != tt.want {
+				t.Errorf("status is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
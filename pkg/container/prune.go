@@ -0,0 +1,201 @@
+// pkg/container/prune.go
+package container
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// staleAge is how old a container directory without metadata must be
+// before CleanupStale takes it for the remains of a crashed run rather
+// than a container being created
+const staleAge = time.Hour
+
+// Prune removes all containers that are neither running nor waiting to be
+// restarted and returns them, so that callers can release their volumes,
+// with the disk space they took
+func Prune() ([]*Container, int64, error) {
+	containers, err := ListContainers(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var removed []*Container
+	var reclaimed int64
+	for _, c := range containers {
+		if c.IsRunning() || c.Status == "restarting" {
+			continue
+		}
+		size := c.DiskUsage()
+		if err := c.Remove(); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, c)
+		reclaimed += size
+	}
+	return removed, reclaimed, nil
+}
+
+// DiskUsage returns the disk space taken by the container's own files: its
+// writable layer, or its copy of the image with the vfs driver, and logs
+func (c *Container) DiskUsage() int64 {
+	return fsutil.DirSize(config.DataPath("containers", c.ID))
+}
+
+// CleanupResult lists what CleanupStale removed
+type CleanupResult struct {
+	Mounts  []string // Mount points left below container directories
+	Cgroups []string // Cgroups of containers that no longer exist
+	Dirs    []string // Container directories without metadata
+	Size    int64    // Disk space freed
+}
+
+// CleanupStale removes what floka processes that crashed left behind:
+// mounts and directories of containers whose metadata was never written
+// or already removed, and the cgroups of containers that no longer exist
+func CleanupStale() (*CleanupResult, error) {
+	result := &CleanupResult{}
+	containersDir, err := filepath.Abs(config.DataPath("containers"))
+	if err != nil {
+		return nil, err
+	}
+
+	known := func(id string) bool {
+		_, err := Load(id)
+		return !errors.Is(err, ErrContainerNotFound)
+	}
+
+	// Mounts go first, the directories below them are removed next
+	mounts, err := mountPoints()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	for _, m := range mounts {
+		rel, err := filepath.Rel(containersDir, m)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		id := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		if known(id) {
+			continue
+		}
+		if err := syscall.Unmount(m, syscall.MNT_DETACH); err != nil {
+			logging.L().Warn("failed to unmount stale mount", "path", m, "err", err)
+			continue
+		}
+		result.Mounts = append(result.Mounts, m)
+	}
+
+	entries, err := os.ReadDir(containersDir)
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || known(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleAge {
+			continue
+		}
+		dir := filepath.Join(containersDir, entry.Name())
+		size := fsutil.DirSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			logging.L().Warn("failed to remove stale container directory", "path", dir, "err", err)
+			continue
+		}
+		result.Dirs = append(result.Dirs, dir)
+		result.Size += size
+	}
+
+	for _, id := range cgroupIDs() {
+		if known(id) {
+			continue
+		}
+		if err := cleanupCgroups(cgroupName(DefaultCgroupParent, id)); err != nil {
+			logging.L().Warn("failed to remove stale cgroup", "container", id, "err", err)
+			continue
+		}
+		result.Cgroups = append(result.Cgroups, id)
+	}
+	return result, nil
+}
+
+// mountPoints returns the mount points of the mount namespace
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// "id parent major:minor root mountpoint options ..."
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, unescapeMountPath(fields[4]))
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes mountinfo uses for spaces,
+// tabs, newlines and backslashes in paths
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if n, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// cgroupIDs returns the IDs of the containers that have a cgroup under
+// the default parent
+func cgroupIDs() []string {
+	cgroupPath := "/sys/fs/cgroup"
+	dirs := []string{filepath.Join(cgroupPath, DefaultCgroupParent)}
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err != nil {
+		dirs = nil
+		for _, subsystem := range cgroupV1Subsystems {
+			dirs = append(dirs, filepath.Join(cgroupPath, subsystem, DefaultCgroupParent))
+		}
+	}
+
+	seen := map[string]bool{}
+	var ids []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				ids = append(ids, entry.Name())
+			}
+		}
+	}
+	return ids
+}
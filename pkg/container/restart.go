@@ -0,0 +1,123 @@
+// pkg/container/restart.go
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Restart policies, given as --restart to floka run
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure" // Optionally with a maximum, on-failure:3
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+const (
+	// restartDelay is how long the supervisor waits before the first
+	// restart, doubled for every further one up to maxRestartDelay
+	restartDelay    = 100 * time.Millisecond
+	maxRestartDelay = time.Minute
+	// restartResetAfter is how long a container must have run for its
+	// restart delay to go back to restartDelay
+	restartResetAfter = 10 * time.Second
+)
+
+// parseRestartPolicy splits a restart policy into its name and maximum
+// number of restarts, 0 meaning no maximum
+func parseRestartPolicy(policy string) (string, int, error) {
+	name, max, hasMax := strings.Cut(policy, ":")
+	switch name {
+	case "", RestartNo, RestartAlways, RestartUnlessStopped:
+		if hasMax {
+			return "", 0, fmt.Errorf("restart policy %s takes no maximum retry count", name)
+		}
+		return name, 0, nil
+	case RestartOnFailure:
+		if !hasMax {
+			return name, 0, nil
+		}
+		count, err := strconv.Atoi(max)
+		if err != nil || count < 1 {
+			return "", 0, fmt.Errorf("invalid maximum retry count in restart policy: %s", policy)
+		}
+		return name, count, nil
+	}
+	return "", 0, fmt.Errorf("unknown restart policy: %s", policy)
+}
+
+// shouldRestart reports whether the container's restart policy asks for it
+// to be started again after it exited. A container stopped with Stop is
+// never restarted.
+func (c *Container) shouldRestart() bool {
+	if c.ManuallyStopped {
+		return false
+	}
+	name, max, err := parseRestartPolicy(c.RestartPolicy)
+	if err != nil {
+		return false
+	}
+	switch name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		return c.ExitCode != 0 && (max == 0 || c.RestartCount < max)
+	}
+	return false
+}
+
+// supervise starts the container and starts it again whenever it exits, as
+// its restart policy says, waiting longer after each restart in a row. It
+// returns the error of the last run once the container is left stopped.
+func (c *Container) supervise(ctx context.Context, rootfs string) error {
+	delay := restartDelay
+	for {
+		started := time.Now()
+		err := c.Start(ctx, rootfs)
+
+		// Only a container that ran and exited on its own is restarted
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil || (err != nil && !errors.As(err, &exitErr)) || !c.shouldRestart() {
+			return err
+		}
+		if time.Since(started) >= restartResetAfter {
+			delay = restartDelay
+		}
+
+		logging.L().Debug("restarting container", "container", c.ID, "policy", c.RestartPolicy,
+			"exit_code", c.ExitCode, "delay", delay)
+		c.Status = "restarting"
+		c.Pid = 0
+		c.RestartCount++
+		if err := c.updateMetadata(); err != nil {
+			// Removed since it exited
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+
+		// Stop or Remove may have been called while waiting
+		cur, loadErr := Load(c.ID)
+		if loadErr != nil {
+			return err
+		}
+		if cur.ManuallyStopped {
+			return err
+		}
+	}
+}
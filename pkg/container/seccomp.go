@@ -0,0 +1,346 @@
+// pkg/container/seccomp.go
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SeccompUnconfined runs a container without a seccomp filter
+const SeccompUnconfined = "unconfined"
+
+// SeccompVar passes the seccomp profile of the container to "floka
+// containerize": empty for the default one, SeccompUnconfined, or the
+// path of a profile file
+const SeccompVar = "FLOKA_CONTAINER_SECCOMP"
+
+// SeccompProfile is a seccomp profile in Docker's JSON format: the action
+// of the first rule matching a syscall applies, DefaultAction when none
+// does
+type SeccompProfile struct {
+	DefaultAction   string        `json:"defaultAction"`
+	DefaultErrnoRet *uint32       `json:"defaultErrnoRet,omitempty"`
+	Syscalls        []SeccompRule `json:"syscalls"`
+}
+
+// SeccompRule gives the action of syscalls, possibly only when their
+// arguments match all of Args
+type SeccompRule struct {
+	Names    []string         `json:"names,omitempty"`
+	Name     string           `json:"name,omitempty"` // Single name of older profiles
+	Action   string           `json:"action"`
+	ErrnoRet *uint32          `json:"errnoRet,omitempty"` // Errno of SCMP_ACT_ERRNO, EPERM when unset
+	Args     []SeccompArg     `json:"args,omitempty"`
+	Includes SeccompCondition `json:"includes"`
+	Excludes SeccompCondition `json:"excludes"`
+}
+
+// SeccompArg compares a syscall argument with Value. SCMP_CMP_MASKED_EQ
+// compares it masked with Value to ValueTwo.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// SeccompCondition limits a rule to architectures and to containers with
+// capabilities
+type SeccompCondition struct {
+	Arches []string `json:"arches,omitempty"`
+	Caps   []string `json:"caps,omitempty"`
+}
+
+// seccompArchNames are the names profiles give the architectures floka
+// builds filters for
+var seccompArchNames = map[uint32]string{
+	unix.AUDIT_ARCH_X86_64:  "SCMP_ARCH_X86_64",
+	unix.AUDIT_ARCH_AARCH64: "SCMP_ARCH_AARCH64",
+}
+
+// LoadSeccompProfile reads a profile file and checks that it compiles
+func LoadSeccompProfile(path string) (*SeccompProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seccomp profile: %w", err)
+	}
+	profile, err := ParseSeccompProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// ParseSeccompProfile parses a profile and checks that it compiles
+func ParseSeccompProfile(data []byte) (*SeccompProfile, error) {
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, err
+	}
+	if _, err := profile.Compile(nil); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// SeccompFilter returns the filter of a container's seccomp profile, given
+// as in SeccompVar, for processes with caps, nil when it is unconfined.
+// The default profile is left out on architectures filters aren't built
+// for.
+func SeccompFilter(profile string, caps []string) ([]unix.SockFilter, error) {
+	switch {
+	case profile == SeccompUnconfined, profile == "" && syscallNumbers == nil:
+		return nil, nil
+	case profile == "":
+		return defaultSeccompProfile.Compile(caps)
+	}
+	p, err := LoadSeccompProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return p.Compile(caps)
+}
+
+// Compile turns the profile into a BPF program for the architecture floka
+// runs on. Syscalls it doesn't have are left out, and so are the rules of
+// other architectures and those conditioned on capabilities caps doesn't
+// hold. Syscalls of other architectures, such as 32-bit ones, kill the
+// process since the profile can't be applied to them.
+func (p *SeccompProfile) Compile(caps []string) ([]unix.SockFilter, error) {
+	if syscallNumbers == nil {
+		return nil, fmt.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+	}
+	defaultAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := []unix.SockFilter{
+		bpfLoad(4), // seccomp_data.arch
+		bpfJump(unix.BPF_JEQ, seccompArch, 1, 0),
+		bpfRet(unix.SECCOMP_RET_KILL_PROCESS),
+		bpfLoad(0), // seccomp_data.nr
+	}
+	if x32SyscallBit != 0 {
+		prog = append(prog,
+			bpfJump(unix.BPF_JGE, x32SyscallBit, 0, 1),
+			bpfRet(unix.SECCOMP_RET_KILL_PROCESS))
+	}
+	for _, rule := range p.Syscalls {
+		action, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		if !rule.applies(caps) {
+			continue
+		}
+		names := rule.Names
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+			block, err := seccompRuleBlock(nr, rule.Args, action)
+			if err != nil {
+				return nil, fmt.Errorf("rule for %s: %w", name, err)
+			}
+			prog = append(prog, block...)
+		}
+	}
+	prog = append(prog, bpfRet(defaultAction))
+
+	if len(prog) > bpfMaxInstructions {
+		return nil, fmt.Errorf("seccomp profile needs %d instructions, more than the %d allowed", len(prog), bpfMaxInstructions)
+	}
+	return prog, nil
+}
+
+// applies tells whether the rule is part of the filter of a container
+// holding caps
+func (r *SeccompRule) applies(caps []string) bool {
+	arch := seccompArchNames[seccompArch]
+	if len(r.Includes.Arches) > 0 && !slices.Contains(r.Includes.Arches, arch) {
+		return false
+	}
+	if slices.Contains(r.Excludes.Arches, arch) {
+		return false
+	}
+	for _, c := range r.Includes.Caps {
+		if !slices.Contains(caps, c) {
+			return false
+		}
+	}
+	for _, c := range r.Excludes.Caps {
+		if slices.Contains(caps, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// seccompAction returns the filter return value of an action
+func seccompAction(action string, errnoRet *uint32) (uint32, error) {
+	data := func(def uint32) uint32 {
+		if errnoRet != nil {
+			return *errnoRet & unix.SECCOMP_RET_DATA
+		}
+		return def
+	}
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return unix.SECCOMP_RET_ALLOW, nil
+	case "SCMP_ACT_ERRNO":
+		return unix.SECCOMP_RET_ERRNO | data(uint32(unix.EPERM)), nil
+	case "SCMP_ACT_TRACE":
+		return unix.SECCOMP_RET_TRACE | data(0), nil
+	case "SCMP_ACT_TRAP":
+		return unix.SECCOMP_RET_TRAP, nil
+	case "SCMP_ACT_LOG":
+		return unix.SECCOMP_RET_LOG, nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// bpfMaxInstructions is the longest filter the kernel accepts
+const bpfMaxInstructions = 4096
+
+// failJump stands for the end of a rule's block in its conditional jumps
+// until the block is complete
+const failJump = 0xff
+
+// seccompRuleBlock returns the instructions returning action for syscall
+// nr when its arguments match, with the syscall number loaded before and
+// after them
+func seccompRuleBlock(nr uint32, args []SeccompArg, action uint32) ([]unix.SockFilter, error) {
+	block := []unix.SockFilter{bpfJump(unix.BPF_JEQ, nr, 0, failJump)}
+	for _, a := range args {
+		if a.Index > 5 {
+			return nil, fmt.Errorf("invalid argument index %d", a.Index)
+		}
+		lo, hi := 16+8*uint32(a.Index), 20+8*uint32(a.Index) // seccomp_data.args, little-endian
+		vlo, vhi := uint32(a.Value), uint32(a.Value>>32)
+		switch a.Op {
+		case "SCMP_CMP_EQ":
+			block = append(block,
+				bpfLoad(hi), bpfJump(unix.BPF_JEQ, vhi, 0, failJump),
+				bpfLoad(lo), bpfJump(unix.BPF_JEQ, vlo, 0, failJump))
+		case "SCMP_CMP_NE":
+			block = append(block,
+				bpfLoad(hi), bpfJump(unix.BPF_JEQ, vhi, 0, 2),
+				bpfLoad(lo), bpfJump(unix.BPF_JEQ, vlo, failJump, 0))
+		case "SCMP_CMP_MASKED_EQ":
+			block = append(block,
+				bpfLoad(hi), bpfAnd(vhi), bpfJump(unix.BPF_JEQ, uint32(a.ValueTwo>>32), 0, failJump),
+				bpfLoad(lo), bpfAnd(vlo), bpfJump(unix.BPF_JEQ, uint32(a.ValueTwo), 0, failJump))
+		case "SCMP_CMP_GT", "SCMP_CMP_GE":
+			op := uint16(unix.BPF_JGT)
+			if a.Op == "SCMP_CMP_GE" {
+				op = unix.BPF_JGE
+			}
+			block = append(block,
+				bpfLoad(hi), bpfJump(unix.BPF_JGT, vhi, 3, 0), bpfJump(unix.BPF_JEQ, vhi, 0, failJump),
+				bpfLoad(lo), bpfJump(op, vlo, 0, failJump))
+		case "SCMP_CMP_LT", "SCMP_CMP_LE":
+			op := uint16(unix.BPF_JGE)
+			if a.Op == "SCMP_CMP_LE" {
+				op = unix.BPF_JGT
+			}
+			block = append(block,
+				bpfLoad(hi), bpfJump(unix.BPF_JGT, vhi, failJump, 0), bpfJump(unix.BPF_JEQ, vhi, 0, 2),
+				bpfLoad(lo), bpfJump(op, vlo, failJump, 0))
+		default:
+			return nil, fmt.Errorf("unsupported comparison %q", a.Op)
+		}
+	}
+	block = append(block, bpfRet(action))
+	if len(args) > 0 {
+		// Arguments replaced the syscall number the next rule compares
+		block = append(block, bpfLoad(0))
+	}
+
+	end := len(block)
+	if len(args) > 0 {
+		end--
+	}
+	for i := range block {
+		if block[i].Code&0x07 != unix.BPF_JMP {
+			continue
+		}
+		if block[i].Jt == failJump {
+			block[i].Jt = uint8(end - i - 1)
+		}
+		if block[i].Jf == failJump {
+			block[i].Jf = uint8(end - i - 1)
+		}
+	}
+	return block, nil
+}
+
+func bpfLoad(offset uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+}
+
+func bpfJump(op uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, Jt: jt, Jf: jf, K: k}
+}
+
+func bpfAnd(k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, K: k}
+}
+
+func bpfRet(k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+}
+
+// WriteSeccompFilter writes a filter for ReadSeccompFilter
+func WriteSeccompFilter(w io.Writer, filter []unix.SockFilter) error {
+	return binary.Write(w, binary.NativeEndian, filter)
+}
+
+// ReadSeccompFilter reads a filter written by WriteSeccompFilter
+func ReadSeccompFilter(r io.Reader) ([]unix.SockFilter, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seccomp filter: %w", err)
+	}
+	size := int(unsafe.Sizeof(unix.SockFilter{}))
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("invalid seccomp filter of %d bytes", len(data))
+	}
+	filter := make([]unix.SockFilter, len(data)/size)
+	if err := binary.Read(bytes.NewReader(data), binary.NativeEndian, filter); err != nil {
+		return nil, fmt.Errorf("invalid seccomp filter: %w", err)
+	}
+	return filter, nil
+}
+
+// InstallSeccomp applies a filter to every thread of the process and the
+// processes it executes, which can't remove it
+func InstallSeccomp(filter []unix.SockFilter) error {
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	if r != 0 {
+		return fmt.Errorf("failed to install seccomp filter: thread %d can't be synchronized", r)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// pkg/container/seccomp_default.go
+package container
+
+import "golang.org/x/sys/unix"
+
+// defaultSeccompProfile is the profile of containers started without one,
+// Docker's default: syscalls are refused with EPERM unless listed, which
+// leaves out those changing the host, such as mount, reboot, kexec_load,
+// init_module, swapon or settimeofday, those escaping namespaces, like
+// unshare and setns, and rarely needed ones that widen the attack surface,
+// like bpf, keyctl, userfaultfd or perf_event_open
+var defaultSeccompProfile = &SeccompProfile{
+	DefaultAction: "SCMP_ACT_ERRNO",
+	Syscalls: []SeccompRule{
+		{
+			Action: "SCMP_ACT_ALLOW",
+			Names: []string{
+				"accept", "accept4", "access", "adjtimex", "alarm", "bind", "brk",
+				"cachestat", "capget", "capset", "chdir", "chmod", "chown",
+				"clock_adjtime", "clock_getres", "clock_gettime",
+				"clock_nanosleep", "close", "close_range", "connect",
+				"copy_file_range", "creat", "dup", "dup2", "dup3",
+				"epoll_create", "epoll_create1", "epoll_ctl", "epoll_ctl_old",
+				"epoll_pwait", "epoll_pwait2", "epoll_wait", "epoll_wait_old",
+				"eventfd", "eventfd2", "execve", "execveat", "exit", "exit_group",
+				"faccessat", "faccessat2", "fadvise64", "fallocate",
+				"fanotify_mark", "fchdir", "fchmod", "fchmodat", "fchmodat2",
+				"fchown", "fchownat", "fcntl", "fdatasync", "fgetxattr",
+				"flistxattr", "flock", "fork", "fremovexattr", "fsetxattr",
+				"fstat", "fstatfs", "fsync", "ftruncate", "futex", "futex_requeue",
+				"futex_wait", "futex_waitv", "futex_wake", "futimesat", "getcpu",
+				"getcwd", "getdents", "getdents64", "getegid", "geteuid", "getgid",
+				"getgroups", "getitimer", "getpeername", "getpgid", "getpgrp",
+				"getpid", "getppid", "getpriority", "getrandom", "getresgid",
+				"getresuid", "getrlimit", "get_robust_list", "getrusage", "getsid",
+				"getsockname", "getsockopt", "get_thread_area", "gettid",
+				"gettimeofday", "getuid", "getxattr", "inotify_add_watch",
+				"inotify_init", "inotify_init1", "inotify_rm_watch", "io_cancel",
+				"ioctl", "io_destroy", "io_getevents", "io_pgetevents",
+				"ioprio_get", "ioprio_set", "io_setup", "io_submit", "kcmp", "kill",
+				"landlock_add_rule", "landlock_create_ruleset",
+				"landlock_restrict_self", "lchown", "lgetxattr", "link", "linkat",
+				"listen", "listxattr", "llistxattr", "lremovexattr", "lseek",
+				"lsetxattr", "lstat", "madvise", "map_shadow_stack", "membarrier",
+				"memfd_create", "memfd_secret", "mincore", "mkdir", "mkdirat",
+				"mknod", "mknodat", "mlock", "mlock2", "mlockall", "mmap",
+				"mprotect", "mq_getsetattr", "mq_notify", "mq_open",
+				"mq_timedreceive", "mq_timedsend", "mq_unlink", "mremap",
+				"msgctl", "msgget", "msgrcv", "msgsnd", "msync", "munlock",
+				"munlockall", "munmap", "name_to_handle_at", "nanosleep",
+				"newfstatat", "open", "openat", "openat2", "pause", "pidfd_getfd",
+				"pidfd_open", "pidfd_send_signal", "pipe", "pipe2", "pkey_alloc",
+				"pkey_free", "pkey_mprotect", "poll", "ppoll", "prctl", "pread64",
+				"preadv", "preadv2", "prlimit64", "process_mrelease",
+				"process_vm_readv", "process_vm_writev", "pselect6", "ptrace",
+				"pwrite64", "pwritev", "pwritev2", "read", "readahead", "readlink",
+				"readlinkat", "readv", "recvfrom", "recvmmsg", "recvmsg",
+				"remap_file_pages", "removexattr", "rename", "renameat",
+				"renameat2", "restart_syscall", "rmdir", "rseq", "rt_sigaction",
+				"rt_sigpending", "rt_sigprocmask", "rt_sigqueueinfo",
+				"rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+				"rt_tgsigqueueinfo", "sched_getaffinity", "sched_getattr",
+				"sched_getparam", "sched_get_priority_max",
+				"sched_get_priority_min", "sched_getscheduler",
+				"sched_rr_get_interval", "sched_setaffinity", "sched_setattr",
+				"sched_setparam", "sched_setscheduler", "sched_yield", "seccomp",
+				"select", "semctl", "semget", "semop", "semtimedop", "sendfile",
+				"sendmmsg", "sendmsg", "sendto", "setfsgid", "setfsuid", "setgid",
+				"setgroups", "setitimer", "setpgid", "setpriority", "setregid",
+				"setresgid", "setresuid", "setreuid", "setrlimit",
+				"set_robust_list", "setdomainname", "sethostname", "setsid", "setsockopt", "set_thread_area",
+				"set_tid_address", "setuid", "setxattr", "shmat", "shmctl",
+				"shmdt", "shmget", "shutdown", "sigaltstack", "signalfd",
+				"signalfd4", "socketpair", "splice", "stat", "statfs", "statx",
+				"symlink", "symlinkat", "sync", "sync_file_range", "syncfs",
+				"sysinfo", "tee", "tgkill", "time", "timer_create",
+				"timer_delete", "timer_getoverrun", "timer_gettime",
+				"timer_settime", "timerfd_create", "timerfd_gettime",
+				"timerfd_settime", "times", "tkill", "truncate", "umask", "uname",
+				"unlink", "unlinkat", "utime", "utimensat", "utimes", "vfork",
+				"vmsplice", "wait4", "waitid", "write", "writev",
+			},
+		},
+		{
+			Action:   "SCMP_ACT_ALLOW",
+			Names:    []string{"arch_prctl", "modify_ldt"},
+			Includes: SeccompCondition{Arches: []string{"SCMP_ARCH_X86_64"}},
+		},
+		// Only the Linux execution domain, with the flags programs commonly
+		// set, and querying it
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"personality"}, Args: []SeccompArg{{Index: 0, Value: 0x0, Op: "SCMP_CMP_EQ"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"personality"}, Args: []SeccompArg{{Index: 0, Value: 0x0008, Op: "SCMP_CMP_EQ"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"personality"}, Args: []SeccompArg{{Index: 0, Value: 0x20000, Op: "SCMP_CMP_EQ"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"personality"}, Args: []SeccompArg{{Index: 0, Value: 0x20008, Op: "SCMP_CMP_EQ"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"personality"}, Args: []SeccompArg{{Index: 0, Value: 0xffffffff, Op: "SCMP_CMP_EQ"}}},
+		// clone without any of the CLONE_NEW* flags creating namespaces
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"clone"}, Args: []SeccompArg{{Index: 0, Value: 0x7e020000, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}}},
+		// clone3 passes its flags in memory the filter can't read, so libc
+		// is told it doesn't exist and falls back to clone
+		{
+			Action:   "SCMP_ACT_ERRNO",
+			Names:    []string{"clone3"},
+			ErrnoRet: &errnoENOSYS,
+			Excludes: SeccompCondition{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		// Sockets of any family except AF_VSOCK, which reaches the host
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"socket"}, Args: []SeccompArg{{Index: 0, Value: 40, Op: "SCMP_CMP_NE"}}},
+		// What capabilities added to the container's allow
+		{
+			Action: "SCMP_ACT_ALLOW",
+			Names: []string{
+				"bpf", "clone", "clone3", "fanotify_init", "fsconfig", "fsmount",
+				"fsopen", "fspick", "lookup_dcookie", "mount", "mount_setattr",
+				"move_mount", "open_tree", "perf_event_open", "quotactl",
+				"quotactl_fd", "setns", "syslog", "umount2", "unshare",
+			},
+			Includes: SeccompCondition{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"reboot"}, Includes: SeccompCondition{Caps: []string{"CAP_SYS_BOOT"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"chroot"}, Includes: SeccompCondition{Caps: []string{"CAP_SYS_CHROOT"}}},
+		{
+			Action:   "SCMP_ACT_ALLOW",
+			Names:    []string{"delete_module", "init_module", "finit_module"},
+			Includes: SeccompCondition{Caps: []string{"CAP_SYS_MODULE"}},
+		},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"acct"}, Includes: SeccompCondition{Caps: []string{"CAP_SYS_PACCT"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"iopl", "ioperm"}, Includes: SeccompCondition{Caps: []string{"CAP_SYS_RAWIO"}}},
+		{
+			Action:   "SCMP_ACT_ALLOW",
+			Names:    []string{"settimeofday", "clock_settime", "adjtimex", "clock_adjtime"},
+			Includes: SeccompCondition{Caps: []string{"CAP_SYS_TIME"}},
+		},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"vhangup"}, Includes: SeccompCondition{Caps: []string{"CAP_SYS_TTY_CONFIG"}}},
+		{
+			Action:   "SCMP_ACT_ALLOW",
+			Names:    []string{"get_mempolicy", "mbind", "set_mempolicy", "set_mempolicy_home_node"},
+			Includes: SeccompCondition{Caps: []string{"CAP_SYS_NICE"}},
+		},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"syslog"}, Includes: SeccompCondition{Caps: []string{"CAP_SYSLOG"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"bpf"}, Includes: SeccompCondition{Caps: []string{"CAP_BPF"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"perf_event_open"}, Includes: SeccompCondition{Caps: []string{"CAP_PERFMON"}}},
+		{Action: "SCMP_ACT_ALLOW", Names: []string{"open_by_handle_at"}, Includes: SeccompCondition{Caps: []string{"CAP_DAC_READ_SEARCH"}}},
+	},
+}
+
+var errnoENOSYS = uint32(unix.ENOSYS)
@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+
+// pkg/container/seccomp_other.go
+package container
+
+// Seccomp filters are only built for x86-64 and arm64, whose syscall
+// numbers floka knows
+const seccompArch = 0
+
+const x32SyscallBit = 0
+
+var syscallNumbers map[string]uint32
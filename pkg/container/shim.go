@@ -0,0 +1,121 @@
+// pkg/container/shim.go
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// shimReady is what the shim reports once the container process is running
+const shimReady = "ok"
+
+// startDetached launches a "floka shim" process that starts the container
+// and supervises it in the background, and returns once the container
+// process is running
+func (c *Container) startDetached() error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get host executable path: %w", err)
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	// The shim reports success or failure through this pipe (fd 3)
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(self, "shim", c.ID)
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.ExtraFiles = []*os.File{readyW}
+	// Run the shim in its own session so it outlives our terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	logging.L().Debug("starting shim", "container", c.ID)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start shim: %w", err)
+	}
+	defer cmd.Process.Release()
+
+	msg, err := io.ReadAll(readyR)
+	if err != nil {
+		return fmt.Errorf("failed to read shim status: %w", err)
+	}
+	switch status := strings.TrimSpace(string(msg)); status {
+	case shimReady:
+	case "":
+		return fmt.Errorf("shim exited before starting the container")
+	default:
+		return fmt.Errorf("failed to start container: %s", status)
+	}
+
+	// Pick up the PID and status the shim recorded
+	started, err := Load(c.ID)
+	if err != nil {
+		return err
+	}
+	c.Pid = started.Pid
+	c.Status = started.Status
+	return nil
+}
+
+// Supervise starts the container with the given ID and waits for it to
+// exit, restarting it as its restart policy says, and records its final
+// state; cancelling ctx stops the container. It
+// is run by the floka shim process, which serves the container's stdio on
+// its attach socket meanwhile; ready receives "ok" once the container
+// process is running, or the error that prevented it from starting.
+func Supervise(ctx context.Context, id string, ready *os.File) error {
+	// Keep the pipe away from the container process, or the parent would
+	// never see it close
+	syscall.CloseOnExec(int(ready.Fd()))
+
+	reported := false
+	report := func(msg string) {
+		if !reported {
+			reported = true
+			io.WriteString(ready, msg)
+			ready.Close()
+		}
+	}
+
+	c, err := Load(id)
+	if err != nil {
+		report(err.Error())
+		return err
+	}
+	c.started = func() { report(shimReady) }
+
+	// The shim holds the container's stdio for "floka attach"
+	server, err := c.serveAttach()
+	if err != nil {
+		logging.L().Warn("container can't be attached to", "container", id, "err", err)
+	} else {
+		defer server.close()
+		c.streams = server
+	}
+
+	err = c.supervise(ctx, config.DataPath("containers", id, "rootfs"))
+	if err != nil {
+		report(err.Error())
+	}
+	return err
+}
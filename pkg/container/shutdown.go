@@ -0,0 +1,145 @@
+// pkg/container/shutdown.go
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Containers run under their shim, not under a floka daemon, so they keep
+// running whatever floka commands do and across upgrades of floka: their
+// record holds the PIDs and start times of the container process and of
+// its shim, and the shim serves the attach socket. What a host shutdown
+// does to them is up to Shutdown, and Restore starts them again after the
+// reboot.
+
+// Shutdown stops the running containers, dependents first: a container
+// joining the network namespace of another is stopped before it, those
+// that don't depend on each other at the same time. Each gets timeout to
+// exit after SIGTERM. The containers are marked as stopped by a shutdown,
+// which Restore doesn't take as stopped by hand. It returns the IDs of the
+// containers stopped.
+func Shutdown(ctx context.Context, timeout time.Duration) ([]string, error) {
+	containers, err := ListContainers(&ListOptions{Status: []string{"running", "paused", "restarting"}})
+	if err != nil {
+		return nil, err
+	}
+	var stopped []string
+	var errs []error
+	for _, wave := range dependencyWaves(containers, true) {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range wave {
+			wg.Add(1)
+			go func(c *Container) {
+				defer wg.Done()
+				c.StoppedByShutdown = true
+				err := c.Stop(ctx, timeout)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to stop container %s: %w", c.ID, err))
+					return
+				}
+				stopped = append(stopped, c.ID)
+			}(c)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return stopped, err
+		}
+	}
+	return stopped, errors.Join(errs...)
+}
+
+// Restore starts the stopped containers whose restart policy asks for it
+// after floka was down, in the background under their shims: those that
+// restart always, and those that restart unless stopped unless they were
+// stopped by hand rather than by Shutdown or a reboot. A container whose
+// network namespace another joins is started before it. It returns the
+// IDs of the containers started.
+func Restore() ([]string, error) {
+	containers, err := ListContainers(&ListOptions{Status: []string{"stopped"}})
+	if err != nil {
+		return nil, err
+	}
+	var restore []*Container
+	for _, c := range containers {
+		name, _, err := parseRestartPolicy(c.RestartPolicy)
+		if err != nil {
+			continue
+		}
+		if name == RestartAlways || (name == RestartUnlessStopped && (!c.ManuallyStopped || c.StoppedByShutdown)) {
+			restore = append(restore, c)
+		}
+	}
+
+	var started []string
+	var errs []error
+	for _, wave := range dependencyWaves(restore, false) {
+		for _, c := range wave {
+			if err := c.Launch(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to start container %s: %w", c.ID, err))
+				continue
+			}
+			logging.L().Debug("restored container", "container", c.ID, "policy", c.RestartPolicy)
+			started = append(started, c.ID)
+		}
+	}
+	return started, errors.Join(errs...)
+}
+
+// dependencyWaves splits containers into groups that can be stopped, or
+// started, at the same time: a container joining the network namespace of
+// another comes in a group before it when dependentsFirst is set, after it
+// otherwise. Containers depending on one not among containers don't wait
+// for it.
+func dependencyWaves(containers []*Container, dependentsFirst bool) [][]*Container {
+	byID := map[string]*Container{}
+	for _, c := range containers {
+		byID[c.ID] = c
+	}
+	// dependents counts, for each container, those left that join it
+	dependents := map[string]int{}
+	for _, c := range containers {
+		if target := c.networkContainer(); byID[target] != nil {
+			dependents[target]++
+		}
+	}
+
+	var waves [][]*Container
+	left := containers
+	for len(left) > 0 {
+		var wave, rest []*Container
+		for _, c := range left {
+			if dependents[c.ID] == 0 {
+				wave = append(wave, c)
+			} else {
+				rest = append(rest, c)
+			}
+		}
+		if len(wave) == 0 {
+			// A cycle, which container:<id> can't make: take them all
+			wave, rest = rest, nil
+		}
+		for _, c := range wave {
+			if target := c.networkContainer(); byID[target] != nil {
+				dependents[target]--
+			}
+		}
+		waves = append(waves, wave)
+		left = rest
+	}
+
+	if !dependentsFirst {
+		for i, j := 0, len(waves)-1; i < j; i, j = i+1, j-1 {
+			waves[i], waves[j] = waves[j], waves[i]
+		}
+	}
+	return waves
+}
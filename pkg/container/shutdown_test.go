@@ -0,0 +1,58 @@
+package container
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDependencyWaves(t *testing.T) {
+	joins := func(id, target string) *Container {
+		c := &Container{ID: id}
+		if target != "" {
+			c.NetworkMode = networkContainerPrefix + target
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		containers []*Container
+		want       [][]string // Stopping order, starting is the reverse
+	}{
+		{"none", nil, nil},
+		{"independent", []*Container{joins("a", ""), joins("b", "")}, [][]string{{"a", "b"}}},
+		{"one joins another", []*Container{joins("a", ""), joins("b", "a")}, [][]string{{"b"}, {"a"}}},
+		{"chain", []*Container{joins("c", "b"), joins("a", ""), joins("b", "a")}, [][]string{{"c"}, {"b"}, {"a"}}},
+		{"several join one", []*Container{joins("a", ""), joins("b", "a"), joins("c", "a"), joins("d", "")}, [][]string{{"b", "c", "d"}, {"a"}}},
+		{"target not listed", []*Container{joins("b", "a"), joins("c", "")}, [][]string{{"b", "c"}}},
+		{"cycle", []*Container{joins("a", "b"), joins("b", "a")}, [][]string{{"a", "b"}}},
+	}
+
+	ids := func(waves [][]*Container) [][]string {
+		var result [][]string
+		for _, wave := range waves {
+			var ids []string
+			for _, c := range wave {
+				ids = append(ids, c.ID)
+			}
+			sort.Strings(ids)
+			result = append(result, ids)
+		}
+		return result
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(dependencyWaves(tt.containers, true)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stopping waves are %v, want %v", got, tt.want)
+			}
+			var reversed [][]string
+			for i := len(tt.want) - 1; i >= 0; i-- {
+				reversed = append(reversed, tt.want[i])
+			}
+			if got := ids(dependencyWaves(tt.containers, false)); !reflect.DeepEqual(got, reversed) {
+				t.Errorf("starting waves are %v, want %v", got, reversed)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// pkg/container/start.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Launch starts a created or stopped container again, in the background
+// under a floka shim as "floka run -d" does, with the command and settings
+// it was created with and the changes its rootfs holds. What a reboot took
+// away, the mounts of its rootfs, volumes and /etc files and its cgroup,
+// is set up again first.
+func (c *Container) Launch() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	cur, err := Load(c.ID)
+	if err != nil {
+		unlock()
+		return err
+	}
+	*c = *cur
+	if c.IsRunning() || c.Status == "restarting" {
+		unlock()
+		return fmt.Errorf("%w: %s", ErrContainerRunning, c.ID)
+	}
+
+	if err := c.restoreMounts(); err != nil {
+		unlock()
+		return err
+	}
+	if !cgroupExists(c.cgroup()) {
+		if err := setupCgroups(c.cgroup(), c.cgroupOpts()); err != nil {
+			unlock()
+			return fmt.Errorf("failed to set up cgroups: %w", err)
+		}
+	}
+	c.ManuallyStopped = false
+	c.StoppedByShutdown = false
+	err = c.updateMetadata()
+	unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save container metadata: %w", err)
+	}
+
+	logging.L().Debug("launching container", "container", c.ID, "status", c.Status)
+	return c.startDetached()
+}
+
+// restoreMounts mounts the container's rootfs, volumes and /etc files
+// again where they aren't mounted anymore
+func (c *Container) restoreMounts() error {
+	containerDir := config.DataPath("containers", c.ID)
+	rootfs := filepath.Join(containerDir, "rootfs")
+	points, err := mountPoints()
+	if err != nil {
+		return fmt.Errorf("failed to read mount points: %w", err)
+	}
+	mounted := map[string]bool{}
+	for _, p := range points {
+		mounted[p] = true
+	}
+	unmounted := func(mounts []Mount) []Mount {
+		var missing []Mount
+		for _, m := range mounts {
+			target, err := fsutil.SecureJoin(rootfs, m.Destination)
+			if err != nil || !mounted[target] {
+				missing = append(missing, m)
+			}
+		}
+		return missing
+	}
+
+	if c.StorageDriver != StorageVFS && !mounted[rootfs] {
+		logging.L().Debug("mounting container rootfs again", "container", c.ID, "driver", c.StorageDriver)
+		if _, err := mountRootfs(containerDir, c.Layers, c.StorageDriver); err != nil {
+			return fmt.Errorf("failed to mount rootfs: %w", err)
+		}
+	}
+	if missing := unmounted(c.Mounts); len(missing) > 0 {
+		if err := mountVolumes(rootfs, missing); err != nil {
+			return err
+		}
+	}
+	if etc := c.etcMounts(); len(unmounted(etc)) == len(etc) {
+		if err := c.mountEtcFiles(rootfs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cgroupOpts returns the options the container's cgroup was set up with
+func (c *Container) cgroupOpts() *ContainerOpts {
+	return &ContainerOpts{
+		Memory:            c.Memory,
+		MemorySwap:        c.MemorySwap,
+		MemoryReservation: c.MemoryReservation,
+		OOMKillDisable:    c.OOMKillDisable,
+		CPUShares:         c.CPUShares,
+		NanoCPUs:          c.NanoCPUs,
+		PidsLimit:         c.PidsLimit,
+		CgroupParent:      c.CgroupParent,
+		Devices:           c.Devices,
+		Privileged:        c.Privileged,
+	}
+}
+
+// cgroupExists reports whether a container's cgroup is there, which it
+// isn't once the container exited without a supervisor or after a reboot
+func cgroupExists(cgroup string) bool {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		_, err := os.Stat(filepath.Join("/sys/fs/cgroup", cgroup))
+		return err == nil
+	}
+	for _, subsystem := range cgroupV1Subsystems {
+		if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", subsystem, cgroup)); err != nil {
+			return false
+		}
+	}
+	return true
+}
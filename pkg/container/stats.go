@@ -0,0 +1,177 @@
+// pkg/container/stats.go
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupV1Subsystems are the cgroup v1 hierarchies a container is placed
+// in: memory and cpu for its limits, freezer to pause it, devices for the
+// devices it may use, the others for its stats
+var cgroupV1Subsystems = []string{"memory", "cpu", "cpuacct", "pids", "blkio", "freezer", "devices"}
+
+// cgroupV2Controllers are enabled for the children of the floka cgroup on
+// cgroup v2, so that containers can be limited and accounted
+var cgroupV2Controllers = []string{"cpu", "memory", "pids", "io"}
+
+// unlimitedMemory is the smallest value cgroup v1 reports as the memory
+// limit of a cgroup without one
+const unlimitedMemory = 1 << 62
+
+// Stats is a sample of the resources a container uses
+type Stats struct {
+	Time        time.Time
+	CPUUsage    time.Duration // CPU time used since the container started
+	MemoryUsage int64
+	MemoryLimit int64 // The container's limit, or the host's memory if none
+	PIDs        int64
+	BlockRead   int64 // Bytes read from block devices
+	BlockWrite  int64 // Bytes written to block devices
+}
+
+// Stats reads the resource usage of a running container from its cgroup.
+// Counters the kernel does not provide are left at zero.
+func (c *Container) Stats() (*Stats, error) {
+	if !c.IsRunning() {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+
+	cgroupPath := "/sys/fs/cgroup"
+	stats := &Stats{Time: time.Now()}
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err == nil {
+		// Cgroup v2
+		dir := filepath.Join(cgroupPath, c.cgroup())
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("failed to read cgroup of container %s: %w", c.ID, err)
+		}
+		stats.MemoryUsage = readCgroupInt(filepath.Join(dir, "memory.current"))
+		stats.MemoryLimit = readCgroupInt(filepath.Join(dir, "memory.max"))
+		usage := readCgroupKeys(filepath.Join(dir, "cpu.stat"), 0)["usage_usec"]
+		stats.CPUUsage = time.Duration(usage) * time.Microsecond
+		stats.PIDs = readCgroupInt(filepath.Join(dir, "pids.current"))
+		stats.BlockRead, stats.BlockWrite = readIOStat(filepath.Join(dir, "io.stat"))
+	} else {
+		// Cgroup v1
+		subsystem := func(name string) string {
+			return filepath.Join(cgroupPath, name, c.cgroup())
+		}
+		if _, err := os.Stat(subsystem("memory")); err != nil {
+			return nil, fmt.Errorf("failed to read cgroup of container %s: %w", c.ID, err)
+		}
+		stats.MemoryUsage = readCgroupInt(filepath.Join(subsystem("memory"), "memory.usage_in_bytes"))
+		stats.MemoryLimit = readCgroupInt(filepath.Join(subsystem("memory"), "memory.limit_in_bytes"))
+		if stats.MemoryLimit >= unlimitedMemory {
+			stats.MemoryLimit = 0
+		}
+		stats.CPUUsage = time.Duration(readCgroupInt(filepath.Join(subsystem("cpuacct"), "cpuacct.usage")))
+		stats.PIDs = readCgroupInt(filepath.Join(subsystem("pids"), "pids.current"))
+		stats.BlockRead, stats.BlockWrite = readBlkioStat(filepath.Join(subsystem("blkio"), "blkio.throttle.io_service_bytes"))
+	}
+
+	if stats.MemoryLimit <= 0 {
+		var info syscall.Sysinfo_t
+		if err := syscall.Sysinfo(&info); err == nil {
+			stats.MemoryLimit = int64(info.Totalram) * int64(info.Unit)
+		}
+	}
+	return stats, nil
+}
+
+// CPUPercent returns the CPU the container used between an earlier sample
+// and this one, in percent of one CPU
+func (s *Stats) CPUPercent(prev *Stats) float64 {
+	if prev == nil || s.CPUUsage < prev.CPUUsage {
+		return 0
+	}
+	elapsed := s.Time.Sub(prev.Time)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.CPUUsage-prev.CPUUsage) / float64(elapsed) * 100
+}
+
+// MemoryPercent returns the memory the container uses in percent of its
+// limit
+func (s *Stats) MemoryPercent() float64 {
+	if s.MemoryLimit <= 0 {
+		return 0
+	}
+	return float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+}
+
+// readCgroupInt reads a cgroup file holding a single number. Missing files
+// and "max" read as 0.
+func readCgroupInt(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// readCgroupKeys sums the numbers of a cgroup file with lines of
+// space-separated fields by their key, the field at index key
+func readCgroupKeys(path string, key int) map[string]int64 {
+	values := map[string]int64{}
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != key+2 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[key+1], 10, 64); err == nil {
+			values[fields[key]] += n
+		}
+	}
+	return values
+}
+
+// readIOStat sums the bytes read and written over all devices in a cgroup
+// v2 io.stat file, whose lines read "8:0 rbytes=1 wbytes=2 ..."
+func readIOStat(path string) (read, write int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+	for _, field := range strings.Fields(string(data)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "rbytes":
+			read += n
+		case "wbytes":
+			write += n
+		}
+	}
+	return read, write
+}
+
+// readBlkioStat sums the bytes read and written over all devices in a
+// cgroup v1 blkio file, whose lines read "8:0 Read 4096"
+func readBlkioStat(path string) (read, write int64) {
+	values := readCgroupKeys(path, 1)
+	return values["Read"], values["Write"]
+}
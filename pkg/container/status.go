@@ -0,0 +1,176 @@
+// pkg/container/status.go
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/network"
+)
+
+// exitStatus returns the exit code of a finished process. A process killed
+// by a signal gets 128 plus the signal number, as a shell would report it.
+func exitStatus(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// StatusText describes the container's state for floka ps, e.g.
+// "Up 5 minutes" or "Exited (1) 2 hours ago"
+func (c *Container) StatusText() string {
+	switch c.Status {
+	case "running":
+		return c.upText()
+	case "paused":
+		return c.upText() + " (Paused)"
+	case "stopped":
+		if c.FinishedAt.IsZero() {
+			return fmt.Sprintf("Exited (%d)", c.ExitCode)
+		}
+		return fmt.Sprintf("Exited (%d) %s ago", c.ExitCode, HumanDuration(time.Since(c.FinishedAt)))
+	case "restarting":
+		if c.FinishedAt.IsZero() {
+			return fmt.Sprintf("Restarting (%d)", c.ExitCode)
+		}
+		return fmt.Sprintf("Restarting (%d) %s ago", c.ExitCode, HumanDuration(time.Since(c.FinishedAt)))
+	case "created":
+		return "Created"
+	case "failed":
+		return "Failed"
+	}
+	return c.Status
+}
+
+// upText tells how long a running container has been up, records from
+// before start times were kept having none
+func (c *Container) upText() string {
+	if c.StartedAt.IsZero() {
+		return "Up"
+	}
+	return "Up " + HumanDuration(time.Since(c.StartedAt))
+}
+
+// HumanDuration formats d roughly as docker does, the way floka ps shows
+// ages and floka history how old images are
+func HumanDuration(d time.Duration) string {
+	hours := int(d.Round(time.Hour) / time.Hour)
+	switch {
+	case d < time.Second:
+		return "Less than a second"
+	case d < time.Minute:
+		return plural(int(d/time.Second), "second")
+	case d < 2*time.Minute:
+		return "About a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case hours == 1:
+		return "About an hour"
+	case hours < 48:
+		return plural(hours, "hour")
+	case hours < 24*7*2:
+		return plural(hours/24, "day")
+	case hours < 24*30*2:
+		return plural(hours/24/7, "week")
+	case hours < 24*365*2:
+		return plural(hours/24/30, "month")
+	}
+	return plural(hours/24/365, "year")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Wait blocks until the container has exited or ctx is cancelled and
+// returns its exit code
+func (c *Container) Wait(ctx context.Context) (int, error) {
+	var gone time.Time
+	for {
+		cur, err := Load(c.ID)
+		if errors.Is(err, ErrContainerNotFound) {
+			return 0, fmt.Errorf("container %s was removed before its exit code was read", c.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if cur.Status != "running" && cur.Status != "paused" && cur.Status != "created" {
+			return cur.ExitCode, nil
+		}
+
+		// The floka process supervising the container records the exit
+		// right after the container process is gone. If nothing does, it
+		// died along with the container.
+		if cur.Pid > 1 && !cur.alive() {
+			if gone.IsZero() {
+				gone = time.Now()
+			} else if time.Since(gone) > 2*time.Second {
+				return 0, fmt.Errorf("container %s exited without recording its exit code", c.ID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// exitCodeUnknown is the exit code of a container whose exit nobody saw,
+// as Docker reports it
+const exitCodeUnknown = 255
+
+// reconcile marks a container left running, paused or restarting by a
+// floka process that was killed, or by a host that rebooted, as stopped:
+// its process and the floka process supervising it are both gone. The
+// port rules and cgroup only its run needed are released.
+func (c *Container) reconcile() {
+	if c.Status != "running" && c.Status != "paused" && c.Status != "restarting" {
+		return
+	}
+	if c.alive() {
+		return
+	}
+	// Containers of older versions don't record their supervisor, only
+	// those that aren't restarting are known to be gone
+	if c.SupervisorPid > 0 && processStarted(c.SupervisorPid, c.SupervisorStartTime) {
+		return
+	}
+	if c.SupervisorPid == 0 && c.Status == "restarting" {
+		return
+	}
+
+	logging.L().Info("container process is gone, marking the container as stopped",
+		"container", c.ID, "status", c.Status, "pid", c.Pid)
+	if c.Network != nil {
+		network.Unpublish(c.Network, c.Ports)
+		if err := network.Disconnect(c.ID); err != nil {
+			logging.L().Warn("failed to disconnect container network", "container", c.ID, "err", err)
+		}
+	}
+	if err := cleanupCgroups(c.cgroup()); err != nil {
+		logging.L().Warn("failed to clean up cgroups", "container", c.ID, "err", err)
+	}
+
+	c.Status = "stopped"
+	c.Pid = 0
+	c.PidStartTime = 0
+	c.SupervisorPid = 0
+	c.SupervisorStartTime = 0
+	c.ExitCode = exitCodeUnknown
+	c.FinishedAt = time.Now()
+	if err := c.updateMetadata(); err != nil {
+		logging.L().Warn("failed to update container metadata", "container", c.ID, "err", err)
+		return
+	}
+	c.emit("die", c.ExitCode)
+}
@@ -0,0 +1,218 @@
+// pkg/container/storage.go
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Storage drivers providing the container rootfs
+const (
+	// StorageOverlay mounts an overlayfs with the image layers as the
+	// read-only lower layers and a per-container upper layer taking the
+	// writes
+	StorageOverlay = "overlay"
+	// StorageVFS gives the container a full copy of the image, for hosts
+	// without overlayfs
+	StorageVFS = "vfs"
+	// StorageBind bind mounts the image's only layer itself, so writes
+	// change the image. Builds use it to run steps on the image being built.
+	StorageBind = "bind"
+)
+
+// overlayOpaque marks a directory hiding the contents of lower layers
+const overlayOpaque = "trusted.overlay.opaque"
+
+// mountRootfs makes the image layers (top first) available at the
+// container's rootfs with the given storage driver, or with overlay falling
+// back to vfs when driver is empty. It returns the driver that was used.
+func mountRootfs(containerDir string, layers []string, driver string) (string, error) {
+	rootfs := filepath.Join(containerDir, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return "", fmt.Errorf("failed to create rootfs: %w", err)
+	}
+
+	switch driver {
+	case "":
+		err := mountOverlay(containerDir, layers)
+		if err == nil {
+			return StorageOverlay, nil
+		}
+		removeOverlayDirs(containerDir)
+		logging.L().Debug("overlay is not available, copying the image", "err", err)
+		return StorageVFS, copyLayers(containerDir, layers)
+	case StorageOverlay:
+		err := mountOverlay(containerDir, layers)
+		if err != nil {
+			removeOverlayDirs(containerDir)
+		}
+		return driver, err
+	case StorageVFS:
+		return driver, copyLayers(containerDir, layers)
+	case StorageBind:
+		if len(layers) != 1 {
+			return "", fmt.Errorf("the bind storage driver needs exactly one layer, got %d", len(layers))
+		}
+		image := layers[0]
+		logging.L().Debug("bind mounting image", "image", image, "rootfs", rootfs)
+		if err := syscall.Mount(image, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return "", fmt.Errorf("failed to bind mount image to rootfs: %w %s", err, image)
+		}
+		return driver, nil
+	default:
+		return "", fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
+
+// mountOverlay stacks the image layers as the lower layers of an overlayfs
+// whose upper and work directories live in the container directory. The
+// upper directory of a container mounted before keeps its changes.
+func mountOverlay(containerDir string, layers []string) error {
+	dir, err := filepath.Abs(containerDir)
+	if err != nil {
+		return err
+	}
+	upper := filepath.Join(dir, "upper")
+	work := filepath.Join(dir, "work")
+	dirs := []string{upper, work}
+	// overlayfs needs a lower layer, even for an empty image
+	if len(layers) == 0 {
+		layers = []string{filepath.Join(dir, "lower")}
+		dirs = append(dirs, layers[0])
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+	}
+
+	lower := make([]string, len(layers))
+	for i, layer := range layers {
+		abs, err := filepath.Abs(layer)
+		if err != nil {
+			return err
+		}
+		lower[i] = escapeOverlayPath(abs)
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lower, ":"), escapeOverlayPath(upper), escapeOverlayPath(work))
+	rootfs := filepath.Join(dir, "rootfs")
+	logging.L().Debug("mounting overlay", "rootfs", rootfs, "options", data)
+	if err := syscall.Mount("overlay", rootfs, "overlay", 0, data); err != nil {
+		return fmt.Errorf("failed to mount overlay: %w", err)
+	}
+	return nil
+}
+
+// removeOverlayDirs removes the upper and work directories of an overlay
+// that failed to mount for a new container
+func removeOverlayDirs(containerDir string) {
+	os.RemoveAll(filepath.Join(containerDir, "upper"))
+	os.RemoveAll(filepath.Join(containerDir, "work"))
+}
+
+// escapeOverlayPath escapes the characters overlayfs options use as
+// separators, such as the colon in image directories named name:tag
+func escapeOverlayPath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`, ",", `\,`).Replace(path)
+}
+
+// copyLayers merges copies of the image layers into the container's
+// rootfs, bottom first
+func copyLayers(containerDir string, layers []string) error {
+	rootfs := filepath.Join(containerDir, "rootfs")
+	for i := len(layers) - 1; i >= 0; i-- {
+		logging.L().Debug("copying image layer", "layer", layers[i], "rootfs", rootfs)
+		if err := mergeLayer(layers[i], rootfs); err != nil {
+			return fmt.Errorf("failed to copy image to rootfs: %w", err)
+		}
+	}
+	return nil
+}
+
+// mergeLayer copies a layer onto rootfs as overlayfs would show it: its
+// whiteouts delete what lower layers put at their path, its opaque
+// directories replace lower ones instead of being merged with them, and
+// its files and directories replace lower entries of another type
+func mergeLayer(layer, rootfs string) error {
+	var whiteouts []string
+	err := filepath.WalkDir(layer, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(rootfs, rel)
+		lower, lowerErr := os.Lstat(target)
+
+		switch {
+		case isWhiteout(d):
+			whiteouts = append(whiteouts, target)
+			return os.RemoveAll(target)
+		case d.IsDir() && isOpaque(path):
+			return os.RemoveAll(target)
+		case lowerErr == nil && d.IsDir() != lower.IsDir():
+			return os.RemoveAll(target)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := fsutil.CopyTree(layer, rootfs); err != nil {
+		return err
+	}
+	// Whiteouts were copied like any other device
+	for _, path := range whiteouts {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isWhiteout reports whether an entry of an overlay layer is a whiteout,
+// a 0/0 character device
+func isWhiteout(d fs.DirEntry) bool {
+	if d.Type()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// isOpaque reports whether a directory of an overlay layer is opaque
+func isOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, overlayOpaque, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// unmountRootfs detaches the container's rootfs. Copied rootfs aren't
+// mounted and are simply removed with the container directory.
+func unmountRootfs(containerDir, driver string) {
+	if driver == StorageVFS {
+		return
+	}
+	rootfs := filepath.Join(containerDir, "rootfs")
+	logging.L().Debug("unmounting container rootfs", "path", rootfs)
+	if err := syscall.Unmount(rootfs, syscall.MNT_DETACH); err != nil && !errors.Is(err, syscall.EINVAL) && !os.IsNotExist(err) {
+		// Removing the directory may still work, or report the busy mount
+		logging.L().Warn("failed to unmount rootfs, proceeding with removal attempt", "path", rootfs, "err", err)
+	}
+}
@@ -0,0 +1,404 @@
+// pkg/container/store.go
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Container metadata lives in a bbolt database under the data root, one
+// JSON record per container, indexed by name, label and status so that
+// ListContainers only reads the records a filter can match
+var (
+	containersBucket = []byte("containers") // ID -> container record
+	namesBucket      = []byte("names")      // Name -> ID
+	labelsBucket     = []byte("labels")     // KEY\0VALUE\0ID -> nothing
+	statusBucket     = []byte("status")     // STATUS\0ID -> nothing
+	metaBucket       = []byte("meta")       // Store version
+)
+
+// storeVersion is written once container.json files of older versions
+// have been imported
+const storeVersion = "1"
+
+// storeTimeout is how long opening the store waits for another floka
+// process writing to it
+const storeTimeout = 30 * time.Second
+
+// storeRecord is how a container is saved in the store
+type storeRecord struct {
+	*Container
+	Updated string
+}
+
+// storePath returns the location of the container store
+func storePath() string {
+	return config.DataPath("containers", "state.db")
+}
+
+// openStore opens the container store, read-only unless write is set.
+// Readers share it, a writer has it to itself until it closes it. The
+// store is created, importing the metadata of older versions, by the
+// first process to open it for writing.
+func openStore(write bool) (*bolt.DB, error) {
+	path := storePath()
+	if !write {
+		if _, err := os.Stat(path); err == nil {
+			db, err := bolt.Open(path, 0644, &bolt.Options{ReadOnly: true, Timeout: storeTimeout})
+			if err != nil {
+				return nil, fmt.Errorf("failed to open container store: %w", err)
+			}
+			return db, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create containers directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: storeTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, namesBucket, labelsBucket, statusBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(metaBucket).Get([]byte("version")) != nil {
+			return nil
+		}
+		if err := importLegacyMetadata(tx); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put([]byte("version"), []byte(storeVersion))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize container store: %w", err)
+	}
+	return db, nil
+}
+
+// importLegacyMetadata moves the metadata/container.json files older
+// versions kept in each container directory into the store
+func importLegacyMetadata(tx *bolt.Tx) error {
+	files, err := filepath.Glob(config.DataPath("containers", "*", "metadata", "container.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		c := &Container{}
+		if err := json.Unmarshal(data, c); err != nil {
+			logging.L().Warn("skipping container metadata", "path", file, "err", err)
+			continue
+		}
+		// The directory name is authoritative, as it was for Load
+		c.ID = filepath.Base(filepath.Dir(filepath.Dir(file)))
+		if err := putRecord(tx, c, true); err != nil {
+			return err
+		}
+		logging.L().Info("moved container metadata to the container store", "container", c.ID)
+		os.Remove(file)
+	}
+	return nil
+}
+
+// putRecord saves a container and updates the indexes. Unless create is
+// set the container must already be in the store.
+func putRecord(tx *bolt.Tx, c *Container, create bool) error {
+	containers := tx.Bucket(containersBucket)
+	old := containers.Get([]byte(c.ID))
+	if old == nil && !create {
+		return fmt.Errorf("%w: %s", ErrContainerNotFound, c.ID)
+	}
+	if old != nil {
+		prev := &Container{}
+		if err := json.Unmarshal(old, prev); err == nil {
+			prev.ID = c.ID
+			if err := deleteIndexes(tx, prev); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(storeRecord{c, time.Now().Format(time.RFC3339)})
+	if err != nil {
+		return fmt.Errorf("failed to serialize container metadata: %w", err)
+	}
+	if err := containers.Put([]byte(c.ID), data); err != nil {
+		return err
+	}
+	if c.Name != "" {
+		if err := tx.Bucket(namesBucket).Put([]byte(c.Name), []byte(c.ID)); err != nil {
+			return err
+		}
+	}
+	for key, value := range c.Labels {
+		if err := tx.Bucket(labelsBucket).Put(labelIndexKey(key, value, c.ID), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(statusBucket).Put(statusIndexKey(c.Status, c.ID), nil)
+}
+
+// deleteIndexes removes the index entries of a container
+func deleteIndexes(tx *bolt.Tx, c *Container) error {
+	names := tx.Bucket(namesBucket)
+	if c.Name != "" && string(names.Get([]byte(c.Name))) == c.ID {
+		if err := names.Delete([]byte(c.Name)); err != nil {
+			return err
+		}
+	}
+	for key, value := range c.Labels {
+		if err := tx.Bucket(labelsBucket).Delete(labelIndexKey(key, value, c.ID)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(statusBucket).Delete(statusIndexKey(c.Status, c.ID))
+}
+
+func labelIndexKey(key, value, id string) []byte {
+	return []byte(key + "\x00" + value + "\x00" + id)
+}
+
+func statusIndexKey(status, id string) []byte {
+	return []byte(status + "\x00" + id)
+}
+
+// getRecord reads a container from the store
+func getRecord(tx *bolt.Tx, id string) (*Container, error) {
+	data := tx.Bucket(containersBucket).Get([]byte(id))
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, id)
+	}
+	c := &Container{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata for container %s: %w", id, err)
+	}
+	c.ID = id
+	return c, nil
+}
+
+// storeGet reads a container from the store
+func storeGet(id string) (*Container, error) {
+	db, err := openStore(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var c *Container
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = getRecord(tx, id)
+		return err
+	})
+	return c, err
+}
+
+// storePut saves a container. Unless create is set, it fails with
+// ErrContainerNotFound for a container that was removed, so that a late
+// update can't bring it back.
+func storePut(c *Container, create bool) error {
+	db, err := openStore(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, c, create)
+	})
+}
+
+// storeRename changes the name of a container, in the same transaction as
+// it checks no other container has the name
+func storeRename(id, name string) error {
+	db, err := openStore(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		if owner := tx.Bucket(namesBucket).Get([]byte(name)); owner != nil && string(owner) != id {
+			return fmt.Errorf("container name %q %w: in use by container %s", name, ErrAlreadyExists, owner)
+		}
+		c, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		c.Name = name
+		return putRecord(tx, c, false)
+	})
+}
+
+// storeDelete removes a container from the store
+func storeDelete(id string) error {
+	db, err := openStore(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		c, err := getRecord(tx, id)
+		if errors.Is(err, ErrContainerNotFound) {
+			return nil
+		}
+		if err == nil {
+			err = deleteIndexes(tx, c)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(containersBucket).Delete([]byte(id))
+	})
+}
+
+// storeList reads the containers that may pass the filters of opts,
+// looked up through the most selective index they use
+func storeList(opts *ListOptions) ([]*Container, error) {
+	db, err := openStore(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var containers []*Container
+	err = db.View(func(tx *bolt.Tx) error {
+		ids, indexed := indexedIDs(tx, opts)
+		if !indexed {
+			if err := tx.Bucket(containersBucket).ForEach(func(k, _ []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			c, err := getRecord(tx, id)
+			if errors.Is(err, ErrContainerNotFound) {
+				continue
+			}
+			if err != nil {
+				logging.L().Warn("skipping container", "err", err)
+				continue
+			}
+			containers = append(containers, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// indexedIDs returns the IDs of the containers an index says may match
+// opts, and false if opts uses no index
+func indexedIDs(tx *bolt.Tx, opts *ListOptions) ([]string, bool) {
+	switch {
+	case opts.Name != "":
+		if id := tx.Bucket(namesBucket).Get([]byte(opts.Name)); id != nil {
+			return []string{string(id)}, true
+		}
+		return nil, true
+	case len(opts.Status) > 0:
+		var ids []string
+		for _, status := range opts.Status {
+			ids = append(ids, scanIndex(tx.Bucket(statusBucket), status+"\x00")...)
+		}
+		return ids, true
+	case len(opts.Labels) > 0:
+		key, value, hasValue := strings.Cut(opts.Labels[0], "=")
+		prefix := key + "\x00"
+		if hasValue {
+			prefix += value + "\x00"
+		}
+		return scanIndex(tx.Bucket(labelsBucket), prefix), true
+	}
+	return nil, false
+}
+
+// scanIndex returns the IDs ending the keys of an index that start with
+// prefix
+func scanIndex(b *bolt.Bucket, prefix string) []string {
+	var ids []string
+	cursor := b.Cursor()
+	for k, _ := cursor.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = cursor.Next() {
+		ids = append(ids, string(k[strings.LastIndexByte(string(k), 0)+1:]))
+	}
+	return ids
+}
+
+// ExportState writes the content of the container store as indented JSON,
+// the containers by ID and the entries of each index, for debugging
+func ExportState(w io.Writer) error {
+	db, err := openStore(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	state := struct {
+		Containers map[string]json.RawMessage
+		Names      map[string]string
+		Labels     []string // KEY=VALUE ID
+		Status     []string // STATUS ID
+	}{Containers: map[string]json.RawMessage{}, Names: map[string]string{}, Labels: []string{}, Status: []string{}}
+	err = db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(containersBucket).ForEach(func(k, v []byte) error {
+			state.Containers[string(k)] = append(json.RawMessage{}, v...)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := tx.Bucket(namesBucket).ForEach(func(k, v []byte) error {
+			state.Names[string(k)] = string(v)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := tx.Bucket(labelsBucket).ForEach(func(k, _ []byte) error {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) == 3 {
+				state.Labels = append(state.Labels, parts[0]+"="+parts[1]+" "+parts[2])
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(statusBucket).ForEach(func(k, _ []byte) error {
+			status, id, _ := strings.Cut(string(k), "\x00")
+			state.Status = append(state.Status, status+" "+id)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read container store: %w", err)
+	}
+	sort.Strings(state.Labels)
+	sort.Strings(state.Status)
+
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,381 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// useTempRoot points the data root at a temporary directory for the test
+func useTempRoot(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(config.RootEnv, dir)
+	if err := config.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// storeIndexes is the content of the indexes of the store
+type storeIndexes struct {
+	Names  map[string]string // Name -> ID
+	Labels []string          // KEY=VALUE ID
+	Status []string          // STATUS ID
+}
+
+// readIndexes reads the indexes of the store
+func readIndexes(t *testing.T) storeIndexes {
+	t.Helper()
+	var state struct {
+		Containers map[string]json.RawMessage
+		storeIndexes
+	}
+	var buf bytes.Buffer
+	if err := ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state.storeIndexes
+}
+
+func TestStoreIndexes(t *testing.T) {
+	steps := []struct {
+		name   string
+		change func() error
+		want   storeIndexes
+	}{
+		{
+			name: "create",
+			change: func() error {
+				if err := storePut(&Container{ID: "a1", Name: "web", Status: "created", Labels: map[string]string{"app": "shop", "tier": "front"}}, true); err != nil {
+					return err
+				}
+				return storePut(&Container{ID: "b2", Status: "created", Labels: map[string]string{"app": "shop"}}, true)
+			},
+			want: storeIndexes{
+				Names:  map[string]string{"web": "a1"},
+				Labels: []string{"app=shop a1", "app=shop b2", "tier=front a1"},
+				Status: []string{"created a1", "created b2"},
+			},
+		},
+		{
+			name:   "rename",
+			change: func() error { return storeRename("a1", "shop") },
+			want: storeIndexes{
+				Names:  map[string]string{"shop": "a1"},
+				Labels: []string{"app=shop a1", "app=shop b2", "tier=front a1"},
+				Status: []string{"created a1", "created b2"},
+			},
+		},
+		{
+			name: "status change",
+			change: func() error {
+				c, err := storeGet("b2")
+				if err != nil {
+					return err
+				}
+				c.Status = "running"
+				return storePut(c, false)
+			},
+			want: storeIndexes{
+				Names:  map[string]string{"shop": "a1"},
+				Labels: []string{"app=shop a1", "app=shop b2", "tier=front a1"},
+				Status: []string{"created a1", "running b2"},
+			},
+		},
+		{
+			name: "label change",
+			change: func() error {
+				c, err := storeGet("a1")
+				if err != nil {
+					return err
+				}
+				c.Labels = map[string]string{"app": "blog"}
+				return storePut(c, false)
+			},
+			want: storeIndexes{
+				Names:  map[string]string{"shop": "a1"},
+				Labels: []string{"app=blog a1", "app=shop b2"},
+				Status: []string{"created a1", "running b2"},
+			},
+		},
+		{
+			name:   "delete",
+			change: func() error { return storeDelete("a1") },
+			want: storeIndexes{
+				Names:  map[string]string{},
+				Labels: []string{"app=shop b2"},
+				Status: []string{"running b2"},
+			},
+		},
+		{
+			name:   "delete missing",
+			change: func() error { return storeDelete("a1") },
+			want: storeIndexes{
+				Names:  map[string]string{},
+				Labels: []string{"app=shop b2"},
+				Status: []string{"running b2"},
+			},
+		},
+	}
+
+	useTempRoot(t)
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := readIndexes(t); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: indexes are %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestPutRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     *Container
+		c       *Container
+		create  bool
+		wantErr error
+		want    storeIndexes
+	}{
+		{
+			name:   "create",
+			c:      &Container{ID: "a1", Name: "web", Status: "created"},
+			create: true,
+			want:   storeIndexes{Names: map[string]string{"web": "a1"}, Labels: []string{}, Status: []string{"created a1"}},
+		},
+		{
+			name:    "update of a removed container",
+			c:       &Container{ID: "a1", Name: "web", Status: "stopped"},
+			wantErr: ErrContainerNotFound,
+			want:    storeIndexes{Names: map[string]string{}, Labels: []string{}, Status: []string{}},
+		},
+		{
+			name: "indexes rewritten",
+			old:  &Container{ID: "a1", Name: "web", Status: "running", Labels: map[string]string{"app": "shop"}},
+			c:    &Container{ID: "a1", Name: "api", Status: "stopped", Labels: map[string]string{"app": "blog", "env": "dev"}},
+			want: storeIndexes{
+				Names:  map[string]string{"api": "a1"},
+				Labels: []string{"app=blog a1", "env=dev a1"},
+				Status: []string{"stopped a1"},
+			},
+		},
+		{
+			name: "name dropped",
+			old:  &Container{ID: "a1", Name: "web", Status: "running"},
+			c:    &Container{ID: "a1", Status: "running"},
+			want: storeIndexes{Names: map[string]string{}, Labels: []string{}, Status: []string{"running a1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempRoot(t)
+			if tt.old != nil {
+				if err := storePut(tt.old, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := storePut(tt.c, tt.create)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("storePut returned %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := readIndexes(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("indexes are %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreRename(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		newName   string
+		wantErr   error
+		wantNames map[string]string
+	}{
+		{"free name", "a1", "api", nil, map[string]string{"api": "a1", "db": "b2"}},
+		{"same name", "a1", "web", nil, map[string]string{"web": "a1", "db": "b2"}},
+		{"name in use", "a1", "db", ErrAlreadyExists, map[string]string{"web": "a1", "db": "b2"}},
+		{"missing container", "c3", "api", ErrContainerNotFound, map[string]string{"web": "a1", "db": "b2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempRoot(t)
+			for _, c := range []*Container{{ID: "a1", Name: "web"}, {ID: "b2", Name: "db"}} {
+				if err := storePut(c, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := storeRename(tt.id, tt.newName)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("storeRename returned %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := readIndexes(t).Names; !reflect.DeepEqual(got, tt.wantNames) {
+				t.Errorf("names are %v, want %v", got, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestImportLegacyMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // Container directory -> metadata/container.json
+		wantIDs []string
+		want    storeIndexes
+	}{
+		{
+			name:    "no legacy metadata",
+			wantIDs: nil,
+			want:    storeIndexes{Names: map[string]string{}, Labels: []string{}, Status: []string{}},
+		},
+		{
+			name: "directory name is the ID",
+			files: map[string]string{
+				"a1": `{"ID": "other", "Name": "web", "Status": "stopped", "Labels": {"app": "shop"}}`,
+				"b2": `{"Status": "created"}`,
+			},
+			wantIDs: []string{"a1", "b2"},
+			want: storeIndexes{
+				Names:  map[string]string{"web": "a1"},
+				Labels: []string{"app=shop a1"},
+				Status: []string{"created b2", "stopped a1"},
+			},
+		},
+		{
+			name: "unreadable metadata skipped",
+			files: map[string]string{
+				"a1": `{"Status": "stopped"}`,
+				"b2": `{not json`,
+			},
+			wantIDs: []string{"a1"},
+			want:    storeIndexes{Names: map[string]string{}, Labels: []string{}, Status: []string{"stopped a1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTempRoot(t)
+			for id, data := range tt.files {
+				dir := filepath.Join(root, "containers", id, "metadata")
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "container.json"), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Opening the store for reading creates it and imports the files
+			containers, err := storeList(&ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, c := range containers {
+				ids = append(ids, c.ID)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("imported containers %v, want %v", ids, tt.wantIDs)
+			}
+			if got := readIndexes(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("indexes are %+v, want %+v", got, tt.want)
+			}
+			for _, id := range tt.wantIDs {
+				if _, err := os.Stat(filepath.Join(root, "containers", id, "metadata", "container.json")); !os.IsNotExist(err) {
+					t.Errorf("metadata file of %s left behind after the import", id)
+				}
+			}
+
+			// Later files are not imported again once the store is versioned
+			dir := filepath.Join(root, "containers", "c3", "metadata")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "container.json"), []byte(`{"Status": "stopped"}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storeGet("c3"); !errors.Is(err, ErrContainerNotFound) {
+				t.Errorf("metadata written after the import was imported: %v", err)
+			}
+		})
+	}
+}
+
+func TestIndexedIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        ListOptions
+		wantIDs     []string
+		wantIndexed bool
+	}{
+		{"no filter", ListOptions{}, nil, false},
+		{"unindexed filters", ListOptions{Ancestor: "alpine", IDPrefix: "a"}, nil, false},
+		{"name", ListOptions{Name: "web"}, []string{"a1"}, true},
+		{"missing name", ListOptions{Name: "nope"}, nil, true},
+		{"name before status", ListOptions{Name: "web", Status: []string{"stopped"}}, []string{"a1"}, true},
+		{"status", ListOptions{Status: []string{"running"}}, []string{"a1", "b2"}, true},
+		{"several statuses", ListOptions{Status: []string{"running", "stopped"}}, []string{"a1", "b2", "c3"}, true},
+		{"status before labels", ListOptions{Status: []string{"stopped"}, Labels: []string{"app"}}, []string{"c3"}, true},
+		{"label key", ListOptions{Labels: []string{"app"}}, []string{"a1", "b2", "c3"}, true},
+		{"label key and value", ListOptions{Labels: []string{"app=shop"}}, []string{"a1", "c3"}, true},
+		{"first label only", ListOptions{Labels: []string{"tier=front", "app=blog"}}, []string{"a1"}, true},
+		{"label key prefix", ListOptions{Labels: []string{"ap"}}, nil, true},
+	}
+
+	useTempRoot(t)
+	for _, c := range []*Container{
+		{ID: "a1", Name: "web", Status: "running", Labels: map[string]string{"app": "shop", "tier": "front"}},
+		{ID: "b2", Status: "running", Labels: map[string]string{"app": "blog"}},
+		{ID: "c3", Status: "stopped", Labels: map[string]string{"app": "shop"}},
+	} {
+		if err := storePut(c, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := openStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			var indexed bool
+			db.View(func(tx *bolt.Tx) error {
+				ids, indexed = indexedIDs(tx, &tt.opts)
+				return nil
+			})
+			sort.Strings(ids)
+			if indexed != tt.wantIndexed || !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("indexedIDs returned %v, %v, want %v, %v", ids, indexed, tt.wantIDs, tt.wantIndexed)
+			}
+		})
+	}
+}
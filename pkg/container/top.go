@@ -0,0 +1,177 @@
+// pkg/container/top.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat, USER_HZ,
+// which is 100 on every architecture Linux runs on
+const clockTicks = 100
+
+// Process describes a process running in a container, as seen from the
+// host
+type Process struct {
+	PID        int
+	PPID       int
+	UID        int
+	State      string        // R, S, D, Z, ... as in ps
+	CPUTime    time.Duration // User and system time used so far
+	CPUPercent float64       // CPU time over the time since it started
+	RSS        int64         // Resident memory in bytes
+	Started    time.Time
+	Command    string
+}
+
+// Processes returns the processes running in the container, those in its
+// cgroup, sorted by PID. Without a cgroup, as when it could not be set up,
+// the processes in the container's PID namespace are returned instead.
+func (c *Container) Processes() ([]*Process, error) {
+	if !c.IsRunning() {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotRunning, c.ID)
+	}
+	pids, err := cgroupPids(c.cgroup())
+	if err != nil || len(pids) == 0 {
+		if pids, err = namespacePids(c.Pid); err != nil {
+			return nil, fmt.Errorf("failed to list processes of container %s: %w", c.ID, err)
+		}
+	}
+
+	bootTime, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+	var processes []*Process
+	for _, pid := range pids {
+		p, err := readProcess(pid, bootTime)
+		if err != nil {
+			// Exited since it was listed
+			continue
+		}
+		processes = append(processes, p)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes, nil
+}
+
+// cgroupPids returns the PIDs of the processes in a container's cgroup,
+// given relative to the root of the cgroup hierarchies
+func cgroupPids(cgroup string) ([]int, error) {
+	cgroupPath := "/sys/fs/cgroup"
+	procsFile := filepath.Join(cgroupPath, cgroup, "cgroup.procs")
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err != nil {
+		procsFile = filepath.Join(cgroupPath, "memory", cgroup, "cgroup.procs")
+	}
+
+	data, err := os.ReadFile(procsFile)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// namespacePids returns the PIDs of the processes in the PID namespace of
+// the process pid
+func namespacePids(pid int) ([]int, error) {
+	ns, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "ns", "pid"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		other, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if link, err := os.Readlink(filepath.Join("/proc", entry.Name(), "ns", "pid")); err == nil && link == ns {
+			pids = append(pids, other)
+		}
+	}
+	return pids, nil
+}
+
+// bootTime returns when the host booted, which process start times in
+// /proc are relative to
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read uptime: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("failed to parse uptime %q", data)
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse uptime %q: %w", data, err)
+	}
+	return time.Now().Add(-time.Duration(uptime * float64(time.Second))), nil
+}
+
+// readProcess reads a process's details from /proc
+func readProcess(pid int, bootTime time.Time) (*Process, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	data, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The command name may contain spaces, so parse after its closing paren:
+	// "pid (comm) state ppid ... utime stime ... starttime vsize rss ..."
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("failed to parse %s/stat", procDir)
+	}
+	comm := string(data[strings.IndexByte(string(data), '(')+1 : i])
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("failed to parse %s/stat", procDir)
+	}
+	stat := func(i int) int64 {
+		n, _ := strconv.ParseInt(fields[i], 10, 64)
+		return n
+	}
+
+	info, err := os.Stat(procDir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Process{
+		PID:     pid,
+		PPID:    int(stat(1)),
+		State:   fields[0],
+		CPUTime: time.Duration(stat(11)+stat(12)) * time.Second / clockTicks,
+		RSS:     stat(21) * int64(os.Getpagesize()),
+		Started: bootTime.Add(time.Duration(stat(19)) * time.Second / clockTicks),
+		Command: comm,
+	}
+	// /proc/<pid> is owned by the process's effective user
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		p.UID = int(st.Uid)
+	}
+	if elapsed := time.Since(p.Started); elapsed > 0 {
+		p.CPUPercent = float64(p.CPUTime) / float64(elapsed) * 100
+	}
+
+	// Kernel threads and zombies have no command line
+	if cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Command = strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+	}
+	return p, nil
+}
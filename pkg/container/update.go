@@ -0,0 +1,233 @@
+// pkg/container/update.go
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// ResourceUpdate holds the resource limits UpdateResources changes. Those
+// left zero are kept as they are.
+type ResourceUpdate struct {
+	Memory            int64 // Memory limit in bytes
+	MemorySwap        int64 // Memory plus swap limit in bytes, at least Memory, or -1 for unlimited swap
+	MemoryReservation int64 // Memory soft limit in bytes
+	CPUShares         int64 // CPU shares (relative weight)
+	NanoCPUs          int64 // CPU time quota in billionths of a CPU, or -1 to lift it
+	PidsLimit         int64 // Maximum number of processes, or -1 to lift it
+}
+
+// UpdateResources changes the resource limits of a container. Those of a
+// running container are changed in its cgroup right away, without
+// restarting it; a stopped container gets them when it is started again.
+func (c *Container) UpdateResources(res *ResourceUpdate) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cur, err := Load(c.ID)
+	if err != nil {
+		return err
+	}
+	*c = *cur
+
+	old := c.cgroupOpts()
+	opts := *old
+	if res.Memory != 0 {
+		opts.Memory = res.Memory
+	}
+	if res.MemorySwap != 0 {
+		opts.MemorySwap = res.MemorySwap
+	}
+	if res.MemoryReservation != 0 {
+		opts.MemoryReservation = res.MemoryReservation
+	}
+	if res.CPUShares != 0 {
+		opts.CPUShares = res.CPUShares
+	}
+	if res.NanoCPUs == -1 {
+		opts.NanoCPUs = 0
+	} else if res.NanoCPUs != 0 {
+		opts.NanoCPUs = res.NanoCPUs
+	}
+	if res.PidsLimit == -1 {
+		opts.PidsLimit = 0
+	} else if res.PidsLimit != 0 {
+		opts.PidsLimit = res.PidsLimit
+	}
+	if opts.Memory < 0 || opts.CPUShares < 0 {
+		return fmt.Errorf("invalid memory limit %d or CPU shares %d", opts.Memory, opts.CPUShares)
+	}
+	if err := checkMemoryOpts(&opts); err != nil {
+		return err
+	}
+	if err := checkLimitOpts(&opts); err != nil {
+		return err
+	}
+
+	if cgroupExists(c.cgroup()) {
+		logging.L().Debug("updating container cgroup", "container", c.ID, "cgroup", c.cgroup())
+		if err := updateCgroup(c.cgroup(), old, &opts); err != nil {
+			return fmt.Errorf("failed to update container %s: %w", c.ID, err)
+		}
+	}
+
+	c.Memory = opts.Memory
+	c.MemorySwap = opts.MemorySwap
+	c.MemoryReservation = opts.MemoryReservation
+	c.CPUShares = opts.CPUShares
+	c.NanoCPUs = opts.NanoCPUs
+	c.PidsLimit = opts.PidsLimit
+	if err := c.updateMetadata(); err != nil {
+		return fmt.Errorf("failed to update container metadata: %w", err)
+	}
+	c.logEvent("update", nil)
+	return nil
+}
+
+// updateCgroup writes the limits that differ between old and opts to an
+// existing container cgroup. Limits that were lifted are set to the
+// highest value.
+func updateCgroup(cgroup string, old, opts *ContainerOpts) error {
+	cgroupPath := "/sys/fs/cgroup"
+	write := func(path, value, what string) error {
+		if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+			return fmt.Errorf("failed to set %s: %w", what, err)
+		}
+		return nil
+	}
+	memoryChanged := opts.Memory != old.Memory
+	swapChanged := opts.MemorySwap != old.MemorySwap
+
+	if _, err := os.Stat(filepath.Join(cgroupPath, "cgroup.controllers")); err == nil {
+		dir := filepath.Join(cgroupPath, cgroup)
+
+		// A container created without a limit may not have the
+		// controller enforcing it yet
+		var required []string
+		if memoryChanged || swapChanged || opts.MemoryReservation != old.MemoryReservation {
+			required = append(required, "memory")
+		}
+		if opts.CPUShares != old.CPUShares || opts.NanoCPUs != old.NanoCPUs {
+			required = append(required, "cpu")
+		}
+		if opts.PidsLimit != old.PidsLimit {
+			required = append(required, "pids")
+		}
+		if err := enableCgroupControllers(cgroupPath, filepath.Dir(dir), required); err != nil {
+			return err
+		}
+
+		if memoryChanged {
+			if err := write(filepath.Join(dir, "memory.max"), strconv.FormatInt(opts.Memory, 10), "memory limit"); err != nil {
+				return err
+			}
+		}
+		// The swap limit of cgroup v2 leaves out memory, so it changes
+		// with the memory limit
+		if opts.MemorySwap != 0 && (swapChanged || memoryChanged) {
+			swap := "max"
+			if opts.MemorySwap > 0 {
+				swap = strconv.FormatInt(opts.MemorySwap-opts.Memory, 10)
+			}
+			if err := write(filepath.Join(dir, "memory.swap.max"), swap, "memory swap limit"); err != nil {
+				return err
+			}
+		}
+		if opts.MemoryReservation != old.MemoryReservation {
+			if err := write(filepath.Join(dir, "memory.low"), strconv.FormatInt(opts.MemoryReservation, 10), "memory reservation"); err != nil {
+				return err
+			}
+		}
+		if opts.CPUShares != old.CPUShares {
+			if err := write(filepath.Join(dir, "cpu.weight"), strconv.FormatInt(cpuWeight(opts.CPUShares), 10), "CPU weight"); err != nil {
+				return err
+			}
+		}
+		if opts.NanoCPUs != old.NanoCPUs {
+			quota := "max"
+			if opts.NanoCPUs > 0 {
+				quota = strconv.FormatInt(cpuQuota(opts.NanoCPUs), 10)
+			}
+			if err := write(filepath.Join(dir, "cpu.max"), fmt.Sprintf("%s %d", quota, cpuPeriod), "CPU quota"); err != nil {
+				return err
+			}
+		}
+		if opts.PidsLimit != old.PidsLimit {
+			limit := "max"
+			if opts.PidsLimit > 0 {
+				limit = strconv.FormatInt(opts.PidsLimit, 10)
+			}
+			if err := write(filepath.Join(dir, "pids.max"), limit, "pids limit"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The memory and swap limit of cgroup v1 can't be below the memory
+	// limit at any time: it is raised before a higher memory limit and
+	// lowered after a lower one
+	memoryDir := filepath.Join(cgroupPath, "memory", cgroup)
+	setMemory := func() error {
+		if !memoryChanged {
+			return nil
+		}
+		return write(filepath.Join(memoryDir, "memory.limit_in_bytes"), strconv.FormatInt(opts.Memory, 10), "memory limit")
+	}
+	setSwap := func() error {
+		if !swapChanged {
+			return nil
+		}
+		return write(filepath.Join(memoryDir, "memory.memsw.limit_in_bytes"), strconv.FormatInt(opts.MemorySwap, 10), "memory swap limit")
+	}
+	first, second := setMemory, setSwap
+	if opts.Memory > old.Memory && old.Memory > 0 {
+		first, second = setSwap, setMemory
+	}
+	if err := first(); err != nil {
+		return err
+	}
+	if err := second(); err != nil {
+		return err
+	}
+	if opts.MemoryReservation != old.MemoryReservation {
+		if err := write(filepath.Join(memoryDir, "memory.soft_limit_in_bytes"), strconv.FormatInt(opts.MemoryReservation, 10), "memory reservation"); err != nil {
+			return err
+		}
+	}
+
+	cpuDir := filepath.Join(cgroupPath, "cpu", cgroup)
+	if opts.CPUShares != old.CPUShares {
+		if err := write(filepath.Join(cpuDir, "cpu.shares"), strconv.FormatInt(opts.CPUShares, 10), "CPU shares"); err != nil {
+			return err
+		}
+	}
+	if opts.NanoCPUs != old.NanoCPUs {
+		quota := int64(-1)
+		if opts.NanoCPUs > 0 {
+			quota = cpuQuota(opts.NanoCPUs)
+		}
+		if err := write(filepath.Join(cpuDir, "cpu.cfs_period_us"), strconv.Itoa(cpuPeriod), "CPU period"); err != nil {
+			return err
+		}
+		if err := write(filepath.Join(cpuDir, "cpu.cfs_quota_us"), strconv.FormatInt(quota, 10), "CPU quota"); err != nil {
+			return err
+		}
+	}
+	if opts.PidsLimit != old.PidsLimit {
+		limit := "max"
+		if opts.PidsLimit > 0 {
+			limit = strconv.FormatInt(opts.PidsLimit, 10)
+		}
+		if err := write(filepath.Join(cgroupPath, "pids", cgroup, "pids.max"), limit, "pids limit"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
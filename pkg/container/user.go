@@ -0,0 +1,142 @@
+// pkg/container/user.go
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// User is the identity the processes of a container run as
+type User struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32 // Supplementary groups
+	Home   string   // Home directory, empty when the user has no passwd entry
+}
+
+// ResolveUser resolves a user given as name, uid, name:group or uid:gid,
+// groups being a name or a gid, against the /etc/passwd and /etc/group
+// files under root. Names must exist there, numeric ids need not. Unless
+// the group is given, the user gets the primary group of its passwd entry,
+// root's without one, and the groups listing it as a member as
+// supplementary groups.
+func ResolveUser(root, spec string) (*User, error) {
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+	if userPart == "" || (hasGroup && groupPart == "") {
+		return nil, fmt.Errorf("invalid user %q, expected NAME|UID[:GROUP|GID]", spec)
+	}
+
+	passwd, err := readIDFile(filepath.Join(root, "etc", "passwd"))
+	if err != nil {
+		return nil, err
+	}
+	user := &User{}
+	name := ""
+	if uid, err := strconv.ParseUint(userPart, 10, 32); err == nil {
+		user.Uid = uint32(uid)
+		for _, entry := range passwd {
+			if len(entry) >= 4 && entry[2] == userPart {
+				name = entry[0]
+				user.Gid = parseID(entry[3])
+				user.Home = field(entry, 5)
+				break
+			}
+		}
+	} else {
+		entry := findEntry(passwd, userPart)
+		if entry == nil || len(entry) < 4 {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		name = userPart
+		user.Uid = parseID(entry[2])
+		user.Gid = parseID(entry[3])
+		user.Home = field(entry, 5)
+	}
+
+	groups, err := readIDFile(filepath.Join(root, "etc", "group"))
+	if err != nil {
+		return nil, err
+	}
+	if hasGroup {
+		if gid, err := strconv.ParseUint(groupPart, 10, 32); err == nil {
+			user.Gid = uint32(gid)
+		} else {
+			entry := findEntry(groups, groupPart)
+			if entry == nil || len(entry) < 3 {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+			user.Gid = parseID(entry[2])
+		}
+		return user, nil
+	}
+
+	user.Groups = []uint32{}
+	if name == "" {
+		return user, nil
+	}
+	for _, entry := range groups {
+		if len(entry) < 4 || parseID(entry[2]) == user.Gid {
+			continue
+		}
+		for _, member := range strings.Split(entry[3], ",") {
+			if member == name {
+				user.Groups = append(user.Groups, parseID(entry[2]))
+				break
+			}
+		}
+	}
+	return user, nil
+}
+
+// readIDFile reads the colon-separated entries of a passwd or group file,
+// a missing file having none
+func readIDFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// findEntry returns the entry with the given name
+func findEntry(entries [][]string, name string) []string {
+	for _, entry := range entries {
+		if entry[0] == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+func parseID(s string) uint32 {
+	id, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(id)
+}
+
+func field(entry []string, i int) string {
+	if i < len(entry) {
+		return entry[i]
+	}
+	return ""
+}
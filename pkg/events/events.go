@@ -0,0 +1,272 @@
+// pkg/events/events.go
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Event types
+const (
+	TypeContainer = "container"
+	TypeImage     = "image"
+)
+
+const (
+	// maxLogSize is the size past which the events log is moved to
+	// logFile().1, replacing the one before
+	maxLogSize = 10 << 20
+	// pollInterval is how often Follow checks the log for new events
+	pollInterval = 200 * time.Millisecond
+)
+
+// Event is a change in the state of a container or an image, as recorded
+// in the events log
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Type       string            `json:"type"`   // TypeContainer or TypeImage
+	Action     string            `json:"action"` // e.g. "start", "die" or "pull"
+	ID         string            `json:"id"`     // Container ID, or image ID or reference
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// logFile returns the append-only log of the events of all floka
+// processes using the data root
+func logFile() string {
+	return config.DataPath("events.log")
+}
+
+// Log appends an event to the events log. Failing to record it is not
+// worth failing the operation it describes, so errors are only logged.
+func Log(typ, action, id string, attributes map[string]string) {
+	event := Event{Time: time.Now().UTC(), Type: typ, Action: action, ID: id, Attributes: attributes}
+	if err := appendEvent(event); err != nil {
+		logging.L().Warn("failed to record event", "type", typ, "action", action, "id", id, "err", err)
+	}
+}
+
+// appendEvent writes an event as a line of JSON. Lines written with
+// O_APPEND by concurrent processes don't interleave.
+func appendEvent(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := rotate(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(logFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves the events log aside once it grows past maxLogSize
+func rotate() error {
+	info, err := os.Stat(logFile())
+	if err != nil || info.Size() < maxLogSize {
+		return nil
+	}
+	lock, err := os.OpenFile(logFile()+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	// Another process may have rotated it while we waited
+	if info, err := os.Stat(logFile()); err != nil || info.Size() < maxLogSize {
+		return nil
+	}
+	return os.Rename(logFile(), logFile()+".1")
+}
+
+// Filter selects events. Empty fields match any event; values in a list
+// match events with any of them.
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Types   []string
+	Actions []string
+	// Containers matches container events by ID, ID prefix or name
+	Containers []string
+	// Images matches image events by ID or reference, and container
+	// events by the image of the container
+	Images []string
+}
+
+// Match reports whether the event passes the filter
+func (f *Filter) Match(e Event) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Actions) > 0 && !contains(f.Actions, e.Action) {
+		return false
+	}
+	if len(f.Containers) > 0 {
+		if e.Type != TypeContainer {
+			return false
+		}
+		found := false
+		for _, c := range f.Containers {
+			if strings.HasPrefix(e.ID, c) || e.Attributes["name"] == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Images) > 0 {
+		// Image events name the image by ID and reference, container
+		// events by the reference the container was created from
+		refs := []string{e.Attributes["image"]}
+		if e.Type == TypeImage {
+			refs = []string{e.ID, e.Attributes["name"]}
+		}
+		found := false
+		for _, img := range f.Images {
+			for _, ref := range refs {
+				if ref != "" && (ref == img || ref == img+":latest" || strings.HasPrefix(ref, "sha256:"+img)) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns the recorded events passing the filter, oldest first
+func Read(filter *Filter) ([]Event, error) {
+	var events []Event
+	for _, path := range []string{logFile() + ".1", logFile()} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read events: %w", err)
+		}
+		_, err = readEvents(f, filter, func(e Event) { events = append(events, e) })
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// Follow calls fn with every event passing the filter that is recorded
+// from now on, until ctx is cancelled or the filter's Until has passed
+func Follow(ctx context.Context, filter *Filter, fn func(Event)) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	var offset int64
+	if info, err := os.Stat(logFile()); err == nil {
+		offset = info.Size()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		// Reopen the log once it is rotated, reading the new one from
+		// its start
+		info, err := os.Stat(logFile())
+		if err == nil && f != nil {
+			if open, statErr := f.Stat(); statErr == nil && !os.SameFile(info, open) {
+				f.Close()
+				f, offset = nil, 0
+			}
+		}
+		if err == nil && f == nil {
+			if f, err = os.Open(logFile()); err != nil {
+				return fmt.Errorf("failed to read events: %w", err)
+			}
+		}
+		if f != nil {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read events: %w", err)
+			}
+			read, err := readEvents(f, filter, fn)
+			if err != nil {
+				return err
+			}
+			offset += read
+		}
+
+		if !filter.Until.IsZero() && time.Now().After(filter.Until) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readEvents calls fn with the events of r passing the filter and returns
+// the number of bytes read, up to the last complete line
+func readEvents(r io.Reader, filter *Filter, fn func(Event)) (int64, error) {
+	var read int64
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line being written is read once it is complete
+			return read, nil
+		}
+		if err != nil {
+			return read, fmt.Errorf("failed to read events: %w", err)
+		}
+		read += int64(len(line))
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			logging.L().Debug("skipping malformed event", "err", err)
+			continue
+		}
+		if filter == nil || filter.Match(event) {
+			fn(event)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// pkg/fimage/add.go
+package fimage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/flokafile"
+)
+
+// add runs ADD: like COPY, except that a local tar archive, compressed or
+// not, is extracted into the destination directory, and that an http(s)
+// source is downloaded. --checksum=sha256:<hex> verifies a download.
+func (b *builder) add(ctx context.Context, inst *flokafile.AddInst) error {
+	if inst.Checksum != "" {
+		if _, err := digestHex(inst.Checksum); err != nil {
+			return fmt.Errorf("invalid --checksum: %w", err)
+		}
+	}
+	if err := checkDest(len(inst.Sources)+len(inst.Heredocs), inst.Dest); err != nil {
+		return err
+	}
+	owner, err := b.copyOwner(inst.Chown)
+	if err != nil {
+		return err
+	}
+	mode, err := parseChmod(inst.Chmod)
+	if err != nil {
+		return err
+	}
+
+	var local []string
+	for _, src := range inst.Sources {
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			destPath, err := b.download(ctx, src, inst.Dest, inst.Checksum)
+			if err != nil {
+				return err
+			}
+			if err := setOwnerAndMode([]string{destPath}, owner, mode); err != nil {
+				return err
+			}
+			continue
+		}
+		if inst.Checksum != "" {
+			return fmt.Errorf("--checksum is only supported for http(s) sources")
+		}
+
+		matches, err := globSource(b.contextDir, src)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s not found in the build context", src)
+		}
+		for _, match := range matches {
+			extracted, err := b.extract(match, inst.Dest)
+			if err != nil {
+				return err
+			}
+			if !extracted {
+				local = append(local, match)
+			}
+		}
+	}
+	if err := checkDest(len(local)+len(inst.Heredocs), inst.Dest); err != nil {
+		return err
+	}
+	if err := b.copyFiles(b.contextDir, local, inst.Dest, owner, mode); err != nil {
+		return err
+	}
+	return b.copyHeredocs(inst.Heredocs, inst.Dest, owner, mode)
+}
+
+// extract extracts a tar archive of the build context into the destination
+// directory. It returns false for other files, which are copied instead.
+func (b *builder) extract(src, dest string) (bool, error) {
+	srcPath, err := fsutil.SecureJoin(b.contextDir, src)
+	if err != nil {
+		return false, err
+	}
+	r, closeArchive, err := openArchive(srcPath)
+	if err != nil || r == nil {
+		return false, err
+	}
+	defer closeArchive()
+
+	destPath, err := b.rootPath(dest)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		return false, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if err := extractLayer(r, destPath, false); err != nil {
+		return false, fmt.Errorf("failed to extract %s: %w", src, err)
+	}
+	return true, nil
+}
+
+// download fetches a file into the rootfs, named after the last element of
+// the URL path when dest is a directory, and returns its path. Like Docker,
+// the file is only readable by its owner and keeps the Last-Modified time.
+func (b *builder) download(ctx context.Context, src, dest, checksum string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", src, err)
+	}
+	if strings.HasSuffix(dest, "/") {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return "", fmt.Errorf("can't name the file downloaded from %s, give its name in the destination", src)
+		}
+		dest += name
+	}
+	destPath, err := b.rootPath(dest)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", src, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(destPath), ".download-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	if checksum != "" {
+		if err := checkDigest(h.Sum(nil), checksum); err != nil {
+			return "", fmt.Errorf("failed to verify %s: %w", src, err)
+		}
+	}
+
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return "", err
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(f.Name(), modified, modified)
+	}
+	if err := os.Rename(f.Name(), destPath); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	return destPath, nil
+}
+
+// openArchive opens a tar archive ADD extracts, decompressing it with gzip,
+// bzip2 or, through the xz command, xz. The reader is nil when the file
+// isn't such an archive. The returned function closes it.
+func openArchive(filename string) (io.Reader, func(), error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, err
+	}
+
+	closers := []func(){func() { f.Close() }}
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	var r io.Reader = bufio.NewReader(f)
+	magic, _ := r.(*bufio.Reader).Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil
+		}
+		r = gz
+		closers = append(closers, func() { gz.Close() })
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(r)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		cmd := exec.Command("xz", "-dc")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("the xz command is needed to extract %s: %w", filepath.Base(filename), err)
+		}
+		r = out
+		closers = append(closers, func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+	}
+
+	// Only tar archives are extracted, other files are copied as they are
+	br := bufio.NewReaderSize(r, 512)
+	header, _ := br.Peek(512)
+	if len(header) < 512 || !bytes.HasPrefix(header[257:], []byte("ustar")) {
+		closeAll()
+		return nil, nil, nil
+	}
+	return br, closeAll, nil
+}
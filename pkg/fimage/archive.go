@@ -0,0 +1,357 @@
+// pkg/fimage/archive.go
+package fimage
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+const (
+	// annotationImageName holds the full name:tag of an image in an
+	// archive's index, as containerd and Docker write it
+	annotationImageName = "io.containerd.image.name"
+	// annotationRefName holds the tag of an image in an OCI layout
+	annotationRefName = "org.opencontainers.image.ref.name"
+
+	ociLayoutVersion = "1.0.0"
+
+	// defaultRegistryAlias is the name Docker gives Docker Hub in
+	// references
+	defaultRegistryAlias = "docker.io"
+)
+
+// ociLayout is the oci-layout file marking the root of an image layout
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// Save writes the images named by refs to w as a tar archive in the OCI
+// image layout: their manifests, configs and layer blobs, and an index
+// naming them. Blobs shared by several images are written once.
+func Save(w io.Writer, refs []string) error {
+	idx := index{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: []Descriptor{}}
+	var blobs []string
+	var saved []*Image
+	seen := map[string]bool{}
+	addBlob := func(digest string) {
+		if !seen[digest] {
+			seen[digest] = true
+			blobs = append(blobs, digest)
+		}
+	}
+
+	for _, ref := range refs {
+		name, tag := ParseReference(ref)
+		img, err := loadImage(name, tag)
+		if err != nil {
+			return err
+		}
+		manifest, err := loadManifest(img.dir())
+		if err != nil {
+			return fmt.Errorf("failed to read manifest of image %s:%s: %w", name, tag, err)
+		}
+		mediaType := manifest.MediaType
+		if mediaType == "" {
+			mediaType = mediaTypeOCIManifest
+		}
+		desc, err := blobDescriptor(img.Digest, mediaType)
+		if err != nil {
+			return err
+		}
+		desc.Annotations = map[string]string{
+			annotationImageName: name + ":" + tag,
+			annotationRefName:   tag,
+		}
+		idx.Manifests = append(idx.Manifests, desc)
+		saved = append(saved, img)
+
+		addBlob(img.Digest)
+		addBlob(manifest.Config.Digest)
+		for _, layer := range manifest.Layers {
+			addBlob(layer.Digest)
+		}
+	}
+
+	layoutJSON, err := json.Marshal(ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+	indexJSON, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeArchiveFile(tw, "oci-layout", layoutJSON); err != nil {
+		return err
+	}
+	if err := writeArchiveFile(tw, "index.json", indexJSON); err != nil {
+		return err
+	}
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir,
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+		}); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	for _, digest := range blobs {
+		if err := writeArchiveBlob(tw, digest); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	for _, img := range saved {
+		logEvent("save", img.ID, img.Name+":"+img.Tag)
+	}
+	return nil
+}
+
+// writeArchiveFile adds a regular file holding data to an archive
+func writeArchiveFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// writeArchiveBlob copies a stored blob into an archive under
+// blobs/sha256/
+func writeArchiveBlob(tw *tar.Writer, digest string) error {
+	blobPath, err := BlobPath(digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return fmt.Errorf("blob %s is missing: %w", digest, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:"),
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// Load reads a tar archive in the OCI image layout, as written by Save or
+// docker save, stores its blobs and layers and tags the images it names.
+// Images in the archive without a name are skipped.
+func Load(r io.Reader) ([]*Image, error) {
+	var indexJSON []byte
+	foundLayout := false
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		switch {
+		case name == "oci-layout":
+			var layout ociLayout
+			if err := json.NewDecoder(tr).Decode(&layout); err != nil {
+				return nil, fmt.Errorf("failed to parse oci-layout: %w", err)
+			}
+			if layout.ImageLayoutVersion != ociLayoutVersion {
+				return nil, fmt.Errorf("unsupported image layout version %q", layout.ImageLayoutVersion)
+			}
+			foundLayout = true
+		case name == "index.json":
+			if indexJSON, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("failed to read index.json: %w", err)
+			}
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			if err := loadBlob(tr, "sha256:"+strings.TrimPrefix(name, "blobs/sha256/")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !foundLayout || indexJSON == nil {
+		return nil, fmt.Errorf("archive is not an OCI image layout: oci-layout or index.json is missing")
+	}
+
+	var idx index
+	if err := json.Unmarshal(indexJSON, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+	var images []*Image
+	for _, desc := range idx.Manifests {
+		name, tag, ok := archiveImageName(desc.Annotations)
+		if !ok {
+			logging.L().Warn("skipping image without a name", "digest", desc.Digest)
+			continue
+		}
+		img, err := loadArchivedImage(desc, name, tag)
+		if err != nil {
+			return images, fmt.Errorf("failed to load image %s:%s: %w", name, tag, err)
+		}
+		logEvent("load", img.ID, name+":"+tag)
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// loadBlob stores a blob read from an archive, checking it against the
+// digest it is named after. Blobs already stored are skipped.
+func loadBlob(r io.Reader, digest string) error {
+	if _, err := digestHex(digest); err != nil {
+		return err
+	}
+	if hasBlob(digest) {
+		return nil
+	}
+
+	f, err := createBlobFile()
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	if err := checkDigest(hash.Sum(nil), digest); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("blob %s: %w", shortDigest(digest), err)
+	}
+	return putBlob(f.Name(), digest)
+}
+
+// archiveImageName returns the name and tag an archive's index gives an
+// image. Docker writes the full reference with its docker.io prefix.
+func archiveImageName(annotations map[string]string) (name, tag string, ok bool) {
+	ref := annotations[annotationImageName]
+	if ref == "" {
+		// A bare ref.name is only a tag, it takes a full reference to
+		// know which image it names
+		ref = annotations[annotationRefName]
+		if !strings.ContainsAny(ref, ":/") {
+			return "", "", false
+		}
+	}
+	ref = strings.TrimPrefix(ref, defaultRegistryAlias+"/library/")
+	ref = strings.TrimPrefix(ref, defaultRegistryAlias+"/")
+	name, tag = ParseReference(ref)
+	return name, tag, true
+}
+
+// loadArchivedImage unpacks the layers of an image whose blobs were loaded
+// from an archive and stores it as name:tag
+func loadArchivedImage(desc Descriptor, name, tag string) (*Image, error) {
+	manifestJSON, err := readBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// An index in the archive holds the image for several platforms
+	if desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerList {
+		var nested index
+		if err := json.Unmarshal(manifestJSON, &nested); err != nil {
+			return nil, fmt.Errorf("failed to parse image index: %w", err)
+		}
+		platformDesc, err := selectPlatform(nested.Manifests)
+		if err != nil {
+			return nil, err
+		}
+		desc = *platformDesc
+		if manifestJSON, err = readBlob(desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	configJSON, err := readBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %w", err)
+	}
+	diffIDs := config.RootFS.DiffIDs
+	if len(diffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("image config lists %d layers but the manifest has %d", len(diffIDs), len(manifest.Layers))
+	}
+
+	for i, layer := range manifest.Layers {
+		blobPath, err := BlobPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(blobPath); err != nil {
+			return nil, fmt.Errorf("layer %s is missing from the archive", shortDigest(layer.Digest))
+		}
+		if err := unpackLayer(blobPath, diffIDs[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return storeImage(name, tag, manifestJSON, configJSON)
+}
+
+// readBlob returns the content of a stored blob
+func readBlob(digest string) ([]byte, error) {
+	blobPath, err := BlobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(blobPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("blob %s is missing from the archive", shortDigest(digest))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,150 @@
+// pkg/fimage/auth.go
+package fimage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// AuthEnv names the file floka login saves credentials to, instead of
+// auth.json next to the config file
+const AuthEnv = "FLOKA_AUTH"
+
+// Credentials log in to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// authConfig is the content of the credentials file, in the format of
+// Docker's config.json
+type authConfig struct {
+	Auths map[string]authEntry `json:"auths"`
+}
+
+type authEntry struct {
+	Auth string `json:"auth"` // base64 of username:password
+}
+
+// AuthPath returns the location of the credentials file
+func AuthPath() string {
+	if path := os.Getenv(AuthEnv); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(config.Path()), "auth.json")
+}
+
+// RegistryHost returns the registry a floka login argument names, the
+// registry of Docker Hub when empty
+func RegistryHost(server string) string {
+	server = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://"), "/")
+	switch server {
+	case "", "docker.io", "index.docker.io":
+		return defaultRegistry
+	}
+	return server
+}
+
+// loadAuthConfig reads the credentials file, empty if missing
+func loadAuthConfig() (*authConfig, error) {
+	cfg := &authConfig{}
+	data, err := os.ReadFile(AuthPath())
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", AuthPath(), err)
+	}
+	return cfg, nil
+}
+
+// saveAuthConfig writes the credentials file, readable by its owner only
+func saveAuthConfig(cfg *authConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(AuthPath()), 0700); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	tmp := AuthPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return os.Rename(tmp, AuthPath())
+}
+
+// lookupCredentials returns the saved credentials for a registry, nil if
+// there are none
+func lookupCredentials(registry string) *Credentials {
+	cfg, err := loadAuthConfig()
+	if err != nil {
+		logging.L().Warn("ignoring saved credentials", "err", err)
+		return nil
+	}
+	entry, ok := cfg.Auths[registry]
+	if !ok && registry == defaultRegistry {
+		// As saved by docker login
+		entry, ok = cfg.Auths["https://index.docker.io/v1/"]
+	}
+	if !ok {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		logging.L().Warn("ignoring invalid saved credentials", "registry", registry)
+		return nil
+	}
+	username, password, _ := strings.Cut(string(decoded), ":")
+	return &Credentials{Username: username, Password: password}
+}
+
+// Login checks credentials against a registry and saves them for pulls and
+// pushes
+func Login(ctx context.Context, registry string, creds Credentials) error {
+	client := &registryClient{http: http.DefaultClient, registry: registry, creds: &creds}
+	resp, err := client.do(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to log in to %s: %w", registry, err)
+	}
+	resp.Body.Close()
+	// A registry open to anyone never asks for the credentials
+	if client.token == "" && !client.basic {
+		logging.L().Debug("registry did not ask for credentials", "registry", registry)
+	}
+
+	cfg, err := loadAuthConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Auths == nil {
+		cfg.Auths = map[string]authEntry{}
+	}
+	cfg.Auths[registry] = authEntry{Auth: base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))}
+	return saveAuthConfig(cfg)
+}
+
+// Logout removes the saved credentials for a registry
+func Logout(registry string) error {
+	cfg, err := loadAuthConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Auths[registry]; !ok {
+		return fmt.Errorf("not logged in to %s", registry)
+	}
+	delete(cfg.Auths, registry)
+	return saveAuthConfig(cfg)
+}
@@ -0,0 +1,133 @@
+// pkg/fimage/blobs.go
+package fimage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// mediaTypeOCIConfig is the media type of image configs floka writes
+const mediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+
+// blobsDir is where manifests, configs and layers are stored by digest,
+// shared by all images
+func blobsDir() string {
+	return config.DataPath("blobs", "sha256")
+}
+
+// BlobPath returns where the blob with the given sha256 digest is stored
+func BlobPath(digest string) (string, error) {
+	hexSum, err := digestHex(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(blobsDir(), hexSum), nil
+}
+
+// digestHex returns the hex part of a sha256 digest, checking that it is
+// one so it can safely be used as a file name
+func digestHex(digest string) (string, error) {
+	algorithm, hexSum, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	if _, err := hex.DecodeString(hexSum); err != nil || len(hexSum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return hexSum, nil
+}
+
+// hasBlob reports whether a blob is stored and still matches its digest
+func hasBlob(digest string) bool {
+	return VerifyBlob(digest) == nil
+}
+
+// VerifyBlob checks that a stored blob still hashes to its digest
+func VerifyBlob(digest string) error {
+	path, err := BlobPath(digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	return checkDigest(hash.Sum(nil), digest)
+}
+
+// createBlobFile creates a temporary file next to the blobs, to be moved
+// into the store with putBlob once its digest is known
+func createBlobFile() (*os.File, error) {
+	if err := os.MkdirAll(blobsDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	f, err := os.CreateTemp(blobsDir(), ".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob file: %w", err)
+	}
+	return f, nil
+}
+
+// putBlob moves a file created by createBlobFile into the store under
+// digest, which the caller has verified
+func putBlob(tmpPath, digest string) error {
+	path, err := BlobPath(digest)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to store blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// writeBlob stores data in the store and returns its descriptor
+func writeBlob(data []byte, mediaType string) (Descriptor, error) {
+	f, err := createBlobFile()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return Descriptor{}, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	desc := Descriptor{
+		MediaType: mediaType,
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+		Size:      int64(len(data)),
+	}
+	return desc, putBlob(f.Name(), desc.Digest)
+}
+
+// blobDescriptor describes a stored blob
+func blobDescriptor(digest, mediaType string) (Descriptor, error) {
+	path, err := BlobPath(digest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("blob %s is missing: %w", digest, err)
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: info.Size()}, nil
+}
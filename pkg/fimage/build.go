@@ -0,0 +1,713 @@
+// pkg/fimage/build.go
+package fimage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/flokafile"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// BuildOptions configures Build
+type BuildOptions struct {
+	Flokafile  string   // Path to the Flokafile, <ContextDir>/flokafile when empty
+	ContextDir string   // Directory COPY sources are resolved in, less what its .flokaignore leaves out
+	Tag        string   // name[:tag] of the new image
+	BuildArgs  []string // KEY=VALUE values for the ARG instructions
+	Target     string   // Stage to stop at, the last one when empty
+}
+
+// builder holds the state of a build while its instructions run. Most of
+// it is the state of the current stage, reset by each FROM.
+type builder struct {
+	opts       BuildOptions
+	tmpDir     string
+	contextDir string // Copy of the build context COPY reads from
+	name       string // Name of the stage given with FROM ... AS
+	rootDir    string
+	layers     []Descriptor         // Layer blobs of the image so far
+	diffIDs    []string             // Digests of the uncompressed layers
+	files      map[string]fileState // Rootfs as of the last committed layer
+	volumes    []string
+	env        []string
+	workDir    string
+	user       string
+	ports      map[string]struct{} // Exposed ports of the base image and EXPOSE
+	labels     map[string]string   // Labels of the base image and LABEL
+	history    []History
+
+	buildArgs  map[string]string // Values given in BuildOptions.BuildArgs
+	usedArgs   map[string]bool   // Build args an ARG instruction declared
+	globalArgs map[string]string // ARGs declared before FROM, usable in FROM
+	args       map[string]string // ARGs of the image, usable after FROM
+	inStage    bool              // FROM has run
+
+	stages  []*builder        // Finished stages, first one first
+	keep    []bool            // Whether each stage leads to the image, so its layers are committed
+	layered bool              // keep of the current stage
+	images  map[string]string // Rootfs of the images COPY --from extracted
+
+	entrypoint  []string
+	cmd         []string
+	cmdFromBase bool // cmd was inherited and is dropped by a new ENTRYPOINT
+}
+
+// Build creates a new image by running the instructions of a Flokafile.
+// Cancelling ctx stops the build after the current step.
+func Build(ctx context.Context, opts BuildOptions) (*Image, error) {
+	if opts.ContextDir == "" {
+		opts.ContextDir = "."
+	}
+	if opts.Flokafile == "" {
+		opts.Flokafile = FindFlokafile(opts.ContextDir)
+	}
+	if opts.Tag == "" {
+		return nil, fmt.Errorf("no tag given for the image")
+	}
+	if info, err := os.Stat(opts.ContextDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", opts.ContextDir)
+	}
+
+	name, tag := ParseReference(opts.Tag)
+	imageFullName := fmt.Sprintf("%s:%s", name, tag)
+	if _, err := loadImage(name, tag); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageExists, imageFullName)
+	}
+
+	file, err := flokafile.Parse(opts.Flokafile)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := planStages(file)
+	if err != nil {
+		return nil, err
+	}
+	target := len(stages) - 1
+	if opts.Target != "" {
+		names := make([]string, len(stages))
+		for i, s := range stages {
+			names[i] = s.name
+		}
+		target = stageIndex(names, opts.Target, false)
+		if target < 0 {
+			return nil, fmt.Errorf("target stage %s not found in %s", opts.Target, opts.Flokafile)
+		}
+	}
+	instructions := file.Instructions[:stages[target].end]
+
+	fmt.Fprintf(Progress, "Building %s from %s\n", imageFullName, opts.Flokafile)
+
+	// Build in a temporary directory so a failed build leaves nothing behind
+	if err := os.MkdirAll(config.DataPath("images"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create images directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(config.DataPath("images"), ".build-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary image directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	contextDir := filepath.Join(tmpDir, "context")
+	size, err := copyContext(opts.ContextDir, contextDir)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(Progress, "Copying build context (%d bytes)\n", size)
+
+	b := &builder{
+		opts:       opts,
+		tmpDir:     tmpDir,
+		contextDir: contextDir,
+		buildArgs:  map[string]string{},
+		usedArgs:   map[string]bool{},
+		globalArgs: map[string]string{},
+		args:       map[string]string{},
+		keep:       keptStages(stages, target),
+		images:     map[string]string{},
+	}
+	for _, kv := range opts.BuildArgs {
+		key, value, _ := strings.Cut(kv, "=")
+		b.buildArgs[key] = value
+	}
+
+	for i, inst := range instructions {
+		fmt.Fprintf(Progress, "Step %d/%d : %s\n", i+1, len(instructions), inst)
+		if err := b.expand(inst); err != nil {
+			return nil, fmt.Errorf("%s: step %d (%s): %w", inst.Pos(), i+1, inst.Name(), err)
+		}
+		if err := b.execute(ctx, inst); err != nil {
+			return nil, fmt.Errorf("%s: step %d (%s): %w", inst.Pos(), i+1, inst.Name(), err)
+		}
+		// Stages the image isn't made from only provide files to COPY
+		if !b.layered {
+			continue
+		}
+		layers := len(b.layers)
+		if err := b.commit(); err != nil {
+			return nil, fmt.Errorf("%s: step %d (%s): %w", inst.Pos(), i+1, inst.Name(), err)
+		}
+		// The base image's history stands for FROM and the ARGs before it
+		if _, ok := inst.(*flokafile.FromInst); !ok {
+			b.history = append(b.history, History{
+				Created:    time.Now(),
+				CreatedBy:  inst.String(),
+				EmptyLayer: len(b.layers) == layers,
+			})
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var unused []string
+	for key := range b.buildArgs {
+		if !b.usedArgs[key] {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Fprintf(Progress, "[Warning] One or more build args %v were not consumed\n", unused)
+	}
+
+	// The image is identified by the digest of its config, which lists
+	// the digests of its layers
+	created := time.Now()
+	configDesc, err := saveConfig(tmpDir, b.config(created))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image config: %w", err)
+	}
+	if _, err := saveManifest(tmpDir, &Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        append([]Descriptor{}, b.layers...),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save image manifest: %w", err)
+	}
+
+	// Containers mount the image from the layer store, where layers of the
+	// base image already are
+	for i, layer := range b.layers {
+		blobPath, err := BlobPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := unpackLayer(blobPath, b.diffIDs[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.RemoveAll(b.rootDir); err != nil {
+		return nil, fmt.Errorf("failed to remove build rootfs: %w", err)
+	}
+
+	img, err := addImage(tmpDir, name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(Progress, "Successfully built %s\n", shortDigest(img.ID))
+	fmt.Fprintf(Progress, "Successfully tagged %s\n", imageFullName)
+	logEvent("build", img.ID, imageFullName)
+	return img, nil
+}
+
+// stagePlan is a stage of a Flokafile: a FROM instruction and those up to
+// the next one
+type stagePlan struct {
+	name string // Given with FROM ... AS, lower case
+	base string // Image or stage the stage starts from
+	end  int    // Index of the instruction after the stage's last one
+}
+
+// planStages splits the instructions of a Flokafile into stages, checking
+// that only ARGs come before the first FROM and that stage names are unique
+func planStages(file *flokafile.Flokafile) ([]stagePlan, error) {
+	var stages []stagePlan
+	for i, inst := range file.Instructions {
+		from, ok := inst.(*flokafile.FromInst)
+		if !ok {
+			if _, isArg := inst.(*flokafile.ArgInst); len(stages) == 0 && !isArg {
+				break
+			}
+			continue
+		}
+		if from.Stage != "" {
+			for _, s := range stages {
+				if s.name == from.Stage {
+					return nil, fmt.Errorf("%s: duplicate stage name %s", from.Pos(), from.Stage)
+				}
+			}
+		}
+		if len(stages) > 0 {
+			stages[len(stages)-1].end = i
+		}
+		stages = append(stages, stagePlan{name: from.Stage, base: from.Image})
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("%s: must start with a FROM instruction", file.Path)
+	}
+	stages[len(stages)-1].end = len(file.Instructions)
+	return stages, nil
+}
+
+// keptStages tells for each stage whether the image of target is built on
+// it: target itself and the stages it comes FROM, directly or not
+func keptStages(stages []stagePlan, target int) []bool {
+	keep := make([]bool, len(stages))
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = s.name
+	}
+	for i := target; i >= 0; i = stageIndex(names[:i], stages[i].base, false) {
+		keep[i] = true
+	}
+	return keep
+}
+
+// stageIndex returns the index of the stage named ref, or numbered ref when
+// byIndex is set, -1 if there is none
+func stageIndex(names []string, ref string, byIndex bool) int {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(name, ref) {
+			return i
+		}
+	}
+	if byIndex {
+		if i, err := strconv.Atoi(ref); err == nil && i >= 0 && i < len(names) {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindFlokafile returns the Flokafile of a build context, accepting both
+// spellings of the name
+func FindFlokafile(contextDir string) string {
+	for _, name := range []string{"flokafile", "Flokafile"} {
+		path := filepath.Join(contextDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(contextDir, "flokafile")
+}
+
+// execute runs a single instruction against the build rootfs
+func (b *builder) execute(ctx context.Context, inst flokafile.Instruction) error {
+	switch inst := inst.(type) {
+	case *flokafile.FromInst:
+		return b.from(ctx, inst)
+	case *flokafile.RunInst:
+		return b.run(ctx, inst)
+	case *flokafile.CopyInst:
+		return b.copy(ctx, inst)
+	case *flokafile.EnvInst:
+		b.setEnv(inst)
+	case *flokafile.VolumeInst:
+		return b.volume(inst)
+	case *flokafile.CmdInst:
+		b.setCmd(inst)
+	case *flokafile.EntrypointInst:
+		b.setEntrypoint(inst)
+	case *flokafile.WorkdirInst:
+		return b.setWorkDir(inst)
+	case *flokafile.UserInst:
+		b.user = inst.User
+	case *flokafile.ArgInst:
+		b.arg(inst)
+	case *flokafile.AddInst:
+		return b.add(ctx, inst)
+	case *flokafile.LabelInst:
+		b.setLabels(inst)
+	case *flokafile.ExposeInst:
+		return b.expose(inst)
+	default:
+		return fmt.Errorf("unsupported instruction %s", inst.Name())
+	}
+	return nil
+}
+
+// from starts a stage, filling its rootfs with a copy of the base image
+// or of the earlier stage it names
+func (b *builder) from(ctx context.Context, inst *flokafile.FromInst) error {
+	ref := inst.Image
+	if err := b.startStage(inst.Stage); err != nil {
+		return err
+	}
+	if ref == "scratch" {
+		return nil
+	}
+	if i := stageIndex(b.stageNames(), ref, false); i >= 0 {
+		return b.fromStage(b.stages[i])
+	}
+
+	name, tag := ParseReference(ref)
+	base, err := Pull(ctx, name, tag, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get base image %s: %w", ref, err)
+	}
+	if err := base.extractTo(b.rootDir); err != nil {
+		return fmt.Errorf("failed to copy base image: %w", err)
+	}
+	b.volumes = append(b.volumes, base.Volumes...)
+	b.env = base.Env
+	b.entrypoint, b.cmd = base.Entrypoint, base.Cmd
+	b.cmdFromBase = len(base.Cmd) > 0
+	b.workDir, b.user = base.WorkingDir, base.User
+	// The base image's layers come first; the copy itself isn't a change
+	baseDir := base.dir()
+	manifest, err := loadManifest(baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of base image: %w", err)
+	}
+	baseConfig, err := loadConfig(baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to read config of base image: %w", err)
+	}
+	b.layers = append(b.layers, manifest.Layers...)
+	b.diffIDs = append(b.diffIDs, baseConfig.RootFS.DiffIDs...)
+	b.ports, b.labels = baseConfig.Config.ExposedPorts, baseConfig.Config.Labels
+	b.history = append(b.history, baseConfig.History...)
+	b.files, err = snapshot(b.rootDir)
+	return err
+}
+
+// startStage ends the current stage, if any, and starts one named name in
+// an empty rootfs
+func (b *builder) startStage(name string) error {
+	if b.inStage {
+		done := *b
+		b.stages = append(b.stages, &done)
+	}
+	index := len(b.stages)
+	*b = builder{
+		opts:       b.opts,
+		tmpDir:     b.tmpDir,
+		contextDir: b.contextDir,
+		name:       name,
+		rootDir:    filepath.Join(b.tmpDir, fmt.Sprintf("stage-%d", index)),
+		buildArgs:  b.buildArgs,
+		usedArgs:   b.usedArgs,
+		globalArgs: b.globalArgs,
+		args:       map[string]string{},
+		inStage:    true,
+		stages:     b.stages,
+		keep:       b.keep,
+		layered:    b.keep[index],
+		images:     b.images,
+	}
+	if err := os.MkdirAll(b.rootDir, 0755); err != nil {
+		return fmt.Errorf("failed to create stage directory: %w", err)
+	}
+	return nil
+}
+
+// fromStage fills the rootfs with a copy of an earlier stage, whose
+// layers and config the stage builds on
+func (b *builder) fromStage(base *builder) error {
+	if err := fsutil.CopyTree(base.rootDir, b.rootDir); err != nil {
+		return fmt.Errorf("failed to copy stage %s: %w", base.name, err)
+	}
+	b.layers = append([]Descriptor{}, base.layers...)
+	b.diffIDs = append([]string{}, base.diffIDs...)
+	b.files = base.files
+	b.volumes = append([]string{}, base.volumes...)
+	b.env, b.workDir, b.user = base.env, base.workDir, base.user
+	b.ports, b.labels = maps.Clone(base.ports), maps.Clone(base.labels)
+	b.history = append([]History{}, base.history...)
+	b.entrypoint, b.cmd, b.cmdFromBase = base.entrypoint, base.cmd, base.cmdFromBase
+	return nil
+}
+
+// stageNames returns the names of the finished stages, in order
+func (b *builder) stageNames() []string {
+	names := make([]string, len(b.stages))
+	for i, s := range b.stages {
+		names[i] = s.name
+	}
+	return names
+}
+
+// stageRoot returns the rootfs COPY --from=ref copies from: that of the
+// stage with that name or index, or else of the image ref, extracted once
+func (b *builder) stageRoot(ctx context.Context, ref string) (string, error) {
+	if i := stageIndex(b.stageNames(), ref, true); i >= 0 {
+		return b.stages[i].rootDir, nil
+	}
+	if b.name != "" && strings.EqualFold(ref, b.name) {
+		return "", fmt.Errorf("COPY --from can't refer to its own stage %s", ref)
+	}
+	if dir, ok := b.images[ref]; ok {
+		return dir, nil
+	}
+	name, tag := ParseReference(ref)
+	img, err := Pull(ctx, name, tag, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image %s: %w", ref, err)
+	}
+	dir := filepath.Join(b.tmpDir, fmt.Sprintf("image-%d", len(b.images)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %w", err)
+	}
+	if err := img.extractTo(dir); err != nil {
+		return "", fmt.Errorf("failed to extract image %s: %w", ref, err)
+	}
+	b.images[ref] = dir
+	return dir, nil
+}
+
+// run executes a command, in exec or shell form, in a transient container
+// on top of the build rootfs, so its changes land in the image
+func (b *builder) run(ctx context.Context, inst *flokafile.RunInst) error {
+	cont, err := container.Run(ctx, b.opts.Tag, inst.Argv(), &container.ContainerOpts{
+		Layers:        []string{b.rootDir},
+		Env:           container.MergeEnv(b.argEnv(), b.env),
+		WorkingDir:    b.workDir,
+		User:          b.user,
+		LogConfig:     container.LogConfig{Type: "none"},
+		StorageDriver: container.StorageBind,
+	})
+	if cont != nil {
+		if removeErr := cont.Remove(); removeErr != nil {
+			logging.L().Warn("failed to remove build container", "container", cont.ID, "err", removeErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("command %q failed: %w", inst.Args, err)
+	}
+	return nil
+}
+
+// commit stores the changes made since the last commit as a new layer
+func (b *builder) commit() error {
+	if b.files == nil {
+		// Nothing before this step, e.g. FROM scratch
+		b.files = map[string]fileState{}
+	}
+	files, err := snapshot(b.rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to scan rootfs: %w", err)
+	}
+	layer, err := commitLayer(b.rootDir, b.files, files)
+	if err != nil {
+		return err
+	}
+	b.files = files
+	if layer != nil {
+		b.layers = append(b.layers, *layer)
+		b.diffIDs = append(b.diffIDs, layer.Digest)
+		fmt.Fprintf(Progress, " ---> %s\n", shortDigest(layer.Digest))
+	}
+	return nil
+}
+
+// setCmd sets the default command of the image
+func (b *builder) setCmd(inst *flokafile.CmdInst) {
+	b.cmd, b.cmdFromBase = inst.Argv(), false
+}
+
+// setEntrypoint sets the entrypoint of the image. A default command
+// inherited from the base image was meant for the base's entrypoint, so
+// it is dropped.
+func (b *builder) setEntrypoint(inst *flokafile.EntrypointInst) {
+	b.entrypoint = inst.Argv()
+	if b.cmdFromBase {
+		b.cmd, b.cmdFromBase = nil, false
+	}
+}
+
+// config returns the image config describing the result of the build
+func (b *builder) config(created time.Time) *ImageConfig {
+	config := &ImageConfig{
+		Created:      created,
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Config: RunConfig{
+			Env:          b.env,
+			Entrypoint:   b.entrypoint,
+			Cmd:          b.cmd,
+			WorkingDir:   b.workDir,
+			ExposedPorts: b.ports,
+			Labels:       b.labels,
+			User:         b.user,
+		},
+		RootFS:  RootFS{Type: "layers", DiffIDs: append([]string{}, b.diffIDs...)},
+		History: b.history,
+	}
+	if len(b.volumes) > 0 {
+		config.Config.Volumes = map[string]struct{}{}
+		for _, v := range b.volumes {
+			config.Config.Volumes[v] = struct{}{}
+		}
+	}
+	return config
+}
+
+// rootPath returns where a path of the image, relative to the working
+// directory, is in the rootfs, which it can't escape
+func (b *builder) rootPath(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(b.workingDir(), p)
+	}
+	return fsutil.SecureJoin(b.rootDir, p)
+}
+
+// setWorkDir sets the directory later RUN steps and containers start in,
+// creating it. A relative path is relative to the previous one.
+func (b *builder) setWorkDir(inst *flokafile.WorkdirInst) error {
+	dir := inst.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(b.workingDir(), dir)
+	}
+	path, err := fsutil.SecureJoin(b.rootDir, dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	b.workDir = filepath.Clean(dir)
+	return nil
+}
+
+// workingDir returns the directory relative paths are resolved in
+func (b *builder) workingDir() string {
+	if b.workDir == "" {
+		return "/"
+	}
+	return b.workDir
+}
+
+// arg declares a build-time variable, NAME or NAME=DEFAULT, set to its
+// build arg when there is one. Unlike ENV, it stays out of the image config.
+// Declared in the image without a default, it takes the value of an ARG of
+// the same name before FROM.
+func (b *builder) arg(inst *flokafile.ArgInst) {
+	name, value, hasDefault := inst.Var, inst.Default, inst.HasDefault
+	scope := b.args
+	if !b.inStage {
+		scope = b.globalArgs
+	}
+	if v, ok := b.buildArgs[name]; ok {
+		value, hasDefault = v, true
+		b.usedArgs[name] = true
+	} else if v, ok := b.globalArgs[name]; ok && b.inStage && !hasDefault {
+		value, hasDefault = v, true
+	}
+	if hasDefault {
+		scope[name] = value
+	}
+}
+
+// argEnv returns the ARGs of the image as KEY=VALUE entries, which RUN
+// steps get in their environment
+func (b *builder) argEnv() []string {
+	var env []string
+	for key, value := range b.args {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// expand substitutes the variables set with ENV and ARG into the arguments
+// of an instruction. Commands are left to the shell that runs them.
+func (b *builder) expand(inst flokafile.Instruction) error {
+	e, ok := inst.(flokafile.Expandable)
+	if !ok {
+		return nil
+	}
+	return e.Expand(func(name string) (string, bool) {
+		if !b.inStage {
+			value, ok := b.globalArgs[name]
+			return value, ok
+		}
+		if value, ok := container.LookupEnv(b.env, name); ok {
+			return value, true
+		}
+		value, ok := b.args[name]
+		return value, ok
+	})
+}
+
+// setEnv sets variables in the image config, where RUN steps and
+// containers pick them up
+func (b *builder) setEnv(inst *flokafile.EnvInst) {
+	var env []string
+	for _, v := range inst.Vars {
+		env = append(env, v.Key+"="+v.Value)
+	}
+	b.env = container.MergeEnv(b.env, env)
+}
+
+// setLabels adds labels to the image config, replacing those of the same
+// key it inherited
+func (b *builder) setLabels(inst *flokafile.LabelInst) {
+	if b.labels == nil {
+		b.labels = map[string]string{}
+	}
+	for _, l := range inst.Labels {
+		b.labels[l.Key] = l.Value
+	}
+}
+
+// expose records ports, PORT[/PROTO] or a range FIRST-LAST[/PROTO], as
+// exposed in the image config, where run -P finds them
+func (b *builder) expose(inst *flokafile.ExposeInst) error {
+	if b.ports == nil {
+		b.ports = map[string]struct{}{}
+	}
+	for _, spec := range inst.Ports {
+		ports, proto, _ := strings.Cut(spec, "/")
+		proto = strings.ToLower(proto)
+		if proto == "" {
+			proto = "tcp"
+		}
+		if proto != "tcp" && proto != "udp" {
+			return fmt.Errorf("invalid protocol in port %q, expected tcp or udp", spec)
+		}
+		first, last, isRange := strings.Cut(ports, "-")
+		if !isRange {
+			last = first
+		}
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return fmt.Errorf("invalid port %q", spec)
+		}
+		for port := from; port <= to; port++ {
+			b.ports[fmt.Sprintf("%d/%s", port, proto)] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// volume declares mount points that get an anonymous volume at run time
+func (b *builder) volume(inst *flokafile.VolumeInst) error {
+	for _, p := range inst.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("VOLUME path must be absolute: %s", p)
+		}
+		dir, err := fsutil.SecureJoin(b.rootDir, p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create volume directory: %w", err)
+		}
+		b.volumes = append(b.volumes, p)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// pkg/fimage/config.go
+package fimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ImageConfig is the part of an OCI image config floka reads and writes
+type ImageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture,omitempty"`
+	OS           string    `json:"os,omitempty"`
+	Config       RunConfig `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// History describes the instruction that produced a layer of an image, or
+// only changed its config when EmptyLayer is set
+type History struct {
+	Created    time.Time `json:"created,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// RootFS lists the digests of the uncompressed layers of an image
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// RunConfig holds the defaults for containers started from an image
+type RunConfig struct {
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	User         string              `json:"User,omitempty"`
+}
+
+// loadConfig reads the image config saved in an image directory
+func loadConfig(imageDir string) (*ImageConfig, error) {
+	data, err := os.ReadFile(filepath.Join(imageDir, "metadata", "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %w", err)
+	}
+	return &config, nil
+}
+
+// volumes returns the paths declared as volumes, sorted
+func (c *ImageConfig) volumes() []string {
+	var volumes []string
+	for volume := range c.Config.Volumes {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	return volumes
+}
+
+// exposedPorts returns the ports declared as exposed, e.g. 80/tcp, sorted
+func (c *ImageConfig) exposedPorts() []string {
+	var ports []string
+	for port := range c.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// saveConfig stores the image config as a blob and in an image directory
+// and returns its descriptor, whose digest is the image ID
+func saveConfig(imageDir string, config *ImageConfig) (Descriptor, error) {
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to serialize image config: %w", err)
+	}
+	return saveMetadataBlob(imageDir, "config.json", data, mediaTypeOCIConfig)
+}
+
+// saveManifest stores an image manifest as a blob and in an image
+// directory and returns its descriptor, whose digest is the image digest
+func saveManifest(imageDir string, manifest *Manifest) (Descriptor, error) {
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to serialize image manifest: %w", err)
+	}
+	return saveMetadataBlob(imageDir, "manifest.json", data, mediaTypeOCIManifest)
+}
+
+// saveMetadataBlob writes data to the blob store and to the metadata
+// directory of an image under name
+func saveMetadataBlob(imageDir, name string, data []byte, mediaType string) (Descriptor, error) {
+	desc, err := writeBlob(data, mediaType)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	metadataDir := filepath.Join(imageDir, "metadata")
+	if err := os.MkdirAll(metadataDir, 0755); err != nil {
+		return Descriptor{}, fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	return desc, os.WriteFile(filepath.Join(metadataDir, name), data, 0644)
+}
+
+// Command returns what a container started from the image runs. args
+// replace the image's default command and, like the default command, are
+// appended to the entrypoint. It is empty when neither the image nor args
+// say what to run.
+func (img *Image) Command(args []string) []string {
+	if len(args) == 0 {
+		args = img.Cmd
+	}
+	command := append([]string{}, img.Entrypoint...)
+	return append(command, args...)
+}
@@ -0,0 +1,60 @@
+// pkg/fimage/context.go
+package fimage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/bensdz/floka/pkg/flokafile"
+)
+
+// copyContext tars the build context, less the paths its .flokaignore
+// leaves out, and unpacks it into dir, where COPY finds its sources.
+// Changes made to the context during the build don't reach the image.
+// It returns the size of the tar.
+func copyContext(contextDir, dir string) (int64, error) {
+	ignore, err := flokafile.ReadIgnore(filepath.Join(contextDir, flokafile.IgnoreFile))
+	if err != nil {
+		return 0, err
+	}
+	var files []string
+	err = filepath.WalkDir(contextDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.Ignored(rel) {
+			// Exceptions may include paths below an ignored directory
+			if d.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read build context: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create build context directory: %w", err)
+	}
+	pr, pw := io.Pipe()
+	var size countingWriter
+	go func() {
+		pw.CloseWithError(writeLayer(io.MultiWriter(pw, &size), contextDir, files, nil))
+	}()
+	if err := extractLayer(pr, dir, false); err != nil {
+		pr.CloseWithError(err)
+		return 0, fmt.Errorf("failed to copy build context: %w", err)
+	}
+	return size.n, nil
+}
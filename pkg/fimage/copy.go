@@ -0,0 +1,224 @@
+// pkg/fimage/copy.go
+package fimage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/flokafile"
+)
+
+// copy runs COPY: copies files and directories matching the sources from
+// the build context, or with --from from an earlier stage or an image, and
+// here-documents into the rootfs. A directory has its contents copied.
+// Several sources need a destination directory ending with /.
+func (b *builder) copy(ctx context.Context, inst *flokafile.CopyInst) error {
+	srcRoot, where := b.contextDir, "the build context"
+	if inst.From != "" {
+		var err error
+		if srcRoot, err = b.stageRoot(ctx, inst.From); err != nil {
+			return err
+		}
+		where = inst.From
+	}
+	owner, err := b.copyOwner(inst.Chown)
+	if err != nil {
+		return err
+	}
+	mode, err := parseChmod(inst.Chmod)
+	if err != nil {
+		return err
+	}
+
+	// Sources can't escape the build context or stage, destinations the
+	// rootfs
+	var matches []string
+	for _, src := range inst.Sources {
+		found, err := globSource(srcRoot, src)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("%s not found in %s", src, where)
+		}
+		matches = append(matches, found...)
+	}
+	if err := checkDest(len(matches)+len(inst.Heredocs), inst.Dest); err != nil {
+		return err
+	}
+	if err := b.copyFiles(srcRoot, matches, inst.Dest, owner, mode); err != nil {
+		return err
+	}
+	return b.copyHeredocs(inst.Heredocs, inst.Dest, owner, mode)
+}
+
+// checkDest checks that the destination of several files is a directory
+func checkDest(files int, dest string) error {
+	if files > 1 && !strings.HasSuffix(dest, "/") {
+		return fmt.Errorf("with more than one source file, the destination must be a directory ending with /")
+	}
+	return nil
+}
+
+// globSource returns the paths under root matching a source pattern, with
+// the syntax of filepath.Match, relative to root
+func globSource(root, pattern string) ([]string, error) {
+	pattern = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(pattern)), "/")
+	if pattern == "" {
+		return []string{"."}, nil
+	}
+	matches, err := fs.Glob(os.DirFS(root), pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %s: %w", pattern, err)
+	}
+	return matches, nil
+}
+
+// copyFiles copies paths relative to srcRoot to dest, giving them an owner
+// and a mode unless nil and 0
+func (b *builder) copyFiles(srcRoot string, sources []string, dest string, owner *container.User, mode os.FileMode) error {
+	toDir := strings.HasSuffix(dest, "/")
+	destPath, err := b.rootPath(dest)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(destPath); err == nil && info.IsDir() {
+		toDir = true
+	}
+
+	for _, src := range sources {
+		srcPath, err := fsutil.SecureJoin(srcRoot, src)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		target := destPath
+		if !info.IsDir() && toDir {
+			target = filepath.Join(destPath, filepath.Base(srcPath))
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create destination directory: %w", err)
+		}
+
+		// Only what is copied gets the owner and mode, not what the
+		// destination already held
+		var copied []string
+		err = filepath.WalkDir(srcPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(srcPath, p)
+			copied = append(copied, filepath.Join(target, rel))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		if err := fsutil.CopyTree(srcPath, target); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", src, err)
+		}
+		if err := setOwnerAndMode(copied, owner, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyHeredocs copies here-documents to dest as files named after them
+func (b *builder) copyHeredocs(heredocs []flokafile.Heredoc, dest string, owner *container.User, mode os.FileMode) error {
+	if len(heredocs) == 0 {
+		return nil
+	}
+	dir, err := os.MkdirTemp(b.tmpDir, "heredoc-")
+	if err != nil {
+		return fmt.Errorf("failed to create here-document directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	var names []string
+	for _, h := range heredocs {
+		if strings.Contains(h.Name, "/") {
+			return fmt.Errorf("invalid here-document name %s", h.Name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, h.Name), []byte(h.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write here-document %s: %w", h.Name, err)
+		}
+		names = append(names, h.Name)
+	}
+	return b.copyFiles(dir, names, dest, owner, mode)
+}
+
+// copyOwner resolves --chown against the rootfs' /etc/passwd and
+// /etc/group. Like Docker, a user without a group gets the group with its
+// UID as GID. It returns nil without --chown.
+func (b *builder) copyOwner(chown string) (*container.User, error) {
+	if chown == "" {
+		return nil, nil
+	}
+	user, err := container.ResolveUser(b.rootDir, chown)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --chown: %w", err)
+	}
+	if !strings.Contains(chown, ":") {
+		user.Gid = user.Uid
+	}
+	return user, nil
+}
+
+// parseChmod reads the octal mode of --chmod, 0 without one
+func parseChmod(chmod string) (os.FileMode, error) {
+	if chmod == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(chmod, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid --chmod %q, expected an octal mode", chmod)
+	}
+	perm := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+	return perm, nil
+}
+
+// setOwnerAndMode gives paths an owner and a mode, leaving them as they are
+// when nil and 0. Symlinks keep their mode.
+func setOwnerAndMode(paths []string, owner *container.User, mode os.FileMode) error {
+	for _, p := range paths {
+		if owner != nil {
+			if err := os.Lchown(p, int(owner.Uid), int(owner.Gid)); err != nil {
+				return fmt.Errorf("failed to set owner of %s: %w", p, err)
+			}
+		}
+		if mode != 0 {
+			info, err := os.Lstat(p)
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			// chmod after chown, which clears setuid and setgid bits
+			if err := os.Chmod(p, mode); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// pkg/fimage/errors.go
+package fimage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors callers can test for with errors.Is
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrImageExists   = errors.New("image already exists")
+	ErrImageInUse    = errors.New("image is in use")
+	ErrUnauthorized  = errors.New("incorrect username or password")
+)
+
+// StatusError is a registry reply other than 200 OK
+type StatusError struct {
+	Code   int    // HTTP status code
+	Status string // HTTP status line, e.g. "404 Not Found"
+	Path   string // Path below /v2/<repository>
+	Body   string // Start of the response body
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("registry returned %s for %s: %s", e.Status, e.Path, e.Body)
+}
+
+// isNotFound reports whether err is a 404 from the registry
+func isNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound
+}
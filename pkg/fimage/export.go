@@ -0,0 +1,137 @@
+// pkg/fimage/export.go
+package fimage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Export writes the filesystem of the image, its layers flattened, as a tar
+// archive, gzip compressed with compress. Files keep their owners, modes,
+// times, extended attributes and hard links, and sparse files their holes.
+func (img *Image) Export(w io.Writer, compress bool) error {
+	logging.L().Debug("exporting image", "image", img.Name+":"+img.Tag)
+
+	// The layers are flattened into a temporary rootfs first
+	tmpDir, err := os.MkdirTemp(config.DataPath("images"), ".export-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary rootfs: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := img.extractTo(tmpDir); err != nil {
+		return err
+	}
+
+	if !compress {
+		return writeTree(w, tmpDir)
+	}
+	gz := gzip.NewWriter(w)
+	if err := writeTree(gz, tmpDir); err != nil {
+		gz.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// writeTree writes every file under root to a tar archive, named relative
+// to root
+func writeTree(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	links := map[uint64]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := fileHeader(root, filepath.ToSlash(rel), links)
+		if err != nil {
+			return err
+		}
+		if err := addXattrs(hdr, p); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if written, err := writeSparseFile(w, tw, hdr, p); written || err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return copyFile(tw, root, hdr)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// addXattrs records the extended attributes of the file at p in its header,
+// as the SCHILY.xattr PAX records GNU tar and extractLayer read
+func addXattrs(hdr *tar.Header, p string) error {
+	if hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil || size == 0 {
+		// Filesystems without extended attributes have none to keep
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(p, buf)
+	if err != nil {
+		return fmt.Errorf("failed to list extended attributes of %s: %w", hdr.Name, err)
+	}
+	for _, name := range splitXattrNames(buf[:size]) {
+		n, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(p, name, value); err != nil {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords["SCHILY.xattr."+name] = string(value[:n])
+	}
+	return nil
+}
+
+// splitXattrNames splits the NUL terminated names listxattr returns
+func splitXattrNames(buf []byte) []string {
+	var names []string
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
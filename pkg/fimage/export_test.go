@@ -0,0 +1,77 @@
+package fimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestWriteTreeSparse checks that sparse files are archived with their
+// holes and read back whole, with the files after them intact
+func TestWriteTreeSparse(t *testing.T) {
+	root := t.TempDir()
+	const size = 8 << 20
+	sparse := filepath.Join(root, "sparse")
+	f, err := os.Create(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{1 << 20, 5 << 20} {
+		if _, err := f.WriteAt([]byte("data"), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	var st syscall.Stat_t
+	if err := syscall.Stat(sparse, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Blocks*512 >= size {
+		t.Skip("the filesystem of the temporary directory has no holes")
+	}
+	if err := os.WriteFile(filepath.Join(root, "zfile"), []byte("after"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTree(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= size {
+		t.Errorf("archive is %d bytes, the holes were written out", buf.Len())
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+	if !bytes.Equal(files["sparse"], want) {
+		t.Errorf("sparse file read back with %d bytes, want its %d bytes", len(files["sparse"]), len(want))
+	}
+	if string(files["zfile"]) != "after" {
+		t.Errorf("file after the sparse one read back as %q", files["zfile"])
+	}
+}
@@ -0,0 +1,100 @@
+// pkg/fimage/filter.go
+package fimage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ListOptions filters, sorts and paginates the result of
+// GetImagesFromLocalStorage. The zero value lists every image ordered by
+// name and tag.
+type ListOptions struct {
+	Reference string   // Only images whose name:tag matches this glob pattern (e.g. "ubuntu:*")
+	Labels    []string // Only images with all of these labels, KEY or KEY=VALUE
+	SortBy    string   // "name" (default), "created" or "size"
+	Reverse   bool     // Reverse the sort order
+	Offset    int      // Number of matching images to skip
+	Limit     int      // Maximum number of images to return, 0 for no limit
+}
+
+// matches reports whether the image passes all filters in opts
+func (o *ListOptions) matches(img *Image) (bool, error) {
+	for _, label := range o.Labels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, ok := img.Labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false, nil
+		}
+	}
+	if o.Reference == "" {
+		return true, nil
+	}
+	if img.Name == "" {
+		return false, nil
+	}
+
+	pattern := o.Reference
+	ref := img.Name + ":" + img.Tag
+	matched, err := path.Match(pattern, ref)
+	if err != nil {
+		return false, fmt.Errorf("invalid reference filter %q: %w", o.Reference, err)
+	}
+	if !matched {
+		// A pattern without a tag matches any tag of the repository
+		matched, _ = path.Match(pattern, img.Name)
+	}
+	return matched, nil
+}
+
+// apply filters, sorts and paginates images according to opts
+func (o *ListOptions) apply(images []*Image) ([]*Image, error) {
+	var less func(a, b *Image) bool
+	switch o.SortBy {
+	case "", "name":
+		less = func(a, b *Image) bool {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Tag < b.Tag
+		}
+	case "created":
+		less = func(a, b *Image) bool { return a.Created.Before(b.Created) }
+	case "size":
+		less = func(a, b *Image) bool { return a.Size < b.Size }
+	default:
+		return nil, fmt.Errorf("unknown sort key: %s", o.SortBy)
+	}
+	if o.Offset < 0 || o.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
+	}
+
+	filtered := []*Image{}
+	for _, img := range images {
+		ok, err := o.matches(img)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, img)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if o.Reverse {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+
+	if o.Offset >= len(filtered) {
+		return []*Image{}, nil
+	}
+	filtered = filtered[o.Offset:]
+	if o.Limit > 0 && o.Limit < len(filtered) {
+		filtered = filtered[:o.Limit]
+	}
+	return filtered, nil
+}
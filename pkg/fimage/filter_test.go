@@ -0,0 +1,99 @@
+package fimage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListOptionsMatches(t *testing.T) {
+	img := &Image{Name: "library/ubuntu", Tag: "22.04", Labels: map[string]string{"vendor": "canonical", "beta": ""}}
+	untagged := &Image{ID: "sha256:abc", Labels: map[string]string{"vendor": "canonical"}}
+
+	tests := []struct {
+		name    string
+		img     *Image
+		opts    ListOptions
+		want    bool
+		wantErr bool
+	}{
+		{"no filters", img, ListOptions{}, true, false},
+		{"exact reference", img, ListOptions{Reference: "library/ubuntu:22.04"}, true, false},
+		{"tag pattern", img, ListOptions{Reference: "library/ubuntu:22.*"}, true, false},
+		{"other tag", img, ListOptions{Reference: "library/ubuntu:20.04"}, false, false},
+		{"repository without tag", img, ListOptions{Reference: "library/ubuntu"}, true, false},
+		{"repository pattern", img, ListOptions{Reference: "library/*"}, true, false},
+		{"star does not cross slashes", img, ListOptions{Reference: "*"}, false, false},
+		{"pattern over both parts", img, ListOptions{Reference: "*/ub*:*"}, true, false},
+		{"invalid pattern", img, ListOptions{Reference: "[ubuntu"}, false, true},
+		{"untagged image with reference", untagged, ListOptions{Reference: "*"}, false, false},
+		{"untagged image without reference", untagged, ListOptions{Labels: []string{"vendor"}}, true, false},
+		{"label key", img, ListOptions{Labels: []string{"beta"}}, true, false},
+		{"label value", img, ListOptions{Labels: []string{"vendor=canonical"}}, true, false},
+		{"other label value", img, ListOptions{Labels: []string{"vendor=debian"}}, false, false},
+		{"missing label", img, ListOptions{Labels: []string{"vendor", "lts"}}, false, false},
+		{"labels and reference", img, ListOptions{Reference: "library/ubuntu", Labels: []string{"beta="}}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.matches(tt.img)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matches returned error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("matches returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListOptionsApply(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	images := []*Image{
+		{Name: "ubuntu", Tag: "22.04", Created: day(3), Size: 300},
+		{Name: "alpine", Tag: "3.19", Created: day(1), Size: 100},
+		{Name: "ubuntu", Tag: "20.04", Created: day(2), Size: 200},
+		{Name: "alpine", Tag: "3.18", Created: day(4), Size: 100},
+	}
+
+	tests := []struct {
+		name    string
+		opts    ListOptions
+		want    []string
+		wantErr bool
+	}{
+		{"by name and tag", ListOptions{}, []string{"alpine:3.18", "alpine:3.19", "ubuntu:20.04", "ubuntu:22.04"}, false},
+		{"by name reversed", ListOptions{SortBy: "name", Reverse: true}, []string{"ubuntu:22.04", "ubuntu:20.04", "alpine:3.19", "alpine:3.18"}, false},
+		{"by creation", ListOptions{SortBy: "created"}, []string{"alpine:3.19", "ubuntu:20.04", "ubuntu:22.04", "alpine:3.18"}, false},
+		{"by size, stable", ListOptions{SortBy: "size"}, []string{"alpine:3.19", "alpine:3.18", "ubuntu:20.04", "ubuntu:22.04"}, false},
+		{"filtered", ListOptions{Reference: "ubuntu"}, []string{"ubuntu:20.04", "ubuntu:22.04"}, false},
+		{"offset and limit", ListOptions{Offset: 1, Limit: 2}, []string{"alpine:3.19", "ubuntu:20.04"}, false},
+		{"offset past the end", ListOptions{Offset: 10}, []string{}, false},
+		{"unknown sort key", ListOptions{SortBy: "id"}, nil, true},
+		{"negative limit", ListOptions{Limit: -1}, nil, true},
+		{"invalid pattern", ListOptions{Reference: "["}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.apply(images)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("apply succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			refs := []string{}
+			for _, img := range got {
+				refs = append(refs, img.Name+":"+img.Tag)
+			}
+			if !reflect.DeepEqual(refs, tt.want) {
+				t.Errorf("apply returned %v, want %v", refs, tt.want)
+			}
+		})
+	}
+}
@@ -2,142 +2,322 @@
 package fimage
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
 )
 
+// Progress receives the progress messages of pulls and builds
+var Progress io.Writer = os.Stdout
+
 // Image represents a container image
 type Image struct {
-    Name    string
-    Tag     string
-    ID      string
-    Size    int64
-    Layers  []string
-    RootDir string // Path to the extracted rootfs
-    Created time.Time
+    Name       string
+    Tag        string
+    ID         string // Digest of the image config
+    Digest     string // Digest of the image manifest
+    RepoTags   []string // All name:tag references to the image
+    Size       int64 // Disk space used by the image's layers
+    Layers     []string // Digests of the layer blobs, bottom first
+    DiffIDs    []string // Digests of the uncompressed layers, bottom first
+    Created    time.Time
+    Volumes    []string // Paths declared with VOLUME in the Flokafile
+    Env        []string // KEY=VALUE variables set in every container
+    Entrypoint []string // Prepended to the command of every container
+    Cmd        []string // Default command when run without one
+    WorkingDir string // Directory the command runs in, / when empty
+    User       string // User the command runs as, NAME|UID[:GROUP|GID], root when empty
+    ExposedPorts []string // Ports the image listens on, e.g. 80/tcp
+    Labels     map[string]string // Metadata inherited by every container
+    History    []History // Instructions that made the image, oldest first
 }
 
-// Pull downloads an image from a registry or creates a mock image locally
-func Pull(name string, tag string) (*Image, error) {
+// Pull returns a local image, downloading it from its registry first if it
+// isn't available locally. Cancelling ctx aborts the download. progress
+// follows the download, a nil one writing text lines to Progress.
+func Pull(ctx context.Context, name string, tag string, progress ProgressReporter) (*Image, error) {
 	
 	if tag == "" {
 		tag = "latest"
     }
     
-    // Set up image directories relative to the current working directory
-    imagesDir := "images"
-    imageID := generateID()
     imageFullName := fmt.Sprintf("%s:%s", name, tag)
-    imageDir := filepath.Join(imagesDir, imageFullName)
-    rootDir := filepath.Join(imageDir, "rootfs")
     
     // Check if we already have the image locally
-    if _, err := os.Stat(imageDir); err == nil {
-    	fmt.Printf("Image %s already exists locally\n", imageFullName)
-    	
-    	// Load existing image metadata
-    	size, _ := dirSize(rootDir)
-        
-        return &Image{
-            Name:    name,
-            Tag:     tag,
-            ID:      imageID,
-            Size:    size,
-            Layers:  []string{"base"},
-            RootDir: rootDir,
-            Created: getCreationTime(imageDir),
-        }, nil
+    if img, err := loadImage(name, tag); err == nil {
+    	logging.L().Debug("image exists locally", "image", imageFullName, "id", img.ID)
+        return img, nil
     }
     
+    if progress == nil {
+        progress = TextProgress(Progress)
+    }
+    return pullFromRegistry(ctx, name, tag, progress)
+}
+
+// loadImage reads the metadata of the local image name:tag
+func loadImage(name, tag string) (*Image, error) {
+    ref := fmt.Sprintf("%s:%s", name, tag)
     
-    // Create image directory structure
-    if err := os.MkdirAll(rootDir, 0755); err != nil {
-        return nil, fmt.Errorf("failed to create image directory: %w", err)
+    // Images stored by older floka versions live in a directory named
+    // after their reference
+    if err := migrateLegacyImage(name, tag); err != nil {
+        return nil, fmt.Errorf("failed to move image %s to the image store: %w", ref, err)
     }
-	fmt.Printf("Created directory for image %s at %s\n", imageFullName, imageDir)
     
-    return nil, fmt.Errorf("image %s not found locally and pull functionality is not implemented", imageFullName)
+    refs, err := loadReferences()
+    if err != nil {
+        return nil, err
+    }
+    id, ok := refs[ref]
+    if !ok {
+        return nil, fmt.Errorf("%w locally: %s", ErrImageNotFound, ref)
+    }
+    img, err := loadImageByID(id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read image %s: %w", ref, err)
+    }
+    img.Name = name
+    img.Tag = tag
+    return img, nil
 }
 
-// saveImageMetadata saves the image metadata to a file
-func saveImageMetadata(img *Image, imageDir string) error {
-    metadataDir := filepath.Join(imageDir, "metadata")
-    if err := os.MkdirAll(metadataDir, 0755); err != nil {
-        return fmt.Errorf("failed to create metadata directory: %w", err)
+// loadImageByID reads the metadata of the stored image with the given ID.
+// Name and Tag are left empty, as the image may have any number of
+// references.
+func loadImageByID(id string) (*Image, error) {
+    imageDir, err := imageDir(id)
+    if err != nil {
+        return nil, err
+    }
+    if !exists(imageDir) {
+        return nil, fmt.Errorf("%w: %s", ErrImageNotFound, id)
     }
     
-    // Write a simple metadata file
-    metadataFile := filepath.Join(metadataDir, "image.info")
-    content := fmt.Sprintf("Name: %s\nTag: %s\nID: %s\nSize: %d bytes\nCreated: %s\n",
-        img.Name, img.Tag, img.ID, img.Size, img.Created.Format(time.RFC3339))
+    id, digest, err := imageDigests(imageDir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read image %s: %w", shortDigest(id), err)
+    }
+    manifest, err := loadManifest(imageDir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read manifest of image %s: %w", shortDigest(id), err)
+    }
+    config, err := loadConfig(imageDir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read config of image %s: %w", shortDigest(id), err)
+    }
+    refs, err := loadReferences()
+    if err != nil {
+        return nil, err
+    }
     
-    return os.WriteFile(metadataFile, []byte(content), 0644)
+    img := &Image{
+        ID:         id,
+        Digest:     digest,
+        RepoTags:   repoTags(refs, id),
+        Layers:     layerDigests(manifest.Layers),
+        DiffIDs:    config.RootFS.DiffIDs,
+        Created:    config.Created,
+        Volumes:    config.volumes(),
+        Env:        config.Config.Env,
+        Entrypoint: config.Config.Entrypoint,
+        Cmd:        config.Config.Cmd,
+        WorkingDir: config.Config.WorkingDir,
+        User:       config.Config.User,
+        ExposedPorts: config.exposedPorts(),
+        Labels:     config.Config.Labels,
+        History:    config.History,
+    }
+    // Images moved from older versions may only list volumes on the side
+    if len(img.Volumes) == 0 {
+        img.Volumes = loadVolumes(imageDir)
+    }
+    if img.Created.IsZero() {
+        img.Created = getCreationTime(imageDir)
+    }
+    if len(img.DiffIDs) != len(img.Layers) {
+        return nil, fmt.Errorf("image %s has %d layers but %d diff IDs", shortDigest(id), len(img.Layers), len(img.DiffIDs))
+    }
+    img.Size = img.layersSize()
+    return img, nil
 }
 
-// GetImagesFromLocalStorage returns all images stored locally
-func GetImagesFromLocalStorage() ([]*Image, error) {
-    imagesDir := "images"
-    if _, err := os.Stat(imagesDir); os.IsNotExist(err) {
-        // Images directory doesn't exist yet
-        return []*Image{}, nil
-    }
+// pullFromRegistry downloads an image manifest, config and layers, verifies
+// their digests and unpacks the layers into a new image directory
+func pullFromRegistry(ctx context.Context, name, tag string, progress ProgressReporter) (*Image, error) {
+    imageFullName := fmt.Sprintf("%s:%s", name, tag)
     
-    // List all directories in the images directory
-    entries, err := os.ReadDir(imagesDir)
+    // Registry mirrors that fail or lack the image are skipped, the layers
+    // coming from the first registry that has it
+    var client *registryClient
+    var manifest *Manifest
+    var manifestJSON []byte
+    var err error
+    clients := pullClients(name)
+    for i, c := range clients {
+        client = c
+        progress.Message(fmt.Sprintf("Pulling %s from %s/%s", imageFullName, client.registry, client.repository))
+        manifest, manifestJSON, err = client.fetchManifest(ctx, tag)
+        if err == nil || i == len(clients)-1 || ctx.Err() != nil {
+            break
+        }
+        logging.L().Warn("failed to pull from registry mirror", "mirror", client.registry, "image", imageFullName, "err", err)
+    }
+    if isNotFound(err) {
+        return nil, fmt.Errorf("%w: %s", ErrImageNotFound, imageFullName)
+    }
     if err != nil {
-        return nil, fmt.Errorf("failed to read images directory: %w", err)
+        return nil, fmt.Errorf("failed to fetch manifest for %s: %w", imageFullName, err)
     }
     
-    var images []*Image
+    // The config holds the image defaults and declared volumes. Blobs
+    // already in the store from other images are not downloaded again.
+    configPath, err := BlobPath(manifest.Config.Digest)
+    if err != nil {
+        return nil, err
+    }
+    if !hasBlob(manifest.Config.Digest) {
+        if configPath, err = client.fetchBlob(ctx, manifest.Config, nil); err != nil {
+            return nil, err
+        }
+    }
+    configJSON, err := os.ReadFile(configPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read image config: %w", err)
+    }
+    var config ImageConfig
+    if err := json.Unmarshal(configJSON, &config); err != nil {
+        return nil, fmt.Errorf("failed to parse image config: %w", err)
+    }
+    diffIDs := config.RootFS.DiffIDs
+    if len(diffIDs) != len(manifest.Layers) {
+        return nil, fmt.Errorf("image config lists %d layers but the manifest has %d", len(diffIDs), len(manifest.Layers))
+    }
     
-    for _, entry := range entries {
-        if !entry.IsDir() {
+    // Each layer is unpacked into the layer store on its own, where images
+    // sharing it find it
+    for _, layer := range manifest.Layers {
+        progress.Layer(LayerProgress{ID: shortDigest(layer.Digest), Phase: PhaseWaiting, Total: layer.Size})
+    }
+    for i, layer := range manifest.Layers {
+        short := shortDigest(layer.Digest)
+        report := func(phase string) func(int64) {
+            return func(n int64) {
+                progress.Layer(LayerProgress{ID: short, Phase: phase, Current: n, Total: layer.Size})
+            }
+        }
+        blobPath, err := BlobPath(layer.Digest)
+        if err != nil {
+            return nil, err
+        }
+        if hasLayer(diffIDs[i]) && hasBlob(layer.Digest) {
+            report(PhaseExists)(layer.Size)
             continue
         }
-        
-        // Parse the image name and tag from the directory name
-        fullName := entry.Name()
-        name := fullName
-        tag := "latest"
-        
-        if strings.Contains(fullName, ":") {
-            parts := strings.Split(fullName, ":")
-            name = parts[0]
-            if len(parts) > 1 {
-                tag = parts[1]
+        if !hasBlob(layer.Digest) {
+            report(PhaseDownloading)(0)
+            if blobPath, err = client.fetchBlob(ctx, layer, report(PhaseDownloading)); err != nil {
+                return nil, err
             }
         }
-        
-        imageDir := filepath.Join(imagesDir, fullName)
-        rootDir := filepath.Join(imageDir, "rootfs")
-        
-        // Check if rootfs exists
-        if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+        report(PhaseExtracting)(0)
+        if err := unpackLayer(blobPath, diffIDs[i], report(PhaseExtracting)); err != nil {
+            return nil, err
+        }
+        report(PhaseComplete)(layer.Size)
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+    }
+    
+    // The manifest and config are kept byte for byte so their digests
+    // stay those of the registry
+    img, err := storeImage(name, tag, manifestJSON, configJSON)
+    if err != nil {
+        return nil, err
+    }
+    
+    progress.Message("Digest: " + img.Digest)
+    progress.Message("Status: Downloaded newer image for " + imageFullName)
+    logEvent("pull", img.ID, imageFullName)
+    return img, nil
+}
+
+// loadManifest reads the registry manifest saved for a pulled image
+func loadManifest(imageDir string) (*Manifest, error) {
+    data, err := os.ReadFile(filepath.Join(imageDir, "metadata", "manifest.json"))
+    if err != nil {
+        return nil, err
+    }
+    var manifest Manifest
+    if err := json.Unmarshal(data, &manifest); err != nil {
+        return nil, err
+    }
+    return &manifest, nil
+}
+
+// loadVolumes reads the VOLUME declarations older floka versions saved
+// next to the image config
+func loadVolumes(imageDir string) []string {
+    data, err := os.ReadFile(filepath.Join(imageDir, "metadata", "volumes.json"))
+    if err != nil {
+        return nil
+    }
+    var volumes []string
+    if err := json.Unmarshal(data, &volumes); err != nil {
+        return nil
+    }
+    return volumes
+}
+
+// GetImagesFromLocalStorage returns the local images selected by opts; nil
+// opts lists all. An image with several references is listed once per
+// reference, and an image without any once with an empty Name and Tag.
+func GetImagesFromLocalStorage(opts *ListOptions) ([]*Image, error) {
+    if opts == nil {
+        opts = &ListOptions{}
+    }
+    
+    // Images stored by older versions are moved to the image store first
+    if err := migrateLegacyImages(); err != nil {
+        return nil, err
+    }
+    
+    ids, err := imageIDs()
+    if err != nil {
+        return nil, err
+    }
+    var images []*Image
+    for _, id := range ids {
+        img, err := loadImageByID(id)
+        if err != nil {
+            logging.L().Warn("skipping unreadable image", "id", id, "err", err)
+            continue
+        }
+        if len(img.RepoTags) == 0 {
+            images = append(images, img)
             continue
         }
-        
-        // Get size
-        size, _ := dirSize(rootDir)
-        
-        // Create image object
-        img := &Image{
-            Name:    name,
-            Tag:     tag,
-            ID:      fullName, // Use the directory name as ID for display
-            Size:    size,
-            RootDir: rootDir,
-            Created: getCreationTime(imageDir),
+        for _, ref := range img.RepoTags {
+            tagged := *img
+            tagged.Name, tagged.Tag = ParseReference(ref)
+            images = append(images, &tagged)
         }
-        
-        images = append(images, img)
     }
     
-    return images, nil
+    return opts.apply(images)
+}
+
+// exists reports whether a file exists
+func exists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
 }
 
 // getCreationTime gets the creation time of a directory
@@ -166,181 +346,8 @@ func dirSize(path string) (int64, error) {
     return size, err
 }
 
-// Build creates a new image from a flokafile
-func Build(flokafilePath, tag string) (*Image, error) {
-    fmt.Printf("Building image from %s with tag %s\n", flokafilePath, tag)
-    
-    // Set up image directories relative to the current working directory
-    imagesDir := "images"
-    imageDir := filepath.Join(imagesDir, tag)
-    rootDir := filepath.Join(imageDir, "rootfs")
-    
-    // Check if image already exists
-    if _, err := os.Stat(imageDir); err == nil {
-        return nil, fmt.Errorf("image %s already exists", tag)
-    }
-    
-    if err := os.MkdirAll(rootDir, 0755); err != nil {
-        return nil, fmt.Errorf("failed to create image directory: %w", err)
-    }
-    
-    // Parse and process the Flokafile
-    flokafileContent, err := os.ReadFile(flokafilePath)
-    if err != nil {
-        return nil, fmt.Errorf("failed to read Flokafile: %w", err)
-    }
-
-    // Parse Flokafile (line by line for simplicity)
-    // Note: Base image creation (previously createMockRootfs) needs to be handled
-    // by ensuring the FROM instruction properly sets up a valid rootfs.
-    lines := strings.Split(string(flokafileContent), "\n")
-    for i, line := range lines {
-        line = strings.TrimSpace(line)
-        if line == "" || strings.HasPrefix(line, "#") {
-            continue // Skip empty lines and comments
-        }
-
-        parts := strings.SplitN(line, " ", 2)
-        if len(parts) < 2 {
-            return nil, fmt.Errorf("invalid instruction at line %d: %s", i+1, line)
-        }
-
-        instruction, args := parts[0], parts[1]
-        
-        fmt.Printf("Executing instruction: %s %s\n", instruction, args)
-        
-        // Process each instruction type
-        switch strings.ToUpper(instruction) {
-        case "FROM":
-            // Get base image - we already have createMockRootfs for now
-            fmt.Printf("Using %s as base image\n", args)
-            
-        case "RUN":
-            // Simulate running a command
-            fmt.Printf("Running command: %s\n", args)
-            // In a real implementation, we would execute the command in a container
-            
-        case "COPY":
-            // Copy files from host to the image
-            copyParts := strings.SplitN(args, " ", 2)
-            if len(copyParts) != 2 {
-                return nil, fmt.Errorf("invalid COPY instruction at line %d", i+1)
-            }
-            src, dest := copyParts[0], copyParts[1]
-            
-            // Make sure destination directory exists
-            destDir := filepath.Join(rootDir, filepath.Dir(dest))
-            if err := os.MkdirAll(destDir, 0755); err != nil {
-                return nil, fmt.Errorf("failed to create destination directory: %w", err)
-            }
-            
-            // Copy the file (simplified)
-            srcFile, err := os.Open(src)
-            if err != nil {
-                return nil, fmt.Errorf("failed to open source file: %w", err)
-            }
-            defer srcFile.Close()
-            
-            destFile, err := os.Create(filepath.Join(rootDir, dest))
-            if err != nil {
-                return nil, fmt.Errorf("failed to create destination file: %w", err)
-            }
-            defer destFile.Close()
-            
-            if _, err := io.Copy(destFile, srcFile); err != nil {
-                return nil, fmt.Errorf("failed to copy file: %w", err)
-            }
-            
-        case "ENV":
-            // Set environment variable
-            envParts := strings.SplitN(args, "=", 2)
-            if len(envParts) != 2 {
-                return nil, fmt.Errorf("invalid ENV instruction at line %d", i+1)
-            }
-            key, value := envParts[0], envParts[1]
-            
-            // Add to /etc/environment (simplified)
-            envFile := filepath.Join(rootDir, "etc", "environment")
-            f, err := os.OpenFile(envFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-            if err != nil {
-                return nil, fmt.Errorf("failed to open environment file: %w", err)
-            }
-            if _, err := fmt.Fprintf(f, "%s=%s\n", key, value); err != nil {
-                f.Close()
-                return nil, fmt.Errorf("failed to write environment variable: %w", err)
-            }
-            f.Close()
-            
-        default:
-            return nil, fmt.Errorf("unknown instruction at line %d: %s", i+1, instruction)
-        }
-        
-        // In a real implementation, we would commit a new layer here
-        fmt.Printf("Committed layer for instruction: %s\n", instruction)
-    }
-    
-    // Extract name and tag from full tag (name:tag)
-    imageName := tag
-    imageTag := "latest"
-    if strings.Contains(tag, ":") {
-        parts := strings.Split(tag, ":")
-        imageName = parts[0]
-        if len(parts) > 1 {
-            imageTag = parts[1]
-        }
-    }
-    
-    img := &Image{
-        Name:    imageName,
-        Tag:     imageTag,
-        ID:      generateID(),
-        Size:    0,
-        Layers:  []string{"base", "app", "config"},
-        RootDir: rootDir,
-        Created: time.Now(),
-    }
-    
-    // Calculate the size
-    if size, err := dirSize(rootDir); err == nil {
-        img.Size = size
-    }
-    
-    // Save metadata
-    if err := saveImageMetadata(img, imageDir); err != nil {
-        return nil, fmt.Errorf("failed to save image metadata: %w", err)
-    }
-    
-    return img, nil
-}
-
-// Export writes an image to a tar file
-func (img *Image) Export(writer io.Writer) error {
-    fmt.Printf("Exporting image %s:%s\n", img.Name, img.Tag)
-    
-    // Check if tar command is available
-    if _, err := exec.LookPath("tar"); err == nil {
-        // Use system tar command for better performance
-        cmd := exec.Command("tar", "-C", img.RootDir, "-cf", "-", ".")
-        cmd.Stdout = writer
-        return cmd.Run()
-    }
-    
-    // If tar is not available, write a placeholder message
-    _, err := writer.Write([]byte(fmt.Sprintf("Mock export of image %s:%s (ID: %s)\n", img.Name, img.Tag, img.ID)))
-    return err
+// dir returns the directory holding the image's metadata
+func (img *Image) dir() string {
+    dir, _ := imageDir(img.ID)
+    return dir
 }
-
-// Remove deletes an image
-func (img *Image) Remove() error {
-    fmt.Printf("Removing image %s:%s\n", img.Name, img.Tag)
-    
-    // Remove the image directory
-    imageDir := filepath.Join("images", fmt.Sprintf("%s:%s", img.Name, img.Tag))
-    return os.RemoveAll(imageDir)
-}
-
-// generateID creates a unique image ID
-func generateID() string {
-    // Use timestamp for uniqueness
-    return fmt.Sprintf("img_%d", time.Now().UnixNano())
-}
\ No newline at end of file
@@ -0,0 +1,42 @@
+// pkg/fimage/history.go
+package fimage
+
+import "time"
+
+// HistoryEntry is a step of the history of an image: the instruction that
+// made one of its layers, or only changed its config
+type HistoryEntry struct {
+	Created   time.Time
+	CreatedBy string
+	Comment   string
+	DiffID    string // Layer the instruction made, empty when it made none
+	Size      int64  // Disk space used by the layer
+}
+
+// LayerHistory returns the history of the image newest first, along with
+// the layer each step made and its size. Layers the image config has no
+// history for, such as those of images pulled without one, get entries of
+// their own at the bottom.
+func (img *Image) LayerHistory() []HistoryEntry {
+	var entries []HistoryEntry
+	layer := 0
+	for _, h := range img.History {
+		entry := HistoryEntry{Created: h.Created, CreatedBy: h.CreatedBy, Comment: h.Comment}
+		if !h.EmptyLayer && layer < len(img.DiffIDs) {
+			entry.DiffID = img.DiffIDs[layer]
+			entry.Size = layerSize(entry.DiffID)
+			layer++
+		}
+		entries = append(entries, entry)
+	}
+	var missing []HistoryEntry
+	for ; layer < len(img.DiffIDs); layer++ {
+		missing = append(missing, HistoryEntry{DiffID: img.DiffIDs[layer], Size: layerSize(img.DiffIDs[layer])})
+	}
+	entries = append(missing, entries...)
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
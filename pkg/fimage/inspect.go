@@ -0,0 +1,70 @@
+// pkg/fimage/inspect.go
+package fimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InspectInfo is the full state of a local image
+type InspectInfo struct {
+	ID       string   // Digest of the image config
+	Digest   string   // Digest of the image manifest
+	RepoTag  string   // Reference the image was looked up by
+	RepoTags []string // All references to the image
+	Name     string
+	Tag      string
+	Size     int64
+	Layers   []string
+	DiffIDs  []string // Digests of the uncompressed layers
+	Created  time.Time
+	Volumes  []string
+	Manifest *Manifest       `json:",omitempty"` // Manifest listing the config and layer blobs
+	Config   json.RawMessage `json:",omitempty"` // Image config with the container defaults
+}
+
+// Inspect returns the full state of the local image ref refers to, by
+// reference or ID. It never pulls.
+func Inspect(ref string) (*InspectInfo, error) {
+	img, err := Lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	return img.Inspect()
+}
+
+// Inspect returns the full state of the image
+func (img *Image) Inspect() (*InspectInfo, error) {
+	info := &InspectInfo{
+		ID:       img.ID,
+		Digest:   img.Digest,
+		RepoTags: img.RepoTags,
+		Name:     img.Name,
+		Tag:      img.Tag,
+		Size:     img.Size,
+		Layers:   img.Layers,
+		DiffIDs:  img.DiffIDs,
+		Created:  img.Created,
+		Volumes:  img.Volumes,
+	}
+
+	if img.Name != "" {
+		info.RepoTag = fmt.Sprintf("%s:%s", img.Name, img.Tag)
+	}
+
+	imageDir := img.dir()
+	if manifest, err := loadManifest(imageDir); err == nil {
+		info.Manifest = manifest
+	}
+	config, err := os.ReadFile(filepath.Join(imageDir, "metadata", "config.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+	if json.Valid(config) {
+		info.Config = config
+	}
+	return info, nil
+}
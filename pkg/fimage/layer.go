@@ -0,0 +1,253 @@
+// pkg/fimage/layer.go
+package fimage
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// fileState is what a snapshot remembers about a file to notice changes
+type fileState struct {
+	mode  os.FileMode
+	size  int64
+	mtime syscall.Timespec
+	ctime syscall.Timespec
+	uid   uint32
+	gid   uint32
+	ino   uint64
+}
+
+// snapshot records the state of every file under root, keyed by its path
+// relative to root
+func snapshot(root string) (map[string]fileState, error) {
+	files := map[string]fileState{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		state, err := statFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = state
+		return nil
+	})
+	return files, err
+}
+
+// statFile returns the snapshot state of a single file
+func statFile(p string) (fileState, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return fileState{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileState{}, fmt.Errorf("unsupported file info for %s", p)
+	}
+	return fileState{
+		mode:  info.Mode(),
+		size:  info.Size(),
+		mtime: st.Mtim,
+		ctime: st.Ctim,
+		uid:   st.Uid,
+		gid:   st.Gid,
+		ino:   st.Ino,
+	}, nil
+}
+
+// diffSnapshots returns the paths that were added or changed and the ones
+// that were deleted between two snapshots. Deleted paths inside deleted
+// directories are left out, the directory's whiteout covers them.
+func diffSnapshots(before, after map[string]fileState) (changed, deleted []string) {
+	for p, state := range after {
+		if old, ok := before[p]; !ok || old != state {
+			changed = append(changed, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; ok {
+			continue
+		}
+		if parent := path.Dir(p); parent != "." {
+			if _, ok := after[parent]; !ok {
+				if _, existed := before[parent]; existed {
+					continue
+				}
+			}
+		}
+		deleted = append(deleted, p)
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+// writeLayer writes the changed files under root and whiteouts for the
+// deleted ones as an uncompressed layer tar
+func writeLayer(w io.Writer, root string, changed, deleted []string) error {
+	tw := tar.NewWriter(w)
+	links := map[uint64]string{}
+
+	for _, name := range changed {
+		if err := writeFile(tw, root, name, links); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range deleted {
+		hdr := &tar.Header{
+			Name:     path.Join(path.Dir(name), whiteoutPrefix+path.Base(name)),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeFile adds the file at name under root to an archive. Files with
+// several links are stored once and linked to from then on, links keeping
+// track of the first name of each.
+func writeFile(tw *tar.Writer, root, name string, links map[uint64]string) error {
+	hdr, err := fileHeader(root, name, links)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	return copyFile(tw, root, hdr)
+}
+
+// fileHeader returns the tar header of the file at name under root, a hard
+// link to the first name in links of a file with several links
+func fileHeader(root, name string, links map[uint64]string) (*tar.Header, error) {
+	p := filepath.Join(root, filepath.FromSlash(name))
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar header for %s: %w", name, err)
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// Host user names mean nothing inside the image
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Format = tar.FormatPAX
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+		if first, ok := links[st.Ino]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			links[st.Ino] = name
+		}
+	}
+	return hdr, nil
+}
+
+// copyFile writes the content of the regular file of hdr under root to an
+// archive
+func copyFile(w io.Writer, root string, hdr *tar.Header) error {
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(hdr.Name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", hdr.Name, err)
+	}
+	return nil
+}
+
+// commitLayer stores the changes between two snapshots of root as an
+// uncompressed layer blob and returns its descriptor, or nil if nothing
+// changed
+func commitLayer(root string, before, after map[string]fileState) (*Descriptor, error) {
+	changed, deleted := diffSnapshots(before, after)
+	if len(changed) == 0 && len(deleted) == 0 {
+		return nil, nil
+	}
+
+	tmp, err := createBlobFile()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	err = writeLayer(io.MultiWriter(tmp, hash, counter), root, changed, deleted)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write layer: %w", err)
+	}
+
+	desc := &Descriptor{
+		MediaType: mediaTypeOCILayer,
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      counter.n,
+	}
+	if err := putBlob(tmp.Name(), desc.Digest); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// shortDigest returns the first 12 hex characters of a digest
+func shortDigest(digest string) string {
+	h := strings.TrimPrefix(digest, "sha256:")
+	if len(h) > 12 {
+		h = h[:12]
+	}
+	return h
+}
@@ -0,0 +1,174 @@
+// pkg/fimage/layerstore.go
+package fimage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// layersDir is where layers are unpacked, once per diff ID however many
+// images share them
+func layersDir() string {
+	return config.DataPath("layers")
+}
+
+// LayerDir returns the directory holding the unpacked layer whose
+// uncompressed content has the given digest
+func LayerDir(diffID string) (string, error) {
+	hexSum, err := digestHex(diffID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(layersDir(), hexSum, "diff"), nil
+}
+
+// hasLayer reports whether a layer is unpacked
+func hasLayer(diffID string) bool {
+	dir, err := LayerDir(diffID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(dir)
+	return err == nil
+}
+
+// unpackLayer extracts a layer blob into the layer store in the form
+// overlayfs mounts, checking that its uncompressed content matches diffID.
+// Layers that are already unpacked are left alone.
+func unpackLayer(blobPath, diffID string, report func(n int64)) error {
+	dir, err := LayerDir(diffID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	// Unpack next to the store so a half-extracted layer is never used
+	if err := os.MkdirAll(layersDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create layers directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(layersDir(), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create layer directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	diff := filepath.Join(tmpDir, "diff")
+	if err := os.Mkdir(diff, 0755); err != nil {
+		return fmt.Errorf("failed to create layer directory: %w", err)
+	}
+
+	r, closeLayer, err := openLayer(blobPath, report)
+	if err != nil {
+		return err
+	}
+	defer closeLayer()
+	hash := sha256.New()
+	tee := io.TeeReader(r, hash)
+	if err := extractLayer(tee, diff, true); err != nil {
+		return fmt.Errorf("failed to extract layer %s: %w", shortDigest(diffID), err)
+	}
+	// The digest covers the padding after the end of the archive too
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return fmt.Errorf("failed to read layer %s: %w", shortDigest(diffID), err)
+	}
+	if err := checkDigest(hash.Sum(nil), diffID); err != nil {
+		return fmt.Errorf("layer %s: %w", shortDigest(diffID), err)
+	}
+	// Layers never change once stored, so their size is measured only once
+	if size, err := dirSize(diff); err == nil {
+		writeLayerSize(filepath.Join(tmpDir, "size"), size)
+	}
+
+	if err := os.Rename(tmpDir, filepath.Dir(dir)); err != nil {
+		// Another pull may have unpacked the same layer meanwhile
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to store layer %s: %w", shortDigest(diffID), err)
+	}
+	return nil
+}
+
+// LayerDirs returns the directories of the image's unpacked layers, top
+// first as overlayfs stacks them
+func (img *Image) LayerDirs() ([]string, error) {
+	dirs := make([]string, len(img.DiffIDs))
+	for i, diffID := range img.DiffIDs {
+		dir, err := LayerDir(diffID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("layer %s of image %s:%s is missing, pull or build the image again", shortDigest(diffID), img.Name, img.Tag)
+		}
+		dirs[len(dirs)-1-i] = dir
+	}
+	return dirs, nil
+}
+
+// extractTo applies the image's layer blobs, bottom first, to rootfs,
+// giving a flat copy of the image
+func (img *Image) extractTo(rootfs string) error {
+	for _, digest := range img.Layers {
+		blobPath, err := BlobPath(digest)
+		if err != nil {
+			return err
+		}
+		if err := applyLayer(blobPath, rootfs); err != nil {
+			return fmt.Errorf("failed to extract layer %s: %w", shortDigest(digest), err)
+		}
+	}
+	return nil
+}
+
+// layersSize returns the disk space used by the image's unpacked layers,
+// counting layers shared with other images too
+func (img *Image) layersSize() int64 {
+	var size int64
+	for _, diffID := range img.DiffIDs {
+		size += layerSize(diffID)
+	}
+	return size
+}
+
+// layerSize returns the disk space used by an unpacked layer, as recorded
+// when it was unpacked. Layers unpacked before sizes were recorded are
+// measured and recorded now.
+func layerSize(diffID string) int64 {
+	dir, err := LayerDir(diffID)
+	if err != nil {
+		return 0
+	}
+	sizeFile := filepath.Join(filepath.Dir(dir), "size")
+	if data, err := os.ReadFile(sizeFile); err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return size
+		}
+	}
+	size, err := dirSize(dir)
+	if err != nil {
+		return size
+	}
+	writeLayerSize(sizeFile, size)
+	return size
+}
+
+// writeLayerSize records the size of a layer, which layerSize reads back.
+// Failing to is harmless, the layer being measured again next time.
+func writeLayerSize(path string, size int64) {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(size, 10)+"\n"), 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
@@ -0,0 +1,82 @@
+// pkg/fimage/progress.go
+package fimage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Phases of a layer during a pull
+const (
+	PhaseWaiting     = "Waiting"
+	PhaseDownloading = "Downloading"
+	PhaseExtracting  = "Extracting"
+	PhaseComplete    = "Pull complete"
+	PhaseExists      = "Already exists"
+)
+
+// LayerProgress is the state of a layer being pulled
+type LayerProgress struct {
+	ID      string // Short digest of the layer blob
+	Phase   string
+	Current int64 // Bytes of the blob downloaded or extracted so far
+	Total   int64 // Size of the blob, 0 if unknown
+}
+
+// ProgressReporter follows the progress of a pull or push. Layer is called
+// for every layer when the transfer starts, then as each one moves on.
+type ProgressReporter interface {
+	// Message reports a line about the transfer as a whole
+	Message(msg string)
+	// Layer reports the state of a layer
+	Layer(p LayerProgress)
+}
+
+// TextProgress returns a reporter writing a line to w for every message
+// and whenever a layer starts downloading or uploading or is done
+func TextProgress(w io.Writer) ProgressReporter {
+	return &textProgress{w: w, phases: map[string]string{}}
+}
+
+type textProgress struct {
+	mu     sync.Mutex
+	w      io.Writer
+	phases map[string]string
+}
+
+func (t *textProgress) Message(msg string) {
+	fmt.Fprintln(t.w, msg)
+}
+
+func (t *textProgress) Layer(p LayerProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.phases[p.ID] == p.Phase {
+		return
+	}
+	t.phases[p.ID] = p.Phase
+	switch p.Phase {
+	case PhaseWaiting, PhaseExtracting:
+	case PhaseDownloading, PhasePushing:
+		fmt.Fprintf(t.w, "%s: %s %d bytes\n", p.ID, p.Phase, p.Total)
+	default:
+		fmt.Fprintf(t.w, "%s: %s\n", p.ID, p.Phase)
+	}
+}
+
+// progressReader calls report with the number of bytes read so far
+type progressReader struct {
+	r      io.Reader
+	n      int64
+	report func(n int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.report(r.n)
+	}
+	return n, err
+}
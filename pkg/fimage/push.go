@@ -0,0 +1,197 @@
+// pkg/fimage/push.go
+package fimage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Phases of a layer during a push
+const (
+	PhasePushing = "Pushing"
+	PhasePushed  = "Pushed"
+	PhaseMounted = "Mounted"
+	PhaseOnline  = "Layer already exists"
+)
+
+// Push uploads a local image to the registry its name points to: the
+// layers and config the registry doesn't have, mounted from another
+// repository of the registry holding them when possible, then the
+// manifest, tagged. progress follows the upload, a nil one writing text
+// lines to Progress.
+func Push(ctx context.Context, ref string, progress ProgressReporter) error {
+	if progress == nil {
+		progress = TextProgress(Progress)
+	}
+	name, tag := ParseReference(ref)
+	img, err := loadImage(name, tag)
+	if err != nil {
+		return err
+	}
+	manifestJSON, err := readBlob(img.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %s:%s: %w", name, tag, err)
+	}
+	manifest, err := loadManifest(img.dir())
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %s:%s: %w", name, tag, err)
+	}
+
+	client := newRegistryClient(name)
+	client.scopes = []string{fmt.Sprintf("repository:%s:push", client.repository)}
+	progress.Message(fmt.Sprintf("The push refers to repository [%s/%s]", client.registry, client.repository))
+
+	// The token has to cover pulling from the repositories blobs are
+	// mounted from
+	sources := map[string]string{}
+	for _, layer := range manifest.Layers {
+		if from := mountSource(client, layer.Digest); from != "" {
+			sources[layer.Digest] = from
+			client.scopes = append(client.scopes, fmt.Sprintf("repository:%s:pull", from))
+		}
+		progress.Layer(LayerProgress{ID: shortDigest(layer.Digest), Phase: PhaseWaiting, Total: layer.Size})
+	}
+	for _, layer := range manifest.Layers {
+		if err := client.pushBlob(ctx, layer, sources[layer.Digest], progress); err != nil {
+			return err
+		}
+	}
+	// The config goes after the layers, as registries may check them
+	if err := client.pushBlob(ctx, manifest.Config, "", nil); err != nil {
+		return err
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = mediaTypeOCIManifest
+	}
+	resp, err := client.send(ctx, "/manifests/"+tag, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, client.baseURL()+"/manifests/"+tag, bytes.NewReader(manifestJSON))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		req.ContentLength = int64(len(manifestJSON))
+		return req, nil
+	}, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to push manifest: %w", err)
+	}
+	resp.Body.Close()
+
+	progress.Message(fmt.Sprintf("%s: digest: %s size: %d", tag, img.Digest, len(manifestJSON)))
+	logEvent("push", img.ID, name+":"+tag)
+	return nil
+}
+
+// pushBlob uploads a blob unless the repository has it, mounting it from
+// the repository from when given. A nil progress reports nothing.
+func (r *registryClient) pushBlob(ctx context.Context, desc Descriptor, from string, progress ProgressReporter) error {
+	short := shortDigest(desc.Digest)
+	report := func(phase string, n int64) {
+		if progress != nil {
+			progress.Layer(LayerProgress{ID: short, Phase: phase, Current: n, Total: desc.Size})
+		}
+	}
+
+	resp, err := r.do(ctx, http.MethodHead, "/blobs/"+desc.Digest, nil)
+	if err == nil {
+		resp.Body.Close()
+		report(PhaseOnline, desc.Size)
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to check blob %s: %w", short, err)
+	}
+
+	// A registry that mounts the blob answers 201, one that doesn't starts
+	// an upload instead
+	query := url.Values{}
+	if from != "" {
+		query.Set("mount", desc.Digest)
+		query.Set("from", from)
+	}
+	resp, err = r.send(ctx, "/blobs/uploads/", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL()+"/blobs/uploads/?"+query.Encode(), nil)
+	}, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return fmt.Errorf("failed to start upload of blob %s: %w", short, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		report(PhaseMounted+" from "+from, desc.Size)
+		return nil
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("registry returned no upload location for blob %s: %w", short, err)
+	}
+	uploadURL := location.Query()
+	uploadURL.Set("digest", desc.Digest)
+	location.RawQuery = uploadURL.Encode()
+
+	path, err := BlobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+	report(PhasePushing, 0)
+	resp, err = r.send(ctx, location.Path, func() (*http.Request, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s: %w", short, err)
+		}
+		// The transport closes the file along with the body
+		body := readCloser{&progressReader{r: f, report: func(n int64) { report(PhasePushing, n) }}, f}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, location.String(), body)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		req.ContentLength = desc.Size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	}, http.StatusCreated)
+	if err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", short, err)
+	}
+	resp.Body.Close()
+	report(PhasePushed, desc.Size)
+	return nil
+}
+
+// mountSource returns another repository of the client's registry that a
+// local image was pulled from or pushed to and that holds the blob, or ""
+func mountSource(client *registryClient, digest string) string {
+	refs, err := loadReferences()
+	if err != nil {
+		return ""
+	}
+	for ref, id := range refs {
+		name, _ := ParseReference(ref)
+		registry, repository := splitRepository(name)
+		if registry != client.registry || repository == client.repository {
+			continue
+		}
+		img, err := loadImageByID(id)
+		if err != nil {
+			continue
+		}
+		for _, layer := range img.Layers {
+			if layer == digest {
+				return repository
+			}
+		}
+	}
+	return ""
+}
+
+// readCloser reads from a reader and closes a closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,39 @@
+// pkg/fimage/reference.go
+package fimage
+
+import "strings"
+
+const (
+	defaultRegistry = "registry-1.docker.io"
+	defaultTag      = "latest"
+)
+
+// ParseReference splits an image reference like "alpine", "alpine:3.19" or
+// "localhost:5000/team/app:v1" into its name and tag
+func ParseReference(ref string) (name, tag string) {
+	// Digests are not tags
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+
+	// A colon after the last slash separates the tag; colons before it
+	// belong to a registry host:port
+	lastSlash := strings.LastIndex(ref, "/")
+	if i := strings.LastIndex(ref, ":"); i > lastSlash {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, defaultTag
+}
+
+// splitRepository returns the registry host and repository path for an
+// image name, applying Docker Hub's defaults for short names
+func splitRepository(name string) (registry, repository string) {
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+	if !found {
+		return defaultRegistry, "library/" + name
+	}
+	return defaultRegistry, name
+}
@@ -0,0 +1,284 @@
+// pkg/fimage/references.go
+package fimage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/events"
+)
+
+// referencesFile maps name:tag references to the IDs of the images they
+// name. An image can have any number of references, or none.
+func referencesFile() string {
+	return config.DataPath("images", "repositories.json")
+}
+
+// imageDir returns the directory holding the metadata of the image with
+// the given ID
+func imageDir(id string) (string, error) {
+	hexSum, err := digestHex(id)
+	if err != nil {
+		return "", err
+	}
+	return config.DataPath("images", hexSum), nil
+}
+
+// loadReferences reads the name:tag to image ID map
+func loadReferences() (map[string]string, error) {
+	refs := map[string]string{}
+	data, err := os.ReadFile(referencesFile())
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image references: %w", err)
+	}
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("failed to parse image references: %w", err)
+	}
+	return refs, nil
+}
+
+// updateReferences lets fn change the name:tag to image ID map while
+// holding the references lock, then saves it
+func updateReferences(fn func(map[string]string) error) error {
+	if err := os.MkdirAll(filepath.Dir(referencesFile()), 0755); err != nil {
+		return fmt.Errorf("failed to create images directory: %w", err)
+	}
+	lock, err := os.OpenFile(referencesFile()+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open image references lock: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock image references: %w", err)
+	}
+
+	refs, err := loadReferences()
+	if err != nil {
+		return err
+	}
+	if err := fn(refs); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(refs, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize image references: %w", err)
+	}
+	tmp := referencesFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write image references: %w", err)
+	}
+	return os.Rename(tmp, referencesFile())
+}
+
+// setReference points name:tag at the image with the given ID, moving it
+// off any image it named before
+func setReference(name, tag, id string) error {
+	return updateReferences(func(refs map[string]string) error {
+		refs[name+":"+tag] = id
+		return nil
+	})
+}
+
+// repoTags returns the references naming the image with the given ID,
+// sorted
+func repoTags(refs map[string]string, id string) []string {
+	tags := []string{}
+	for ref, refID := range refs {
+		if refID == id {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Tag adds the reference target to the image source names. A target
+// naming another image is moved to this one, leaving the other untagged
+// if it was its only reference.
+func Tag(source, target string) error {
+	img, err := Lookup(source)
+	if err != nil {
+		return err
+	}
+	name, tag := ParseReference(target)
+	if err := validateReference(name, tag); err != nil {
+		return err
+	}
+	if err := setReference(name, tag, img.ID); err != nil {
+		return err
+	}
+	logEvent("tag", img.ID, name+":"+tag)
+	return nil
+}
+
+// Untag removes the reference ref. The image it named is left in place,
+// even with no reference left.
+func Untag(ref string) error {
+	name, tag := ParseReference(ref)
+	var id string
+	err := updateReferences(func(refs map[string]string) error {
+		var ok bool
+		if id, ok = refs[name+":"+tag]; !ok {
+			return fmt.Errorf("%w: %s:%s", ErrImageNotFound, name, tag)
+		}
+		delete(refs, name+":"+tag)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logEvent("untag", id, name+":"+tag)
+	return nil
+}
+
+// logEvent records an event about the image id, known as ref, in the
+// events log
+func logEvent(action, id, ref string) {
+	var attributes map[string]string
+	if ref != "" {
+		attributes = map[string]string{"name": ref}
+	}
+	events.Log(events.TypeImage, action, id, attributes)
+}
+
+// validateReference checks that name:tag can be used as a reference
+func validateReference(name, tag string) error {
+	if name == "" || tag == "" {
+		return fmt.Errorf("invalid reference %q", name+":"+tag)
+	}
+	if name != strings.ToLower(name) {
+		return fmt.Errorf("invalid reference %q: repository names must be lowercase", name+":"+tag)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid reference %q", name+":"+tag)
+		}
+	}
+	if strings.ContainsAny(tag, "/:") {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	return nil
+}
+
+// Lookup returns the local image ref refers to: a name with an optional
+// tag, an image ID or a unique prefix of one of at least 4 characters, or
+// a manifest digest, alone or as name@digest. It never pulls.
+func Lookup(ref string) (*Image, error) {
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		img, err := loadImageByDigest(digest)
+		if err != nil {
+			return nil, err
+		}
+		// A tag before the digest is ignored, as the digest names the image
+		name, _ = ParseReference(name)
+		for _, tag := range img.RepoTags {
+			if tagName, _ := ParseReference(tag); tagName == name {
+				return img, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	}
+	if strings.HasPrefix(ref, "sha256:") {
+		img, err := loadImageByIDPrefix(strings.TrimPrefix(ref, "sha256:"), ref)
+		if errors.Is(err, ErrImageNotFound) {
+			if _, digestErr := digestHex(ref); digestErr == nil {
+				return loadImageByDigest(ref)
+			}
+		}
+		return img, err
+	}
+
+	name, tag := ParseReference(ref)
+	img, err := loadImage(name, tag)
+	if err == nil || !isHexPrefix(ref) {
+		return img, err
+	}
+	return loadImageByIDPrefix(ref, ref)
+}
+
+// loadImageByDigest loads the stored image whose manifest has the given
+// digest
+func loadImageByDigest(digest string) (*Image, error) {
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		img, err := loadImageByID(id)
+		if err != nil {
+			continue
+		}
+		if img.Digest == digest {
+			return img, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrImageNotFound, digest)
+}
+
+// isHexPrefix reports whether s could be a shortened image ID
+func isHexPrefix(s string) bool {
+	if len(s) < 4 || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadImageByIDPrefix loads the only stored image whose ID starts with
+// prefix
+func loadImageByIDPrefix(prefix, ref string) (*Image, error) {
+	if !isHexPrefix(prefix) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	}
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	var found string
+	for _, id := range ids {
+		if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), prefix) {
+			if found != "" {
+				return nil, fmt.Errorf("image ID prefix %s is ambiguous", prefix)
+			}
+			found = id
+		}
+	}
+	if found == "" {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	}
+	return loadImageByID(found)
+}
+
+// imageIDs returns the IDs of all stored images, tagged or not
+func imageIDs() ([]string, error) {
+	entries, err := os.ReadDir(config.DataPath("images"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images directory: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		id := "sha256:" + entry.Name()
+		if _, err := digestHex(id); err == nil && entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,407 @@
+// pkg/fimage/registry.go
+package fimage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Media types accepted from registries
+const (
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCILayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeOCILayer        = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Descriptor points to a blob or manifest by digest
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform identifies the OS and architecture of a manifest in an index
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Manifest is an OCI image manifest or Docker v2 schema 2 manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// index is an OCI image index or Docker manifest list
+type index struct {
+	SchemaVersion int          `json:"schemaVersion,omitempty"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// registryClient talks to one repository of an OCI distribution registry
+type registryClient struct {
+	http       *http.Client
+	registry   string
+	repository string
+	token      string
+	// creds are those saved by floka login for the registry, nil for
+	// anonymous access
+	creds *Credentials
+	// basic is set once the registry asked for HTTP basic authentication
+	basic bool
+	// scopes are the token scopes asked for besides pulling from the
+	// repository, e.g. pushing to it
+	scopes []string
+	// scheme is that of a registry mirror, which baseURL picks otherwise
+	scheme string
+}
+
+func newRegistryClient(name string) *registryClient {
+	registry, repository := splitRepository(name)
+	return &registryClient{
+		http:       &http.Client{Timeout: 30 * time.Minute},
+		registry:   registry,
+		repository: repository,
+		creds:      lookupCredentials(registry),
+	}
+}
+
+// pullClients returns the clients to try pulling an image with, in order:
+// those of the registry mirrors of the config file for Docker Hub images,
+// then that of the image's own registry
+func pullClients(name string) []*registryClient {
+	client := newRegistryClient(name)
+	if client.registry != defaultRegistry {
+		return []*registryClient{client}
+	}
+	var clients []*registryClient
+	for _, mirror := range config.Current().RegistryMirrors {
+		u, err := config.MirrorURL(mirror)
+		if err != nil {
+			logging.L().Warn("ignoring registry mirror of the config file", "err", err)
+			continue
+		}
+		clients = append(clients, &registryClient{
+			http:       client.http,
+			registry:   u.Host,
+			repository: client.repository,
+			creds:      lookupCredentials(u.Host),
+			scheme:     u.Scheme,
+		})
+	}
+	return append(clients, client)
+}
+
+// baseURL uses plain HTTP only for local registries and mirrors configured
+// with http://
+func (r *registryClient) baseURL() string {
+	scheme := "https"
+	host := strings.Split(r.registry, ":")[0]
+	if r.scheme != "" {
+		scheme = r.scheme
+	} else if host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, r.registry, r.repository)
+}
+
+// do sends a GET or HEAD request, fetching a bearer token and retrying
+// once if the registry asks for authentication
+func (r *registryClient) do(ctx context.Context, method, path string, accept []string) (*http.Response, error) {
+	return r.send(ctx, path, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, r.baseURL()+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		return req, nil
+	}, http.StatusOK)
+}
+
+// send sends the request newRequest builds, building it again after
+// authenticating if the registry asks for it. Replies with a status other
+// than those in ok are returned as a *StatusError.
+func (r *registryClient) send(ctx context.Context, path string, newRequest func() (*http.Request, error), ok ...int) (*http.Response, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if r.basic && r.creds != nil {
+			req.SetBasicAuth(r.creds.Username, r.creds.Password)
+		}
+
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := r.authenticate(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized && r.creds != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry authentication failed for %s: %w", r.registry, ErrUnauthorized)
+		}
+		for _, code := range ok {
+			if resp.StatusCode == code {
+				return resp, nil
+			}
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status, Path: path, Body: strings.TrimSpace(string(body))}
+	}
+	return nil, fmt.Errorf("registry authentication failed for %s", r.repository)
+}
+
+// authenticate answers a "Basic realm=..." challenge with the saved
+// credentials, or obtains a token from the realm named in a "Bearer
+// realm=...,service=...,scope=..." challenge, anonymously without them
+func (r *registryClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "Basic") {
+		if r.creds == nil {
+			return fmt.Errorf("registry %s requires a login, use floka login %s", r.registry, r.registry)
+		}
+		r.basic = true
+		return nil
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("unsupported registry authentication scheme: %q", scheme)
+	}
+
+	values := map[string]string{}
+	for _, part := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	realm := values["realm"]
+	if realm == "" {
+		return fmt.Errorf("registry authentication challenge has no realm")
+	}
+
+	query := url.Values{}
+	if service := values["service"]; service != "" {
+		query.Set("service", service)
+	}
+	// Logging in only checks the credentials, with no repository
+	if r.repository != "" {
+		query.Add("scope", fmt.Sprintf("repository:%s:pull", r.repository))
+	}
+	for _, scope := range r.scopes {
+		query.Add("scope", scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	if r.creds != nil {
+		req.SetBasicAuth(r.creds.Username, r.creds.Password)
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && r.creds != nil {
+		return fmt.Errorf("failed to fetch registry token: %w", ErrUnauthorized)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch registry token: %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
+
+// fetchManifest resolves a tag or digest to an image manifest for the
+// current platform, following indexes/manifest lists
+func (r *registryClient) fetchManifest(ctx context.Context, reference string) (*Manifest, []byte, error) {
+	accept := []string{mediaTypeOCIIndex, mediaTypeDockerList, mediaTypeOCIManifest, mediaTypeDockerManifest}
+
+	for depth := 0; depth < 2; depth++ {
+		resp, err := r.do(ctx, http.MethodGet, "/manifests/"+reference, accept)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		mediaType := resp.Header.Get("Content-Type")
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		if strings.HasPrefix(reference, "sha256:") {
+			if err := verifyBytes(body, reference); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		var probe struct {
+			MediaType string            `json:"mediaType"`
+			Manifests []json.RawMessage `json:"manifests"`
+		}
+		if err := json.Unmarshal(body, &probe); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		if probe.MediaType != "" {
+			mediaType = probe.MediaType
+		}
+
+		if mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerList || len(probe.Manifests) > 0 {
+			var idx index
+			if err := json.Unmarshal(body, &idx); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse image index: %w", err)
+			}
+			desc, err := selectPlatform(idx.Manifests)
+			if err != nil {
+				return nil, nil, err
+			}
+			reference = desc.Digest
+			continue
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		if manifest.SchemaVersion != 2 {
+			return nil, nil, fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+		}
+		return &manifest, body, nil
+	}
+	return nil, nil, fmt.Errorf("image index points to another index")
+}
+
+// selectPlatform picks the manifest matching the host OS and architecture
+func selectPlatform(manifests []Descriptor) (*Descriptor, error) {
+	variant := ""
+	if runtime.GOARCH == "arm" {
+		variant = "v7"
+	} else if runtime.GOARCH == "arm64" {
+		variant = "v8"
+	}
+
+	var fallback *Descriptor
+	for i := range manifests {
+		p := manifests[i].Platform
+		if p == nil || p.OS != runtime.GOOS || p.Architecture != runtime.GOARCH {
+			continue
+		}
+		if p.Variant == "" || p.Variant == variant {
+			return &manifests[i], nil
+		}
+		if fallback == nil {
+			fallback = &manifests[i]
+		}
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("no image found for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// fetchBlob downloads a blob into the blob store, verifying its digest,
+// and returns its path there. Blobs already stored are not downloaded again.
+// A non-nil report is called with the number of bytes downloaded so far.
+func (r *registryClient) fetchBlob(ctx context.Context, desc Descriptor, report func(n int64)) (string, error) {
+	path, err := BlobPath(desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	if hasBlob(desc.Digest) {
+		return path, nil
+	}
+
+	resp, err := r.do(ctx, http.MethodGet, "/blobs/"+desc.Digest, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := createBlobFile()
+	if err != nil {
+		return "", err
+	}
+
+	var body io.Reader = resp.Body
+	if report != nil {
+		body = &progressReader{r: resp.Body, report: report}
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), body)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkDigest(hash.Sum(nil), desc.Digest)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to download blob %s: %w", desc.Digest, err)
+	}
+	return path, putBlob(f.Name(), desc.Digest)
+}
+
+// verifyBytes checks data against a sha256 digest
+func verifyBytes(data []byte, digest string) error {
+	sum := sha256.Sum256(data)
+	return checkDigest(sum[:], digest)
+}
+
+func checkDigest(sum []byte, digest string) error {
+	algorithm, expected, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest %q", digest)
+	}
+	if actual := hex.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+// pkg/fimage/remove.go
+package fimage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// RemoveResult lists what removing an image did
+type RemoveResult struct {
+	Untagged []string // References removed
+	Deleted  []string // IDs of deleted images and diff IDs of deleted layers
+	Size     int64    // Disk space freed
+}
+
+// Remove removes the reference the image was looked up by, and deletes
+// the image once it has no reference left. An image looked up by ID or
+// digest loses all its references. Without force, an image is not deleted
+// while a container uses it, nor by ID while it has several references.
+// Layers still used by a container are never deleted.
+func (img *Image) Remove(force bool) (*RemoveResult, error) {
+	logging.L().Debug("removing image", "image", img.Name+":"+img.Tag, "id", img.ID)
+	ref := shortDigest(img.ID)
+	if img.Name != "" {
+		ref = img.Name + ":" + img.Tag
+	}
+
+	users, err := imageUsers(img)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RemoveResult{}
+	deleted := false
+	err = updateReferences(func(refs map[string]string) error {
+		tags := repoTags(refs, img.ID)
+		if img.Name != "" {
+			if refs[ref] != img.ID {
+				return fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+			}
+			tags = []string{ref}
+		} else if len(tags) > 1 && !force {
+			return fmt.Errorf("image %s is referenced as %s, remove it by name or with force", ref, strings.Join(tags, ", "))
+		}
+		last := len(repoTags(refs, img.ID)) == len(tags)
+		if last && len(users) > 0 && !force {
+			return fmt.Errorf("%w: %s is used by container %s", ErrImageInUse, ref, users[0].ID)
+		}
+
+		for _, tag := range tags {
+			delete(refs, tag)
+		}
+		result.Untagged = tags
+		if !last {
+			return nil
+		}
+		if err := os.RemoveAll(img.dir()); err != nil {
+			return fmt.Errorf("failed to remove image %s: %w", ref, err)
+		}
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, tag := range result.Untagged {
+		logEvent("untag", img.ID, tag)
+	}
+	if !deleted {
+		return result, nil
+	}
+	logEvent("delete", img.ID, "")
+
+	result.Deleted = append(result.Deleted, img.ID)
+	layers, size, err := removeUnused(img)
+	result.Deleted = append(result.Deleted, layers...)
+	result.Size = size
+	return result, err
+}
+
+// imageUsers returns the containers created from the image. Containers of
+// older versions only recorded the image reference.
+func imageUsers(img *Image) ([]*container.Container, error) {
+	containers, err := container.ListContainers(nil)
+	if err != nil {
+		return nil, err
+	}
+	var users []*container.Container
+	for _, c := range containers {
+		if c.ImageID == img.ID {
+			users = append(users, c)
+			continue
+		}
+		if c.ImageID != "" {
+			continue
+		}
+		for _, tag := range img.RepoTags {
+			if c.ImageRef() == tag {
+				users = append(users, c)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// usedContent returns the digests of the blobs stored images refer to and
+// the diff IDs of the layers stored images or containers use
+func usedContent() (blobs, layers map[string]bool, err error) {
+	// Images of older versions refer to blobs as well
+	if err := migrateLegacyImages(); err != nil {
+		return nil, nil, err
+	}
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+	blobs = map[string]bool{}
+	layers = map[string]bool{}
+	for _, id := range ids {
+		img, err := loadImageByID(id)
+		if err != nil {
+			// Keep everything rather than risk removing what it uses
+			return nil, nil, fmt.Errorf("failed to read image %s: %w", shortDigest(id), err)
+		}
+		blobs[img.ID] = true
+		blobs[img.Digest] = true
+		for _, digest := range img.Layers {
+			blobs[digest] = true
+		}
+		for _, diffID := range img.DiffIDs {
+			layers[diffID] = true
+		}
+	}
+	containers, err := container.ListContainers(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range containers {
+		for _, dir := range c.Layers {
+			layers["sha256:"+filepath.Base(filepath.Dir(dir))] = true
+		}
+	}
+	return blobs, layers, nil
+}
+
+// removeUnused deletes the blobs of a deleted image that no other image
+// refers to, and its unpacked layers that no other image or container
+// uses. It returns the diff IDs of the deleted layers and the disk space
+// freed.
+func removeUnused(img *Image) ([]string, int64, error) {
+	usedBlobs, usedLayers, err := usedContent()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	for _, digest := range append([]string{img.ID, img.Digest}, img.Layers...) {
+		if usedBlobs[digest] {
+			continue
+		}
+		usedBlobs[digest] = true
+		freed, err := removeBlob(digest)
+		if err != nil {
+			return nil, size, err
+		}
+		size += freed
+	}
+	var deleted []string
+	for _, diffID := range img.DiffIDs {
+		if usedLayers[diffID] {
+			continue
+		}
+		usedLayers[diffID] = true
+		freed, err := removeLayer(diffID)
+		if err != nil {
+			return deleted, size, err
+		}
+		if freed >= 0 {
+			deleted = append(deleted, diffID)
+			size += freed
+		}
+	}
+	return deleted, size, nil
+}
+
+// removeBlob deletes a stored blob and returns its size
+func removeBlob(digest string) (int64, error) {
+	path, err := BlobPath(digest)
+	if err != nil {
+		return 0, nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to remove blob %s: %w", digest, err)
+	}
+	return info.Size(), nil
+}
+
+// removeLayer deletes an unpacked layer and returns the disk space it
+// took, or -1 if it was not unpacked
+func removeLayer(diffID string) (int64, error) {
+	dir, err := LayerDir(diffID)
+	if err != nil || !exists(dir) {
+		return -1, nil
+	}
+	size := fsutil.DirSize(dir)
+	if err := os.RemoveAll(filepath.Dir(dir)); err != nil {
+		return 0, fmt.Errorf("failed to remove layer %s: %w", shortDigest(diffID), err)
+	}
+	return size, nil
+}
+
+// staleAge is how old a blob or layer no image refers to must be before
+// Prune deletes it, so that those of pulls and builds in progress are kept
+const staleAge = time.Hour
+
+// Prune deletes the images without a reference and, with all, every image
+// no container uses, along with their blobs and layers. Blobs and layers
+// no image or container uses, left by interrupted pulls and builds or by
+// images removed while containers used them, are deleted as well.
+func Prune(all bool) (*RemoveResult, error) {
+	images, err := GetImagesFromLocalStorage(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RemoveResult{}
+	seen := map[string]bool{}
+	for _, img := range images {
+		if seen[img.ID] || (len(img.RepoTags) > 0 && !all) {
+			continue
+		}
+		seen[img.ID] = true
+		users, err := imageUsers(img)
+		if err != nil {
+			return result, err
+		}
+		if len(users) > 0 {
+			continue
+		}
+
+		// Every reference goes, as with removal by ID
+		img.Name, img.Tag = "", ""
+		removed, err := img.Remove(true)
+		if removed != nil {
+			result.Untagged = append(result.Untagged, removed.Untagged...)
+			result.Deleted = append(result.Deleted, removed.Deleted...)
+			result.Size += removed.Size
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	deleted, size, err := removeOrphans()
+	result.Deleted = append(result.Deleted, deleted...)
+	result.Size += size
+	return result, err
+}
+
+// removeOrphans deletes the blobs no image refers to and the layers no
+// image or container uses that are older than staleAge. It returns the
+// diff IDs of the deleted layers and the disk space freed.
+func removeOrphans() ([]string, int64, error) {
+	usedBlobs, usedLayers, err := usedContent()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	for _, digest := range staleEntries(blobsDir()) {
+		if usedBlobs[digest] {
+			continue
+		}
+		freed, err := removeBlob(digest)
+		if err != nil {
+			return nil, size, err
+		}
+		size += freed
+	}
+	var deleted []string
+	for _, diffID := range staleEntries(layersDir()) {
+		if usedLayers[diffID] {
+			continue
+		}
+		freed, err := removeLayer(diffID)
+		if err != nil {
+			return deleted, size, err
+		}
+		if freed >= 0 {
+			deleted = append(deleted, diffID)
+			size += freed
+		}
+	}
+	return deleted, size, nil
+}
+
+// staleEntries returns the digests of the entries of a blob or layer
+// store directory older than staleAge. Unfinished ones are left out.
+func staleEntries(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var digests []string
+	for _, entry := range entries {
+		digest := "sha256:" + entry.Name()
+		if _, err := digestHex(digest); err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleAge {
+			continue
+		}
+		digests = append(digests, digest)
+	}
+	return digests
+}
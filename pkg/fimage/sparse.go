@@ -0,0 +1,193 @@
+// pkg/fimage/sparse.go
+package fimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// archive/tar reads sparse files but has no way to write them, so they are
+// written as GNU sparse entries of format 1.0 by hand: a PAX header naming
+// the file and its size, then an entry holding the map of its data
+// segments followed by the data alone
+
+// tarBlockSize is the size of tar headers, entries being padded to it too
+const tarBlockSize = 512
+
+// dataSegment is a part of a sparse file that isn't a hole
+type dataSegment struct {
+	offset, length int64
+}
+
+// writeSparseFile writes the regular file of hdr, at p, to an archive as a
+// sparse entry if it has holes, writing to w between the entries of tw.
+// It reports whether it did.
+func writeSparseFile(w io.Writer, tw *tar.Writer, hdr *tar.Header, p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	segments, err := dataSegments(f, hdr.Size)
+	if err != nil || (len(segments) == 1 && segments[0] == dataSegment{0, hdr.Size}) {
+		// Without holes, or a filesystem that can't tell where they are
+		return false, nil
+	}
+
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(segments))
+	size := int64(0)
+	for _, s := range segments {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", s.offset, s.length)
+		size += s.length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	size += int64(sparseMap.Len())
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+		"size":                strconv.FormatInt(size, 10),
+		"uid":                 strconv.Itoa(hdr.Uid),
+		"gid":                 strconv.Itoa(hdr.Gid),
+		"mtime":               fmt.Sprintf("%d.%09d", hdr.ModTime.Unix(), hdr.ModTime.Nanosecond()),
+	}
+	for key, value := range hdr.PAXRecords {
+		records[key] = value
+	}
+	dir, file := path.Split(hdr.Name)
+	name := path.Join(dir, "GNUSparseFile.0", file)
+
+	// Whatever tw wrote last is padded before writing past it
+	if err := tw.Flush(); err != nil {
+		return true, err
+	}
+	var paxData bytes.Buffer
+	for _, key := range sortedKeys(records) {
+		paxData.WriteString(paxRecord(key, records[key]))
+	}
+	paxHeader := ustarHeader(path.Join(dir, "PaxHeaders.0", file), tar.TypeXHeader, 0644, int64(paxData.Len()), hdr)
+	paxData.Write(make([]byte, blockPadding(int64(paxData.Len()))))
+	entryHeader := ustarHeader(name, tar.TypeReg, hdr.Mode, 0, hdr)
+	for _, b := range [][]byte{paxHeader, paxData.Bytes(), entryHeader, sparseMap.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return true, err
+		}
+	}
+
+	for _, s := range segments {
+		if _, err := io.Copy(w, io.NewSectionReader(f, s.offset, s.length)); err != nil {
+			return true, fmt.Errorf("failed to add %s to archive: %w", hdr.Name, err)
+		}
+	}
+	_, err = w.Write(make([]byte, blockPadding(size)))
+	return true, err
+}
+
+// dataSegments returns where the data of a file of the given size is,
+// ending with an empty segment at its end when it ends with a hole, as GNU
+// tar wants
+func dataSegments(f *os.File, size int64) ([]dataSegment, error) {
+	fd := int(f.Fd())
+	var segments []dataSegment
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// Only a hole is left
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if end > size {
+			end = size
+		}
+		segments = append(segments, dataSegment{start, end - start})
+		offset = end
+	}
+	if len(segments) == 0 || segments[len(segments)-1].offset+segments[len(segments)-1].length < size {
+		segments = append(segments, dataSegment{size, 0})
+	}
+	return segments, nil
+}
+
+// ustarHeader encodes a tar header whose other fields are in the PAX
+// header before it. Numbers too large for their fields are left out, the
+// PAX header holding them.
+func ustarHeader(name string, typeflag byte, mode, size int64, hdr *tar.Header) []byte {
+	b := make([]byte, tarBlockSize)
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	copy(b[0:100], name)
+	putOctal(b[100:108], mode&07777)
+	putOctal(b[108:116], int64(hdr.Uid))
+	putOctal(b[116:124], int64(hdr.Gid))
+	putOctal(b[124:136], size)
+	putOctal(b[136:148], hdr.ModTime.Unix())
+	b[156] = typeflag
+	copy(b[257:265], "ustar\x0000")
+
+	// The checksum is taken with its own field filled with spaces
+	copy(b[148:156], "        ")
+	sum := int64(0)
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b
+}
+
+// putOctal writes n as a NUL terminated octal number filling field, or
+// leaves field empty if n doesn't fit
+func putOctal(field []byte, n int64) {
+	s := strconv.FormatInt(n, 8)
+	if n < 0 || len(s) > len(field)-1 {
+		return
+	}
+	for i := 0; i < len(field)-1-len(s); i++ {
+		field[i] = '0'
+	}
+	copy(field[len(field)-1-len(s):], s)
+}
+
+// paxRecord formats a PAX record, whose length counts its own digits
+func paxRecord(key, value string) string {
+	record := " " + key + "=" + value + "\n"
+	n := len(record) + len(strconv.Itoa(len(record)))
+	// Counting the digits may take one more
+	if m := len(record) + len(strconv.Itoa(n)); m != n {
+		n = m
+	}
+	return strconv.Itoa(n) + record
+}
+
+// blockPadding returns how many bytes pad n bytes to a tar block
+func blockPadding(n int64) int64 {
+	return -n & (tarBlockSize - 1)
+}
+
+// sortedKeys returns the keys of a PAX header in order, for archives that
+// don't change from one export to the next
+func sortedKeys(records map[string]string) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
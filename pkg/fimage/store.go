@@ -0,0 +1,319 @@
+// pkg/fimage/store.go
+package fimage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// imageDigests returns the ID of the image stored in imageDir, the digest
+// of its config, and its digest, the digest of its manifest
+func imageDigests(imageDir string) (id, digest string, err error) {
+	configJSON, err := os.ReadFile(filepath.Join(imageDir, "metadata", "config.json"))
+	if err != nil {
+		return "", "", err
+	}
+	manifestJSON, err := os.ReadFile(filepath.Join(imageDir, "metadata", "manifest.json"))
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(configJSON)), fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJSON)), nil
+}
+
+// layerDigests returns the digests of layer descriptors
+func layerDigests(layers []Descriptor) []string {
+	digests := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		digests = append(digests, layer.Digest)
+	}
+	return digests
+}
+
+// migrateLegacyImage moves an image stored by an older floka version in a
+// directory named after its reference, images/<name>:<tag>, to the image
+// store and tags it with that reference. Nothing is done for images that
+// were never stored that way or were already moved.
+func migrateLegacyImage(name, tag string) error {
+	legacyDir := config.DataPath("images", name+":"+tag)
+	if !exists(legacyDir) {
+		return nil
+	}
+	metadataDir := filepath.Join(legacyDir, "metadata")
+	rootDir := filepath.Join(legacyDir, "rootfs")
+
+	if !exists(metadataDir) {
+		refs, err := loadReferences()
+		if err != nil {
+			return err
+		}
+		// Only a rootfs still used by a container is left of a moved image
+		if _, ok := refs[name+":"+tag]; ok || !exists(rootDir) {
+			if err := removeLegacyRootfs(legacyDir); err != nil {
+				return err
+			}
+			os.Remove(legacyDir)
+			return nil
+		}
+	}
+	if exists(rootDir) {
+		if err := migrateImage(legacyDir); err != nil {
+			return err
+		}
+	}
+
+	id, _, err := imageDigests(legacyDir)
+	if err != nil {
+		return err
+	}
+	dir, err := imageDir(id)
+	if err != nil {
+		return err
+	}
+	if exists(dir) {
+		if err := os.RemoveAll(metadataDir); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create image directory: %w", err)
+		}
+		if err := os.Rename(metadataDir, filepath.Join(dir, "metadata")); err != nil {
+			return fmt.Errorf("failed to move image metadata: %w", err)
+		}
+		os.Remove(filepath.Join(dir, "metadata", "image.info"))
+	}
+	if err := setReference(name, tag, id); err != nil {
+		return err
+	}
+	logging.L().Info("moved image to the image store", "image", name+":"+tag, "id", shortDigest(id))
+
+	// The directory stays while a container is mounted on its rootfs
+	os.Remove(legacyDir)
+	return nil
+}
+
+// migrateLegacyImages moves all images stored by older floka versions in
+// directories named after their reference to the image store
+func migrateLegacyImages() error {
+	refs, err := legacyImageRefs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		name, tag := ParseReference(ref)
+		if err := migrateLegacyImage(name, tag); err != nil {
+			logging.L().Warn("skipping image stored by an older version", "image", ref, "err", err)
+		}
+	}
+	return nil
+}
+
+// legacyImageRefs returns the references of the images stored by older
+// floka versions in directories named after them
+func legacyImageRefs() ([]string, error) {
+	imagesDir := config.DataPath("images")
+	var refs []string
+	err := filepath.WalkDir(imagesDir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == imagesDir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == imagesDir {
+			return nil
+		}
+		// Hidden directories hold operations in progress
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(imagesDir, path)
+		if err != nil {
+			return err
+		}
+		if _, err := digestHex("sha256:" + rel); err == nil {
+			return filepath.SkipDir
+		}
+		// Names like team/app live in nested directories
+		if exists(filepath.Join(path, "metadata")) || exists(filepath.Join(path, "rootfs")) {
+			refs = append(refs, rel)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// migrateImage moves an image stored by an older floka version, which has
+// a flat rootfs, to the blob and layer stores. Images without a manifest
+// get one with their whole rootfs as a single layer, the others have their
+// layers unpacked from their blobs. The rootfs is removed once no
+// container is mounted on it.
+func migrateImage(imageDir string) error {
+	if _, err := os.Stat(filepath.Join(imageDir, "metadata", "manifest.json")); os.IsNotExist(err) {
+		if err := storeRootfs(imageDir); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := loadManifest(imageDir)
+	if err != nil {
+		return err
+	}
+	config, err := loadConfig(imageDir)
+	if err != nil {
+		return err
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("image config lists %d layers but the manifest has %d", len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	for i, layer := range manifest.Layers {
+		if hasLayer(config.RootFS.DiffIDs[i]) {
+			continue
+		}
+		logging.L().Info("moving image layer to the layer store", "path", imageDir, "layer", shortDigest(layer.Digest))
+		blobPath, err := BlobPath(layer.Digest)
+		if err != nil {
+			return err
+		}
+		if err := unpackLayer(blobPath, config.RootFS.DiffIDs[i], nil); err != nil {
+			return err
+		}
+	}
+
+	return removeLegacyRootfs(imageDir)
+}
+
+// removeLegacyRootfs removes the flat rootfs of an image stored by an older
+// floka version unless a container is still mounted on it
+func removeLegacyRootfs(imageDir string) error {
+	rootDir := filepath.Join(imageDir, "rootfs")
+	containers, err := container.ListContainers(nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.Image == rootDir && c.StorageDriver != container.StorageVFS {
+			logging.L().Debug("keeping rootfs used by a container", "path", rootDir, "container", c.ID)
+			return nil
+		}
+	}
+	return os.RemoveAll(rootDir)
+}
+
+// storeRootfs stores the flat rootfs of an image without a manifest as a
+// single layer blob and gives the image a manifest, and a config made up
+// from what is known about it if it has none
+func storeRootfs(imageDir string) error {
+	rootDir := filepath.Join(imageDir, "rootfs")
+	files, err := snapshot(rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to scan rootfs: %w", err)
+	}
+	layer, err := commitLayer(rootDir, map[string]fileState{}, files)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig(imageDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		config = &ImageConfig{
+			Created:      getCreationTime(imageDir),
+			Architecture: runtime.GOARCH,
+			OS:           runtime.GOOS,
+		}
+		if volumes := loadVolumes(imageDir); len(volumes) > 0 {
+			config.Config.Volumes = map[string]struct{}{}
+			for _, v := range volumes {
+				config.Config.Volumes[v] = struct{}{}
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	layers := []Descriptor{}
+	config.RootFS = RootFS{Type: "layers", DiffIDs: []string{}}
+	if layer != nil {
+		layers = append(layers, *layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.Digest)
+	}
+	configDesc, err := saveConfig(imageDir, config)
+	if err != nil {
+		return err
+	}
+	// The manifest goes last, an interrupted move starts over
+	if _, err := saveManifest(imageDir, &Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	}); err != nil {
+		return err
+	}
+
+	// Built images had their own layer files, the single layer replaces them
+	os.RemoveAll(filepath.Join(imageDir, "layers"))
+	os.Remove(filepath.Join(imageDir, "metadata", "layers.json"))
+	return nil
+}
+
+// storeImage records the image name:tag for a manifest and config whose
+// blobs and layers are already in the stores. The manifest and config are
+// kept byte for byte.
+func storeImage(name, tag string, manifestJSON, configJSON []byte) (*Image, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(config.DataPath("images"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create images directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(config.DataPath("images"), ".store-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary image directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := saveMetadataBlob(tmpDir, "manifest.json", manifestJSON, manifest.MediaType); err != nil {
+		return nil, fmt.Errorf("failed to save manifest: %w", err)
+	}
+	if _, err := saveMetadataBlob(tmpDir, "config.json", configJSON, manifest.Config.MediaType); err != nil {
+		return nil, fmt.Errorf("failed to save image config: %w", err)
+	}
+	return addImage(tmpDir, name, tag)
+}
+
+// addImage moves the image metadata assembled in tmpDir into the image
+// store, unless an image with the same ID is stored already, and points
+// name:tag at it. An image name:tag named before is left untagged if that
+// was its only reference.
+func addImage(tmpDir, name, tag string) (*Image, error) {
+	id, _, err := imageDigests(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image metadata: %w", err)
+	}
+	dir, err := imageDir(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil && !exists(dir) {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := setReference(name, tag, id); err != nil {
+		return nil, err
+	}
+	return loadImage(name, tag)
+}
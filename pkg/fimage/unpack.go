@@ -0,0 +1,320 @@
+// pkg/fimage/unpack.go
+package fimage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// overlayOpaque marks a directory hiding the contents of lower layers
+	overlayOpaque = "trusted.overlay.opaque"
+
+	// xattrRecord prefixes the PAX records holding extended attributes
+	xattrRecord = "SCHILY.xattr."
+)
+
+// applyLayer extracts a (possibly gzip compressed) layer tarball onto
+// rootfs, applying OCI whiteouts for files deleted by the layer
+func applyLayer(layerPath, rootfs string) error {
+	r, closeLayer, err := openLayer(layerPath, nil)
+	if err != nil {
+		return err
+	}
+	defer closeLayer()
+	return extractLayer(r, rootfs, false)
+}
+
+// openLayer opens a layer tarball, decompressing it if needed. The
+// returned function closes it.
+func openLayer(layerPath string, report func(n int64)) (io.Reader, func(), error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open layer: %w", err)
+	}
+
+	var src io.Reader = f
+	if report != nil {
+		src = &progressReader{r: f, report: report}
+	}
+	br := bufio.NewReader(src)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to decompress layer: %w", err)
+		}
+		return gz, func() { gz.Close(); f.Close() }, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		f.Close()
+		return nil, nil, fmt.Errorf("zstd compressed layers are not supported")
+	}
+	return br, func() { f.Close() }, nil
+}
+
+// extractLayer extracts an uncompressed layer tarball onto rootfs. With
+// overlay set, rootfs is a layer directory of its own and whiteouts are
+// kept in the form overlayfs understands, as 0/0 character devices and
+// opaque directories, instead of being applied.
+func extractLayer(r io.Reader, rootfs string, overlay bool) error {
+	// Paths written by this layer are never removed by its own whiteouts
+	written := map[string]bool{}
+	type dirTimes struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTimes
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer: %w", err)
+		}
+
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := filepath.Split(name)
+
+		parent, err := fsutil.SecureJoin(rootfs, dir)
+		if err != nil {
+			return fmt.Errorf("invalid path %s in layer: %w", hdr.Name, err)
+		}
+
+		// Whiteouts delete entries from lower layers
+		if base == whiteoutOpaque {
+			if overlay {
+				err = markOpaque(parent)
+			} else {
+				err = clearOpaqueDir(parent, filepath.Clean(dir), written)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			// The deleted entry itself is named, a symlink included, so only
+			// its directory is resolved, and a name that isn't an entry of it
+			// would lead out of it
+			deleted := strings.TrimPrefix(base, whiteoutPrefix)
+			if deleted == "" || deleted == "." || deleted == ".." || strings.ContainsRune(deleted, '/') {
+				return fmt.Errorf("invalid whiteout %s in layer", hdr.Name)
+			}
+			target := filepath.Join(parent, deleted)
+			if overlay {
+				err = createWhiteout(target)
+			} else {
+				err = os.RemoveAll(target)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply whiteout %s: %w", hdr.Name, err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", hdr.Name, err)
+		}
+		target := filepath.Join(parent, base)
+		written[name] = true
+
+		// Replace whatever a lower layer had here, except when both are
+		// directories
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", hdr.Name, err)
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", hdr.Name, err)
+			}
+			dirs = append(dirs, dirTimes{target, hdr.ModTime})
+
+		case tar.TypeReg, tar.TypeRegA:
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", hdr.Name, err)
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeLink:
+			// The link is to the named file itself, a symlink included
+			linkName := filepath.Clean("/" + hdr.Linkname)
+			linkDir, err := fsutil.SecureJoin(rootfs, filepath.Dir(linkName))
+			if err != nil || linkName == "/" {
+				return fmt.Errorf("invalid hard link %s to %s", hdr.Name, hdr.Linkname)
+			}
+			source := filepath.Join(linkDir, filepath.Base(linkName))
+			if err := os.Link(source, target); err != nil {
+				return fmt.Errorf("failed to create hard link %s: %w", hdr.Name, err)
+			}
+
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			mode := uint32(hdr.Mode & 07777)
+			switch hdr.Typeflag {
+			case tar.TypeChar:
+				mode |= syscall.S_IFCHR
+			case tar.TypeBlock:
+				mode |= syscall.S_IFBLK
+			default:
+				mode |= syscall.S_IFIFO
+			}
+			dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+			if err := unix.Mknod(target, mode, int(dev)); err != nil {
+				logging.L().Warn("skipping device node", "path", hdr.Name, "err", err)
+				continue
+			}
+
+		default:
+			logging.L().Debug("skipping unsupported tar entry", "path", hdr.Name, "type", hdr.Typeflag)
+			continue
+		}
+
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+			return fmt.Errorf("failed to chown %s: %w", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+		// Extended attributes after chown too, which clears file capabilities
+		setXattrs(target, hdr)
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			setSymlinkTime(target, hdr.ModTime)
+		default:
+			// chmod after chown, which clears setuid/setgid bits
+			if err := os.Chmod(target, os.FileMode(hdr.Mode&0777)|modeBits(hdr.Mode)); err != nil {
+				return fmt.Errorf("failed to chmod %s: %w", hdr.Name, err)
+			}
+			if hdr.Typeflag != tar.TypeDir {
+				os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			}
+		}
+	}
+
+	// Directory times change while their contents are written
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return nil
+}
+
+// modeBits converts the setuid, setgid and sticky bits of a tar mode
+func modeBits(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// setXattrs sets the extended attributes recorded for an entry on the file
+// extracted from it. Those the filesystem or our privileges don't allow
+// are skipped, as are overlayfs attributes, with which a layer could hide
+// or redirect the files of lower layers.
+func setXattrs(target string, hdr *tar.Header) {
+	for key, value := range hdr.PAXRecords {
+		name := strings.TrimPrefix(key, xattrRecord)
+		if name == key {
+			continue
+		}
+		if strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil {
+			logging.L().Warn("skipping extended attribute", "path", hdr.Name, "xattr", name, "err", err)
+		}
+	}
+}
+
+// setSymlinkTime sets the modification time of a symlink itself
+func setSymlinkTime(target string, mtime time.Time) {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	unix.UtimesNanoAt(unix.AT_FDCWD, target, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// markOpaque marks a directory of an overlay layer as opaque
+func markOpaque(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create opaque directory: %w", err)
+	}
+	if err := syscall.Setxattr(dir, overlayOpaque, []byte("y"), 0); err != nil {
+		return fmt.Errorf("failed to mark %s opaque: %w", dir, err)
+	}
+	return nil
+}
+
+// createWhiteout creates the overlayfs whiteout hiding path in lower layers
+func createWhiteout(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	return syscall.Mknod(path, syscall.S_IFCHR, 0)
+}
+
+// clearOpaqueDir removes everything lower layers put in a directory marked
+// opaque, keeping entries this layer already wrote
+func clearOpaqueDir(dir, name string, written map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read opaque directory %s: %w", name, err)
+	}
+	for _, entry := range entries {
+		if written[filepath.Join(name, entry.Name())] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear opaque directory %s: %w", name, err)
+		}
+	}
+	return nil
+}
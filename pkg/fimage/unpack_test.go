@@ -0,0 +1,48 @@
+package fimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExtractLayerRejectsEscapingWhiteouts checks that whiteouts naming the
+// rootfs or its parent are refused rather than deleting them
+func TestExtractLayerRejectsEscapingWhiteouts(t *testing.T) {
+	for _, name := range []string{".wh..", ".wh.", "dir/.wh..", "dir/.wh."} {
+		for _, overlay := range []bool{false, true} {
+			base := t.TempDir()
+			rootfs := filepath.Join(base, "rootfs")
+			if err := os.MkdirAll(filepath.Join(rootfs, "dir"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			keep := filepath.Join(base, "keep")
+			if err := os.WriteFile(keep, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := extractLayer(&buf, rootfs, overlay); err == nil {
+				t.Errorf("extractLayer with whiteout %q (overlay %v) succeeded, want an error", name, overlay)
+			}
+			for _, p := range []string{keep, rootfs, filepath.Join(rootfs, "dir")} {
+				info, err := os.Lstat(p)
+				if err != nil {
+					t.Errorf("whiteout %q (overlay %v) removed %s", name, overlay, p)
+				} else if info.Mode()&os.ModeCharDevice != 0 {
+					t.Errorf("whiteout %q (overlay %v) replaced %s with a whiteout", name, overlay, p)
+				}
+			}
+		}
+	}
+}
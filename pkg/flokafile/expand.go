@@ -0,0 +1,97 @@
+// pkg/flokafile/expand.go
+package flokafile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expand replaces the $VAR and ${VAR} references in s with the values
+// lookup returns, the empty string for variables it doesn't know.
+// ${VAR:-word} gives word when VAR is unset or empty, ${VAR:+word} gives
+// word when it is set and not empty. A backslash keeps a $ literal.
+func Expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && s[i+1] == '$' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		if c != '$' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		if s[i+1] != '{' {
+			end := i + 1
+			for end < len(s) && isNameChar(s[end], end == i+1) {
+				end++
+			}
+			if end == i+1 {
+				// Not a variable, e.g. "$ " or "$1"
+				b.WriteByte(c)
+				continue
+			}
+			value, _ := lookup(s[i+1 : end])
+			b.WriteString(value)
+			i = end - 1
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("missing '}' in %q", s)
+		}
+		expr := s[i+2 : i+end]
+		name, word, op := expr, "", ""
+		if j := strings.Index(expr, ":"); j >= 0 {
+			name, op, word = expr[:j], expr[j:min(j+2, len(expr))], expr[min(j+2, len(expr)):]
+		}
+		if !validName(name) {
+			return "", fmt.Errorf("invalid variable reference ${%s}", expr)
+		}
+		value, _ := lookup(name)
+		switch op {
+		case "":
+		case ":-":
+			if value == "" {
+				value = word
+			}
+		case ":+":
+			if value != "" {
+				value = word
+			}
+		default:
+			return "", fmt.Errorf("unsupported modifier in ${%s}", expr)
+		}
+		b.WriteString(value)
+		i += end
+	}
+	return b.String(), nil
+}
+
+// isNameChar tells whether c can be part of a variable name, digits not
+// being allowed first
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+// pkg/flokafile/ignore.go
+package flokafile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the file of a build context listing the paths left out of
+// the context
+const IgnoreFile = ".flokaignore"
+
+// Ignore decides which paths of a build context are left out, following
+// the patterns of a .flokaignore file
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	re        *regexp.Regexp
+	exception bool // The pattern started with !, it includes paths again
+}
+
+// ReadIgnore reads an ignore file, Docker's .dockerignore syntax: a pattern
+// per line, with * and ? matching within a path element, ** matching any
+// number of them and a leading ! including again what earlier patterns
+// left out. Lines starting with # are comments. A missing file ignores
+// nothing.
+func ReadIgnore(filename string) (*Ignore, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return &Ignore{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	ignore := &Ignore{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		exception := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimSpace(strings.TrimPrefix(pattern, "!"))
+		pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		re, err := compileIgnorePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %w", filename, line, pattern, err)
+		}
+		ignore.patterns = append(ignore.patterns, ignorePattern{re: re, exception: exception})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return ignore, nil
+}
+
+// Ignored tells whether a path of the context, relative to it and with /
+// separators, is left out. A pattern matching a directory matches
+// everything below it, and the last pattern matching wins.
+func (i *Ignore) Ignored(name string) bool {
+	ignored := false
+	for _, p := range i.patterns {
+		if p.exception == !ignored {
+			continue
+		}
+		for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if p.re.MatchString(dir) {
+				ignored = !p.exception
+				break
+			}
+		}
+	}
+	return ignored
+}
+
+// HasExceptions tells whether a pattern includes paths again, so that an
+// ignored directory may still have paths that aren't
+func (i *Ignore) HasExceptions() bool {
+	for _, p := range i.patterns {
+		if p.exception {
+			return true
+		}
+	}
+	return false
+}
+
+// compileIgnorePattern turns a pattern into a regular expression matching
+// whole paths
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ also matches no directory at all
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
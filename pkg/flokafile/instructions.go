@@ -0,0 +1,527 @@
+// pkg/flokafile/instructions.go
+package flokafile
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Instruction is an instruction of a Flokafile, one of the *Inst types
+type Instruction interface {
+	Name() string   // Upper case, e.g. RUN
+	Pos() Position  // Where the instruction starts
+	String() string // The instruction as written, continued lines joined
+}
+
+// Expandable is implemented by the instructions whose arguments can refer
+// to variables, which Expand replaces with the values lookup returns. RUN,
+// CMD and ENTRYPOINT leave them to the shell.
+type Expandable interface {
+	Instruction
+	Expand(lookup func(name string) (string, bool)) error
+}
+
+// node is what all instructions have
+type node struct {
+	name     string
+	pos      Position
+	original string
+}
+
+func (n *node) Name() string   { return n.name }
+func (n *node) Pos() Position  { return n.pos }
+func (n *node) String() string { return n.original }
+
+// FromInst is FROM IMAGE [AS NAME], starting a stage from an image, an
+// earlier stage or scratch
+type FromInst struct {
+	node
+	Image string
+	Stage string // Name given with AS, lower case
+}
+
+// Command is the command of RUN, CMD or ENTRYPOINT, in exec form, a JSON
+// array run as it is, or in shell form, run with /bin/sh -c
+type Command struct {
+	Args  []string // The exec form's arguments, or the shell form's command line alone
+	Shell bool
+}
+
+// Argv returns the arguments the command runs with, nil for none
+func (c Command) Argv() []string {
+	if c.Shell {
+		return []string{"/bin/sh", "-c", c.Args[0]}
+	}
+	return c.Args
+}
+
+// RunInst is RUN, running a command in the build rootfs
+type RunInst struct {
+	node
+	Command
+}
+
+// CmdInst is CMD, the default command of the image
+type CmdInst struct {
+	node
+	Command
+}
+
+// EntrypointInst is ENTRYPOINT, the command the image runs its command with
+type EntrypointInst struct {
+	node
+	Command
+}
+
+// SourcesAndDest are the files COPY or ADD copies and their destination
+type SourcesAndDest struct {
+	Sources  []string
+	Heredocs []Heredoc // Files given inline, named after their here-document
+	Dest     string
+}
+
+// CopyInst is COPY [--from=STAGE] [--chown=USER[:GROUP]] [--chmod=MODE]
+// SRC... DEST
+type CopyInst struct {
+	node
+	SourcesAndDest
+	From  string
+	Chown string
+	Chmod string
+}
+
+// AddInst is ADD [--checksum=DIGEST] [--chown=USER[:GROUP]] [--chmod=MODE]
+// SRC... DEST
+type AddInst struct {
+	node
+	SourcesAndDest
+	Checksum string
+	Chown    string
+	Chmod    string
+}
+
+// KeyValue is a variable of ENV or a label of LABEL
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// EnvInst is ENV KEY=VALUE..., or ENV KEY VALUE
+type EnvInst struct {
+	node
+	Vars   []KeyValue
+	legacy bool // KEY VALUE form
+}
+
+// LabelInst is LABEL KEY=VALUE..., or LABEL KEY VALUE
+type LabelInst struct {
+	node
+	Labels []KeyValue
+	legacy bool // KEY VALUE form
+}
+
+// ArgInst is ARG NAME[=DEFAULT], declaring a build-time variable
+type ArgInst struct {
+	node
+	Var        string
+	Default    string
+	HasDefault bool
+}
+
+// VolumeInst is VOLUME PATH... or VOLUME ["PATH", ...]
+type VolumeInst struct {
+	node
+	Paths []string
+}
+
+// WorkdirInst is WORKDIR PATH
+type WorkdirInst struct {
+	node
+	Path string
+}
+
+// UserInst is USER USER[:GROUP]
+type UserInst struct {
+	node
+	User string
+}
+
+// ExposeInst is EXPOSE PORT[/PROTOCOL]...
+type ExposeInst struct {
+	node
+	Ports []string
+}
+
+// parseInstruction reads the arguments of an instruction into its type
+func parseInstruction(n node, args string, heredocs []heredocMarker) (Instruction, error) {
+	switch n.name {
+	case "FROM":
+		fields := strings.Fields(args)
+		switch {
+		case len(fields) == 1:
+			return &FromInst{node: n, Image: fields[0]}, nil
+		case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+			return &FromInst{node: n, Image: fields[0], Stage: strings.ToLower(fields[2])}, nil
+		}
+		return nil, fmt.Errorf("FROM requires an image, optionally followed by AS NAME")
+	case "RUN":
+		cmd := parseCommand(args, heredocs)
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("RUN requires a command")
+		}
+		return &RunInst{node: n, Command: cmd}, nil
+	case "CMD":
+		return &CmdInst{node: n, Command: parseCommand(args, nil)}, nil
+	case "ENTRYPOINT":
+		return &EntrypointInst{node: n, Command: parseCommand(args, nil)}, nil
+	case "COPY":
+		flags, rest, err := parseFlags(n.name, args, "from", "chown", "chmod")
+		if err != nil {
+			return nil, err
+		}
+		files, err := parseSourcesAndDest(n.name, rest, heredocs)
+		if err != nil {
+			return nil, err
+		}
+		return &CopyInst{node: n, SourcesAndDest: *files, From: flags["from"], Chown: flags["chown"], Chmod: flags["chmod"]}, nil
+	case "ADD":
+		flags, rest, err := parseFlags(n.name, args, "checksum", "chown", "chmod")
+		if err != nil {
+			return nil, err
+		}
+		files, err := parseSourcesAndDest(n.name, rest, heredocs)
+		if err != nil {
+			return nil, err
+		}
+		return &AddInst{node: n, SourcesAndDest: *files, Checksum: flags["checksum"], Chown: flags["chown"], Chmod: flags["chmod"]}, nil
+	case "ENV":
+		vars, legacy, err := parseKeyValues(n.name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &EnvInst{node: n, Vars: vars, legacy: legacy}, nil
+	case "LABEL":
+		labels, legacy, err := parseKeyValues(n.name, args)
+		if err != nil {
+			return nil, err
+		}
+		return &LabelInst{node: n, Labels: labels, legacy: legacy}, nil
+	case "ARG":
+		words, err := splitWords(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) != 1 {
+			return nil, fmt.Errorf("ARG requires NAME or NAME=DEFAULT")
+		}
+		name, value, hasDefault := strings.Cut(words[0], "=")
+		if name == "" || strings.Contains(name, "$") {
+			return nil, fmt.Errorf("ARG requires NAME or NAME=DEFAULT")
+		}
+		return &ArgInst{node: n, Var: name, Default: value, HasDefault: hasDefault}, nil
+	case "VOLUME":
+		paths, err := parseList(n.name, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("VOLUME requires at least one path")
+		}
+		return &VolumeInst{node: n, Paths: paths}, nil
+	case "WORKDIR":
+		if args == "" {
+			return nil, fmt.Errorf("WORKDIR requires a path")
+		}
+		return &WorkdirInst{node: n, Path: args}, nil
+	case "USER":
+		fields := strings.Fields(args)
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("USER requires exactly one user")
+		}
+		return &UserInst{node: n, User: fields[0]}, nil
+	case "EXPOSE":
+		ports := strings.Fields(args)
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("EXPOSE requires at least one port")
+		}
+		return &ExposeInst{node: n, Ports: ports}, nil
+	}
+	return nil, fmt.Errorf("unknown instruction %s", n.name)
+}
+
+// parseCommand reads a command in exec form or, when args isn't a valid
+// JSON array of strings, in shell form. The here-documents of a shell form
+// RUN are passed on to the shell, or make the script when they are all
+// there is.
+func parseCommand(args string, heredocs []heredocMarker) Command {
+	if strings.HasPrefix(args, "[") {
+		var argv []string
+		if err := json.Unmarshal([]byte(args), &argv); err == nil {
+			return Command{Args: argv}
+		}
+	}
+	if args == "" {
+		return Command{}
+	}
+	script := args
+	if len(heredocs) == 1 && args == heredocs[0].token {
+		script = heredocs[0].Content
+	} else {
+		for _, h := range heredocs {
+			script += "\n" + h.Content + h.Name
+		}
+	}
+	return Command{Args: []string{script}, Shell: true}
+}
+
+// parseFlags reads the leading --name=value flags of args, returning them
+// by name and the rest of args
+func parseFlags(command, args string, names ...string) (map[string]string, string, error) {
+	flags := map[string]string{}
+	rest := args
+	for strings.HasPrefix(rest, "--") {
+		flag, remaining, _ := strings.Cut(rest, " ")
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		known := false
+		for _, n := range names {
+			known = known || n == name
+		}
+		if !known {
+			return nil, "", fmt.Errorf("unknown %s flag --%s", command, name)
+		}
+		if value == "" {
+			return nil, "", fmt.Errorf("--%s requires a value", name)
+		}
+		flags[name] = value
+		rest = strings.TrimSpace(remaining)
+	}
+	return flags, rest, nil
+}
+
+// parseSourcesAndDest reads the sources and destination of COPY or ADD,
+// given one after the other or, for paths with spaces, as a JSON array.
+// Sources can be here-documents.
+func parseSourcesAndDest(command, args string, heredocs []heredocMarker) (*SourcesAndDest, error) {
+	paths, err := parseList(command, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("%s requires at least one source and a destination", command)
+	}
+	files := &SourcesAndDest{Dest: paths[len(paths)-1]}
+	for _, p := range paths[:len(paths)-1] {
+		heredoc := false
+		for _, h := range heredocs {
+			if p == h.token {
+				files.Heredocs = append(files.Heredocs, h.Heredoc)
+				heredoc = true
+				break
+			}
+		}
+		if !heredoc {
+			files.Sources = append(files.Sources, p)
+		}
+	}
+	return files, nil
+}
+
+// parseList reads whitespace-separated arguments or a JSON array of them
+func parseList(command, args string) ([]string, error) {
+	if !strings.HasPrefix(args, "[") {
+		return strings.Fields(args), nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(args), &list); err != nil {
+		return nil, fmt.Errorf("invalid %s instruction: %w", command, err)
+	}
+	return list, nil
+}
+
+// parseKeyValues reads the KEY=VALUE pairs of ENV or LABEL, with values
+// quoted when they hold spaces, or the older KEY VALUE form, whose value is
+// the rest of the line, telling which form it was
+func parseKeyValues(command, args string) ([]KeyValue, bool, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		key, value := words[0], ""
+		if j := strings.IndexAny(args, " \t"); j >= 0 {
+			key, value = args[:j], strings.TrimSpace(args[j+1:])
+		}
+		if value == "" {
+			return nil, false, fmt.Errorf("%s requires KEY=VALUE", command)
+		}
+		return []KeyValue{{Key: key, Value: value}}, true, nil
+	}
+
+	var pairs []KeyValue
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, false, fmt.Errorf("%s requires KEY=VALUE, got %q", command, word)
+		}
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+	}
+	if len(pairs) == 0 {
+		return nil, false, fmt.Errorf("%s requires KEY=VALUE", command)
+	}
+	return pairs, false, nil
+}
+
+// splitWords splits args into words at unquoted whitespace, removing the
+// quotes and backslashes. A $ that was escaped or single-quoted is left
+// escaped for Expand, which then keeps it literal.
+func splitWords(args string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && i+1 < len(args):
+			i++
+			if args[i] == '$' {
+				word.WriteByte('\\')
+			}
+			word.WriteByte(args[i])
+		case c == '\'':
+			end := strings.IndexByte(args[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", args)
+			}
+			word.WriteString(strings.ReplaceAll(args[i+1:i+1+end], "$", `\$`))
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(args) && args[i] != '"'; i++ {
+				if args[i] == '\\' && i+1 < len(args) && strings.IndexByte(`"\`, args[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(args[i])
+			}
+			if i == len(args) {
+				return nil, fmt.Errorf("unterminated quote in %q", args)
+			}
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Expand replaces the variables in the image name
+func (i *FromInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandWords(lookup, &i.Image)
+}
+
+// Expand replaces the variables in the flags, paths and here-documents
+func (i *CopyInst) Expand(lookup func(name string) (string, bool)) error {
+	if err := expandWords(lookup, &i.From, &i.Chown, &i.Chmod); err != nil {
+		return err
+	}
+	return i.SourcesAndDest.expand(lookup)
+}
+
+// Expand replaces the variables in the flags, paths and here-documents
+func (i *AddInst) Expand(lookup func(name string) (string, bool)) error {
+	if err := expandWords(lookup, &i.Checksum, &i.Chown, &i.Chmod); err != nil {
+		return err
+	}
+	return i.SourcesAndDest.expand(lookup)
+}
+
+func (s *SourcesAndDest) expand(lookup func(name string) (string, bool)) error {
+	for i := range s.Sources {
+		if err := expandWords(lookup, &s.Sources[i]); err != nil {
+			return err
+		}
+	}
+	for i := range s.Heredocs {
+		if s.Heredocs[i].Expand {
+			if err := expandWords(lookup, &s.Heredocs[i].Content); err != nil {
+				return err
+			}
+		}
+	}
+	return expandWords(lookup, &s.Dest)
+}
+
+// Expand replaces the variables in the values
+func (i *EnvInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandKeyValues(lookup, i.Vars)
+}
+
+// Expand replaces the variables in the keys and values
+func (i *LabelInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandKeyValues(lookup, i.Labels)
+}
+
+// Expand replaces the variables in the default value
+func (i *ArgInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandWords(lookup, &i.Default)
+}
+
+// Expand replaces the variables in the paths
+func (i *VolumeInst) Expand(lookup func(name string) (string, bool)) error {
+	for j := range i.Paths {
+		if err := expandWords(lookup, &i.Paths[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand replaces the variables in the path
+func (i *WorkdirInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandWords(lookup, &i.Path)
+}
+
+// Expand replaces the variables in the user
+func (i *UserInst) Expand(lookup func(name string) (string, bool)) error {
+	return expandWords(lookup, &i.User)
+}
+
+// Expand replaces the variables in the ports
+func (i *ExposeInst) Expand(lookup func(name string) (string, bool)) error {
+	for j := range i.Ports {
+		if err := expandWords(lookup, &i.Ports[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandKeyValues(lookup func(name string) (string, bool), pairs []KeyValue) error {
+	for j := range pairs {
+		if err := expandWords(lookup, &pairs[j].Key, &pairs[j].Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandWords(lookup func(name string) (string, bool), words ...*string) error {
+	for _, w := range words {
+		value, err := Expand(*w, lookup)
+		if err != nil {
+			return err
+		}
+		*w = value
+	}
+	return nil
+}
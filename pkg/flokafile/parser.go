@@ -2,129 +2,218 @@
 package flokafile
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"strings"
 )
 
-// Instruction represents a single flokafile instruction
-type Instruction struct {
-	Command string
-	Args    string
+// Flokafile is a parsed Flokafile
+type Flokafile struct {
+	Path         string
+	Instructions []Instruction
 }
 
-// flokafile represents a parsed flokafile
-type flokafile struct {
-	Instructions []Instruction
-	BasePath     string
+// Position is where an instruction starts in a Flokafile
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
 }
 
-// Parse reads a flokafile and returns its instructions
-func Parse(path string) (*flokafile, error) {
-	file, err := os.Open(path)
+// Heredoc is a here-document of RUN, COPY or ADD: <<NAME on the
+// instruction line, followed by the lines up to one holding only NAME.
+// With <<-NAME, leading tabs are stripped from the lines.
+type Heredoc struct {
+	Name    string
+	Content string
+	Expand  bool // NAME wasn't quoted, so variables in the content are expanded
+}
+
+// Problem is an error in a Flokafile, at the instruction it is about
+type Problem struct {
+	Pos     Position
+	Message string
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Pos, p.Message)
+}
+
+// Parse reads a Flokafile into its instructions. Lines starting with # are
+// comments, and a line ending with a backslash continues on the next one.
+// An invalid instruction is returned as a *Problem.
+func Parse(path string) (*Flokafile, error) {
+	f, problems, err := parse(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open flokafile: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-	
-	flokafile := &flokafile{
-		BasePath: path,
+	if len(problems) > 0 {
+		return nil, problems[0]
 	}
-	
-	scanner := bufio.NewScanner(file)
-	var currentInstruction *Instruction
-	
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+	return f, nil
+}
+
+// parse reads a Flokafile, leaving out the instructions it can't parse and
+// returning their problems
+func parse(path string) (*Flokafile, []*Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open flokafile: %w", err)
+	}
+	f, problems := parseData(path, data)
+	return f, problems, nil
+}
+
+// parseData parses the content of the Flokafile at path
+func parseData(path string, data []byte) (*Flokafile, []*Problem) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	f := &Flokafile{Path: path}
+	var problems []*Problem
+	for i := 0; i < len(lines); {
+		pos := Position{File: path, Line: i + 1}
+		line := strings.TrimSpace(lines[i])
+		i++
+		if skipLine(line) {
 			continue
 		}
-		
-		// Check if it's a continuation line (ends with \)
-		if currentInstruction != nil && strings.HasSuffix(line, "\\") {
-			// Remove the backslash and add to current args
-			line = strings.TrimSuffix(line, "\\")
-			currentInstruction.Args += " " + strings.TrimSpace(line)
-			continue
+
+		// Comments and empty lines within a continued instruction are
+		// skipped too
+		for strings.HasSuffix(line, "\\") {
+			line = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+			for i < len(lines) && skipLine(strings.TrimSpace(lines[i])) {
+				i++
+			}
+			if i == len(lines) {
+				break
+			}
+			line += " " + strings.TrimSpace(lines[i])
+			i++
 		}
-		
-		// If we have a pending instruction, add it
-		if currentInstruction != nil {
-			flokafile.Instructions = append(flokafile.Instructions, *currentInstruction)
-			currentInstruction = nil
+
+		command, args := line, ""
+		if j := strings.IndexAny(line, " \t"); j >= 0 {
+			command, args = line[:j], strings.TrimSpace(line[j+1:])
 		}
-		
-		// Parse new instruction
-		parts := strings.SplitN(line, " ", 2)
-		command := strings.ToUpper(parts[0])
-		args := ""
-		if len(parts) > 1 {
-			args = strings.TrimSpace(parts[1])
+		command = strings.ToUpper(command)
+
+		var heredocs []heredocMarker
+		switch command {
+		case "RUN", "COPY", "ADD":
+			heredocs = findHeredocs(args)
 		}
-		
-		// Check if this line ends with a continuation
-		if strings.HasSuffix(args, "\\") {
-			args = strings.TrimSuffix(args, "\\")
-			currentInstruction = &Instruction{
-				Command: command,
-				Args:    args,
+		for k := range heredocs {
+			content, next, ok := readHeredoc(lines, i, heredocs[k])
+			if !ok {
+				problems = append(problems, &Problem{Pos: pos, Message: "unterminated here-document " + heredocs[k].Name})
+				return f, problems
 			}
-		} else {
-			// Complete instruction
-			flokafile.Instructions = append(flokafile.Instructions, Instruction{
-				Command: command,
-				Args:    args,
-			})
+			heredocs[k].Content = content
+			i = next
 		}
+
+		n := node{name: command, pos: pos, original: strings.TrimSpace(command + " " + args)}
+		inst, err := parseInstruction(n, args, heredocs)
+		if err != nil {
+			problems = append(problems, &Problem{Pos: pos, Message: err.Error()})
+			continue
+		}
+		f.Instructions = append(f.Instructions, inst)
 	}
-	
-	// Add any pending instruction
-	if currentInstruction != nil {
-		flokafile.Instructions = append(flokafile.Instructions, *currentInstruction)
-	}
-	
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading flokafile: %w", err)
+	return f, problems
+}
+
+func skipLine(line string) bool {
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// heredocMarker is a here-document and the <<NAME starting it
+type heredocMarker struct {
+	Heredoc
+	token string // <<NAME as written
+	strip bool   // <<-NAME
+}
+
+// findHeredocs returns the here-documents the <<NAME markers of args start,
+// in order. Markers within quotes are part of an argument.
+func findHeredocs(args string) []heredocMarker {
+	var markers []heredocMarker
+	var quote byte
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\\':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+			continue
+		case strings.HasPrefix(args[i:], "<<<"):
+			// A here-string, whose word is an argument
+			i += 2
+			continue
+		case !strings.HasPrefix(args[i:], "<<"):
+			continue
+		}
+
+		m := heredocMarker{Heredoc: Heredoc{Expand: true}}
+		j := i + 2
+		if j < len(args) && args[j] == '-' {
+			m.strip = true
+			j++
+		}
+		var nameQuote byte
+		if j < len(args) && (args[j] == '\'' || args[j] == '"') {
+			nameQuote = args[j]
+			m.Expand = false
+			j++
+		}
+		start := j
+		for j < len(args) && !strings.ContainsRune(" \t;|&<>()", rune(args[j])) && args[j] != nameQuote {
+			j++
+		}
+		m.Name = args[start:j]
+		if nameQuote != 0 {
+			if j == len(args) {
+				// Unterminated, not a here-document
+				continue
+			}
+			j++
+		}
+		if m.Name == "" {
+			continue
+		}
+		m.token = args[i:j]
+		markers = append(markers, m)
+		i = j - 1
 	}
-	
-	return flokafile, nil
+	return markers
 }
 
-// Execute processes the flokafile instructions
-func (df *flokafile) Execute() error {
-	fmt.Println("Executing flokafile instructions:")
-	
-	for _, instruction := range df.Instructions {
-		fmt.Printf("  %s %s\n", instruction.Command, instruction.Args)
-		
-		// In a real implementation, we would:
-		// 1. Create a new layer for each instruction
-		// 2. Execute the instruction in a container
-		// 3. Commit the changes to the layer
-		
-		// Here we'll just print what we would do
-		switch instruction.Command {
-		case "FROM":
-			fmt.Printf("    (Would pull base image: %s)\n", instruction.Args)
-		case "RUN":
-			fmt.Printf("    (Would execute command: %s)\n", instruction.Args)
-		case "COPY", "ADD":
-			fmt.Printf("    (Would copy files: %s)\n", instruction.Args)
-		case "CMD", "ENTRYPOINT":
-			fmt.Printf("    (Would set default command: %s)\n", instruction.Args)
-		case "ENV":
-			fmt.Printf("    (Would set environment variable: %s)\n", instruction.Args)
-		case "WORKDIR":
-			fmt.Printf("    (Would set working directory: %s)\n", instruction.Args)
-		case "EXPOSE":
-			fmt.Printf("    (Would expose port: %s)\n", instruction.Args)
-		default:
-			fmt.Printf("    (Unknown instruction: %s)\n", instruction.Command)
+// readHeredoc reads the lines of a here-document from lines[i], returning
+// its content and the index of the line after its end
+func readHeredoc(lines []string, i int, m heredocMarker) (string, int, bool) {
+	var content strings.Builder
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m.strip {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == m.Name {
+			return content.String(), i + 1, true
 		}
+		content.WriteString(line + "\n")
 	}
-	
-	return nil
-}
\ No newline at end of file
+	return "", i, false
+}
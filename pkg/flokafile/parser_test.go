@@ -0,0 +1,172 @@
+package flokafile
+
+import (
+	"reflect"
+	"testing"
+)
+
+// at returns the node of an instruction starting on line of the test
+// Flokafile
+func at(line int, name, original string) node {
+	return node{name: name, pos: Position{File: "Flokafile", Line: line}, original: original}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Instruction
+	}{
+		{
+			name: "FROM with and without a stage",
+			data: "FROM alpine:3.19\nfrom golang AS Build\n",
+			want: []Instruction{
+				&FromInst{node: at(1, "FROM", "FROM alpine:3.19"), Image: "alpine:3.19"},
+				&FromInst{node: at(2, "FROM", "FROM golang AS Build"), Image: "golang", Stage: "build"},
+			},
+		},
+		{
+			name: "comments, empty lines and CRLF",
+			data: "# syntax\r\n\r\nFROM scratch\r\n  # indented\r\nWORKDIR /app\r\n",
+			want: []Instruction{
+				&FromInst{node: at(3, "FROM", "FROM scratch"), Image: "scratch"},
+				&WorkdirInst{node: at(5, "WORKDIR", "WORKDIR /app"), Path: "/app"},
+			},
+		},
+		{
+			name: "continued lines skipping comments",
+			data: "RUN apk add \\\n  # the compiler\n  gcc \\\n\n  make\nUSER app\n",
+			want: []Instruction{
+				&RunInst{node: at(1, "RUN", "RUN apk add gcc make"), Command: Command{Args: []string{"apk add gcc make"}, Shell: true}},
+				&UserInst{node: at(6, "USER", "USER app"), User: "app"},
+			},
+		},
+		{
+			name: "exec and shell forms",
+			data: "CMD [\"nginx\", \"-g\", \"daemon off;\"]\nENTRYPOINT [not json\nCMD\n",
+			want: []Instruction{
+				&CmdInst{node: at(1, "CMD", `CMD ["nginx", "-g", "daemon off;"]`), Command: Command{Args: []string{"nginx", "-g", "daemon off;"}}},
+				&EntrypointInst{node: at(2, "ENTRYPOINT", "ENTRYPOINT [not json"), Command: Command{Args: []string{"[not json"}, Shell: true}},
+				&CmdInst{node: at(3, "CMD", "CMD")},
+			},
+		},
+		{
+			name: "COPY and ADD flags and JSON paths",
+			data: "COPY --from=build --chown=app:app a b /dst/\nADD --checksum=sha256:abc [\"my file\", \"/my dir/\"]\n",
+			want: []Instruction{
+				&CopyInst{node: at(1, "COPY", "COPY --from=build --chown=app:app a b /dst/"), SourcesAndDest: SourcesAndDest{Sources: []string{"a", "b"}, Dest: "/dst/"}, From: "build", Chown: "app:app"},
+				&AddInst{node: at(2, "ADD", `ADD --checksum=sha256:abc ["my file", "/my dir/"]`), SourcesAndDest: SourcesAndDest{Sources: []string{"my file"}, Dest: "/my dir/"}, Checksum: "sha256:abc"},
+			},
+		},
+		{
+			name: "ENV and LABEL forms",
+			data: "ENV A=1 B=\"two words\" C='$HOME'\nENV PATH /usr/local/bin:$PATH\nLABEL version=\"1.0\"\n",
+			want: []Instruction{
+				&EnvInst{node: at(1, "ENV", `ENV A=1 B="two words" C='$HOME'`), Vars: []KeyValue{{"A", "1"}, {"B", "two words"}, {"C", `\$HOME`}}},
+				&EnvInst{node: at(2, "ENV", "ENV PATH /usr/local/bin:$PATH"), Vars: []KeyValue{{"PATH", "/usr/local/bin:$PATH"}}, legacy: true},
+				&LabelInst{node: at(3, "LABEL", `LABEL version="1.0"`), Labels: []KeyValue{{"version", "1.0"}}},
+			},
+		},
+		{
+			name: "ARG, VOLUME and EXPOSE",
+			data: "ARG VERSION\nARG TAG=latest\nARG EMPTY=\nVOLUME /data /logs\nVOLUME [\"/cache\"]\nEXPOSE 80 443/tcp\n",
+			want: []Instruction{
+				&ArgInst{node: at(1, "ARG", "ARG VERSION"), Var: "VERSION"},
+				&ArgInst{node: at(2, "ARG", "ARG TAG=latest"), Var: "TAG", Default: "latest", HasDefault: true},
+				&ArgInst{node: at(3, "ARG", "ARG EMPTY="), Var: "EMPTY", HasDefault: true},
+				&VolumeInst{node: at(4, "VOLUME", "VOLUME /data /logs"), Paths: []string{"/data", "/logs"}},
+				&VolumeInst{node: at(5, "VOLUME", `VOLUME ["/cache"]`), Paths: []string{"/cache"}},
+				&ExposeInst{node: at(6, "EXPOSE", "EXPOSE 80 443/tcp"), Ports: []string{"80", "443/tcp"}},
+			},
+		},
+		{
+			name: "RUN here-document as the script",
+			data: "RUN <<EOF\necho $HOME\nEOF\nUSER app\n",
+			want: []Instruction{
+				&RunInst{node: at(1, "RUN", "RUN <<EOF"), Command: Command{Args: []string{"echo $HOME\n"}, Shell: true}},
+				&UserInst{node: at(4, "USER", "USER app"), User: "app"},
+			},
+		},
+		{
+			name: "RUN here-document passed to a command",
+			data: "RUN python3 <<-'PY'\n\tprint(1)\n\tPY\n",
+			want: []Instruction{
+				&RunInst{node: at(1, "RUN", "RUN python3 <<-'PY'"), Command: Command{Args: []string{"python3 <<-'PY'\nprint(1)\nPY"}, Shell: true}},
+			},
+		},
+		{
+			name: "COPY here-documents and quoted markers",
+			data: "COPY <<A \"<<B\" <<\"C\" /dst/\na\nA\nc $X\nC\n",
+			want: []Instruction{
+				&CopyInst{node: at(1, "COPY", `COPY <<A "<<B" <<"C" /dst/`), SourcesAndDest: SourcesAndDest{
+					Sources:  []string{`"<<B"`},
+					Heredocs: []Heredoc{{Name: "A", Content: "a\n", Expand: true}, {Name: "C", Content: "c $X\n"}},
+					Dest:     "/dst/",
+				}},
+			},
+		},
+		{
+			name: "here-string is no here-document",
+			data: "RUN cat <<<word\n",
+			want: []Instruction{
+				&RunInst{node: at(1, "RUN", "RUN cat <<<word"), Command: Command{Args: []string{"cat <<<word"}, Shell: true}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, problems := parseData("Flokafile", []byte(tt.data))
+			if len(problems) > 0 {
+				t.Fatalf("unexpected problems: %v", problems)
+			}
+			if !reflect.DeepEqual(f.Instructions, tt.want) {
+				t.Errorf("parsed\n%#v\nwant\n%#v", f.Instructions, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProblems(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantProblems []string
+		wantParsed   int
+	}{
+		{"unknown instruction", "FROM a\nHEALTHCHECK NONE\n", []string{"Flokafile:2: unknown instruction HEALTHCHECK"}, 1},
+		{"FROM without image", "FROM\n", []string{"Flokafile:1: FROM requires an image, optionally followed by AS NAME"}, 0},
+		{"FROM with a bad AS", "FROM a B c\n", []string{"Flokafile:1: FROM requires an image, optionally followed by AS NAME"}, 0},
+		{"RUN without command", "RUN\n", []string{"Flokafile:1: RUN requires a command"}, 0},
+		{"unknown COPY flag", "COPY --link a b\n", []string{"Flokafile:1: unknown COPY flag --link"}, 0},
+		{"flag without value", "ADD --chmod= a b\n", []string{"Flokafile:1: --chmod requires a value"}, 0},
+		{"COPY without destination", "COPY a\n", []string{"Flokafile:1: COPY requires at least one source and a destination"}, 0},
+		{"ENV without value", "ENV A\n", []string{"Flokafile:1: ENV requires KEY=VALUE"}, 0},
+		{"ENV with a bare word", "ENV A=1 B\n", []string{`Flokafile:1: ENV requires KEY=VALUE, got "B"`}, 0},
+		{"unterminated quote", "LABEL a=\"b\n", []string{`Flokafile:1: unterminated quote in "a=\"b"`}, 0},
+		{"ARG with two names", "ARG A B\n", []string{"Flokafile:1: ARG requires NAME or NAME=DEFAULT"}, 0},
+		{"USER with two users", "USER a b\n", []string{"Flokafile:1: USER requires exactly one user"}, 0},
+		{"every problem reported", "FROM a\nWORKDIR\nEXPOSE\nVOLUME\n", []string{
+			"Flokafile:2: WORKDIR requires a path",
+			"Flokafile:3: EXPOSE requires at least one port",
+			"Flokafile:4: VOLUME requires at least one path",
+		}, 1},
+		{"unterminated here-document stops parsing", "FROM a\nRUN <<EOF\necho\nUSER app\n", []string{"Flokafile:2: unterminated here-document EOF"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, problems := parseData("Flokafile", []byte(tt.data))
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.Error())
+			}
+			if !reflect.DeepEqual(got, tt.wantProblems) {
+				t.Errorf("problems are %q, want %q", got, tt.wantProblems)
+			}
+			if len(f.Instructions) != tt.wantParsed {
+				t.Errorf("parsed %d instructions, want %d", len(f.Instructions), tt.wantParsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// pkg/flokafile/validate.go
+package flokafile
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate reads a Flokafile without running it and returns its problems,
+// in the order of their lines: instructions that can't be parsed, such as
+// unknown ones or COPY without a destination, ENV or LABEL in the KEY VALUE
+// form, instructions before the first FROM other than ARG, duplicate stage
+// names and stages the last one neither starts from nor copies from. The
+// error is only set when the file can't be read.
+func Validate(path string) ([]*Problem, error) {
+	f, problems, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string // Stage names, empty for unnamed stages
+	var from []*FromInst
+	for _, inst := range f.Instructions {
+		switch inst := inst.(type) {
+		case *FromInst:
+			for _, name := range names {
+				if inst.Stage != "" && name == inst.Stage {
+					problems = append(problems, &Problem{Pos: inst.pos, Message: "duplicate stage name " + inst.Stage})
+				}
+			}
+			names = append(names, inst.Stage)
+			from = append(from, inst)
+		case *ArgInst:
+		default:
+			if len(from) == 0 {
+				problems = append(problems, &Problem{Pos: inst.Pos(), Message: inst.Name() + " before the first FROM, only ARG can come before it"})
+			}
+		}
+		switch inst := inst.(type) {
+		case *EnvInst:
+			if inst.legacy {
+				problems = append(problems, &Problem{Pos: inst.pos, Message: "ENV KEY VALUE is deprecated, use ENV KEY=VALUE"})
+			}
+		case *LabelInst:
+			if inst.legacy {
+				problems = append(problems, &Problem{Pos: inst.pos, Message: "LABEL KEY VALUE is deprecated, use LABEL KEY=VALUE"})
+			}
+		}
+	}
+	if len(from) == 0 {
+		problems = append(problems, &Problem{Pos: Position{File: path, Line: 1}, Message: "no FROM instruction"})
+	} else {
+		problems = append(problems, unreachableStages(f, names)...)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Pos.Line < problems[j].Pos.Line
+	})
+	return problems, nil
+}
+
+// unreachableStages reports the stages the image, built from the last
+// stage, doesn't depend on. Stages named by variables could be any, so
+// none is reported when there are some.
+func unreachableStages(f *Flokafile, names []string) []*Problem {
+	var stages []*FromInst
+	deps := map[int][]int{} // Stages each stage starts from or copies from
+	stage := func(ref string, byIndex bool, before int) (int, bool) {
+		if strings.Contains(ref, "$") {
+			return -1, false
+		}
+		for i, name := range names[:before] {
+			if name != "" && strings.EqualFold(name, ref) {
+				return i, true
+			}
+		}
+		if i, err := strconv.Atoi(ref); byIndex && err == nil && i >= 0 && i < before {
+			return i, true
+		}
+		return -1, true
+	}
+	for _, inst := range f.Instructions {
+		var ref string
+		byIndex := false
+		switch inst := inst.(type) {
+		case *FromInst:
+			stages = append(stages, inst)
+			ref = inst.Image
+		case *CopyInst:
+			ref, byIndex = inst.From, true
+		}
+		if ref == "" || len(stages) == 0 {
+			continue
+		}
+		current := len(stages) - 1
+		i, known := stage(ref, byIndex, current)
+		if !known {
+			return nil
+		}
+		if i >= 0 {
+			deps[current] = append(deps[current], i)
+		}
+	}
+
+	reached := make([]bool, len(stages))
+	var reach func(i int)
+	reach = func(i int) {
+		if reached[i] {
+			return
+		}
+		reached[i] = true
+		for _, dep := range deps[i] {
+			reach(dep)
+		}
+	}
+	reach(len(stages) - 1)
+
+	var problems []*Problem
+	for i, s := range stages {
+		if !reached[i] {
+			name := s.Stage
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			problems = append(problems, &Problem{Pos: s.pos, Message: fmt.Sprintf("stage %s is not used by the last stage", name)})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,528 @@
+// pkg/kube/kube.go
+package kube
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/internal/yaml"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Pod is a Kubernetes pod, as "floka kube play" runs it
+type Pod struct {
+	Name          string
+	Labels        map[string]string
+	RestartPolicy string // floka restart policy of the containers
+	Containers    []*Container
+	Volumes       map[string]*Volume
+}
+
+// Container is a container of a pod
+type Container struct {
+	Name      string
+	Image     string
+	Command   []string // Replaces the image's ENTRYPOINT, and its CMD with it, when set
+	Args      []string // Replaces the image's CMD when set
+	Env       []string // KEY=VALUE
+	Ports     []string // As given to floka pod create -p, those with a hostPort
+	Mounts    []Mount
+	Memory    int64   // Memory limit in bytes, 0 for none
+	MemoryLow int64   // Memory request in bytes, a soft limit, 0 for none
+	CPUs      float64 // CPU limit, 0 for none
+}
+
+// Mount is a volume mounted into a container
+type Mount struct {
+	Volume   string
+	Path     string
+	ReadOnly bool
+}
+
+// Volume is a volume of a pod: an emptyDir, a floka volume of the pod, or
+// a hostPath, a directory or file of the host
+type Volume struct {
+	Name     string
+	HostPath string // Empty for an emptyDir
+}
+
+// dnsLabel matches the names Kubernetes gives pods, containers and volumes
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Load reads a manifest holding a Pod, or a Deployment with one replica
+// whose pod template is run as the pod
+func Load(path string) (*Pod, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	pod, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pod, nil
+}
+
+// Parse reads a Pod or Deployment manifest
+func Parse(data []byte) (*Pod, error) {
+	doc, err := yaml.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	top, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping at the top level")
+	}
+	kind, _ := top["kind"].(string)
+	metadata, err := mapping(top["metadata"])
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	spec, err := mapping(top["spec"])
+	if err != nil {
+		return nil, fmt.Errorf("spec: %w", err)
+	}
+
+	switch kind {
+	case "Pod":
+		return parsePod(metadata, spec)
+	case "Deployment":
+		// The pod is named after the deployment, its template may have
+		// labels only
+		if replicas, ok := spec["replicas"]; ok && replicas != "1" {
+			return nil, fmt.Errorf("spec.replicas: only deployments with 1 replica can be played, got %v", replicas)
+		}
+		template, err := mapping(spec["template"])
+		if err != nil {
+			return nil, fmt.Errorf("spec.template: %w", err)
+		}
+		templateMetadata, err := mapping(template["metadata"])
+		if err != nil {
+			return nil, fmt.Errorf("spec.template.metadata: %w", err)
+		}
+		templateSpec, err := mapping(template["spec"])
+		if err != nil {
+			return nil, fmt.Errorf("spec.template.spec: %w", err)
+		}
+		templateMetadata["name"] = metadata["name"]
+		pod, err := parsePod(templateMetadata, templateSpec)
+		if err != nil {
+			return nil, fmt.Errorf("spec.template: %w", err)
+		}
+		return pod, nil
+	case "":
+		return nil, fmt.Errorf("kind is required")
+	}
+	return nil, fmt.Errorf("unsupported kind %s, expected Pod or Deployment", kind)
+}
+
+// parsePod reads the metadata and spec of a pod
+func parsePod(metadata, spec map[string]interface{}) (*Pod, error) {
+	name, _ := metadata["name"].(string)
+	if !dnsLabel.MatchString(name) {
+		return nil, fmt.Errorf("metadata.name: invalid pod name %q", name)
+	}
+	pod := &Pod{Name: name, Volumes: map[string]*Volume{}}
+	var err error
+	if pod.Labels, err = stringMap(metadata["labels"]); err != nil {
+		return nil, fmt.Errorf("metadata.labels: %w", err)
+	}
+
+	for key, value := range spec {
+		switch key {
+		case "containers":
+			err = pod.parseContainers(value)
+		case "volumes":
+			err = pod.parseVolumes(value)
+		case "restartPolicy":
+			err = pod.parseRestartPolicy(value)
+		case "initContainers", "ephemeralContainers":
+			err = fmt.Errorf("not supported")
+		default:
+			logging.L().Warn("ignoring unsupported pod setting", "pod", name, "key", "spec."+key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("spec.%s: %w", key, err)
+		}
+	}
+	if len(pod.Containers) == 0 {
+		return nil, fmt.Errorf("spec.containers: no containers defined")
+	}
+	if pod.RestartPolicy == "" {
+		pod.RestartPolicy = "always"
+	}
+
+	for _, c := range pod.Containers {
+		for _, m := range c.Mounts {
+			if pod.Volumes[m.Volume] == nil {
+				return nil, fmt.Errorf("container %s mounts undefined volume %s", c.Name, m.Volume)
+			}
+		}
+	}
+	return pod, nil
+}
+
+// parseRestartPolicy maps the pod's restart policy to that of floka
+func (p *Pod) parseRestartPolicy(value interface{}) error {
+	switch value {
+	case "Always":
+		p.RestartPolicy = "always"
+	case "OnFailure":
+		p.RestartPolicy = "on-failure"
+	case "Never":
+		p.RestartPolicy = "no"
+	default:
+		return fmt.Errorf("expected Always, OnFailure or Never, got %v", value)
+	}
+	return nil
+}
+
+// parseContainers reads the containers of the pod
+func (p *Pod) parseContainers(value interface{}) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("expected a list")
+	}
+	names := map[string]bool{}
+	for i, item := range items {
+		c, err := parseContainer(item)
+		if err != nil {
+			return fmt.Errorf("%d: %w", i, err)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("%d: duplicate container name %s", i, c.Name)
+		}
+		names[c.Name] = true
+		p.Containers = append(p.Containers, c)
+	}
+	return nil
+}
+
+// parseContainer reads a container of the pod
+func parseContainer(value interface{}) (*Container, error) {
+	fields, err := mapping(value)
+	if err != nil {
+		return nil, err
+	}
+	c := &Container{}
+	c.Name, _ = fields["name"].(string)
+	if !dnsLabel.MatchString(c.Name) {
+		return nil, fmt.Errorf("name: invalid container name %q", c.Name)
+	}
+	for key, value := range fields {
+		switch key {
+		case "name":
+		case "image":
+			c.Image, _ = value.(string)
+		case "command":
+			c.Command, err = stringList(value)
+		case "args":
+			c.Args, err = stringList(value)
+		case "env":
+			c.Env, err = parseEnv(value)
+		case "ports":
+			c.Ports, err = parsePorts(value)
+		case "volumeMounts":
+			c.Mounts, err = parseMounts(value)
+		case "resources":
+			err = c.parseResources(value)
+		default:
+			logging.L().Warn("ignoring unsupported container setting", "container", c.Name, "key", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", c.Name, key, err)
+		}
+	}
+	if c.Image == "" {
+		return nil, fmt.Errorf("%s: image is required", c.Name)
+	}
+	return c, nil
+}
+
+// parseEnv reads name and value pairs into KEY=VALUE variables
+func parseEnv(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+	var env []string
+	for _, item := range items {
+		fields, err := mapping(item)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := fields["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("name is required")
+		}
+		if _, ok := fields["valueFrom"]; ok {
+			return nil, fmt.Errorf("%s: valueFrom is not supported", name)
+		}
+		v, _ := fields["value"].(string)
+		env = append(env, name+"="+v)
+	}
+	return env, nil
+}
+
+// parsePorts reads the ports of a container. Only those with a hostPort
+// are published, containerPort alone only documents the port.
+func parsePorts(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+	var ports []string
+	for _, item := range items {
+		fields, err := mapping(item)
+		if err != nil {
+			return nil, err
+		}
+		containerPort, _ := fields["containerPort"].(string)
+		if containerPort == "" {
+			return nil, fmt.Errorf("containerPort is required")
+		}
+		hostPort, _ := fields["hostPort"].(string)
+		if hostPort == "" {
+			continue
+		}
+		spec := hostPort + ":" + containerPort
+		if hostIP, _ := fields["hostIP"].(string); hostIP != "" {
+			spec = hostIP + ":" + spec
+		}
+		if protocol, _ := fields["protocol"].(string); protocol != "" {
+			spec += "/" + strings.ToLower(protocol)
+		}
+		ports = append(ports, spec)
+	}
+	return ports, nil
+}
+
+// parseMounts reads the volume mounts of a container
+func parseMounts(value interface{}) ([]Mount, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+	var mounts []Mount
+	for _, item := range items {
+		fields, err := mapping(item)
+		if err != nil {
+			return nil, err
+		}
+		var m Mount
+		m.Volume, _ = fields["name"].(string)
+		m.Path, _ = fields["mountPath"].(string)
+		if m.Volume == "" || !filepath.IsAbs(m.Path) {
+			return nil, fmt.Errorf("name and an absolute mountPath are required")
+		}
+		if _, ok := fields["subPath"]; ok {
+			return nil, fmt.Errorf("%s: subPath is not supported", m.Volume)
+		}
+		m.ReadOnly = fields["readOnly"] == "true"
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// parseResources reads the memory and CPU limits of a container, and its
+// memory request
+func (c *Container) parseResources(value interface{}) error {
+	fields, err := mapping(value)
+	if err != nil {
+		return err
+	}
+	limits, err := stringMap(fields["limits"])
+	if err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	requests, err := stringMap(fields["requests"])
+	if err != nil {
+		return fmt.Errorf("requests: %w", err)
+	}
+	if s, ok := limits["memory"]; ok {
+		if c.Memory, err = ParseQuantity(s); err != nil {
+			return fmt.Errorf("limits.memory: %w", err)
+		}
+	}
+	if s, ok := requests["memory"]; ok {
+		if c.MemoryLow, err = ParseQuantity(s); err != nil {
+			return fmt.Errorf("requests.memory: %w", err)
+		}
+	}
+	if s, ok := limits["cpu"]; ok {
+		if c.CPUs, err = ParseCPU(s); err != nil {
+			return fmt.Errorf("limits.cpu: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseVolumes reads the volumes of the pod
+func (p *Pod) parseVolumes(value interface{}) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("expected a list")
+	}
+	for _, item := range items {
+		fields, err := mapping(item)
+		if err != nil {
+			return err
+		}
+		v := &Volume{}
+		v.Name, _ = fields["name"].(string)
+		if !dnsLabel.MatchString(v.Name) {
+			return fmt.Errorf("invalid volume name %q", v.Name)
+		}
+		switch {
+		case hasKey(fields, "emptyDir"):
+			emptyDir, _ := fields["emptyDir"].(map[string]interface{})
+			if medium, _ := emptyDir["medium"].(string); medium != "" {
+				return fmt.Errorf("%s: emptyDir medium %s is not supported", v.Name, medium)
+			}
+		case fields["hostPath"] != nil:
+			hostPath, err := mapping(fields["hostPath"])
+			if err != nil {
+				return fmt.Errorf("%s: hostPath: %w", v.Name, err)
+			}
+			v.HostPath, _ = hostPath["path"].(string)
+			if !filepath.IsAbs(v.HostPath) {
+				return fmt.Errorf("%s: hostPath: an absolute path is required", v.Name)
+			}
+			switch hostPath["type"] {
+			case nil, "", "Directory", "File":
+			case "DirectoryOrCreate":
+				if err := os.MkdirAll(v.HostPath, 0755); err != nil {
+					return fmt.Errorf("%s: hostPath: %w", v.Name, err)
+				}
+			default:
+				return fmt.Errorf("%s: hostPath: type %v is not supported", v.Name, hostPath["type"])
+			}
+		default:
+			return fmt.Errorf("%s: only emptyDir and hostPath volumes are supported", v.Name)
+		}
+		if p.Volumes[v.Name] != nil {
+			return fmt.Errorf("duplicate volume name %s", v.Name)
+		}
+		p.Volumes[v.Name] = v
+	}
+	return nil
+}
+
+// VolumeName returns the name of the floka volume of an emptyDir volume
+// of the pod
+func (p *Pod) VolumeName(v *Volume) string {
+	return p.Name + "-" + v.Name
+}
+
+// EmptyDirs returns the names of the floka volumes of the pod's emptyDir
+// volumes, sorted
+func (p *Pod) EmptyDirs() []string {
+	var names []string
+	for _, v := range p.Volumes {
+		if v.HostPath == "" {
+			names = append(names, p.VolumeName(v))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// quantitySuffixes are the multipliers of the suffixes of Kubernetes
+// quantities
+var quantitySuffixes = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// ParseQuantity parses a Kubernetes memory quantity, such as 128Mi or 1G,
+// to bytes
+func ParseQuantity(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	multiplier, ok := quantitySuffixes[s[i:]]
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if !ok || err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return int64(math.Ceil(value * multiplier)), nil
+}
+
+// ParseCPU parses a Kubernetes CPU quantity, a number of CPUs such as 1.5
+// or of thousandths of a CPU such as 500m
+func ParseCPU(s string) (float64, error) {
+	millis, isMillis := strings.CutSuffix(s, "m")
+	value, err := strconv.ParseFloat(millis, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid CPU quantity %q", s)
+	}
+	if isMillis {
+		value /= 1000
+	}
+	return value, nil
+}
+
+// mapping reads a mapping, an empty one for a missing value
+func mapping(value interface{}) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case nil:
+		return map[string]interface{}{}, nil
+	}
+	return nil, fmt.Errorf("expected a mapping")
+}
+
+// hasKey reports whether a mapping has a key, null values included
+func hasKey(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// stringMap reads a mapping of scalars
+func stringMap(value interface{}) (map[string]string, error) {
+	m, err := mapping(value)
+	if err != nil || len(m) == 0 {
+		return nil, err
+	}
+	result := map[string]string{}
+	for key, v := range m {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a string", key)
+		}
+		result[key] = s
+	}
+	return result, nil
+}
+
+// stringList reads a sequence of scalars
+func stringList(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings")
+		}
+		list[i] = s
+	}
+	return list, nil
+}
@@ -0,0 +1,182 @@
+package kube
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePod(t *testing.T) {
+	data := `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  restartPolicy: OnFailure
+  containers:
+    - name: nginx
+      image: nginx:alpine
+      command: ["nginx"]
+      args: ["-g", "daemon off;"]
+      env:
+        - name: MODE
+          value: prod
+      ports:
+        - containerPort: 80
+          hostPort: 8080
+        - containerPort: 53
+          hostPort: 5353
+          hostIP: 127.0.0.1
+          protocol: UDP
+        - containerPort: 9000
+      volumeMounts:
+        - name: cache
+          mountPath: /cache
+        - name: config
+          mountPath: /etc/nginx/conf.d
+          readOnly: true
+      resources:
+        limits:
+          memory: 128Mi
+          cpu: 500m
+        requests:
+          memory: 64M
+  volumes:
+    - name: cache
+      emptyDir: {}
+    - name: config
+      hostPath:
+        path: /srv/web/conf.d
+`
+	pod, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Pod{
+		Name:          "web",
+		Labels:        map[string]string{"app": "web"},
+		RestartPolicy: "on-failure",
+		Containers: []*Container{{
+			Name:      "nginx",
+			Image:     "nginx:alpine",
+			Command:   []string{"nginx"},
+			Args:      []string{"-g", "daemon off;"},
+			Env:       []string{"MODE=prod"},
+			Ports:     []string{"8080:80", "127.0.0.1:5353:53/udp"},
+			Mounts:    []Mount{{Volume: "cache", Path: "/cache"}, {Volume: "config", Path: "/etc/nginx/conf.d", ReadOnly: true}},
+			Memory:    128 << 20,
+			MemoryLow: 64e6,
+			CPUs:      0.5,
+		}},
+		Volumes: map[string]*Volume{
+			"cache":  {Name: "cache"},
+			"config": {Name: "config", HostPath: "/srv/web/conf.d"},
+		},
+	}
+	if !reflect.DeepEqual(pod, want) {
+		t.Errorf("pod is %+v, want %+v", pod, want)
+	}
+	if got := pod.EmptyDirs(); !reflect.DeepEqual(got, []string{"web-cache"}) {
+		t.Errorf("emptyDir volumes are %v, want [web-cache]", got)
+	}
+}
+
+func TestParseDeployment(t *testing.T) {
+	data := `kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: 1
+  template:
+    metadata:
+      name: ignored
+      labels:
+        app: api
+    spec:
+      containers:
+        - name: app
+          image: api:latest
+`
+	pod, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Name != "api" || pod.Labels["app"] != "api" || pod.RestartPolicy != "always" {
+		t.Errorf("pod is %+v, want api labelled app=api restarting always", pod)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	container := "\n  containers:\n    - name: app\n      image: app\n"
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"no kind", "metadata:\n  name: web\n", "kind is required"},
+		{"unsupported kind", "kind: Service\nmetadata:\n  name: web\n", "unsupported kind Service"},
+		{"replicas", "kind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: 3\n", "only deployments with 1 replica"},
+		{"invalid name", "kind: Pod\nmetadata:\n  name: Web\nspec:" + container, "invalid pod name"},
+		{"no containers", "kind: Pod\nmetadata:\n  name: web\nspec:\n  restartPolicy: Never\n", "no containers defined"},
+		{"no image", "kind: Pod\nmetadata:\n  name: web\nspec:\n  containers:\n    - name: app\n", "image is required"},
+		{"init containers", "kind: Pod\nmetadata:\n  name: web\nspec:\n  initContainers: []" + container, "spec.initContainers: not supported"},
+		{"undefined volume", "kind: Pod\nmetadata:\n  name: web\nspec:" + container + "      volumeMounts:\n        - name: data\n          mountPath: /data\n", "undefined volume data"},
+		{"valueFrom", "kind: Pod\nmetadata:\n  name: web\nspec:" + container + "      env:\n        - name: KEY\n          valueFrom: {}\n", "valueFrom is not supported"},
+		{"relative hostPath", "kind: Pod\nmetadata:\n  name: web\nspec:" + container + "  volumes:\n    - name: data\n      hostPath:\n        path: data\n", "an absolute path is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error is %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"1024", 1024},
+		{"1k", 1000},
+		{"1Ki", 1024},
+		{"1.5Gi", 3 << 29},
+		{"2M", 2e6},
+		{"128Mi", 128 << 20},
+	}
+	for _, tt := range tests {
+		if got, err := ParseQuantity(tt.s); err != nil || got != tt.want {
+			t.Errorf("ParseQuantity(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "Mi", "1Xi", "-1", "0"} {
+		if _, err := ParseQuantity(s); err == nil {
+			t.Errorf("ParseQuantity(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseCPU(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"2", 2},
+		{"0.5", 0.5},
+		{"250m", 0.25},
+	}
+	for _, tt := range tests {
+		if got, err := ParseCPU(tt.s); err != nil || got != tt.want {
+			t.Errorf("ParseCPU(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "m", "-1", "1x"} {
+		if _, err := ParseCPU(s); err == nil {
+			t.Errorf("ParseCPU(%q) succeeded, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// pkg/logdriver/journald.go
+package logdriver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journald speaks the native journal protocol over its datagram socket
+type journald struct {
+	conn   *net.UnixConn
+	fields map[string]string
+}
+
+func newJournald(info Info) (*journald, error) {
+	fields := map[string]string{
+		"CONTAINER_ID":      info.ContainerID,
+		"SYSLOG_IDENTIFIER": info.ContainerID,
+	}
+
+	for key, value := range info.Options {
+		switch key {
+		case "tag":
+			fields["SYSLOG_IDENTIFIER"] = value
+		default:
+			return nil, fmt.Errorf("unknown log option %q for journald", key)
+		}
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &journald{conn: conn, fields: fields}, nil
+}
+
+func (d *journald) Log(msg *Message) error {
+	priority := "6" // info
+	if msg.Source == "stderr" {
+		priority = "3" // err
+	}
+
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", string(msg.Line))
+	writeField(&buf, "PRIORITY", priority)
+	for key, value := range d.fields {
+		writeField(&buf, key, value)
+	}
+
+	_, err := d.conn.Write(buf.Bytes())
+	return err
+}
+
+func (d *journald) Close() error {
+	return d.conn.Close()
+}
+
+// writeField encodes a field, using the binary form for values with newlines
+func writeField(buf *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", key, value)
+		return
+	}
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
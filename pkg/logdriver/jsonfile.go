@@ -0,0 +1,302 @@
+// pkg/logdriver/jsonfile.go
+package logdriver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonEntry is one line of a json-file log
+type jsonEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// jsonFile writes one JSON object per line and rotates the file once it
+// exceeds max-size, keeping at most max-file files
+type jsonFile struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64 // 0 means no rotation
+	maxFile int
+}
+
+func newJSONFile(info Info) (*jsonFile, error) {
+	d := &jsonFile{
+		path:    filepath.Join(info.LogDir, "container.log"),
+		maxFile: 1,
+	}
+
+	for key, value := range info.Options {
+		switch key {
+		case "max-size":
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			d.maxSize = size
+		case "max-file":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid max-file: %s", value)
+			}
+			d.maxFile = n
+		default:
+			return nil, fmt.Errorf("unknown log option %q for json-file", key)
+		}
+	}
+
+	return d, nil
+}
+
+func (d *jsonFile) Log(msg *Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(jsonEntry{Log: string(msg.Line) + "\n", Stream: msg.Source, Time: msg.Timestamp})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if d.maxSize > 0 && d.size+int64(len(line)) > d.maxSize && d.size > 0 {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := d.file.Write(line)
+	d.size += int64(n)
+	return err
+}
+
+func (d *jsonFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
+
+func (d *jsonFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.file = f
+	d.size = info.Size()
+	return nil
+}
+
+// rotate shifts container.log -> container.log.1 -> ... and drops the
+// oldest file beyond max-file
+func (d *jsonFile) rotate() error {
+	d.file.Close()
+	d.file = nil
+
+	if d.maxFile == 1 {
+		if err := os.Truncate(d.path, 0); err != nil {
+			return err
+		}
+		return d.open()
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", d.path, d.maxFile-1))
+	for i := d.maxFile - 2; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", d.path, i), fmt.Sprintf("%s.%d", d.path, i+1))
+	}
+	if err := os.Rename(d.path, d.path+".1"); err != nil {
+		return err
+	}
+	return d.open()
+}
+
+// ReadLogs returns messages from the rotated files oldest first. When
+// following, it then polls container.log for new lines, reopening it when
+// it gets rotated, until opts.Done is closed.
+func (d *jsonFile) ReadLogs(opts ReadOptions, fn func(*Message) error) error {
+	var messages []*Message
+	collect := func(msg *Message) error {
+		messages = append(messages, msg)
+		return nil
+	}
+
+	for i := d.maxFile - 1; i >= 1; i-- {
+		f, err := os.Open(fmt.Sprintf("%s.%d", d.path, i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		_, err = decodeEntries(bufio.NewReader(f), nil, opts, collect)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+	}
+
+	// Keep the current file open, following continues where this stops
+	current, err := os.Open(d.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	var reader *bufio.Reader
+	var partial []byte
+	if current != nil {
+		defer func() { current.Close() }()
+		reader = bufio.NewReader(current)
+		if partial, err = decodeEntries(reader, nil, opts, collect); err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+	}
+
+	if opts.Tail > 0 && len(messages) > opts.Tail {
+		messages = messages[len(messages)-opts.Tail:]
+	}
+	for _, msg := range messages {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-opts.Done:
+			// Pick up whatever was written before the container stopped
+			done = true
+		case <-ticker.C:
+		}
+
+		if current == nil {
+			if current, err = os.Open(d.path); err != nil {
+				current = nil
+				continue
+			}
+			reader = bufio.NewReader(current)
+		}
+		if partial, err = decodeEntries(reader, partial, opts, fn); err != nil {
+			return err
+		}
+
+		// After a rotation the path names a new file, or the same one
+		// truncated when only one file is kept
+		info, err := os.Stat(d.path)
+		if err != nil {
+			continue
+		}
+		openInfo, err := current.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat log file: %w", err)
+		}
+		pos, err := current.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+		if !os.SameFile(info, openInfo) {
+			// Finish the old file, it may have grown before it was rotated
+			if _, err := decodeEntries(reader, partial, opts, fn); err != nil {
+				return err
+			}
+			current.Close()
+			if current, err = os.Open(d.path); err != nil {
+				current = nil
+				continue
+			}
+			reader.Reset(current)
+			partial = nil
+		} else if info.Size() < pos {
+			if _, err := current.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read log file: %w", err)
+			}
+			reader.Reset(current)
+			partial = nil
+		}
+	}
+	return nil
+}
+
+// decodeEntries passes the messages of the complete lines in r that match
+// opts to fn. A trailing partial line is returned, so that a follower can
+// finish it once the rest has been written.
+func decodeEntries(r *bufio.Reader, partial []byte, opts ReadOptions, fn func(*Message) error) ([]byte, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		partial = append(partial, line...)
+		if err == io.EOF {
+			return partial, nil
+		}
+		if err != nil {
+			return partial, err
+		}
+
+		var entry jsonEntry
+		if err := json.Unmarshal(partial, &entry); err == nil &&
+			(opts.Since.IsZero() || !entry.Time.Before(opts.Since)) {
+			err := fn(&Message{
+				Line:      []byte(strings.TrimSuffix(entry.Log, "\n")),
+				Source:    entry.Stream,
+				Timestamp: entry.Time,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		partial = nil
+	}
+}
+
+// parseSize parses sizes like 512k, 10m or 1g into bytes
+func parseSize(value string) (int64, error) {
+	s := strings.ToLower(value)
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	s = strings.TrimRight(s, "kmgb")
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,186 @@
+// pkg/logdriver/logdriver.go
+package logdriver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/plugin"
+)
+
+// DefaultDriver is used when a container doesn't choose a log driver
+const DefaultDriver = "json-file"
+
+// ErrReadNotSupported is returned by ReadLogs for drivers that only write
+var ErrReadNotSupported = errors.New("log driver does not support reading")
+
+// Message is a single line of container output
+type Message struct {
+	Line      []byte
+	Source    string // "stdout" or "stderr"
+	Timestamp time.Time
+}
+
+// Info tells a driver which container it logs for
+type Info struct {
+	ContainerID string
+	LogDir      string // Directory drivers may store log files in
+	Options     map[string]string
+}
+
+// ReadOptions selects which messages ReadLogs returns
+type ReadOptions struct {
+	Since time.Time // Only messages at or after this time
+	Tail  int       // Only the last Tail messages, all when <= 0
+
+	Follow bool            // Keep waiting for new messages after the existing ones
+	Done   <-chan struct{} // Stops following once closed
+}
+
+// Driver receives the output of a container
+type Driver interface {
+	Log(msg *Message) error
+	Close() error
+}
+
+// Reader is implemented by drivers that can read back what they logged
+type Reader interface {
+	ReadLogs(opts ReadOptions, fn func(*Message) error) error
+}
+
+// New creates the named driver. Names that aren't built in are looked up
+// as log driver plugins.
+func New(name string, info Info) (Driver, error) {
+	if info.Options == nil {
+		info.Options = map[string]string{}
+	}
+
+	switch name {
+	case "", DefaultDriver:
+		return newJSONFile(info)
+	case "journald":
+		return newJournald(info)
+	case "syslog":
+		return newSyslog(info)
+	case "none":
+		return noneDriver{}, nil
+	}
+
+	p, err := plugin.Get(name, plugin.LogDriver)
+	if err != nil {
+		return nil, fmt.Errorf("unknown log driver %s: %w", name, err)
+	}
+	return newPluginDriver(p, info), nil
+}
+
+// Check returns an error if there is no driver of the given name, built in
+// or plugin
+func Check(name string) error {
+	switch name {
+	case "", DefaultDriver, "journald", "syslog", "none":
+		return nil
+	}
+	if _, err := plugin.Get(name, plugin.LogDriver); err != nil {
+		return fmt.Errorf("unknown log driver %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReadLogs reads messages back from a driver, if it supports reading
+func ReadLogs(d Driver, opts ReadOptions, fn func(*Message) error) error {
+	r, ok := d.(Reader)
+	if !ok {
+		return ErrReadNotSupported
+	}
+	return r.ReadLogs(opts, fn)
+}
+
+// lineWriter splits a stream into lines and logs each as a Message. It
+// sits next to the container's stdout and stderr, so the errors of the
+// driver are only reported, never returned: a failing driver must not stop
+// the output or change how the container exits.
+type lineWriter struct {
+	mu     sync.Mutex
+	driver Driver
+	source string
+	buf    []byte
+	errors errorReporter
+}
+
+// NewWriter returns a writer that logs every line written to it. Close
+// flushes a trailing line without a newline.
+func NewWriter(d Driver, source string) io.WriteCloser {
+	return &lineWriter{driver: d, source: source}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := make([]byte, i)
+		copy(line, w.buf[:i])
+		w.buf = w.buf[i+1:]
+		w.log(line)
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	w.log(line)
+	return nil
+}
+
+func (w *lineWriter) log(line []byte) {
+	if err := w.driver.Log(&Message{Line: line, Source: w.source, Timestamp: time.Now()}); err != nil {
+		w.errors.report("failed to log container output", err)
+	}
+}
+
+// errorReportInterval is how often errorReporter warns at most
+const errorReportInterval = 10 * time.Second
+
+// errorReporter warns about the errors of a driver, at most once per
+// errorReportInterval with the number of errors left out in between, so
+// that a driver failing on every line doesn't flood floka's log
+type errorReporter struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (r *errorReporter) report(msg string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.last) < errorReportInterval {
+		r.suppressed++
+		return
+	}
+	logging.L().Warn(msg, "err", err, "suppressed", r.suppressed)
+	r.last = time.Now()
+	r.suppressed = 0
+}
+
+// noneDriver discards everything
+type noneDriver struct{}
+
+func (noneDriver) Log(*Message) error { return nil }
+func (noneDriver) Close() error       { return nil }
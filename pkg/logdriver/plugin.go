@@ -0,0 +1,108 @@
+// pkg/logdriver/plugin.go
+package logdriver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bensdz/floka/pkg/plugin"
+)
+
+const (
+	// pluginBatchSize is the most messages sent to a plugin at once
+	pluginBatchSize = 100
+	// pluginBatchDelay is how long a message waits for others to be sent
+	// with
+	pluginBatchDelay = 100 * time.Millisecond
+	// pluginQueueSize is how many messages wait for a slow plugin before
+	// new ones are dropped
+	pluginQueueSize = 4096
+)
+
+// pluginDriver forwards messages to a log driver plugin in batches, sent
+// from a goroutine of its own so that a slow plugin doesn't hold up the
+// container's output
+type pluginDriver struct {
+	plugin *plugin.Plugin
+	info   Info
+	queue  chan *Message
+	done   chan struct{}
+	errors errorReporter
+}
+
+// pluginMessage is a message as plugins receive it
+type pluginMessage struct {
+	Line   string    `json:"line"`
+	Source string    `json:"source"`
+	Time   time.Time `json:"time"`
+}
+
+func newPluginDriver(p *plugin.Plugin, info Info) *pluginDriver {
+	d := &pluginDriver{
+		plugin: p,
+		info:   info,
+		queue:  make(chan *Message, pluginQueueSize),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Log queues a message, dropping it if the plugin is too far behind
+func (d *pluginDriver) Log(msg *Message) error {
+	select {
+	case d.queue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("log driver plugin %s is too slow, dropped a line", d.plugin.Name)
+	}
+}
+
+// Close sends the messages still queued
+func (d *pluginDriver) Close() error {
+	close(d.queue)
+	<-d.done
+	return nil
+}
+
+// run sends the queued messages until the queue is closed, each batch once
+// it is full or its first message has waited pluginBatchDelay
+func (d *pluginDriver) run() {
+	defer close(d.done)
+	var batch []pluginMessage
+	var flush <-chan time.Time
+	send := func() {
+		if len(batch) > 0 {
+			d.send(batch)
+		}
+		batch, flush = nil, nil
+	}
+	for {
+		select {
+		case msg, ok := <-d.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, pluginMessage{Line: string(msg.Line), Source: msg.Source, Time: msg.Timestamp})
+			if len(batch) >= pluginBatchSize {
+				send()
+			} else if flush == nil {
+				flush = time.After(pluginBatchDelay)
+			}
+		case <-flush:
+			send()
+		}
+	}
+}
+
+func (d *pluginDriver) send(batch []pluginMessage) {
+	params := map[string]interface{}{
+		"container_id": d.info.ContainerID,
+		"options":      d.info.Options,
+		"messages":     batch,
+	}
+	if err := d.plugin.Call("LogDriver.Log", params, nil); err != nil {
+		d.errors.report("log driver plugin failed", err)
+	}
+}
@@ -0,0 +1,72 @@
+// pkg/logdriver/syslog.go
+package logdriver
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+var facilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogDriver sends stdout at info and stderr at error priority
+type syslogDriver struct {
+	writer *syslog.Writer
+}
+
+func newSyslog(info Info) (*syslogDriver, error) {
+	facility := syslog.LOG_DAEMON
+	tag := info.ContainerID
+	network, address := "", ""
+
+	for key, value := range info.Options {
+		switch key {
+		case "syslog-address":
+			// e.g. udp://host:514, tcp://host:514 or unix:///dev/log
+			u, err := url.Parse(value)
+			if err != nil || u.Scheme == "" {
+				return nil, fmt.Errorf("invalid syslog-address: %s", value)
+			}
+			network = u.Scheme
+			address = u.Host
+			if network == "unix" || network == "unixgram" {
+				address = u.Path
+			}
+		case "syslog-facility":
+			f, ok := facilities[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown syslog-facility: %s", value)
+			}
+			facility = f
+		case "tag":
+			tag = value
+		default:
+			return nil, fmt.Errorf("unknown log option %q for syslog", key)
+		}
+	}
+
+	w, err := syslog.Dial(network, address, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogDriver{writer: w}, nil
+}
+
+func (d *syslogDriver) Log(msg *Message) error {
+	if msg.Source == "stderr" {
+		return d.writer.Err(string(msg.Line))
+	}
+	return d.writer.Info(string(msg.Line))
+}
+
+func (d *syslogDriver) Close() error {
+	return d.writer.Close()
+}
@@ -0,0 +1,100 @@
+// pkg/logging/logging.go
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables carrying the logging setup into re-executed floka
+// processes (e.g. the containerize step inside the container)
+const (
+	LevelEnv  = "FLOKA_LOG_LEVEL"
+	FormatEnv = "FLOKA_LOG_FORMAT"
+)
+
+// DefaultLevel keeps diagnostics out of normal command output
+const DefaultLevel = "warn"
+
+// Logger is what floka's packages log through. *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var (
+	mu     sync.RWMutex
+	logger Logger
+)
+
+// SetLogger makes floka's packages log through l. With nil they use the
+// default slog logger again.
+func SetLogger(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	logger = l
+}
+
+// L returns the logger floka's packages log through
+func L() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// ParseLevel converts a level name (debug, info, warn, error) to a slog level
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", level)
+}
+
+// Setup installs the default slog logger writing to w in the given format
+// ("text" or "json") and exports the settings for child floka processes
+func Setup(level, format string, w io.Writer) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	os.Setenv(LevelEnv, level)
+	os.Setenv(FormatEnv, format)
+	return nil
+}
+
+// EnvOr returns the value of the environment variable key, or def if unset
+func EnvOr(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
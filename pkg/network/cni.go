@@ -0,0 +1,355 @@
+// pkg/network/cni.go
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// DriverCNI is the driver of networks set up by CNI plugins, one per
+// network configuration in the CNI configuration directory
+const DriverCNI = "cni"
+
+// cniIfName is the interface CNI plugins create in containers
+const cniIfName = "eth0"
+
+// cniConfList is a CNI network configuration list. Single plugin
+// configurations (.conf files) are read as a list of one.
+type cniConfList struct {
+	CNIVersion string            `json:"cniVersion"`
+	Name       string            `json:"name"`
+	Plugins    []json.RawMessage `json:"plugins"`
+}
+
+// cniResult is the part of the result of CNI plugins floka uses
+type cniResult struct {
+	Interfaces []struct {
+		Name    string `json:"name"`
+		Mac     string `json:"mac"`
+		Sandbox string `json:"sandbox"`
+	} `json:"interfaces"`
+	IPs []struct {
+		Address string `json:"address"`
+		Gateway string `json:"gateway"`
+	} `json:"ips"`
+}
+
+// cniCache is what is kept of a container's attachment to a CNI network,
+// the result of ADD being passed to the plugins again on DEL
+type cniCache struct {
+	Network string
+	Result  json.RawMessage
+}
+
+// cniDirs returns the CNI configuration directory and plugin directories
+// from the config file
+func cniDirs() (string, []string) {
+	confDir, binDirs := config.DefaultCNIConfDir, config.DefaultCNIBinDir
+	if cfg, err := config.Load(config.Path()); err == nil {
+		if cfg.CNIConfDir != "" {
+			confDir = cfg.CNIConfDir
+		}
+		if cfg.CNIBinDir != "" {
+			binDirs = cfg.CNIBinDir
+		}
+	} else {
+		logging.L().Warn("failed to load config file, using the default CNI directories", "err", err)
+	}
+	return confDir, filepath.SplitList(binDirs)
+}
+
+// cniNetworks returns the networks of the CNI configuration directory,
+// the first file by name winning when several have the same network name
+func cniNetworks() ([]*Network, error) {
+	confDir, _ := cniDirs()
+	entries, err := os.ReadDir(confDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CNI configuration directory: %w", err)
+	}
+
+	var networks []*Network
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".conflist", ".conf", ".json":
+		default:
+			continue
+		}
+		path := filepath.Join(confDir, entry.Name())
+		list, err := loadCNIConfList(path)
+		if err != nil {
+			logging.L().Warn("skipping CNI configuration", "path", path, "err", err)
+			continue
+		}
+		if seen[list.Name] || reservedNames[list.Name] {
+			continue
+		}
+		seen[list.Name] = true
+		networks = append(networks, &Network{
+			Name:       list.Name,
+			ID:         list.Name,
+			Driver:     DriverCNI,
+			ConfigFile: path,
+		})
+	}
+	return networks, nil
+}
+
+// getCNINetwork returns the CNI network with the given name
+func getCNINetwork(name string) (*Network, error) {
+	networks, err := cniNetworks()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNetworkNotFound, name)
+}
+
+// loadCNIConfList reads a .conflist file, or a .conf file holding the
+// configuration of a single plugin
+func loadCNIConfList(path string) (*cniConfList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list := &cniConfList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	if list.Plugins == nil {
+		list.Plugins = []json.RawMessage{data}
+	}
+	if list.Name == "" {
+		return nil, fmt.Errorf("no network name")
+	}
+	return list, nil
+}
+
+// connectCNI runs the ADD command of the network's plugins for the
+// container whose init process is pid, and records the address the
+// plugins gave it in ep
+func connectCNI(n *Network, containerID string, pid int, ep *Endpoint) error {
+	list, err := loadCNIConfList(n.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to load CNI configuration of network %s: %w", n.Name, err)
+	}
+	netns := filepath.Join("/proc", strconv.Itoa(pid), "ns", "net")
+
+	var result json.RawMessage
+	for i, plugin := range list.Plugins {
+		out, err := runCNIPlugin(list, plugin, result, "ADD", containerID, netns)
+		if err != nil {
+			// Undo what the plugins before did, as the CNI spec wants
+			for j := i; j >= 0; j-- {
+				if _, delErr := runCNIPlugin(list, list.Plugins[j], result, "DEL", containerID, netns); delErr != nil {
+					logging.L().Warn("failed to clean up CNI plugin", "network", n.Name, "err", delErr)
+				}
+			}
+			return err
+		}
+		result = out
+	}
+
+	if err := writeCNICache(containerID, &cniCache{Network: n.Name, Result: result}); err != nil {
+		return err
+	}
+
+	parsed := &cniResult{}
+	if err := json.Unmarshal(result, parsed); err != nil {
+		return fmt.Errorf("failed to parse CNI result: %w", err)
+	}
+	for _, ip := range parsed.IPs {
+		addr, subnet, err := net.ParseCIDR(ip.Address)
+		if err != nil || addr.To4() == nil {
+			continue
+		}
+		ep.IPAddress = addr.String()
+		ep.PrefixLen, _ = subnet.Mask.Size()
+		ep.Gateway = ip.Gateway
+		break
+	}
+	for _, iface := range parsed.Interfaces {
+		if iface.Name == cniIfName && iface.Sandbox != "" {
+			ep.MacAddress = iface.Mac
+		}
+	}
+	logging.L().Debug("connected container with CNI", "container", containerID, "network", n.Name, "address", ep.IPAddress)
+	return nil
+}
+
+// disconnectCNI runs the DEL command of the plugins of the network a
+// container was last connected to, if it still is. The network namespace
+// is gone by then, which plugins must cope with.
+func disconnectCNI(containerID string) error {
+	path := cniCacheFile(containerID)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read CNI cache: %w", err)
+	}
+	cache := &cniCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return fmt.Errorf("failed to parse CNI cache: %w", err)
+	}
+
+	n, err := getCNINetwork(cache.Network)
+	if err != nil {
+		return err
+	}
+	list, err := loadCNIConfList(n.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to load CNI configuration of network %s: %w", n.Name, err)
+	}
+	var firstErr error
+	for i := len(list.Plugins) - 1; i >= 0; i-- {
+		if _, err := runCNIPlugin(list, list.Plugins[i], cache.Result, "DEL", containerID, ""); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return os.Remove(path)
+}
+
+// cniContainers returns the IDs of the containers connected to a CNI
+// network, by address
+func cniContainers(name string) (map[string]string, error) {
+	files, err := filepath.Glob(cniCacheFile("*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	containers := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		cache := &cniCache{}
+		if json.Unmarshal(data, cache) != nil || cache.Network != name {
+			continue
+		}
+		parsed := &cniResult{}
+		json.Unmarshal(cache.Result, parsed)
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		for _, ip := range parsed.IPs {
+			if addr, _, err := net.ParseCIDR(ip.Address); err == nil {
+				containers[addr.String()] = id
+			}
+		}
+	}
+	return containers, nil
+}
+
+// runCNIPlugin runs one plugin of a configuration list, passing it the
+// result of the plugins before, and returns its result
+func runCNIPlugin(list *cniConfList, plugin, prevResult json.RawMessage, command, containerID, netns string) (json.RawMessage, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(plugin, &conf); err != nil {
+		return nil, fmt.Errorf("invalid CNI plugin configuration: %w", err)
+	}
+	pluginType, _ := conf["type"].(string)
+	if pluginType == "" || strings.ContainsRune(pluginType, '/') {
+		return nil, fmt.Errorf("invalid CNI plugin type %q", pluginType)
+	}
+	conf["name"] = list.Name
+	conf["cniVersion"] = list.CNIVersion
+	delete(conf, "prevResult")
+	if len(prevResult) > 0 {
+		conf["prevResult"] = prevResult
+	}
+	stdin, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	_, binDirs := cniDirs()
+	var binary string
+	for _, dir := range binDirs {
+		if info, err := os.Stat(filepath.Join(dir, pluginType)); err == nil && !info.IsDir() {
+			binary = filepath.Join(dir, pluginType)
+			break
+		}
+	}
+	if binary == "" {
+		return nil, fmt.Errorf("CNI plugin %s not found in %s", pluginType, strings.Join(binDirs, ":"))
+	}
+
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+containerID,
+		"CNI_NETNS="+netns,
+		"CNI_IFNAME="+cniIfName,
+		"CNI_ARGS=",
+		"CNI_PATH="+strings.Join(binDirs, ":"),
+	)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logging.L().Debug("running CNI plugin", "plugin", pluginType, "command", command, "container", containerID)
+	if err := cmd.Run(); err != nil {
+		// Plugins report errors as JSON on stdout
+		var pluginErr struct {
+			Code    int    `json:"code"`
+			Msg     string `json:"msg"`
+			Details string `json:"details"`
+		}
+		if json.Unmarshal(stdout.Bytes(), &pluginErr) == nil && pluginErr.Msg != "" {
+			msg := pluginErr.Msg
+			if pluginErr.Details != "" {
+				msg += ": " + pluginErr.Details
+			}
+			return nil, fmt.Errorf("CNI plugin %s %s failed with code %d: %s", pluginType, command, pluginErr.Code, msg)
+		}
+		return nil, fmt.Errorf("CNI plugin %s %s failed: %w: %s", pluginType, command, err, strings.TrimSpace(stderr.String()))
+	}
+	if command != "ADD" {
+		return nil, nil
+	}
+	return json.RawMessage(bytes.TrimSpace(stdout.Bytes())), nil
+}
+
+// cniCacheFile returns the file keeping the attachment of a container to
+// a CNI network
+func cniCacheFile(containerID string) string {
+	return config.DataPath("networks", "cni", containerID+".json")
+}
+
+// writeCNICache records the attachment of a container to a CNI network
+func writeCNICache(containerID string, cache *cniCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	path := cniCacheFile(containerID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create CNI cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write CNI cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// pkg/network/errors.go
+package network
+
+import "errors"
+
+// Errors callers can test for with errors.Is
+var (
+	ErrNetworkNotFound = errors.New("no such network")
+	ErrNetworkExists   = errors.New("network already exists")
+	ErrNetworkInUse    = errors.New("network is in use")
+)
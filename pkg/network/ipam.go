@@ -0,0 +1,138 @@
+// pkg/network/ipam.go
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bensdz/floka/pkg/config"
+)
+
+// ipamFile returns the file recording which container holds which address
+// of the network of a bridge. The lock file next to it serializes
+// allocations.
+func ipamFile(bridge string) string {
+	return config.DataPath("networks", bridge+".json")
+}
+
+// Allocate reserves a free address of a network for a container
+func Allocate(name, containerID string) (*Endpoint, error) {
+	n, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	// CNI and network driver plugins give the address when the container
+	// connects
+	if n.Driver == DriverCNI || isPluginDriver(n.Driver) {
+		return &Endpoint{Network: n.Name}, nil
+	}
+	_, subnet, err := net.ParseCIDR(n.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet of network %s: %w", n.Name, err)
+	}
+	gw := net.ParseIP(n.Gateway).To4()
+
+	var ep *Endpoint
+	err = updateAllocations(n.Bridge, func(allocations map[string]string) error {
+		ones, _ := subnet.Mask.Size()
+		broadcast := lastIP(subnet)
+		for ip := nextIP(subnet.IP); subnet.Contains(ip) && !ip.Equal(broadcast); ip = nextIP(ip) {
+			if _, used := allocations[ip.String()]; used || ip.Equal(gw) {
+				continue
+			}
+			allocations[ip.String()] = containerID
+			ep = &Endpoint{
+				Network:   n.Name,
+				Bridge:    n.Bridge,
+				IPAddress: ip.String(),
+				PrefixLen: ones,
+				Gateway:   gw.String(),
+			}
+			return nil
+		}
+		return fmt.Errorf("no free address left in %s", subnet)
+	})
+	return ep, err
+}
+
+// Release frees the addresses a container holds on the network of ep
+func Release(ep *Endpoint, containerID string) error {
+	if ep.bridge() == "" {
+		return Disconnect(containerID)
+	}
+	return updateAllocations(ep.bridge(), func(allocations map[string]string) error {
+		for ip, id := range allocations {
+			if id == containerID {
+				delete(allocations, ip)
+			}
+		}
+		return nil
+	})
+}
+
+// updateAllocations lets fn change the address allocations of the network
+// of a bridge while holding the IPAM lock, then saves them
+func updateAllocations(bridge string, fn func(map[string]string) error) error {
+	path := ipamFile(bridge)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create networks directory: %w", err)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open IPAM lock: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock IPAM state: %w", err)
+	}
+
+	allocations := map[string]string{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read IPAM state: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &allocations); err != nil {
+			return fmt.Errorf("failed to parse IPAM state: %w", err)
+		}
+	}
+
+	if err := fn(allocations); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(allocations, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize IPAM state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write IPAM state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP{}, ip.To4()...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// lastIP returns the broadcast address of a subnet
+func lastIP(subnet *net.IPNet) net.IP {
+	ip := append(net.IP{}, subnet.IP.To4()...)
+	for i := range ip {
+		ip[i] |= ^subnet.Mask[len(subnet.Mask)-len(ip)+i]
+	}
+	return ip
+}
@@ -0,0 +1,331 @@
+// pkg/network/network.go
+package network
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bensdz/floka/internal/netlink"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+const (
+	// BridgeName is the host bridge every container is connected to
+	BridgeName = "floka0"
+	// DefaultSubnet is the subnet of the bridge network
+	DefaultSubnet = "172.18.0.0/16"
+	// SubnetEnv overrides the subnet of the bridge network
+	SubnetEnv = "FLOKA_BRIDGE_SUBNET"
+)
+
+// Endpoint is a container's connection to a network
+type Endpoint struct {
+	Network    string `json:",omitempty"` // Name of the network, the default one when empty
+	Bridge     string
+	IPAddress  string
+	PrefixLen  int
+	Gateway    string
+	MacAddress string `json:",omitempty"`
+}
+
+// Subnet returns the subnet of the bridge network
+func Subnet() (*net.IPNet, error) {
+	cidr := os.Getenv(SubnetEnv)
+	if cidr == "" {
+		cidr = DefaultSubnet
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bridge subnet %q: %w", cidr, err)
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("bridge subnet %s is not an IPv4 subnet", cidr)
+	}
+	if ones, bits := subnet.Mask.Size(); bits-ones < 2 {
+		return nil, fmt.Errorf("bridge subnet %s is too small", cidr)
+	}
+	return subnet, nil
+}
+
+// bridge returns the bridge of the endpoint's network, floka0 for
+// endpoints of older versions and none for CNI networks
+func (ep *Endpoint) bridge() string {
+	if ep.Bridge == "" && ep.Network == "" {
+		return BridgeName
+	}
+	return ep.Bridge
+}
+
+// gateway returns the first address of the subnet, which the bridge uses
+func gateway(subnet *net.IPNet) net.IP {
+	return nextIP(subnet.IP.To4())
+}
+
+// Connect creates a veth pair for the container whose init process is
+// pid, attaches one end to the bridge of ep's network and configures the
+// other as eth0 inside the container. It records the MAC address in ep.
+// The plugins of CNI networks, or the network driver plugin, do all that
+// instead, and ep gets the address they give the container.
+func Connect(containerID string, pid int, ep *Endpoint) error {
+	if ep.Network != "" && ep.Bridge == "" {
+		if n, err := Get(ep.Network); err == nil && isPluginDriver(n.Driver) {
+			return connectPlugin(n, containerID, pid, ep)
+		}
+		n, err := getCNINetwork(ep.Network)
+		if err != nil {
+			return err
+		}
+		return connectCNI(n, containerID, pid, ep)
+	}
+
+	bridge, err := ensureBridge(ep)
+	if err != nil {
+		return err
+	}
+
+	hostName, peerName := vethNames(containerID)
+	if err := netlink.AddVeth(hostName, peerName); err != nil {
+		return err
+	}
+	host, err := net.InterfaceByName(hostName)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", hostName, err)
+	}
+	// Deleting one end of a veth pair deletes both
+	cleanup := func() {
+		if err := netlink.DeleteLink(host.Index); err != nil {
+			logging.L().Warn("failed to delete veth", "name", hostName, "err", err)
+		}
+	}
+
+	peer, err := net.InterfaceByName(peerName)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("failed to find %s: %w", peerName, err)
+	}
+	if err := netlink.SetMaster(host.Index, bridge.Index); err != nil {
+		cleanup()
+		return err
+	}
+	if err := netlink.SetUp(host.Index); err != nil {
+		cleanup()
+		return err
+	}
+	if err := netlink.SetNsPid(peer.Index, pid); err != nil {
+		cleanup()
+		return err
+	}
+
+	addr := &net.IPNet{IP: net.ParseIP(ep.IPAddress), Mask: net.CIDRMask(ep.PrefixLen, 32)}
+	logging.L().Debug("connecting container", "container", containerID, "veth", hostName, "address", addr)
+	err = netlink.InNetns(pid, func() error {
+		if lo, err := net.InterfaceByName("lo"); err == nil {
+			if err := netlink.SetUp(lo.Index); err != nil {
+				return err
+			}
+		}
+		if err := netlink.SetName(peer.Index, "eth0"); err != nil {
+			return err
+		}
+		if err := netlink.AddAddr(peer.Index, addr); err != nil {
+			return err
+		}
+		if err := netlink.SetUp(peer.Index); err != nil {
+			return err
+		}
+		if err := netlink.AddDefaultRoute(net.ParseIP(ep.Gateway)); err != nil {
+			return err
+		}
+		if eth0, err := net.InterfaceByName("eth0"); err == nil {
+			ep.MacAddress = eth0.HardwareAddr.String()
+		}
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("failed to configure container network: %w", err)
+	}
+	return nil
+}
+
+// Disconnect tells the plugins of the CNI network, or the network driver
+// plugin, a container was connected to that it stopped. The veth pairs of
+// bridge networks go away with the container's network namespace.
+func Disconnect(containerID string) error {
+	err := disconnectCNI(containerID)
+	if pluginErr := disconnectPlugin(containerID); err == nil {
+		err = pluginErr
+	}
+	return err
+}
+
+// SetupLoopback brings up the loopback interface of the network namespace
+// of pid, for containers that get no other interface
+func SetupLoopback(pid int) error {
+	return netlink.InNetns(pid, func() error {
+		lo, err := net.InterfaceByName("lo")
+		if err != nil {
+			return fmt.Errorf("failed to find lo: %w", err)
+		}
+		return netlink.SetUp(lo.Index)
+	})
+}
+
+// vethNames returns the names of the host and container ends of the veth
+// pair of a container. Interface names are limited to 15 characters.
+func vethNames(containerID string) (string, string) {
+	h := fnv.New32a()
+	h.Write([]byte(containerID))
+	sum := h.Sum32()
+	return fmt.Sprintf("veth%08x", sum), fmt.Sprintf("vpeer%08x", sum)
+}
+
+// ensureBridge creates the bridge of ep's network with the gateway address
+// if it doesn't exist yet, makes sure containers can reach outside
+// networks and keeps them from reaching the other networks
+func ensureBridge(ep *Endpoint) (*net.Interface, error) {
+	name := ep.bridge()
+	if bridge, err := net.InterfaceByName(name); err == nil {
+		return bridge, nil
+	}
+
+	gw := net.ParseIP(ep.Gateway).To4()
+	if gw == nil {
+		return nil, fmt.Errorf("invalid gateway %q", ep.Gateway)
+	}
+	mask := net.CIDRMask(ep.PrefixLen, 32)
+	subnet := &net.IPNet{IP: gw.Mask(mask), Mask: mask}
+
+	logging.L().Debug("creating bridge", "name", name, "subnet", subnet)
+	if err := netlink.AddBridge(name); err != nil {
+		return nil, err
+	}
+	bridge, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find bridge %s: %w", name, err)
+	}
+	if err := netlink.AddAddr(bridge.Index, &net.IPNet{IP: gw, Mask: mask}); err != nil {
+		return nil, err
+	}
+	if err := netlink.SetUp(bridge.Index); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		logging.L().Warn("failed to enable IP forwarding", "err", err)
+	}
+	setupMasquerade(name, subnet)
+	return bridge, nil
+}
+
+// setupMasquerade adds the iptables rules that let containers reach
+// outside networks through the host's address, but not the containers of
+// other networks
+func setupMasquerade(bridge string, subnet *net.IPNet) {
+	if !haveIptables() {
+		logging.L().Warn("iptables not found, containers can't reach outside networks")
+		return
+	}
+	for _, r := range masqueradeRules(bridge, subnet) {
+		if err := r.ensure(); err != nil {
+			logging.L().Warn("failed to add iptables rule", "err", err)
+		}
+	}
+
+	// The rules dropping traffic between networks go first, the rules
+	// above accept everything coming from a bridge
+	networks, err := List()
+	if err != nil {
+		logging.L().Warn("failed to list networks to isolate", "err", err)
+	}
+	for _, n := range networks {
+		if n.Bridge == bridge || n.Bridge == "" {
+			continue
+		}
+		for _, r := range isolationRules(bridge, n.Bridge) {
+			if err := r.insert(); err != nil {
+				logging.L().Warn("failed to add iptables rule", "err", err)
+			}
+		}
+	}
+}
+
+// masqueradeRules are the iptables rules letting the containers on a
+// bridge reach outside networks
+func masqueradeRules(bridge string, subnet *net.IPNet) []rule {
+	return []rule{
+		{"nat", "POSTROUTING", []string{"-s", subnet.String(), "!", "-o", bridge, "-j", "MASQUERADE"}},
+		{"filter", "FORWARD", []string{"-i", bridge, "-j", "ACCEPT"}},
+		{"filter", "FORWARD", []string{"-o", bridge, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}},
+	}
+}
+
+// isolationRules are the iptables rules dropping traffic between two
+// bridges
+func isolationRules(a, b string) []rule {
+	return []rule{
+		{"filter", "FORWARD", []string{"-i", a, "-o", b, "-j", "DROP"}},
+		{"filter", "FORWARD", []string{"-i", b, "-o", a, "-j", "DROP"}},
+	}
+}
+
+// rule is an iptables rule
+type rule struct {
+	table string
+	chain string
+	spec  []string
+}
+
+// haveIptables reports whether the iptables command is available
+func haveIptables() bool {
+	_, err := exec.LookPath("iptables")
+	return err == nil
+}
+
+// IptablesVersion returns what "iptables --version" prints, such as
+// "iptables v1.8.9 (nf_tables)" for an iptables whose rules end up in
+// nftables, or an error without iptables
+func IptablesVersion() (string, error) {
+	out, err := exec.Command("iptables", "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// run runs iptables with the given action (-C, -A, -I, -D) on the rule
+func (r rule) run(action string) error {
+	args := append([]string{"-t", r.table, action, r.chain}, r.spec...)
+	if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ensure adds the rule unless it is already there
+func (r rule) ensure() error {
+	if r.run("-C") == nil {
+		return nil
+	}
+	return r.run("-A")
+}
+
+// insert adds the rule first in its chain unless it is already there
+func (r rule) insert() error {
+	if r.run("-C") == nil {
+		return nil
+	}
+	return r.run("-I")
+}
+
+// delete removes the rule if it is there
+func (r rule) delete() error {
+	if r.run("-C") != nil {
+		return nil
+	}
+	return r.run("-D")
+}
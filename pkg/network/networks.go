@@ -0,0 +1,442 @@
+// pkg/network/networks.go
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/internal/netlink"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// DriverBridge is the driver of networks floka makes of a bridge
+const DriverBridge = "bridge"
+
+// DefaultNetwork is the name of the network of the floka0 bridge, which
+// containers join unless given another
+const DefaultNetwork = "bridge"
+
+// Network is a bridge with its own subnet. Containers on different networks
+// can't reach each other. Networks of the CNI driver are set up by CNI
+// plugins instead, from a configuration file of the CNI configuration
+// directory, and those of other drivers by the network driver plugin of
+// that name.
+type Network struct {
+	Name       string
+	ID         string
+	Driver     string            // "bridge", "cni" or the name of a plugin
+	Bridge     string            `json:",omitempty"` // Host bridge interface
+	Subnet     string            `json:",omitempty"`
+	Gateway    string            `json:",omitempty"` // Address of the bridge, the containers' default route
+	Labels     map[string]string `json:",omitempty"`
+	ConfigFile string            `json:",omitempty"` // CNI network configuration
+	Created    time.Time
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// reservedNames can't be given to networks, "none" and "host" being
+// network modes of containers
+var reservedNames = map[string]bool{DefaultNetwork: true, "none": true, "host": true}
+
+// subnetPool is where the subnets of networks created without one are
+// taken from, the /16 after the default bridge's onwards, unless the config
+// file has address pools
+var subnetPool = func() []string {
+	var pool []string
+	for i := 19; i <= 31; i++ {
+		pool = append(pool, fmt.Sprintf("172.%d.0.0/16", i))
+	}
+	return pool
+}()
+
+// networkFile returns the file holding the configuration of a network
+func networkFile(name string) string {
+	return config.DataPath("networks", name, "network.json")
+}
+
+// defaultNetwork returns the network of the floka0 bridge, whose subnet
+// comes from the environment rather than the network store
+func defaultNetwork() (*Network, error) {
+	subnet, err := Subnet()
+	if err != nil {
+		return nil, err
+	}
+	return &Network{
+		Name:    DefaultNetwork,
+		ID:      DefaultNetwork,
+		Driver:  DriverBridge,
+		Bridge:  BridgeName,
+		Subnet:  subnet.String(),
+		Gateway: gateway(subnet).String(),
+	}, nil
+}
+
+// Get returns the network with the given name, floka networks hiding CNI
+// networks of the same name
+func Get(name string) (*Network, error) {
+	if name == DefaultNetwork {
+		return defaultNetwork()
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %s", ErrNetworkNotFound, name)
+	}
+	data, err := os.ReadFile(networkFile(name))
+	if os.IsNotExist(err) {
+		return getCNINetwork(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network %s: %w", name, err)
+	}
+	n := &Network{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("failed to parse network %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// List returns the default network followed by the others by name, then
+// the CNI networks not hidden by floka networks
+func List() ([]*Network, error) {
+	def, err := defaultNetwork()
+	if err != nil {
+		return nil, err
+	}
+	networks := []*Network{def}
+
+	entries, err := os.ReadDir(config.DataPath("networks"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read networks directory: %w", err)
+	}
+	for _, entry := range entries {
+		// Skipping the attachments to plugin networks
+		if !entry.IsDir() || !validName.MatchString(entry.Name()) {
+			continue
+		}
+		n, err := Get(entry.Name())
+		if err != nil {
+			logging.L().Warn("skipping network", "name", entry.Name(), "err", err)
+			continue
+		}
+		networks = append(networks, n)
+	}
+	sort.SliceStable(networks[1:], func(i, j int) bool { return networks[i+1].Name < networks[j+1].Name })
+
+	cni, err := cniNetworks()
+	if err != nil {
+		logging.L().Warn("skipping CNI networks", "err", err)
+	}
+	names := map[string]bool{}
+	for _, n := range networks {
+		names[n.Name] = true
+	}
+	for _, n := range cni {
+		if !names[n.Name] {
+			networks = append(networks, n)
+		}
+	}
+	return networks, nil
+}
+
+// Create adds a network of a driver, bridge when empty. A bridge network
+// without a subnet gets the first /16 of the pool no other network
+// overlaps, and without a gateway the first address of its subnet. Its
+// bridge is only created when a container connects. Other drivers are
+// network driver plugins, given the subnet and gateway as they are.
+func Create(name, driver, cidr, gw string, labels map[string]string) (*Network, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid network name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if reservedNames[name] {
+		return nil, fmt.Errorf("network name %q is reserved", name)
+	}
+	if driver == "" {
+		driver = DriverBridge
+	}
+	if driver == DriverCNI {
+		return nil, fmt.Errorf("CNI networks are made by adding their configuration to the CNI configuration directory")
+	}
+
+	unlock, err := lockNetworks()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(networkFile(name)); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNetworkExists, name)
+	}
+	if _, err := getCNINetwork(name); err == nil {
+		return nil, fmt.Errorf("%w: %s is a CNI network", ErrNetworkExists, name)
+	}
+
+	var n *Network
+	if isPluginDriver(driver) {
+		n = &Network{
+			Name:    name,
+			ID:      generateID(),
+			Driver:  driver,
+			Subnet:  cidr,
+			Gateway: gw,
+			Labels:  labels,
+			Created: time.Now(),
+		}
+		err = createPluginNetwork(n)
+	} else {
+		n, err = newBridgeNetwork(name, cidr, gw, labels)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.save(); err != nil {
+		if isPluginDriver(n.Driver) {
+			if deleteErr := deletePluginNetwork(n); deleteErr != nil {
+				logging.L().Warn("failed to delete plugin network", "network", n.Name, "err", deleteErr)
+			}
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+// newBridgeNetwork returns a new bridge network whose subnet overlaps no
+// other network's
+func newBridgeNetwork(name, cidr, gw string, labels map[string]string) (*Network, error) {
+	existing, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var subnet *net.IPNet
+	if cidr != "" {
+		if _, subnet, err = net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+		}
+		if subnet.IP.To4() == nil {
+			return nil, fmt.Errorf("subnet %s is not an IPv4 subnet", cidr)
+		}
+		if ones, bits := subnet.Mask.Size(); bits-ones < 2 {
+			return nil, fmt.Errorf("subnet %s is too small", cidr)
+		}
+		if other := overlapping(subnet, existing); other != nil {
+			return nil, fmt.Errorf("subnet %s overlaps network %s (%s)", subnet, other.Name, other.Subnet)
+		}
+	} else if subnet = freeSubnet(existing); subnet == nil {
+		return nil, fmt.Errorf("no free subnet left, give one with --subnet")
+	}
+
+	gwIP := gateway(subnet)
+	if gw != "" {
+		gwIP = net.ParseIP(gw).To4()
+		if gwIP == nil || !subnet.Contains(gwIP) || gwIP.Equal(subnet.IP) || gwIP.Equal(lastIP(subnet)) {
+			return nil, fmt.Errorf("invalid gateway %q for subnet %s", gw, subnet)
+		}
+	}
+
+	id := generateID()
+	return &Network{
+		Name:    name,
+		ID:      id,
+		Driver:  DriverBridge,
+		Bridge:  "br-" + id[:12],
+		Subnet:  subnet.String(),
+		Gateway: gwIP.String(),
+		Labels:  labels,
+		Created: time.Now(),
+	}, nil
+}
+
+// save writes the configuration of a new network
+func (n *Network) save() error {
+	data, err := json.MarshalIndent(n, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize network: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(networkFile(n.Name)), 0755); err != nil {
+		return fmt.Errorf("failed to create network directory: %w", err)
+	}
+	if err := os.WriteFile(networkFile(n.Name), data, 0644); err != nil {
+		os.RemoveAll(filepath.Dir(networkFile(n.Name)))
+		return fmt.Errorf("failed to save network: %w", err)
+	}
+	return nil
+}
+
+// freeSubnet returns the first subnet overlapping no network of the
+// address pools of the config file, or of subnetPool without them, nil if
+// there is none
+func freeSubnet(existing []*Network) *net.IPNet {
+	pools := config.Current().DefaultAddressPools
+	if len(pools) == 0 {
+		for _, candidate := range subnetPool {
+			_, s, _ := net.ParseCIDR(candidate)
+			if overlapping(s, existing) == nil {
+				return s
+			}
+		}
+		return nil
+	}
+
+	for _, pool := range pools {
+		base, err := pool.Subnet()
+		if err != nil {
+			logging.L().Warn("ignoring address pool of the config file", "err", err)
+			continue
+		}
+		ones, _ := base.Mask.Size()
+		first := binary.BigEndian.Uint32(base.IP.To4())
+		step := uint64(1) << (32 - pool.Size)
+		for i := uint64(0); i < uint64(1)<<(pool.Size-ones); i++ {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, first+uint32(i*step))
+			s := &net.IPNet{IP: ip, Mask: net.CIDRMask(pool.Size, 32)}
+			if overlapping(s, existing) == nil {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// overlapping returns the network among networks whose subnet overlaps
+// subnet, if any
+func overlapping(subnet *net.IPNet, networks []*Network) *Network {
+	for _, n := range networks {
+		_, other, err := net.ParseCIDR(n.Subnet)
+		if err != nil {
+			continue
+		}
+		if other.Contains(subnet.IP) || subnet.Contains(other.IP) {
+			return n
+		}
+	}
+	return nil
+}
+
+// Containers returns the IDs of the containers holding an address of the
+// network, by address
+func (n *Network) Containers() (map[string]string, error) {
+	if n.Driver == DriverCNI {
+		return cniContainers(n.Name)
+	}
+	if isPluginDriver(n.Driver) {
+		return pluginContainers(n.Name)
+	}
+	var containers map[string]string
+	err := updateAllocations(n.Bridge, func(allocations map[string]string) error {
+		containers = allocations
+		return nil
+	})
+	return containers, err
+}
+
+// Remove deletes a network no container is connected to, along with its
+// bridge and iptables rules, or has its plugin delete it
+func (n *Network) Remove() error {
+	if n.Name == DefaultNetwork {
+		return fmt.Errorf("the default network %s can't be removed", DefaultNetwork)
+	}
+	if n.Driver == DriverCNI {
+		return fmt.Errorf("network %s is a CNI network, remove %s instead", n.Name, n.ConfigFile)
+	}
+
+	unlock, err := lockNetworks()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	containers, err := n.Containers()
+	if err != nil {
+		return err
+	}
+	if len(containers) > 0 {
+		var ids []string
+		for _, id := range containers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return fmt.Errorf("%w: %s has container(s) %v", ErrNetworkInUse, n.Name, ids)
+	}
+
+	if isPluginDriver(n.Driver) {
+		if err := deletePluginNetwork(n); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Dir(networkFile(n.Name))); err != nil {
+			return fmt.Errorf("failed to remove network: %w", err)
+		}
+		return nil
+	}
+
+	if bridge, err := net.InterfaceByName(n.Bridge); err == nil {
+		if err := netlink.DeleteLink(bridge.Index); err != nil {
+			return fmt.Errorf("failed to delete bridge %s: %w", n.Bridge, err)
+		}
+	}
+	if haveIptables() {
+		_, subnet, err := net.ParseCIDR(n.Subnet)
+		if err == nil {
+			for _, r := range masqueradeRules(n.Bridge, subnet) {
+				if err := r.delete(); err != nil {
+					logging.L().Warn("failed to delete iptables rule", "err", err)
+				}
+			}
+		}
+		others, _ := List()
+		for _, other := range others {
+			if other.Bridge == n.Bridge || other.Bridge == "" {
+				continue
+			}
+			for _, r := range isolationRules(n.Bridge, other.Bridge) {
+				if err := r.delete(); err != nil {
+					logging.L().Warn("failed to delete iptables rule", "err", err)
+				}
+			}
+		}
+	}
+
+	os.Remove(ipamFile(n.Bridge))
+	os.Remove(ipamFile(n.Bridge) + ".lock")
+	if err := os.RemoveAll(filepath.Dir(networkFile(n.Name))); err != nil {
+		return fmt.Errorf("failed to remove network: %w", err)
+	}
+	return nil
+}
+
+// lockNetworks keeps other floka processes from creating or removing
+// networks until the returned function is called
+func lockNetworks() (func(), error) {
+	dir := config.DataPath("networks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create networks directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock networks: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock networks: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// generateID returns a random network ID
+func generateID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+// pkg/network/plugin.go
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/plugin"
+)
+
+// Networks of drivers other than bridge and cni are made by the network
+// driver plugin of that name, which floka asks to create and delete them
+// and to connect containers to them in their network namespace
+
+// pluginAttachment is what is kept of a container's attachment to the
+// network of a plugin
+type pluginAttachment struct {
+	Network   string
+	Driver    string
+	IPAddress string `json:",omitempty"`
+}
+
+// isPluginDriver reports whether networks of a driver are made by a plugin
+func isPluginDriver(driver string) bool {
+	return driver != "" && driver != DriverBridge && driver != DriverCNI
+}
+
+// createPluginNetwork asks the plugin of n's driver to create the network
+func createPluginNetwork(n *Network) error {
+	p, err := plugin.Get(n.Driver, plugin.NetworkDriver)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"name":    n.Name,
+		"id":      n.ID,
+		"subnet":  n.Subnet,
+		"gateway": n.Gateway,
+		"labels":  n.Labels,
+	}
+	return p.Call("NetworkDriver.CreateNetwork", params, nil)
+}
+
+// deletePluginNetwork asks the plugin of n's driver to delete the network
+func deletePluginNetwork(n *Network) error {
+	p, err := plugin.Get(n.Driver, plugin.NetworkDriver)
+	if err != nil {
+		return err
+	}
+	return p.Call("NetworkDriver.DeleteNetwork", map[string]string{"name": n.Name, "id": n.ID}, nil)
+}
+
+// connectPlugin asks the plugin of n's driver to connect the container
+// whose init process is pid, and records the address it gave the
+// container in ep
+func connectPlugin(n *Network, containerID string, pid int, ep *Endpoint) error {
+	p, err := plugin.Get(n.Driver, plugin.NetworkDriver)
+	if err != nil {
+		return err
+	}
+	params := map[string]string{
+		"network":   n.Name,
+		"id":        n.ID,
+		"container": containerID,
+		"netns":     filepath.Join("/proc", strconv.Itoa(pid), "ns", "net"),
+		"ifname":    cniIfName,
+	}
+	var result struct {
+		Address    string `json:"address"` // In CIDR notation
+		Gateway    string `json:"gateway"`
+		MacAddress string `json:"macAddress"`
+	}
+	if err := p.Call("NetworkDriver.Join", params, &result); err != nil {
+		return err
+	}
+	if result.Address != "" {
+		addr, subnet, err := net.ParseCIDR(result.Address)
+		if err != nil {
+			return fmt.Errorf("plugin %s gave an invalid address %q: %w", n.Driver, result.Address, err)
+		}
+		ep.IPAddress = addr.String()
+		ep.PrefixLen, _ = subnet.Mask.Size()
+	}
+	ep.Gateway = result.Gateway
+	ep.MacAddress = result.MacAddress
+
+	if err := writePluginAttachment(containerID, &pluginAttachment{Network: n.Name, Driver: n.Driver, IPAddress: ep.IPAddress}); err != nil {
+		return err
+	}
+	logging.L().Debug("connected container with plugin", "container", containerID, "network", n.Name, "driver", n.Driver, "address", ep.IPAddress)
+	return nil
+}
+
+// disconnectPlugin asks the plugin of the network a container was last
+// connected to to disconnect it, if it still is
+func disconnectPlugin(containerID string) error {
+	path := pluginAttachmentFile(containerID)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read network attachment: %w", err)
+	}
+	attachment := &pluginAttachment{}
+	if err := json.Unmarshal(data, attachment); err != nil {
+		return fmt.Errorf("failed to parse network attachment: %w", err)
+	}
+
+	p, err := plugin.Get(attachment.Driver, plugin.NetworkDriver)
+	if err != nil {
+		return err
+	}
+	if err := p.Call("NetworkDriver.Leave", map[string]string{"network": attachment.Network, "container": containerID}, nil); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pluginContainers returns the IDs of the containers connected to the
+// network of a plugin, by address
+func pluginContainers(name string) (map[string]string, error) {
+	files, err := filepath.Glob(pluginAttachmentFile("*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	containers := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		attachment := &pluginAttachment{}
+		if json.Unmarshal(data, attachment) != nil || attachment.Network != name {
+			continue
+		}
+		containers[attachment.IPAddress] = strings.TrimSuffix(filepath.Base(file), ".json")
+	}
+	return containers, nil
+}
+
+// pluginAttachmentFile returns the file keeping the attachment of a
+// container to the network of a plugin. The directory's name can't be a
+// network's.
+func pluginAttachmentFile(containerID string) string {
+	return config.DataPath("networks", ".plugins", containerID+".json")
+}
+
+// writePluginAttachment records the attachment of a container to the
+// network of a plugin
+func writePluginAttachment(containerID string, attachment *pluginAttachment) error {
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+	path := pluginAttachmentFile(containerID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create network attachment directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write network attachment: %w", err)
+	}
+	return nil
+}
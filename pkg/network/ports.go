@@ -0,0 +1,190 @@
+// pkg/network/ports.go
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// PortMapping publishes a container port on a host port
+type PortMapping struct {
+	HostIP        string `json:",omitempty"` // Host address to listen on, all when empty
+	HostPort      int
+	ContainerPort int
+	Protocol      string // "tcp" or "udp"
+}
+
+// String formats the mapping the way floka ps shows it
+func (m PortMapping) String() string {
+	hostIP := m.HostIP
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
+	return fmt.Sprintf("%s->%d/%s", net.JoinHostPort(hostIP, strconv.Itoa(m.HostPort)), m.ContainerPort, m.Protocol)
+}
+
+// ParsePortSpec parses a -p value: [[hostIP:]hostPort:]containerPort[/proto].
+// Without a host port a free one is picked.
+func ParsePortSpec(spec string) (PortMapping, error) {
+	m := PortMapping{Protocol: "tcp"}
+	rest := spec
+	if i := strings.LastIndex(rest, "/"); i >= 0 {
+		m.Protocol = strings.ToLower(rest[i+1:])
+		rest = rest[:i]
+	}
+	if m.Protocol != "tcp" && m.Protocol != "udp" {
+		return m, fmt.Errorf("invalid protocol in port %q, expected tcp or udp", spec)
+	}
+
+	// IPv6 host addresses are given in brackets
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 || len(rest) < end+2 || rest[end+1] != ':' {
+			return m, fmt.Errorf("invalid port specification %q", spec)
+		}
+		m.HostIP = rest[1:end]
+		rest = rest[end+2:]
+	}
+
+	parts := strings.Split(rest, ":")
+	var hostPort, containerPort string
+	switch {
+	case len(parts) == 1:
+		containerPort = parts[0]
+	case len(parts) == 2:
+		hostPort, containerPort = parts[0], parts[1]
+	case len(parts) == 3 && m.HostIP == "":
+		m.HostIP, hostPort, containerPort = parts[0], parts[1], parts[2]
+	default:
+		return m, fmt.Errorf("invalid port specification %q", spec)
+	}
+
+	if m.HostIP != "" && net.ParseIP(m.HostIP) == nil {
+		return m, fmt.Errorf("invalid host address in port %q", spec)
+	}
+	var err error
+	if m.ContainerPort, err = parsePort(containerPort); err != nil {
+		return m, fmt.Errorf("invalid container port in %q: %w", spec, err)
+	}
+	if hostPort == "" {
+		if m.HostPort, err = freePort(m.HostIP, m.Protocol); err != nil {
+			return m, err
+		}
+	} else if m.HostPort, err = parsePort(hostPort); err != nil {
+		return m, fmt.Errorf("invalid host port in %q: %w", spec, err)
+	}
+	return m, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	return port, nil
+}
+
+// freePort asks the kernel for an unused port
+func freePort(hostIP, protocol string) (int, error) {
+	address := net.JoinHostPort(hostIP, "0")
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find a free port: %w", err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Publish makes the given ports of the container at the address of ep
+// reachable on the host. TCP ports are served by a proxy in this process, which
+// also covers connections to the loopback address, and with iptables
+// available DNAT rules forward traffic straight to the container. UDP
+// ports need iptables. The returned function stops the proxies.
+func Publish(ep *Endpoint, ports []PortMapping) (func(), error) {
+	var proxies []*proxy
+	stop := func() {
+		for _, p := range proxies {
+			p.Close()
+		}
+	}
+
+	iptables := haveIptables()
+	for _, m := range ports {
+		if m.Protocol == "tcp" {
+			p, err := startProxy(m, ep.IPAddress)
+			if err != nil {
+				stop()
+				Unpublish(ep, ports)
+				return nil, err
+			}
+			proxies = append(proxies, p)
+		} else if !iptables {
+			logging.L().Warn("iptables not found, UDP port can't be published", "port", m.String())
+		}
+
+		if !iptables {
+			continue
+		}
+		for _, r := range portRules(ep, m) {
+			if err := r.ensure(); err != nil {
+				stop()
+				Unpublish(ep, ports)
+				return nil, fmt.Errorf("failed to publish port %s: %w", m, err)
+			}
+		}
+	}
+	return stop, nil
+}
+
+// Unpublish removes the iptables rules of published ports
+func Unpublish(ep *Endpoint, ports []PortMapping) {
+	if len(ports) == 0 || !haveIptables() {
+		return
+	}
+	for _, m := range ports {
+		for _, r := range portRules(ep, m) {
+			if err := r.delete(); err != nil {
+				logging.L().Warn("failed to remove iptables rule", "err", err)
+			}
+		}
+	}
+}
+
+// portRules returns the iptables rules publishing one port
+func portRules(ep *Endpoint, m PortMapping) []rule {
+	destination := net.JoinHostPort(ep.IPAddress, strconv.Itoa(m.ContainerPort))
+	match := []string{"-p", m.Protocol}
+	if m.HostIP != "" {
+		match = append(match, "-d", m.HostIP)
+	}
+	match = append(match, "--dport", strconv.Itoa(m.HostPort), "-m", "addrtype", "--dst-type", "LOCAL")
+	dnat := append(append([]string{}, match...), "-j", "DNAT", "--to-destination", destination)
+
+	rules := []rule{{"nat", "PREROUTING", dnat}}
+	// The plugins of CNI networks set up forwarding themselves
+	if bridge := ep.bridge(); bridge != "" {
+		rules = append(rules, rule{"filter", "FORWARD", []string{"-d", ep.IPAddress, "-o", bridge, "-p", m.Protocol,
+			"--dport", strconv.Itoa(m.ContainerPort), "-j", "ACCEPT"}})
+	}
+	// Connections from the host itself; loopback ones go through the proxy
+	if ip := net.ParseIP(m.HostIP); ip == nil || !ip.IsLoopback() {
+		local := dnat
+		if m.HostIP == "" {
+			local = append([]string{"!", "-d", "127.0.0.0/8"}, dnat...)
+		}
+		rules = append(rules, rule{"nat", "OUTPUT", local})
+	}
+	return rules
+}
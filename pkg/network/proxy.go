@@ -0,0 +1,74 @@
+// pkg/network/proxy.go
+package network
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// proxy forwards TCP connections from a host port to a container
+type proxy struct {
+	listener net.Listener
+	target   string
+}
+
+// startProxy listens on the host side of a mapping and forwards every
+// connection to the container
+func startProxy(m PortMapping, containerIP string) (*proxy, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(m.HostIP, strconv.Itoa(m.HostPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish port %s: %w", m, err)
+	}
+	p := &proxy{
+		listener: l,
+		target:   net.JoinHostPort(containerIP, strconv.Itoa(m.ContainerPort)),
+	}
+	logging.L().Debug("proxying port", "listen", l.Addr().String(), "target", p.target)
+	go p.serve()
+	return p, nil
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+// forward copies data both ways between a client and the container
+func (p *proxy) forward(client net.Conn) {
+	defer client.Close()
+	backend, err := net.DialTimeout("tcp", p.target, 10*time.Second)
+	if err != nil {
+		logging.L().Debug("failed to reach container", "target", p.target, "err", err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Pass the end of the stream on, the other direction may go on
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(backend, client)
+	go pipe(client, backend)
+	<-done
+	<-done
+}
+
+// Close stops accepting connections
+func (p *proxy) Close() error {
+	return p.listener.Close()
+}
@@ -0,0 +1,280 @@
+// pkg/plugin/plugin.go
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Plugins live in a single directory. Each entry is either an executable,
+// which floka runs once per call with the JSON-RPC request on stdin and
+// reads the response from stdout, or a unix socket named <name>.sock that
+// accepts newline-delimited JSON-RPC 2.0 requests.
+//
+// Every plugin must answer "Plugin.Activate" with the driver kinds it
+// implements, e.g. {"implements": ["VolumeDriver"]}.
+
+// DefaultDir is the plugin directory used unless FLOKA_PLUGINS is set
+const DefaultDir = "/etc/floka/plugins"
+
+// Driver kinds a plugin can implement
+const (
+	VolumeDriver  = "VolumeDriver"
+	NetworkDriver = "NetworkDriver"
+	LogDriver     = "LogDriver"
+)
+
+const activateMethod = "Plugin.Activate"
+
+// Transport types
+const (
+	TransportExec   = "exec"
+	TransportSocket = "socket"
+)
+
+var callTimeout = 30 * time.Second
+
+// validName matches the names plugins can be asked for by, which can't
+// lead out of the plugin directory
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Plugin is an external driver discovered in the plugin directory
+type Plugin struct {
+	Name       string
+	Path       string
+	Transport  string
+	Implements []string
+}
+
+// Error is an error returned by a plugin in a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+var nextID uint64
+
+// Dir returns the plugin directory in use
+func Dir() string {
+	if dir := os.Getenv("FLOKA_PLUGINS"); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// Discover finds and activates all plugins in dir. Plugins that fail to
+// activate are skipped with a warning.
+func Discover(dir string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []*Plugin{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	var plugins []*Plugin
+	for _, entry := range entries {
+		p := fromEntry(dir, entry)
+		if p == nil {
+			continue
+		}
+		if err := p.activate(); err != nil {
+			logging.L().Warn("plugin failed to activate", "plugin", p.Name, "err", err)
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, nil
+}
+
+// Get finds and activates the named plugin and checks it implements kind
+func Get(name, kind string) (*Plugin, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid plugin name %q: only [A-Za-z0-9][A-Za-z0-9_.-] are allowed", name)
+	}
+	dir := Dir()
+	for _, candidate := range []string{name, name + ".sock"} {
+		info, err := os.Stat(filepath.Join(dir, candidate))
+		if err != nil {
+			continue
+		}
+		p := fromEntry(dir, fileInfoEntry{info})
+		if p == nil {
+			continue
+		}
+		if err := p.activate(); err != nil {
+			return nil, fmt.Errorf("plugin %s failed to activate: %w", name, err)
+		}
+		if !p.Supports(kind) {
+			return nil, fmt.Errorf("plugin %s does not implement %s", name, kind)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("plugin %s not found in %s", name, dir)
+}
+
+// Supports reports whether the plugin implements the given driver kind
+func (p *Plugin) Supports(kind string) bool {
+	for _, k := range p.Implements {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Call invokes a method on the plugin and decodes its result into result,
+// which may be nil when the result is not needed
+func (p *Plugin) Call(method string, params, result interface{}) error {
+	req := request{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&nextID, 1),
+		Method:  method,
+		Params:  params,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	var raw []byte
+	switch p.Transport {
+	case TransportSocket:
+		raw, err = p.callSocket(body)
+	default:
+		raw, err = p.callExec(body)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %s failed: %w", p.Name, method, err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return fmt.Errorf("plugin %s: invalid response to %s: %w", p.Name, method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("plugin %s: invalid result for %s: %w", p.Name, method, err)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) activate() error {
+	var result struct {
+		Implements []string `json:"implements"`
+	}
+	if err := p.Call(activateMethod, nil, &result); err != nil {
+		return err
+	}
+	p.Implements = result.Implements
+	return nil
+}
+
+func (p *Plugin) callExec(body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s", callTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (p *Plugin) callSocket(body []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", p.Path, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(callTimeout))
+
+	if _, err := conn.Write(append(body, '\n')); err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(conn).ReadBytes('\n')
+}
+
+// fromEntry builds a plugin from a directory entry, or returns nil if the
+// entry is neither an executable nor a socket
+func fromEntry(dir string, entry dirEntry) *Plugin {
+	info, err := entry.Info()
+	if err != nil {
+		return nil
+	}
+	path := filepath.Join(dir, entry.Name())
+
+	switch {
+	case info.Mode()&os.ModeSocket != 0:
+		return &Plugin{
+			Name:      strings.TrimSuffix(entry.Name(), ".sock"),
+			Path:      path,
+			Transport: TransportSocket,
+		}
+	case info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0:
+		return &Plugin{
+			Name:      entry.Name(),
+			Path:      path,
+			Transport: TransportExec,
+		}
+	}
+	return nil
+}
+
+// dirEntry is the subset of os.DirEntry used by fromEntry
+type dirEntry interface {
+	Name() string
+	Info() (os.FileInfo, error)
+}
+
+// fileInfoEntry adapts an os.FileInfo to dirEntry
+type fileInfoEntry struct{ os.FileInfo }
+
+func (f fileInfoEntry) Info() (os.FileInfo, error) { return f.FileInfo, nil }
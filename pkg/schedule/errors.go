@@ -0,0 +1,10 @@
+// pkg/schedule/errors.go
+package schedule
+
+import "errors"
+
+// Errors callers can test for with errors.Is
+var (
+	ErrScheduleNotFound = errors.New("no such schedule")
+	ErrScheduleExists   = errors.New("schedule already exists")
+)
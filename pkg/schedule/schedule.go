@@ -0,0 +1,481 @@
+// pkg/schedule/schedule.go
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// Overlap policies, what a trigger does while the container of an earlier
+// run of the schedule still runs
+const (
+	OverlapSkip    = "skip"    // Skip the run
+	OverlapAllow   = "allow"   // Run another container alongside
+	OverlapReplace = "replace" // Stop the running containers first
+)
+
+// LabelSchedule is set on the containers of a schedule to its name
+const LabelSchedule = "floka.schedule"
+
+// historyLimit is the number of runs a schedule remembers. The containers
+// of older runs are removed once they have exited.
+const historyLimit = 10
+
+// Schedule runs a container from the same image and command each time a
+// cron expression fires. Its scheduler process, "floka scheduler NAME",
+// waits for the triggers and runs the containers with "floka run -d".
+type Schedule struct {
+	Name    string
+	Spec    string // Cron expression
+	Overlap string
+	Options []string // floka run flags of the containers
+	Image   string
+	Command []string
+	Pid     int // Of the scheduler process
+	Created time.Time
+	History []Run // Oldest first
+
+	cron *Cron
+}
+
+// Run is a trigger of a schedule
+type Run struct {
+	Time      time.Time
+	Container string // ID of the container run, empty if skipped or failed
+	Skipped   bool   // The container of an earlier run still ran
+	Error     string `json:",omitempty"`
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// schedulesDir returns the schedule store under the data root
+func schedulesDir() string {
+	return config.DataPath("schedules")
+}
+
+// Create stores a new schedule, without starting its scheduler. An empty
+// name is replaced by a random one, an empty overlap policy by skip.
+func Create(s *Schedule) error {
+	if s.Name == "" {
+		s.Name = generateName()
+	}
+	if !validName.MatchString(s.Name) {
+		return fmt.Errorf("invalid schedule name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", s.Name)
+	}
+	switch s.Overlap {
+	case "":
+		s.Overlap = OverlapSkip
+	case OverlapSkip, OverlapAllow, OverlapReplace:
+	default:
+		return fmt.Errorf("invalid overlap policy %q, expected skip, allow or replace", s.Overlap)
+	}
+	cron, err := ParseCron(s.Spec)
+	if err != nil {
+		return err
+	}
+	if cron.Next(time.Now()).IsZero() {
+		return fmt.Errorf("cron expression %q never fires", s.Spec)
+	}
+	s.cron = cron
+	s.Created = time.Now()
+
+	if err := os.MkdirAll(schedulesDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create schedules directory: %w", err)
+	}
+	err = os.Mkdir(filepath.Join(schedulesDir(), s.Name), 0755)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s", ErrScheduleExists, s.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create schedule directory: %w", err)
+	}
+	if err := s.save(); err != nil {
+		os.RemoveAll(filepath.Join(schedulesDir(), s.Name))
+		return err
+	}
+	return nil
+}
+
+// Get loads a schedule by name
+func Get(name string) (*Schedule, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+	}
+	data, err := os.ReadFile(filepath.Join(schedulesDir(), name, "schedule.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule %s: %w", name, err)
+	}
+
+	s := &Schedule{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %w", name, err)
+	}
+	if s.cron, err = ParseCron(s.Spec); err != nil {
+		return nil, fmt.Errorf("schedule %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// List returns all schedules sorted by name
+func List() ([]*Schedule, error) {
+	entries, err := os.ReadDir(schedulesDir())
+	if os.IsNotExist(err) {
+		return []*Schedule{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules directory: %w", err)
+	}
+
+	schedules := []*Schedule{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		s, err := Get(entry.Name())
+		if err != nil {
+			logging.L().Warn("skipping schedule", "err", err)
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules, nil
+}
+
+// Next returns the time of the schedule's next run after t
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.cron.Next(t)
+}
+
+// Active reports whether the schedule's scheduler process runs. The PID
+// must still belong to "floka scheduler NAME", not to a later process.
+func (s *Schedule) Active() bool {
+	if s.Pid <= 0 {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(s.Pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+	return len(args) >= 3 && args[len(args)-2] == "scheduler" && args[len(args)-1] == s.Name
+}
+
+// Launch starts the schedule's scheduler process in the background, in a
+// session of its own so that it outlives our terminal
+func (s *Schedule) Launch() error {
+	if s.Active() {
+		return fmt.Errorf("scheduler of schedule %s is already running", s.Name)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get host executable path: %w", err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(self, "scheduler", s.Name)
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start scheduler: %w", err)
+	}
+	defer cmd.Process.Release()
+
+	s.Pid = cmd.Process.Pid
+	return update(s.Name, func(cur *Schedule) { cur.Pid = s.Pid })
+}
+
+// Stop ends the schedule's scheduler process, letting a container it is
+// starting come up first, and kills it if it hasn't exited after timeout
+func (s *Schedule) Stop(timeout time.Duration) error {
+	if !s.Active() {
+		return nil
+	}
+	if err := syscall.Kill(s.Pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop scheduler of schedule %s: %w", s.Name, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for s.Active() {
+		if time.Now().After(deadline) {
+			syscall.Kill(s.Pid, syscall.SIGKILL)
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// Remove stops the schedule's scheduler and removes the schedule. The
+// containers of its runs are kept, they can be found by their
+// floka.schedule label.
+func (s *Schedule) Remove() error {
+	if err := s.Stop(container.DefaultStopTimeout); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(schedulesDir(), s.Name)); err != nil {
+		return fmt.Errorf("failed to remove schedule %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// Supervise waits for the triggers of the schedule with the given name
+// and runs its container at each, until ctx is cancelled or the schedule
+// is removed. It is run by the floka scheduler process. Triggers missed
+// while no scheduler ran are skipped, as cron does.
+func Supervise(ctx context.Context, name string) error {
+	for {
+		s, err := Get(name)
+		if errors.Is(err, ErrScheduleNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		next := s.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("cron expression %q never fires", s.Spec)
+		}
+		logging.L().Debug("waiting for next run", "schedule", name, "time", next)
+
+		// Wake up at least every minute, in case the clock was set or
+		// the host suspended
+		for wait := time.Until(next); wait > 0; wait = time.Until(next) {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(min(wait, time.Minute)):
+			}
+		}
+		s.trigger(next)
+	}
+}
+
+// trigger runs the schedule's container for the trigger at t, as its
+// overlap policy allows, and records the run
+func (s *Schedule) trigger(t time.Time) {
+	run := Run{Time: t}
+	running := s.runningContainers()
+	switch {
+	case len(running) > 0 && s.Overlap == OverlapSkip:
+		run.Skipped = true
+	default:
+		if s.Overlap == OverlapReplace {
+			for _, c := range running {
+				if err := c.Stop(context.Background(), container.DefaultStopTimeout); err != nil {
+					logging.L().Warn("failed to stop container of earlier run", "schedule", s.Name, "container", c.ID, "err", err)
+				}
+			}
+		}
+		id, err := s.runContainer(t)
+		run.Container = id
+		if err != nil {
+			run.Error = err.Error()
+		}
+	}
+	logging.L().Debug("schedule triggered", "schedule", s.Name, "container", run.Container, "skipped", run.Skipped, "err", run.Error)
+
+	var dropped []Run
+	err := update(s.Name, func(cur *Schedule) {
+		cur.History = append(cur.History, run)
+		if n := len(cur.History) - historyLimit; n > 0 {
+			dropped = cur.History[:n]
+			cur.History = cur.History[n:]
+		}
+	})
+	if err != nil {
+		logging.L().Warn("failed to record run", "schedule", s.Name, "err", err)
+	}
+	for _, r := range dropped {
+		if r.Container != "" {
+			removeContainer(r.Container)
+		}
+	}
+}
+
+// runningContainers returns the containers of the schedule's runs that
+// still run
+func (s *Schedule) runningContainers() []*container.Container {
+	var running []*container.Container
+	for _, r := range s.History {
+		if r.Container == "" {
+			continue
+		}
+		c, err := container.Find(r.Container)
+		if err == nil && c.IsRunning() {
+			running = append(running, c)
+		}
+	}
+	return running
+}
+
+// runContainer runs the schedule's container in the background with
+// "floka run -d", named after the schedule and the trigger time, and
+// returns its ID
+func (s *Schedule) runContainer(t time.Time) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get host executable path: %w", err)
+	}
+	args := []string{"run", "-d", "--name", s.Name + "-" + t.Format("200601021504"), "--label", LabelSchedule + "=" + s.Name}
+	args = append(args, s.Options...)
+	args = append(args, s.Image)
+	args = append(args, s.Command...)
+
+	// floka run prints the container ID, or what went wrong
+	var out bytes.Buffer
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = &out
+	err = cmd.Run()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if err != nil {
+		if last == "" {
+			return "", err
+		}
+		return "", errors.New(strings.TrimPrefix(last, "Error: "))
+	}
+	return last, nil
+}
+
+// removeContainer removes the container of a run that fell out of the
+// history with "floka rm", releasing its volumes, unless it still runs
+func removeContainer(id string) {
+	c, err := container.Find(id)
+	if err != nil || c.IsRunning() {
+		return
+	}
+	self, err := os.Executable()
+	if err == nil {
+		err = exec.Command(self, "rm", id).Run()
+	}
+	if err != nil {
+		logging.L().Warn("failed to remove container of old run", "container", id, "err", err)
+	}
+}
+
+// Restore starts the schedulers of the schedules whose scheduler doesn't
+// run, after a reboot, and returns their names
+func Restore() ([]string, error) {
+	schedules, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var started []string
+	var errs []error
+	for _, s := range schedules {
+		if s.Active() {
+			continue
+		}
+		if err := s.Launch(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		started = append(started, s.Name)
+	}
+	return started, errors.Join(errs...)
+}
+
+// Shutdown stops the schedulers of all schedules, before the containers
+// are stopped, so that no container starts meanwhile. The schedules are
+// kept for Restore.
+func Shutdown() error {
+	schedules, err := List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range schedules {
+		if err := s.Stop(container.DefaultStopTimeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// update changes the stored schedule with fn while holding its lock, as
+// the scheduler records runs while other floka processes launch it
+func update(name string, fn func(*Schedule)) error {
+	f, err := os.OpenFile(filepath.Join(schedulesDir(), name, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock schedule %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock schedule %s: %w", name, err)
+	}
+
+	s, err := Get(name)
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return s.save()
+}
+
+// save writes the schedule's metadata, replacing the file so that readers
+// never see it half written
+func (s *Schedule) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize schedule: %w", err)
+	}
+	path := filepath.Join(schedulesDir(), s.Name, "schedule.json")
+	f, err := os.CreateTemp(filepath.Dir(path), ".schedule.json.*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to save schedule %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// generateName creates a random name for a schedule
+func generateName() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("schedule_%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
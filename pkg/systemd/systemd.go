@@ -0,0 +1,137 @@
+// pkg/systemd/systemd.go
+package systemd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bensdz/floka/pkg/container"
+)
+
+// UnitOptions controls how a unit file is generated for a container
+type UnitOptions struct {
+	Executable    string   // Absolute path to the floka binary
+	DataRoot      string   // Data root holding floka's images and containers
+	RestartPolicy string   // Docker-style policy: no, on-failure[:max], always, unless-stopped
+	StopTimeout   int      // Seconds systemd waits after ExecStop before SIGKILL
+	Requires      []string // IDs of containers that must be started before this one
+}
+
+// UnitName returns the systemd unit name used for a container
+func UnitName(containerID string) string {
+	return fmt.Sprintf("floka-%s.service", containerID)
+}
+
+// GenerateUnit renders a systemd service unit that runs the container's
+// image and command in the foreground under systemd supervision
+func GenerateUnit(c *container.Container, opts UnitOptions) (string, error) {
+	if opts.Executable == "" || !filepath.IsAbs(opts.Executable) {
+		return "", fmt.Errorf("floka executable path must be absolute: %q", opts.Executable)
+	}
+	if opts.DataRoot == "" || !filepath.IsAbs(opts.DataRoot) {
+		return "", fmt.Errorf("data root must be absolute: %q", opts.DataRoot)
+	}
+
+	restart, limits, err := translateRestartPolicy(opts.RestartPolicy)
+	if err != nil {
+		return "", err
+	}
+
+	execStart := []string{opts.Executable, "--data-root", opts.DataRoot, "run", c.ImageRef()}
+	execStart = append(execStart, c.Command...)
+
+	after := []string{"network-online.target"}
+	var requires []string
+	for _, dep := range opts.Requires {
+		after = append(after, UnitName(dep))
+		requires = append(requires, UnitName(dep))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", UnitName(c.ID))
+	fmt.Fprintf(&b, "# generated by floka for container %s\n\n", c.ID)
+
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=Floka container %s\n", c.ID)
+	b.WriteString("Wants=network-online.target\n")
+	fmt.Fprintf(&b, "After=%s\n", strings.Join(after, " "))
+	if len(requires) > 0 {
+		fmt.Fprintf(&b, "Requires=%s\n", strings.Join(requires, " "))
+	}
+	for _, l := range limits {
+		b.WriteString(l + "\n")
+	}
+
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=simple\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", joinArgs(execStart))
+	// floka run forwards SIGTERM to the container process and removes the
+	// container once it exits
+	b.WriteString("ExecStop=/bin/kill -s TERM $MAINPID\n")
+	fmt.Fprintf(&b, "Restart=%s\n", restart)
+	if opts.StopTimeout > 0 {
+		fmt.Fprintf(&b, "TimeoutStopSec=%d\n", opts.StopTimeout)
+	}
+	b.WriteString("KillMode=mixed\n")
+
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+
+	return b.String(), nil
+}
+
+// translateRestartPolicy maps a Docker-style restart policy to the systemd
+// Restart= value and any [Unit] start limit settings it needs
+func translateRestartPolicy(policy string) (string, []string, error) {
+	name, max, _ := strings.Cut(policy, ":")
+
+	switch name {
+	case "", "no":
+		return "no", nil, nil
+	case "always", "unless-stopped":
+		// "systemctl stop" never triggers a restart, so unless-stopped
+		// behaves like always under systemd
+		return "always", nil, nil
+	case "on-failure":
+		if max == "" {
+			return "on-failure", nil, nil
+		}
+		count, err := strconv.Atoi(max)
+		if err != nil || count < 1 {
+			return "", nil, fmt.Errorf("invalid maximum retry count in restart policy: %s", policy)
+		}
+		return "on-failure", []string{
+			"StartLimitIntervalSec=infinity",
+			fmt.Sprintf("StartLimitBurst=%d", count+1),
+		}, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown restart policy: %s", policy)
+}
+
+// joinArgs quotes each argument for a systemd command line
+func joinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = escapeArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// escapeArg escapes specifiers and variable expansion in a single argument and
+// wraps it in double quotes when it contains whitespace or quotes
+func escapeArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, "\\", "\\\\")
+	arg = strings.ReplaceAll(arg, "\"", "\\\"")
+	arg = strings.ReplaceAll(arg, "\n", "\\n")
+	return "\"" + arg + "\""
+}
@@ -0,0 +1,11 @@
+// pkg/volume/errors.go
+package volume
+
+import "errors"
+
+// Errors callers can test for with errors.Is
+var (
+	ErrVolumeNotFound = errors.New("no such volume")
+	ErrVolumeExists   = errors.New("volume already exists")
+	ErrVolumeInUse    = errors.New("volume is in use")
+)
@@ -0,0 +1,346 @@
+// pkg/volume/volume.go
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/bensdz/floka/internal/fsutil"
+	"github.com/bensdz/floka/pkg/config"
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+	"github.com/bensdz/floka/pkg/plugin"
+)
+
+// DefaultDriver stores volume data in a directory under the volumes store
+const DefaultDriver = "local"
+
+// Volume is a named piece of storage that outlives containers
+type Volume struct {
+	Name       string
+	Driver     string
+	Options    map[string]string
+	Mountpoint string
+	Anonymous  bool // Created implicitly for a container, removed along with it
+	CreatedAt  time.Time
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// volumesDir returns the volume store under the data root
+func volumesDir() string {
+	return config.DataPath("volumes")
+}
+
+// Create makes a new volume. An empty name creates an anonymous volume with
+// a random name.
+func Create(name, driver string, opts map[string]string) (*Volume, error) {
+	anonymous := name == ""
+	if anonymous {
+		name = generateName()
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid volume name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	if opts == nil {
+		opts = map[string]string{}
+	}
+
+	volumeDir := filepath.Join(volumesDir(), name)
+	if _, err := os.Stat(volumeDir); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeExists, name)
+	}
+
+	v := &Volume{
+		Name:      name,
+		Driver:    driver,
+		Options:   opts,
+		Anonymous: anonymous,
+		CreatedAt: time.Now(),
+	}
+
+	if err := os.MkdirAll(volumeDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	var err error
+	if driver == DefaultDriver {
+		err = v.createLocal()
+	} else {
+		err = v.createPlugin()
+	}
+	if err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, err
+	}
+
+	if err := v.save(); err != nil {
+		v.remove()
+		return nil, err
+	}
+	return v, nil
+}
+
+// Get loads a volume by name
+func Get(name string) (*Volume, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, name)
+	}
+	data, err := os.ReadFile(filepath.Join(volumesDir(), name, "volume.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume %s: %w", name, err)
+	}
+
+	v := &Volume{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata for volume %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// List returns all volumes sorted by name
+func List() ([]*Volume, error) {
+	entries, err := os.ReadDir(volumesDir())
+	if os.IsNotExist(err) {
+		return []*Volume{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volumes directory: %w", err)
+	}
+
+	volumes := []*Volume{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := Get(entry.Name())
+		if err != nil {
+			logging.L().Warn("skipping volume", "err", err)
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// UsedBy returns the IDs of containers that mount the volume
+func (v *Volume) UsedBy() ([]string, error) {
+	containers, err := container.ListContainers(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if m.Type == "volume" && m.Name == v.Name {
+				ids = append(ids, c.ID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Remove deletes the volume and its data. Volumes still mounted by a
+// container are refused.
+func (v *Volume) Remove() error {
+	users, err := v.UsedBy()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("%w: %s is used by container(s) %v", ErrVolumeInUse, v.Name, users)
+	}
+	return v.remove()
+}
+
+// Mount prepares the volume for use by a container and returns the host
+// path to bind mount
+func (v *Volume) Mount() (string, error) {
+	if v.Driver == DefaultDriver {
+		return v.Mountpoint, nil
+	}
+
+	p, err := plugin.Get(v.Driver, plugin.VolumeDriver)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Mountpoint string `json:"mountpoint"`
+	}
+	if err := p.Call("VolumeDriver.Mount", map[string]string{"name": v.Name}, &result); err != nil {
+		return "", err
+	}
+	return result.Mountpoint, nil
+}
+
+// Unmount tells the volume driver that a container no longer uses the volume
+func (v *Volume) Unmount() error {
+	if v.Driver == DefaultDriver {
+		return nil
+	}
+
+	p, err := plugin.Get(v.Driver, plugin.VolumeDriver)
+	if err != nil {
+		return err
+	}
+	return p.Call("VolumeDriver.Unmount", map[string]string{"name": v.Name}, nil)
+}
+
+// Prune removes all volumes not used by any container and returns their
+// names and the disk space they took
+func Prune() ([]string, int64, error) {
+	volumes, err := List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var removed []string
+	var reclaimed int64
+	for _, v := range volumes {
+		users, err := v.UsedBy()
+		if err != nil {
+			return removed, reclaimed, err
+		}
+		if len(users) > 0 {
+			continue
+		}
+		size := v.DiskUsage()
+		if err := v.remove(); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, v.Name)
+		reclaimed += size
+	}
+	return removed, reclaimed, nil
+}
+
+// DiskUsage returns the disk space taken by the volume's data. It is 0 for
+// volumes of plugins, which keep their data elsewhere.
+func (v *Volume) DiskUsage() int64 {
+	if v.Driver != DefaultDriver {
+		return 0
+	}
+	return fsutil.DirSize(v.Mountpoint)
+}
+
+// createLocal sets up the data directory of a local volume. With
+// type=tmpfs the data lives in a tmpfs, which also supports a size quota.
+func (v *Volume) createLocal() error {
+	dataDir, err := filepath.Abs(filepath.Join(volumesDir(), v.Name, "_data"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create volume data directory: %w", err)
+	}
+	v.Mountpoint = dataDir
+
+	for key := range v.Options {
+		if key != "type" && key != "size" {
+			return fmt.Errorf("unknown option %q for the local driver", key)
+		}
+	}
+
+	switch v.Options["type"] {
+	case "":
+		if _, ok := v.Options["size"]; ok {
+			return fmt.Errorf("size quota requires type=tmpfs with the local driver")
+		}
+	case "tmpfs":
+		data := "mode=755"
+		if size, ok := v.Options["size"]; ok {
+			data += ",size=" + size
+		}
+		if err := syscall.Mount("tmpfs", dataDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+			return fmt.Errorf("failed to mount tmpfs for volume %s: %w", v.Name, err)
+		}
+	default:
+		return fmt.Errorf("unsupported volume type %q for the local driver", v.Options["type"])
+	}
+	return nil
+}
+
+// createPlugin asks a volume plugin to create the volume
+func (v *Volume) createPlugin() error {
+	p, err := plugin.Get(v.Driver, plugin.VolumeDriver)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{"name": v.Name, "options": v.Options}
+	return p.Call("VolumeDriver.Create", params, nil)
+}
+
+// remove deletes the volume without checking for users
+func (v *Volume) remove() error {
+	if v.Driver == DefaultDriver {
+		if v.Options["type"] == "tmpfs" {
+			if err := syscall.Unmount(v.Mountpoint, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+				return fmt.Errorf("failed to unmount volume %s: %w", v.Name, err)
+			}
+		}
+	} else {
+		p, err := plugin.Get(v.Driver, plugin.VolumeDriver)
+		if err != nil {
+			return err
+		}
+		if err := p.Call("VolumeDriver.Remove", map[string]string{"name": v.Name}, nil); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(volumesDir(), v.Name))
+}
+
+// save writes the volume metadata, through a temporary file so that
+// readers never see it half written
+func (v *Volume) save() error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize volume metadata: %w", err)
+	}
+	path := filepath.Join(volumesDir(), v.Name, "volume.json")
+	f, err := os.CreateTemp(filepath.Dir(path), ".volume.json.*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// generateName creates a random name for an anonymous volume
+func generateName() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("anon_%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
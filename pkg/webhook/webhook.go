@@ -0,0 +1,137 @@
+// pkg/webhook/webhook.go
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bensdz/floka/pkg/container"
+	"github.com/bensdz/floka/pkg/logging"
+)
+
+// DefaultConfigPath is where webhook endpoints are read from unless
+// FLOKA_WEBHOOKS points somewhere else
+const DefaultConfigPath = "/etc/floka/webhooks.json"
+
+// Endpoint is an HTTP endpoint notified about container events
+type Endpoint struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"` // Key for the HMAC-SHA256 signature
+	Events []string `json:"events,omitempty"` // Event types to send, all when empty
+}
+
+// payload is the JSON body posted to endpoints
+type payload struct {
+	Event       string    `json:"event"`
+	ContainerID string    `json:"container_id"`
+	Image       string    `json:"image"`
+	ExitCode    int       `json:"exit_code"`
+	Time        time.Time `json:"time"`
+}
+
+var client = &http.Client{Timeout: 5 * time.Second}
+
+// ConfigPath returns the webhook configuration file in use
+func ConfigPath() string {
+	if path := os.Getenv("FLOKA_WEBHOOKS"); path != "" {
+		return path
+	}
+	return DefaultConfigPath
+}
+
+// LoadEndpoints reads the list of endpoints from a JSON config file.
+// A missing file means no webhooks are configured.
+func LoadEndpoints(path string) ([]Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+
+	var endpoints []Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config %s: %w", path, err)
+	}
+	for _, ep := range endpoints {
+		if ep.URL == "" {
+			return nil, fmt.Errorf("webhook config %s has an endpoint without url", path)
+		}
+	}
+	return endpoints, nil
+}
+
+// Hook returns a container event hook that posts events to the endpoints
+func Hook(endpoints []Endpoint) container.EventHook {
+	return func(event container.Event) {
+		for _, ep := range endpoints {
+			if !ep.wants(event.Type) {
+				continue
+			}
+			if err := ep.send(event); err != nil {
+				logging.L().Warn("webhook failed", "url", ep.URL, "event", event.Type, "err", err)
+			}
+		}
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with the given secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ep Endpoint) wants(eventType string) bool {
+	if len(ep.Events) == 0 {
+		return true
+	}
+	for _, e := range ep.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (ep Endpoint) send(event container.Event) error {
+	body, err := json.Marshal(payload{
+		Event:       event.Type,
+		ContainerID: event.ContainerID,
+		Image:       event.Image,
+		ExitCode:    event.ExitCode,
+		Time:        event.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Floka-Event", event.Type)
+	if ep.Secret != "" {
+		req.Header.Set("X-Floka-Signature", "sha256="+Sign(ep.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}